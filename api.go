@@ -1,16 +1,22 @@
 package telehash
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net"
+	"net/http"
 	"time"
 
 	"github.com/telehash/gogotelehash/e3x"
 	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
 	"github.com/telehash/gogotelehash/transports"
 
 	"github.com/telehash/gogotelehash/internal/modules/bridge"
+	"github.com/telehash/gogotelehash/internal/modules/dht"
+	"github.com/telehash/gogotelehash/internal/modules/metrics"
 	"github.com/telehash/gogotelehash/internal/modules/paths"
 )
 
@@ -24,21 +30,151 @@ type (
 	Identity       struct{ inner *e3x.Identity }
 	Identifier     e3x.Identifier
 	Packet         lob.Packet
+	DHTConfig      dht.Config
 )
 
+// The log types are aliases of the internal logs package, so sinks written
+// outside of this package can be handed to LogTo and ModuleLogSink.
+type (
+	LogSink     = logs.Sink
+	LogEntry    = logs.Entry
+	LogField    = logs.Field
+	LogLevel    = logs.Level
+	LogFileSink = logs.FileSink
+)
+
+const (
+	LogDebug = logs.Debug
+	LogInfo  = logs.Info
+	LogWarn  = logs.Warn
+	LogError = logs.Error
+)
+
+// NewTerminalLogSink returns a sink which writes colored, human readable lines
+// to w.
+func NewTerminalLogSink(w io.Writer) LogSink {
+	return logs.NewTerminalSink(w)
+}
+
+// NewWriterLogSink returns a sink which writes logfmt lines to w.
+func NewWriterLogSink(w io.Writer) LogSink {
+	return logs.NewWriterSink(w)
+}
+
+// NewFileLogSink returns a sink which appends logfmt lines to the file at path
+// and rotates it once it grows beyond maxSize, keeping keep old files.
+func NewFileLogSink(path string, maxSize int64, keep int) (*LogFileSink, error) {
+	return logs.NewFileSink(path, maxSize, keep)
+}
+
+// NewSyslogLogSink returns a sink which writes to the local syslog daemon,
+// tagged with tag.
+func NewSyslogLogSink(tag string) (LogSink, error) {
+	return logs.NewSyslogSink(tag)
+}
+
 func Transport(config transports.Config) EndpointOption {
 	return EndpointOption(e3x.Transport(config))
 }
 
+// Metrics publishes the metrics of the endpoint in the "telehash" expvar map.
+// When listenAddr is not empty an HTTP server on listenAddr serves them at
+// /metrics (for Prometheus) and /debug/vars (expvar).
+func Metrics(listenAddr string) EndpointOption {
+	return EndpointOption(metrics.Module(metrics.Config{ListenAddr: listenAddr}))
+}
+
+// CipherSets limits the cipher sets the endpoint generates keys for (see
+// e3x.CipherSets).
+func CipherSets(csids ...uint8) EndpointOption {
+	return EndpointOption(e3x.CipherSets(csids...))
+}
+
+// Log makes the endpoint log to w (os.Stderr when w is nil).
+func Log(w io.Writer) EndpointOption {
+	return EndpointOption(e3x.Log(w))
+}
+
+// LogTo makes the endpoint log to s.
+func LogTo(s LogSink) EndpointOption {
+	return EndpointOption(e3x.LogSink(s))
+}
+
+// ModuleLogSink routes the entries of module (e3x, dht, bridge, ...) to s. A
+// nil s removes the route. The route is process wide.
+func ModuleLogSink(module string, s LogSink) EndpointOption {
+	return func(e *e3x.Endpoint) error {
+		logs.SetSink(module, s)
+		return nil
+	}
+}
+
+// ModuleLogLevel drops the entries of module below lvl. The level is process
+// wide.
+func ModuleLogLevel(module string, lvl LogLevel) EndpointOption {
+	return func(e *e3x.Endpoint) error {
+		logs.SetLevel(module, lvl)
+		return nil
+	}
+}
+
+// DHT configures the DHT of the endpoint. By default the endpoint runs a DHT
+// with the default configuration.
+func DHT(config DHTConfig) EndpointOption {
+	return EndpointOption(dht.Module(dht.Config(config)))
+}
+
+// Keepalive makes every channel of the endpoint send keepalives at interval
+// (see e3x.ChannelKeepalive).
+func Keepalive(interval time.Duration) EndpointOption {
+	return EndpointOption(e3x.ChannelKeepalive(interval))
+}
+
+// MaxChannels caps the number of channels per exchange (see e3x.MaxChannels).
+func MaxChannels(n int) EndpointOption {
+	return EndpointOption(e3x.MaxChannels(n))
+}
+
+// PathMTUDiscovery makes the endpoint probe the MTU of the paths to its peers
+// (see e3x.PathMTUDiscovery).
+func PathMTUDiscovery() EndpointOption {
+	return EndpointOption(e3x.PathMTUDiscovery())
+}
+
+// LineKeepalive makes every line of the endpoint send keepalives when it was
+// quiet for interval (see e3x.LineKeepalive).
+func LineKeepalive(interval time.Duration) EndpointOption {
+	return EndpointOption(e3x.LineKeepalive(interval))
+}
+
+// LineIdleTimeout sets how long a line without channels stays open (see
+// e3x.LineIdleTimeout).
+func LineIdleTimeout(d time.Duration) EndpointOption {
+	return EndpointOption(e3x.LineIdleTimeout(d))
+}
+
+// RekeyInterval rotates the line keys of the endpoint every d (see
+// e3x.RekeyInterval).
+func RekeyInterval(d time.Duration) EndpointOption {
+	return EndpointOption(e3x.RekeyInterval(d))
+}
+
+// RekeyAfterBytes rotates a line key once n bytes were encrypted with it (see
+// e3x.RekeyAfterBytes).
+func RekeyAfterBytes(n uint64) EndpointOption {
+	return EndpointOption(e3x.RekeyAfterBytes(n))
+}
+
 func Open(options ...EndpointOption) (*Endpoint, error) {
-	innerOptions := make([]e3x.EndpointOption, len(options)+10)
+	innerOptions := make([]e3x.EndpointOption, 0, len(options)+3)
+
+	for _, option := range options {
+		innerOptions = append(innerOptions, e3x.EndpointOption(option))
+	}
 
 	innerOptions = append(innerOptions, paths.Module())
 	innerOptions = append(innerOptions, bridge.Module(bridge.Config{}))
-
-	for i, option := range options {
-		innerOptions[i] = e3x.EndpointOption(option)
-	}
+	innerOptions = append(innerOptions, defaultDHT)
 
 	inner, err := e3x.Open(innerOptions...)
 	if err != nil {
@@ -48,6 +184,13 @@ func Open(options ...EndpointOption) (*Endpoint, error) {
 	return &Endpoint{inner: inner}, nil
 }
 
+func defaultDHT(e *e3x.Endpoint) error {
+	if dht.FromEndpoint(e) != nil {
+		return nil
+	}
+	return dht.Module(dht.Config{})(e)
+}
+
 func (e *Endpoint) Close() error {
 	return e.inner.Close()
 }
@@ -65,8 +208,49 @@ func (e *Endpoint) LocalIdentity() (*Identity, error) {
 	return &Identity{inner}, nil
 }
 
+// ReflexiveAddr returns the best guess of the public UDP address of the
+// endpoint, as reported by its peers.
+func (e *Endpoint) ReflexiveAddr() (*net.UDPAddr, bool) {
+	p := paths.FromEndpoint(e.inner)
+	if p == nil {
+		return nil, false
+	}
+	return p.ReflexiveAddr()
+}
+
+// MetricsHandler returns a http.Handler which exposes the metrics of the
+// endpoint in the Prometheus text format.
+func (e *Endpoint) MetricsHandler() http.Handler {
+	return e.inner.MetricsHandler()
+}
+
+// Seek iteratively looks up the peers closest to target through the peers the
+// endpoint has exchanges with. The peers which are found are connected to
+// along the way. The closest peers are returned, closest first.
+func (e *Endpoint) Seek(target Hashname) ([]Hashname, error) {
+	return e.SeekContext(context.Background(), target)
+}
+
+// SeekContext is like Seek but gives up once ctx is done.
+func (e *Endpoint) SeekContext(ctx context.Context, target Hashname) ([]Hashname, error) {
+	found, err := dht.FromEndpoint(e.inner).LookupContext(ctx, hashname.H(target))
+	if err != nil {
+		return nil, err
+	}
+
+	l := make([]Hashname, len(found))
+	for i, hn := range found {
+		l[i] = Hashname(hn)
+	}
+	return l, nil
+}
+
 func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
-	inner, err := e.inner.Dial(e3x.Identifier(identifier))
+	return e.DialContext(context.Background(), identifier)
+}
+
+func (e *Endpoint) DialContext(ctx context.Context, identifier Identifier) (*Exchange, error) {
+	inner, err := e.inner.DialContext(ctx, e3x.Identifier(identifier))
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +259,11 @@ func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
 }
 
 func (e *Endpoint) Open(identifier Identifier, typ string, reliable bool) (*Channel, error) {
-	inner, err := e.inner.Open(identifier, typ, reliable)
+	return e.OpenContext(context.Background(), identifier, typ, reliable)
+}
+
+func (e *Endpoint) OpenContext(ctx context.Context, identifier Identifier, typ string, reliable bool) (*Channel, error) {
+	inner, err := e.inner.OpenContext(ctx, identifier, typ, reliable)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +276,11 @@ func (x *Exchange) RemoteIdentity() *Identity {
 }
 
 func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
-	inner, err := x.inner.Open(typ, reliable)
+	return x.OpenContext(context.Background(), typ, reliable)
+}
+
+func (x *Exchange) OpenContext(ctx context.Context, typ string, reliable bool) (*Channel, error) {
+	inner, err := x.inner.OpenContext(ctx, typ, reliable)
 	if err != nil {
 		return nil, err
 	}
@@ -134,7 +326,11 @@ func (c *Channel) Write(b []byte) (int, error) {
 }
 
 func (c *Channel) ReadPacket() (*Packet, error) {
-	inner, err := c.inner.ReadPacket()
+	return c.ReadPacketContext(context.Background())
+}
+
+func (c *Channel) ReadPacketContext(ctx context.Context) (*Packet, error) {
+	inner, err := c.inner.ReadPacketContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,124 @@
+package telehash
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestSeek(t *testing.T) {
+	assert := assert.New(t)
+
+	var endpoints []*Endpoint
+	for i := 0; i < 3; i++ {
+		e, err := Open(Transport(inproc.Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+		endpoints = append(endpoints, e)
+	}
+	A, R, P := endpoints[0], endpoints[1], endpoints[2]
+
+	r, err := R.LocalIdentity()
+	assert.NoError(err)
+	p, err := P.LocalIdentity()
+	assert.NoError(err)
+	_, err = R.Dial(p)
+	assert.NoError(err)
+	_, err = A.Dial(r)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	found, err := A.Seek(p.Hashname())
+	if assert.NoError(err) && assert.NotEmpty(found) {
+		assert.Equal(p.Hashname(), found[0])
+	}
+}
+
+func TestOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	// a configured DHT replaces the default one
+	e, err := Open(
+		Transport(inproc.Config{}),
+		Log(ioutil.Discard),
+		CipherSets(0x3a),
+		DHT(DHTConfig{K: 4}),
+		Keepalive(time.Minute),
+		MaxChannels(8))
+	if !assert.NoError(err) {
+		return
+	}
+	defer e.Close()
+
+	ident, err := e.LocalIdentity()
+	if assert.NoError(err) {
+		assert.Len(ident.inner.Keys(), 1)
+	}
+}
+
+type recordingSink struct {
+	mtx   sync.Mutex
+	infos map[string]int
+}
+
+func (s *recordingSink) Write(e *LogEntry) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if e.Level == LogInfo {
+		s.infos[e.Module]++
+	}
+	return nil
+}
+
+// takeInfos returns the number of info entries of module since the last call.
+func (s *recordingSink) takeInfos(module string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	n := s.infos[module]
+	delete(s.infos, module)
+	return n
+}
+
+func TestLogOptions(t *testing.T) {
+	defer logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	sink := &recordingSink{infos: map[string]int{}}
+
+	A, err := Open(Transport(inproc.Config{}), LogTo(sink))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	dial := func(options ...EndpointOption) {
+		options = append(options, Transport(inproc.Config{}), Log(ioutil.Discard))
+		B, err := Open(options...)
+		if !assert.NoError(err) {
+			return
+		}
+		defer B.Close()
+
+		ident, err := B.LocalIdentity()
+		assert.NoError(err)
+		_, err = A.Dial(ident)
+		assert.NoError(err)
+	}
+
+	// A logs the channels it opens
+	dial()
+	assert.True(sink.takeInfos("e3x") > 0)
+
+	// the e3x level is process wide; A drops its info entries as well
+	dial(ModuleLogLevel("e3x", LogWarn))
+	assert.Equal(0, sink.takeInfos("e3x"))
+}
@@ -0,0 +1,173 @@
+package telehash
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// ErrUnreliableConn is returned by NewConn for unreliable channels; a byte
+// stream can't tolerate lost packets.
+var ErrUnreliableConn = errors.New("telehash: Conn requires a reliable channel")
+
+// timeoutError is returned by a Conn once a deadline was reached. Unlike
+// e3x.ErrTimeout it satisfies net.Error.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return e3x.ErrTimeout.Error() }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Conn = (*Conn)(nil)
+
+// Conn is a byte stream over a reliable channel. It implements net.Conn so code
+// written against net.Conn can run over telehash without framing packets
+// itself:
+//
+//   - Read returns the bytes of the received packets in order. A packet which
+//     doesn't fit in the buffer passed to Read is returned by successive reads.
+//   - Write splits its buffer over as many packets as needed (see
+//     e3x.Channel.Write).
+//   - Read returns io.EOF once the remote end closed the channel and all the
+//     data was read.
+//
+// The channel delivers no more packets to a server until it answered the
+// initial packet. A Conn which reads the initial packet before it wrote any data
+// answers with an empty packet; empty packets carry no stream data and are
+// skipped by Read.
+type Conn struct {
+	c *Channel
+
+	rmtx sync.Mutex
+	rbuf []byte // the unread remainder of the last packet
+	rerr error  // returned once the channel ended
+
+	wmtx     sync.Mutex
+	answered uint32 // set (atomically) once a packet was written
+}
+
+// NewConn returns a Conn reading from and writing to c.
+func NewConn(c *Channel) (*Conn, error) {
+	if !c.inner.Reliable() {
+		return nil, ErrUnreliableConn
+	}
+	return &Conn{c: c}, nil
+}
+
+// Channel returns the channel underlying the stream.
+func (c *Conn) Channel() *Channel {
+	return c.c
+}
+
+// Read implements the net.Conn Read method.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rmtx.Lock()
+	defer c.rmtx.Unlock()
+
+	for len(c.rbuf) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+
+		pkt, err := c.c.inner.ReadPacket()
+		if err != nil {
+			if err == e3x.ErrTimeout {
+				return 0, timeoutError{}
+			}
+			// the channel ended; it won't deliver any more packets
+			c.rerr = err
+			return 0, err
+		}
+
+		// packets without a body are skipped
+		c.rbuf = pkt.Body(nil)
+		pkt.Free()
+
+		if err := c.answer(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// Write implements the net.Conn Write method. When an error occurs part of b may
+// have been written; the number of bytes written is returned along with the
+// error.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.wmtx.Lock()
+	defer c.wmtx.Unlock()
+
+	atomic.StoreUint32(&c.answered, 1)
+
+	var n int
+	for n < len(b) {
+		chunk := b[n:]
+		if len(chunk) > e3x.MaxMessageSize {
+			chunk = chunk[:e3x.MaxMessageSize]
+		}
+
+		m, err := c.c.inner.Write(chunk)
+		n += m
+		if err == e3x.ErrTimeout {
+			return n, timeoutError{}
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// answer writes an empty packet when nothing was written yet. It never waits
+// for Write; a blocked Write must not keep Read from reading (and acking) the
+// packets of the remote end.
+func (c *Conn) answer() error {
+	if !atomic.CompareAndSwapUint32(&c.answered, 0, 1) {
+		return nil
+	}
+
+	err := c.c.inner.WritePacket(lob.New(nil))
+	if err == e3x.ErrTimeout {
+		return timeoutError{}
+	}
+	return err
+}
+
+// Close implements the net.Conn Close method. It closes the channel; unread
+// data is discarded.
+func (c *Conn) Close() error {
+	return c.c.Close()
+}
+
+// LocalAddr returns the hashname of the local endpoint.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.c.LocalAddr()
+}
+
+// RemoteAddr returns the hashname of the remote endpoint.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.c.RemoteAddr()
+}
+
+// SetDeadline implements the net.Conn SetDeadline method.
+func (c *Conn) SetDeadline(d time.Time) error {
+	return c.c.SetDeadline(d)
+}
+
+// SetReadDeadline implements the net.Conn SetReadDeadline method.
+func (c *Conn) SetReadDeadline(d time.Time) error {
+	return c.c.SetReadDeadline(d)
+}
+
+// SetWriteDeadline implements the net.Conn SetWriteDeadline method.
+func (c *Conn) SetWriteDeadline(d time.Time) error {
+	return c.c.SetWriteDeadline(d)
+}
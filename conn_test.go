@@ -0,0 +1,197 @@
+package telehash
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestConn(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(e3x.Transport(inproc.Config{}), e3x.Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := e3x.Open(e3x.Transport(inproc.Config{}), e3x.Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	ident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := A.Open(ident, "unreliable", false)
+	if assert.NoError(err) {
+		_, err = NewConn(&Channel{c})
+		assert.Equal(ErrUnreliableConn, err)
+		c.Kill()
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 350) // spans several packets
+
+	l := B.Listen("stream", true)
+	defer l.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		c, err := l.AcceptChannel()
+		if !assert.NoError(err) {
+			received <- nil
+			return
+		}
+		conn, err := NewConn(&Channel{c})
+		if !assert.NoError(err) {
+			received <- nil
+			return
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		// short reads return the rest of a packet in successive reads
+		head := make([]byte, 7)
+		_, err = io.ReadFull(conn, head)
+		assert.NoError(err)
+
+		rest, err := ioutil.ReadAll(conn)
+		assert.NoError(err)
+
+		// the end of the stream is sticky
+		n, err := conn.Read(head)
+		assert.Equal(0, n)
+		assert.Equal(io.EOF, err)
+
+		assert.NoError(conn.Close())
+		received <- append(head, rest...)
+	}()
+
+	c, err = A.Open(ident, "stream", true)
+	if !assert.NoError(err) {
+		return
+	}
+	conn, err := NewConn(&Channel{c})
+	if !assert.NoError(err) {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	assert.Equal(B.LocalHashname(), conn.RemoteAddr())
+	assert.Equal(A.LocalHashname(), conn.LocalAddr())
+
+	n, err := conn.Write(data)
+	assert.NoError(err)
+	assert.Equal(len(data), n)
+	assert.NoError(conn.Close())
+
+	assert.Equal(string(data), string(<-received))
+}
+
+func TestConnBidirectional(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(e3x.Transport(inproc.Config{}), e3x.Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := e3x.Open(e3x.Transport(inproc.Config{}), e3x.Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	ident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	// much more than fits in the windows of both ends
+	data := bytes.Repeat([]byte("0123456789"), 200000)
+
+	// exchange writes data and reads as much while the write is in progress.
+	exchange := func(conn *Conn) []byte {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+		written := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(data)
+			written <- err
+		}()
+
+		buf := make([]byte, len(data))
+		_, err := io.ReadFull(conn, buf)
+		assert.NoError(err)
+		assert.NoError(<-written)
+		return buf
+	}
+
+	l := B.Listen("stream", true)
+	defer l.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		c, err := l.AcceptChannel()
+		if !assert.NoError(err) {
+			received <- nil
+			return
+		}
+		conn, err := NewConn(&Channel{c})
+		if !assert.NoError(err) {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		received <- exchange(conn)
+	}()
+
+	c, err := A.Open(ident, "stream", true)
+	if !assert.NoError(err) {
+		return
+	}
+	conn, err := NewConn(&Channel{c})
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+
+	assert.True(bytes.Equal(data, exchange(conn)), "A received other data")
+	assert.True(bytes.Equal(data, <-received), "B received other data")
+}
+
+func TestConnTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(e3x.Transport(inproc.Config{}), e3x.Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := e3x.Open(e3x.Transport(inproc.Config{}), e3x.Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	ident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := A.Open(ident, "stream", true)
+	if !assert.NoError(err) {
+		return
+	}
+	conn, err := NewConn(&Channel{c})
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 10))
+	if nerr, ok := err.(net.Error); assert.True(ok, "not a net.Error: %v", err) {
+		assert.True(nerr.Timeout())
+	}
+}
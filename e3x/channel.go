@@ -1,13 +1,17 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +32,77 @@ func (err UnreachableEndpointError) Error() string {
 
 var ErrTimeout = errors.New("e3x: deadline reached")
 
+// RemoteError is returned by ReadPacket when the remote end closed the channel
+// with an error. Handlers can pass a *RemoteError to Channel.Error to reject a
+// request with a structured error; all its fields are reconstructed on the
+// other end.
+//
+// On the wire the error is encoded in the headers of the final packet:
+//
+//	"err"        the message (always present)
+//	"err_code"   the code (omitted when empty)
+//	"err_detail" the detail as a JSON value (omitted when empty)
+type RemoteError struct {
+	Code    string
+	Message string
+	Detail  json.RawMessage
+}
+
+func (err *RemoteError) Error() string {
+	if err.Code != "" {
+		return "e3x: remote error: " + err.Code + ": " + err.Message
+	}
+	return "e3x: remote error: " + err.Message
+}
+
+func (err *RemoteError) setHeader(hdr *lob.Header) {
+	hdr.SetString("err", err.Message)
+	if err.Code != "" {
+		hdr.SetString("err_code", err.Code)
+	}
+	if len(err.Detail) > 0 {
+		hdr.Set("err_detail", err.Detail)
+	}
+}
+
+// remoteErrorFromHeader removes the error headers from hdr and returns the
+// error they describe, or nil when hdr has no "err" header.
+func remoteErrorFromHeader(hdr *lob.Header) *RemoteError {
+	msg, found := hdr.GetString("err")
+	if !found {
+		return nil
+	}
+
+	err := &RemoteError{Message: msg}
+	err.Code, _ = hdr.GetString("err_code")
+	if v, found := hdr.Get("err_detail"); found {
+		if detail, e := json.Marshal(v); e == nil {
+			err.Detail = detail
+		}
+	}
+
+	delete(hdr.Extra, "err")
+	delete(hdr.Extra, "err_code")
+	delete(hdr.Extra, "err_detail")
+	return err
+}
+
+// ErrPacketTooLarge is returned by WritePacket when the encoded packet (headers
+// and body) exceeds MaxPacketSize. The packet is not sent and the channel remains
+// usable. Large headers must be split by the application, for example by moving
+// them into the body of one or more packets.
+var ErrPacketTooLarge = errors.New("e3x: packet too large")
+
+// HeaderError is returned by ReadPacketHeader (along with the packet) when the
+// custom headers of a packet could not be decoded.
+type HeaderError struct {
+	Err error
+}
+
+func (err *HeaderError) Error() string {
+	return "e3x: invalid packet header: " + err.Err.Error()
+}
+
 type BrokenChannelError struct {
 	hn  hashname.H
 	typ string
@@ -38,12 +113,26 @@ func (err *BrokenChannelError) Error() string {
 	return fmt.Sprintf("e3x: broken channel (type=%s id=%d hashname=%s)", err.typ, err.id, err.hn)
 }
 
+// ErrPeerGone is returned by the pending and subsequent operations on a channel
+// when the exchange it belongs to was dropped (see Endpoint.DropExchange).
+var ErrPeerGone = errors.New("e3x: peer gone")
+
+// MaxPacketSize is the maximum size of an encoded channel packet, including the
+// ack header which is added when a packet is (re)sent. The miss list is left
+// out of packets it doesn't fit in; the remainder of a buffer is reserved for
+// the cipher set framing.
+const MaxPacketSize = 1200
+
 const (
 	cReadBufferSize  = 100
 	cWriteBufferSize = 100
-	earlyAdHocAck    = 50
+	earlyAdHocAck    = cMinCwnd // the acks must keep up with the smallest congestion window
 	cBlankSeq        = uint32(0)
 	cInitialSeq      = uint32(1)
+
+	// cAckSize is the largest encoded size of an ack-only packet without its
+	// miss list.
+	cAckSize = 2 + len(`{"c":4294967295,"ack":4294967295}`)
 )
 
 type Channel struct {
@@ -57,11 +146,14 @@ type Channel struct {
 	x            exchangeI
 	channelHooks ChannelHooks
 	serverside   bool
+	openedVia    *Pipe // the pipe the packet opening a serverside channel arrived on
 	id           uint32
 	typ          string
 	hashname     hashname.H
 	reliable     bool
+	unordered    bool
 	broken       bool
+	brokenErr    error // returned instead of a BrokenChannelError when set
 
 	oSeq         uint32 // highest seq in write stream
 	iBufferedSeq uint32 // highest buffered seq in read stream
@@ -73,6 +165,8 @@ type Channel struct {
 	deliveredEnd bool
 	receivedEnd  bool
 	readEnd      bool
+	remoteErr    *RemoteError // the error of the end packet that was read
+	receivedErr  *RemoteError // the error of the end packet that was received
 	needsResend  bool
 
 	openDeadlineReached  bool
@@ -82,6 +176,8 @@ type Channel struct {
 
 	readBuffer  readBufferSlice
 	writeBuffer map[uint32]*writeBufferEntry
+	rcvBudget   *receiveBudget
+	rcvStalled  uint32 // highest seq dropped because rcvBudget was exhausted
 
 	tOpenDeadline  *time.Timer
 	tCloseDeadline *time.Timer
@@ -89,6 +185,27 @@ type Channel struct {
 	tWriteDeadline *time.Timer
 	tResend        *time.Timer
 	tAcker         *time.Timer
+	tHeartbeat     *time.Timer
+	tKeepalive     *time.Timer
+
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	keepaliveInterval time.Duration
+	keepOpen          bool
+	lastSent          time.Time
+	lastRcvd          time.Time
+	rtt               rttEstimator
+	cwnd              congestionWindow
+	maxResends        int         // see MaxResends
+	priority          int         // DSCP value the packets are marked with
+	copyIDs           *nonceCache // ids of the SendN copies received so far
+	stats             ChannelStats
+	lingering         bool // closed but still registered (see ChannelLinger)
+
+	mtxWriteMsg   sync.Mutex // serializes the fragments of WriteMessage
+	mtxReadMsg    sync.Mutex // serializes ReadMessage and Read
+	readRemainder []byte     // the part of a message which Read didn't return yet
+	readPartial   []byte     // the fragments read before ReadMessage failed
 }
 
 type ChannelOption func(*Channel) error
@@ -97,19 +214,30 @@ type exchangeI interface {
 	deliverPacket(pkt *lob.Packet, dst *Pipe) error
 	RemoteIdentity() *Identity
 	getTID() tracer.ID
+	sampleRTT(d time.Duration)
+	countRetransmits(n int)
+	pathMTU() int
 }
 
 type readBufferEntry struct {
-	pkt *lob.Packet
-	seq uint32
-	end bool
+	pkt  *lob.Packet
+	seq  uint32
+	end  bool
+	err  *RemoteError
+	read bool // read out of order; kept until the gap before it is filled
+	size int  // bytes taken from the receive budget
 }
 
 type writeBufferEntry struct {
 	pkt        *lob.Packet
 	end        bool
+	sentAt     time.Time
 	lastResend time.Time
 	dst        *Pipe
+	size       int  // encoded size of pkt without the miss list (see packetSizeAt)
+	resends    int  // number of resends since the peer was last heard
+	backoff    int  // number of resends; the resend interval doubles with each
+	received   bool // the receiver reported the packet as buffered
 }
 
 func newChannel(
@@ -133,6 +261,7 @@ func newChannel(
 		iSeq:         cBlankSeq,
 		oAckedSeq:    cBlankSeq,
 		iAckedSeq:    cBlankSeq,
+		cwnd:         newCongestionWindow(),
 	}
 
 	c.cndRead = sync.NewCond(&c.mtx)
@@ -147,7 +276,7 @@ func newChannel(
 	c.tWriteDeadline.Stop()
 
 	if reliable {
-		c.tResend = time.AfterFunc(1*time.Second, c.resendLastPacket)
+		c.tResend = time.AfterFunc(1*time.Second, c.resendUnackedPackets)
 		c.tAcker = time.AfterFunc(10*time.Second, c.autoDeliverAck)
 	}
 
@@ -170,6 +299,9 @@ func registerExchange(x *Exchange) ChannelOption {
 	return func(c *Channel) error {
 		c.channelHooks = x.channelHooks
 		c.channelHooks.channel = c
+		c.rcvBudget = x.rcvBudget
+		c.rtt.min, c.rtt.max = x.rtoMin, x.rtoMax
+		c.maxResends = x.maxResends
 		return nil
 	}
 }
@@ -272,10 +404,21 @@ func (c *Channel) RemoteHashname() hashname.H {
 	return c.hashname
 }
 
+// Reliable returns true when the packets of the channel are acked and resent.
+func (c *Channel) Reliable() bool {
+	return c.reliable
+}
+
 func (c *Channel) RemoteIdentity() *Identity {
 	return c.x.RemoteIdentity()
 }
 
+// OpenedVia returns the pipe the packet which opened the channel arrived on,
+// or nil when the channel was opened by the local endpoint.
+func (c *Channel) OpenedVia() *Pipe {
+	return c.openedVia
+}
+
 func (c *Channel) Exchange() *Exchange {
 	if x, ok := c.x.(*Exchange); ok && x != nil {
 		return x
@@ -283,13 +426,20 @@ func (c *Channel) Exchange() *Exchange {
 	return nil
 }
 
-func (e *Endpoint) Open(i Identifier, typ string, reliable bool) (*Channel, error) {
-	x, err := e.Dial(i)
+func (e *Endpoint) Open(i Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	return e.OpenContext(context.Background(), i, typ, reliable, options...)
+}
+
+// OpenContext dials the peer identified by i and opens a channel (like Open).
+// Once ctx is done ctx.Err() is returned; the handshake continues in the
+// background.
+func (e *Endpoint) OpenContext(ctx context.Context, i Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	x, err := e.DialContext(ctx, i)
 	if err != nil {
 		return nil, err
 	}
 
-	return x.Open(typ, reliable)
+	return x.OpenContext(ctx, typ, reliable, options...)
 }
 
 func (c *Channel) WritePacket(pkt *lob.Packet) error {
@@ -320,6 +470,12 @@ func (c *Channel) WritePacketTo(pkt *lob.Packet, p *Pipe) error {
 }
 
 func (c *Channel) blockWrite() bool {
+	if c.broken || c.receivedErr != nil {
+		// Never block when the channel is torn down; the packets would
+		// never be acknowledged.
+		return false
+	}
+
 	if c.writeDeadlineReached {
 		// Never block when the write deadline is reached
 		return false
@@ -337,9 +493,9 @@ func (c *Channel) blockWrite() bool {
 		return true
 	}
 
-	if len(c.writeBuffer) >= cWriteBufferSize {
-		// When a channel filled its write buffer then
-		// all writes must be deferred.
+	if len(c.writeBuffer) >= c.sendWindow() {
+		// When a channel filled its write buffer (or its congestion
+		// window) then all writes must be deferred.
 		return true
 	}
 
@@ -347,6 +503,15 @@ func (c *Channel) blockWrite() bool {
 }
 
 func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
+	err := c.send(pkt, p)
+	if err == nil && !c.reliable {
+		pkt.Free()
+	}
+	return err
+}
+
+// send writes pkt like write but never frees it.
+func (c *Channel) send(pkt *lob.Packet, p *Pipe) error {
 	if pkt.TID == 0 {
 		pkt.TID = tracer.NewID()
 	}
@@ -354,8 +519,14 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 	if c.broken {
 		// When a channel is marked as broken the all writes
 		// must return a BrokenChannelError.
+		return c.traceWriteError(pkt, p, c.brokenError())
+	}
+
+	if hdr := pkt.Header(); c.receivedErr != nil && !(hdr.HasEnd && hdr.End) {
+		// When the remote end closed the channel with an error then all
+		// writes (except for the closing end packet) must return the error.
 		return c.traceWriteError(pkt, p,
-			&BrokenChannelError{c.hashname, c.typ, c.id})
+			c.receivedErr)
 	}
 
 	if c.writeDeadlineReached {
@@ -372,6 +543,11 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 			io.EOF)
 	}
 
+	size, err := c.checkPacketSize(pkt)
+	if err != nil {
+		return c.traceWriteError(pkt, p, err)
+	}
+
 	c.oSeq++
 	hdr := pkt.Header()
 	hdr.C, hdr.HasC = c.id, true
@@ -380,6 +556,7 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 	}
 	if !c.serverside && c.oSeq == cInitialSeq {
 		hdr.Type, hdr.HasType = c.typ, true
+		hdr.SetString(openNonceHeader, newOpenNonce())
 	}
 
 	end := hdr.HasEnd && hdr.End
@@ -389,19 +566,25 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 	}
 
 	if c.reliable {
-		if c.oSeq%30 == 0 || hdr.End {
-			c.applyAckHeaders(pkt)
+		// piggyback pending acks; this also allows the remote end to take
+		// accurate RTT samples.
+		if c.oSeq%30 == 0 || hdr.End || c.iSeq > c.iAckedSeq {
+			c.applyAckHeaders(pkt, size)
 		}
-		c.writeBuffer[c.oSeq] = &writeBufferEntry{pkt, end, time.Time{}, p}
+		c.writeBuffer[c.oSeq] = &writeBufferEntry{pkt: pkt, end: end, sentAt: time.Now(), dst: p, size: size}
 		c.needsResend = false
 	}
 
-	err := c.x.deliverPacket(pkt, p)
+	err = c.x.deliverPacket(pkt, p)
 	if err != nil {
 		return c.traceWriteError(pkt, p, err)
 	}
+	c.lastSent = time.Now()
+	c.stats.PacketsSent++
+	c.stats.BytesSent += uint64(pkt.BodyLen())
 	statChannelSndPkt.Add(1)
 	if pkt.Header().HasAck {
+		c.stats.AcksSent++
 		statChannelSndAckInline.Add(1)
 	}
 
@@ -411,20 +594,95 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 
 	c.traceWrite(pkt, p)
 
-	if !c.reliable {
-		pkt.Free()
+	return nil
+}
+
+// checkPacketSize verifies that pkt will fit in MaxPacketSize once the channel
+// headers are applied. It returns the size computed by packetSizeAt.
+func (c *Channel) checkPacketSize(pkt *lob.Packet) (int, error) {
+	return c.checkPacketSizeAt(pkt, c.oSeq+1)
+}
+
+// checkPacketSizeAt verifies that pkt will fit in MaxPacketSize once it is
+// written with seq.
+func (c *Channel) checkPacketSizeAt(pkt *lob.Packet, seq uint32) (int, error) {
+	n, err := c.packetSizeAt(pkt, seq)
+	if err != nil {
+		return 0, err
+	}
+	if n > MaxPacketSize {
+		return 0, ErrPacketTooLarge
 	}
+	return n, nil
+}
 
-	return nil
+// placeholderOpenNonce stands in for the nonce of the initial packet when its
+// size is computed; all nonces have the same length.
+var placeholderOpenNonce = strings.Repeat("0", 2*openNonceLen)
+
+// packetSizeAt returns the encoded size of pkt once it is written with seq. The
+// size of the packets of reliable channels includes an ack header with the
+// largest possible seq; the miss list is not included (see missListFits).
+func (c *Channel) packetSizeAt(pkt *lob.Packet, seq uint32) (int, error) {
+	var (
+		hdr = *pkt.Header()
+	)
+
+	hdr.C, hdr.HasC = c.id, true
+	if c.reliable {
+		hdr.Seq, hdr.HasSeq = seq, true
+		hdr.Ack, hdr.HasAck = math.MaxUint32, true
+		hdr.Miss, hdr.HasMiss = nil, false
+	}
+	if !c.serverside && seq == cInitialSeq {
+		hdr.Type, hdr.HasType = c.typ, true
+
+		// don't add the nonce to the headers of pkt
+		extra := make(map[string]interface{}, len(hdr.Extra)+1)
+		for k, v := range hdr.Extra {
+			extra[k] = v
+		}
+		hdr.Extra = extra
+		hdr.SetString(openNonceHeader, placeholderOpenNonce)
+	}
+
+	n, err := hdr.EncodedLen()
+	if err != nil {
+		return 0, err
+	}
+
+	// the length prefix, the header and the body
+	return 2 + n + pkt.BodyLen(), nil
+}
+
+// missListFits reports whether the miss list l can be added to a packet of
+// size bytes (see packetSizeAt) without exceeding MaxPacketSize.
+func missListFits(l []uint32, size int) bool {
+	hdr := lob.Header{Miss: l, HasMiss: true}
+	n, _ := hdr.EncodedLen() // {"miss":[...]}
+	return size+n-1 <= MaxPacketSize
 }
 
 func (c *Channel) ReadPacket() (*lob.Packet, error) {
+	return c.ReadPacketContext(context.Background())
+}
+
+// ReadPacketContext reads a packet (like ReadPacket). Once ctx is done a
+// blocked read returns ctx.Err(); the channel remains usable.
+func (c *Channel) ReadPacketContext(ctx context.Context) (*lob.Packet, error) {
 	if c == nil {
 		return nil, os.ErrInvalid
 	}
 
 	c.mtx.Lock()
+	if c.blockRead() {
+		defer wakeOnDone(ctx, &c.mtx, c.cndRead)()
+	}
 	for c.blockRead() {
+		if err := ctx.Err(); err != nil {
+			c.mtx.Unlock()
+			return nil, err
+		}
 		c.cndRead.Wait()
 	}
 
@@ -437,6 +695,24 @@ func (c *Channel) ReadPacket() (*lob.Packet, error) {
 	return pkt, err
 }
 
+// ReadPacketHeader reads a packet (like ReadPacket) and decodes its custom
+// headers into hdr (see lob.Header.Decode). Decoding is skipped when hdr is nil.
+//
+// When the headers can't be decoded into hdr the packet is still returned
+// along with a *HeaderError. This error is not fatal; the channel remains
+// usable and the body and raw headers of the packet are intact.
+func (c *Channel) ReadPacketHeader(hdr interface{}) (*lob.Packet, error) {
+	pkt, err := c.ReadPacket()
+	if err != nil || hdr == nil {
+		return pkt, err
+	}
+
+	if err := pkt.Header().Decode(hdr); err != nil {
+		return pkt, &HeaderError{err}
+	}
+	return pkt, nil
+}
+
 func (c *Channel) blockRead() bool {
 	if c.broken {
 		// When a channel is marked as broken the all reads
@@ -456,9 +732,10 @@ func (c *Channel) blockRead() bool {
 		return false
 	}
 
-	if c.serverside && c.oSeq == cBlankSeq && c.iSeq >= cInitialSeq {
+	if c.serverside && c.oSeq == cBlankSeq && c.iSeq >= cInitialSeq && c.receivedErr == nil {
 		// When a server channel read a packet but did not yet respond
-		// to the initial packet then subsequent reads must be deferred.
+		// to the initial packet then subsequent reads must be deferred
+		// (unless the remote end closed the channel with an error).
 		return true
 	}
 
@@ -468,8 +745,7 @@ func (c *Channel) blockRead() bool {
 		return true
 	}
 
-	rSeq := c.iSeq + 1
-	if len(c.readBuffer) == 0 || c.readBuffer[0].seq != rSeq {
+	if c.nextReadable() < 0 {
 		// Packet has not yet been received
 		// defer the read
 		return true
@@ -482,7 +758,7 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 	if c.broken {
 		// When a channel is marked as broken the all reads
 		// must return a BrokenChannelError.
-		return nil, &BrokenChannelError{c.hashname, c.typ, c.id}
+		return nil, c.brokenError()
 	}
 
 	if c.readDeadlineReached {
@@ -493,11 +769,15 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 
 	if c.readEnd {
 		// When a channel read a packet with the "end" header set
-		// then all subsequent reads must return io.EOF
+		// then all subsequent reads must return io.EOF (or the RemoteError
+		// when the remote end closed with an error)
+		if c.remoteErr != nil {
+			return nil, c.remoteErr
+		}
 		return nil, io.EOF
 	}
 
-	e := c.readBuffer[0]
+	e := c.readBuffer[c.nextReadable()]
 
 	{ // clean headers
 		h := e.pkt.Header()
@@ -512,32 +792,82 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 	if e.pkt.BodyLen() == 0 && e.pkt.Header().IsZero() && e.end {
 		// read empty `end` packet
 		c.readPacket()
+		if e.err != nil {
+			return nil, e.err
+		}
 		return nil, io.EOF
 	}
 
 	return e.pkt, nil
 }
 
-func (c *Channel) readPacket() {
-	rSeq := c.iSeq + 1
-	e := c.readBuffer[0]
+// Drain discards the packets which were received but not yet read and returns
+// the number of discarded packets. The channel remains open. Discarded packets
+// are acknowledged as if they were read; the final end packet (and on ordered
+// channels the packets which arrived after a missing packet) are kept.
+func (c *Channel) Drain() int {
+	if c == nil {
+		return 0
+	}
 
-	c.iSeq = rSeq
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.broken {
+		return 0
+	}
+
+	n := 0
+	for {
+		idx := c.nextReadable()
+		if idx < 0 || c.readBuffer[idx].end {
+			break
+		}
+
+		pkt := c.readBuffer[idx].pkt
+
+		c.readPacket()
+		pkt.Free()
+		n++
+	}
+
+	return n
+}
 
-	// remove entry
-	copy(c.readBuffer, c.readBuffer[1:])
-	c.readBuffer = c.readBuffer[:len(c.readBuffer)-1]
+func (c *Channel) readPacket() {
+	idx := c.nextReadable()
+	e := c.readBuffer[idx]
+	c.releaseEntry(e)
+
+	if e.seq != c.iSeq+1 {
+		// read out of order; the entry stays in the buffer (for acks, misses
+		// and duplicate detection) until the packets before it are read.
+		e.read = true
+		e.pkt = nil
+	} else {
+		c.popReadEntries()
+	}
 
 	if e.end {
 		c.deliverAck()
 		c.readEnd = true
+		c.remoteErr = e.err
+
+		// All blocked readers must observe the end of the channel. Readers
+		// that return io.EOF don't consume a packet and won't pass on a
+		// signal, so they must all be woken here.
+		c.cndRead.Broadcast()
 	}
 
 	if c.iSeq == cInitialSeq && !c.serverside {
 		c.unsetOpenDeadline()
 	}
 
-	c.maybeDeliverAdHocAck()
+	if c.awaitsResend() {
+		c.requestResend()
+	} else {
+		c.maybeDeliverAdHocAck()
+	}
 
 	if c.deliveredEnd && !c.blockClose() {
 		c.cndClose.Signal()
@@ -556,17 +886,51 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		errMissingSeq      = "missing seq"
 		errDuplicatePacket = "duplicate packet"
 		errFullBuffer      = "full buffer"
+		errOverBudget      = "receive budget exhausted"
+		errWrongChannel    = "wrong channel id"
 	)
 
 	c.mtx.Lock()
 
-	if c.broken {
+	if hdr := pkt.Header(); c.broken && !(c.lingering && hdr.HasAck && !hdr.HasSeq) {
+		// lingering channels still process late acks
 		c.mtx.Unlock()
 		c.traceDroppedPacket(pkt, errBrokenChannel)
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
 
+	if hdr := pkt.Header(); !hdr.HasC || hdr.C != c.id {
+		// drop: the seq and ack headers of a packet must only be interpreted
+		// in the sequence space of the channel the packet belongs to
+		c.mtx.Unlock()
+		c.traceDroppedPacket(pkt, errWrongChannel)
+		statChannelRcvPktDrop.Add(1)
+		return
+	}
+
+	c.lastRcvd = time.Now()
+	c.heardFromPeer()
+	if _, found := pkt.Header().Get(heartbeatHeader); found {
+		// heartbeats are never delivered
+		c.mtx.Unlock()
+		return
+	}
+
+	if id, found := pkt.Header().GetString(copyIDHeader); found && !c.reliable {
+		// only the first copy of a packet sent with SendN is delivered
+		delete(pkt.Header().Extra, copyIDHeader)
+		if c.copyIDs == nil {
+			c.copyIDs = newNonceCache(copyIDTTL, copyIDCacheSize)
+		}
+		if !c.copyIDs.add(id, c.lastRcvd) {
+			c.mtx.Unlock()
+			c.traceDroppedPacket(pkt, errDuplicatePacket)
+			statChannelRcvPktDrop.Add(1)
+			return
+		}
+	}
+
 	var (
 		hdr           = pkt.Header()
 		seq, hasSeq   = hdr.Seq, hdr.HasSeq
@@ -583,6 +947,7 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 	} else {
 		// determine what to drop from the write buffer
 		if hasAck {
+			c.stats.AcksReceived++
 			if hasSeq {
 				statChannelRcvAckInline.Add(1)
 			} else {
@@ -592,6 +957,8 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 			var (
 				oldAck  = c.oAckedSeq
 				changed bool
+				acked   int
+				rtt     time.Duration = -1
 			)
 
 			if c.oAckedSeq < ack {
@@ -601,25 +968,45 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 
 			for i := oldAck + 1; i <= ack; i++ {
 				if e := c.writeBuffer[i]; e != nil {
+					if e.lastResend.IsZero() {
+						// only packets which were sent once are sampled
+						rtt = time.Since(e.sentAt)
+					}
 					e.pkt.Free()
+					acked++
 				}
 				delete(c.writeBuffer, i)
 				changed = true
 			}
 
+			if acked > 0 {
+				c.cwnd.acked(acked)
+			} else if !hasSeq && ack == oldAck && len(c.writeBuffer) > 0 && c.cwnd.duplicateAck() {
+				c.fastRetransmit()
+			}
+
+			if rtt >= 0 {
+				c.rtt.sample(rtt)
+				c.x.sampleRTT(rtt)
+			}
+
 			if len(c.writeBuffer) == 0 {
 				c.needsResend = false
 			}
 
 			if changed {
-				c.cndWrite.Signal()
+				// wake all writers; a waiting batch may need more room than
+				// the first writer to wake up.
+				c.cndWrite.Broadcast()
 				if c.deliveredEnd || c.receivedEnd {
 					c.cndClose.Signal()
 				}
 			}
 
-			if hasMiss {
-				c.processMissingPackets(ack, miss)
+			if hasMiss && !c.processMissingPackets(ack, miss) {
+				c.mtx.Unlock()
+				c.breakWith(ErrResendLimit)
+				return
 			}
 		}
 	}
@@ -636,6 +1023,10 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		return
 	}
 
+	// a packet beyond the next expected one opens a gap, which ends up in the
+	// miss list of the next ack
+	gap := c.reliable && seq > c.iSeenSeq+1 && seq > c.iSeq+1
+
 	if c.reliable && c.iSeenSeq < seq {
 		// record highest seen seq
 		c.iSeenSeq = seq
@@ -643,6 +1034,7 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 
 	if seq <= c.iSeq {
 		// drop: the reader already read a packet with this seq
+		c.ackDropped()
 		c.mtx.Unlock()
 		c.traceDroppedPacket(pkt, errDuplicatePacket)
 		statChannelRcvPktDrop.Add(1)
@@ -659,12 +1051,40 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 
 	if c.readBuffer.IndexOf(seq) >= 0 {
 		// drop: a packet with this seq is already buffered
+		c.ackDropped()
 		c.mtx.Unlock()
 		c.traceDroppedPacket(pkt, errDuplicatePacket)
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
 
+	size := pkt.BodyLen()
+	if !c.rcvBudget.reserve(size, seq == c.iSeq+1) {
+		// drop: the endpoint buffers too many unread packets. The packet is
+		// not acked; the sender resends it once the reader caught up. The next
+		// packet in sequence is always accepted so every reader can progress.
+		if c.reliable && c.rcvStalled < seq {
+			c.rcvStalled = seq
+		}
+		c.ackDropped()
+		c.mtx.Unlock()
+		c.traceDroppedPacket(pkt, errOverBudget)
+		statChannelRcvPktDrop.Add(1)
+		statChannelRcvPktOverBudget.Add(1)
+		return
+	}
+
+	rerr := remoteErrorFromHeader(hdr)
+	if rerr != nil {
+		// an "err" packet always ends the channel
+		end, hasEnd = true, true
+
+		// the remote end stopped reading; wake the blocked writers and
+		// flushes.
+		c.receivedErr = rerr
+		c.cndWrite.Broadcast()
+	}
+
 	if c.iBufferedSeq < seq {
 		c.iBufferedSeq = seq
 	}
@@ -673,8 +1093,16 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		c.deliverAck()
 	}
 
-	c.readBuffer = append(c.readBuffer, &readBufferEntry{pkt, seq, end})
+	c.readBuffer = append(c.readBuffer, &readBufferEntry{pkt: pkt, seq: seq, end: end, err: rerr, size: size})
 	sort.Sort(c.readBuffer)
+	c.stats.PacketsReceived++
+	c.stats.BytesReceived += uint64(size)
+
+	if gap && c.iSeq >= cInitialSeq {
+		// report the new gap right away, the sender would wait for the rto
+		// otherwise
+		c.deliverAck()
+	}
 
 	c.cndRead.Signal()
 	c.mtx.Unlock()
@@ -687,18 +1115,32 @@ func (c *Channel) Errorf(format string, args ...interface{}) error {
 	return c.Error(fmt.Errorf(format, args...))
 }
 
+// Error closes the channel with err. When err is a *RemoteError its code and
+// detail are sent along with the message.
 func (c *Channel) Error(err error) error {
+	return c.ErrorWith(err, nil, nil)
+}
+
+// ErrorWith closes the channel with err (like Error). The error travels in a
+// final packet along with body and the custom headers taken from hdr (see
+// CloseWith).
+func (c *Channel) ErrorWith(err error, hdr interface{}, body []byte) error {
 	if c == nil {
 		return os.ErrInvalid
 	}
 
+	pkt, perr := finalPacket(hdr, body)
+	if perr != nil {
+		return perr
+	}
+
 	c.mtx.Lock()
 
 	if c.broken {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	for c.blockWrite() {
@@ -710,8 +1152,11 @@ func (c *Channel) Error(err error) error {
 		return nil
 	}
 
-	pkt := &lob.Packet{}
-	pkt.Header().SetString("err", err.Error())
+	if rerr, ok := err.(*RemoteError); ok {
+		rerr.setHeader(pkt.Header())
+	} else {
+		pkt.Header().SetString("err", err.Error())
+	}
 	if err := c.write(pkt, nil); err != nil {
 		c.mtx.Unlock()
 		return err
@@ -729,17 +1174,31 @@ func (c *Channel) Error(err error) error {
 }
 
 func (c *Channel) Close() error {
+	return c.CloseWith(nil, nil)
+}
+
+// CloseWith closes the channel (like Close) with a final packet carrying body
+// and the custom headers taken from hdr (see lob.Header.Encode); hdr may be
+// nil. The last message and the end of the channel travel together: the peer
+// reads the final packet before it reads io.EOF. Nothing is sent when hdr can't
+// be encoded.
+func (c *Channel) CloseWith(hdr interface{}, body []byte) error {
 	if c == nil {
 		return os.ErrInvalid
 	}
 
+	pkt, err := finalPacket(hdr, body)
+	if err != nil {
+		return err
+	}
+
 	c.mtx.Lock()
 
 	if c.broken {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	c.setCloseDeadline()
@@ -750,9 +1209,6 @@ func (c *Channel) Close() error {
 		}
 
 		if !c.deliveredEnd {
-			pkt := &lob.Packet{}
-			hdr := pkt.Header()
-			hdr.End, hdr.HasEnd = true, true
 			if err := c.write(pkt, nil); err != nil {
 				c.mtx.Unlock()
 				return err
@@ -768,7 +1224,7 @@ func (c *Channel) Close() error {
 		if pkt != nil {
 			c.readPacket()
 		}
-		if err == io.EOF {
+		if _, ok := err.(*RemoteError); ok || err == io.EOF {
 			break
 		}
 		if err != nil {
@@ -785,7 +1241,7 @@ func (c *Channel) Close() error {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	c.unsetTimers()
@@ -800,6 +1256,18 @@ func (c *Channel) Close() error {
 	return nil
 }
 
+// finalPacket returns the packet which ends a channel.
+func finalPacket(hdr interface{}, body []byte) (*lob.Packet, error) {
+	pkt := lob.New(body)
+	if hdr != nil {
+		if err := pkt.Header().Encode(hdr); err != nil {
+			return nil, err
+		}
+	}
+	pkt.Header().End, pkt.Header().HasEnd = true, true
+	return pkt, nil
+}
+
 func (c *Channel) blockClose() bool {
 	if c.broken {
 		return false
@@ -809,6 +1277,12 @@ func (c *Channel) blockClose() bool {
 		return true
 	}
 
+	if c.remoteErr != nil {
+		// The remote end closed with an error and will not
+		// acknowledge any outstanding packets.
+		return false
+	}
+
 	if c.reliable && len(c.writeBuffer) > 0 {
 		return true
 	}
@@ -848,6 +1322,9 @@ func (c *Channel) buildMissList() []uint32 {
 				goto ADD_HIGHEST_ACCEPTABLE_SEQ
 			}
 		}
+
+		// e itself is buffered
+		seq++
 	}
 
 	for seq <= c.iSeenSeq {
@@ -872,15 +1349,25 @@ ADD_HIGHEST_ACCEPTABLE_SEQ:
 	return miss
 }
 
-func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
+// processMissingPackets resends the packets the receiver reported missing in
+// the miss list of an ack. Each packet is resent with an exponential backoff
+// (see resendDue). It returns false when a packet exhausted its resends (see
+// MaxResends).
+func (c *Channel) processMissingPackets(ack uint32, miss []uint32) bool {
 	var (
-		omiss     = c.buildMissList()
-		now       = time.Now()
-		oneSecAgo = now.Add(-1 * time.Second)
-		last      = ack
+		omiss = c.buildMissList()
+		now   = time.Now()
+		last  = ack
 	)
 
-	for _, delta := range miss {
+	if len(miss) == 0 {
+		return true
+	}
+
+	c.markReceived(ack, miss)
+
+	// the last entry is the highest seq the receiver accepts
+	for _, delta := range miss[:len(miss)-1] {
 		seq := last + delta
 		last = seq
 
@@ -889,58 +1376,84 @@ func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
 			continue
 		}
 
-		if e.lastResend.After(oneSecAgo) {
+		if !c.resendDue(e, now) {
 			continue
 		}
 
-		hdr := e.pkt.Header()
-		if c.iSeq >= cInitialSeq {
-			hdr.Ack, hdr.HasAck = c.iSeq, true
+		if !c.prepareResend(e, omiss, now) {
+			return false
 		}
-		if len(omiss) > 0 {
-			hdr.Miss, hdr.HasMiss = omiss, true
-		}
-		e.lastResend = now
 
 		err := c.x.deliverPacket(e.pkt, e.dst)
 		if err == nil {
+			c.stats.PacketsRetransmitted++
+			c.x.countRetransmits(1)
 			statChannelSndPkt.Add(1)
+			statChannelSndPktResend.Add(1)
 		}
+
+		// the receiver reported a loss
+		c.cwnd.lost(c.oAckedSeq, c.oSeq)
 	}
+
+	return true
 }
 
-func (c *Channel) resendLastPacket() {
+// resendUnackedPackets is called every rto. When no packets were sent since
+// the last call the unacked packets are resent, oldest first; the oldest
+// unacked packet blocks the contiguous delivery at the receiver. Packets which
+// the receiver reported as buffered and packets which are still backing off
+// (see resendDue) are skipped. The rto is doubled every time packets are
+// resent, until the next round-trip time sample. The channel breaks with
+// ErrResendLimit when a packet exhausted its resends.
+func (c *Channel) resendUnackedPackets() {
 	c.mtx.Lock()
 
 	var needsResend bool
 	needsResend, c.needsResend = c.needsResend, true
-	c.tResend.Reset(1 * time.Second)
 
 	if !needsResend {
+		c.tResend.Reset(c.rtt.rto())
 		c.mtx.Unlock()
 		return
 	}
 
-	e := c.writeBuffer[c.oSeq]
-	if e == nil {
-		c.mtx.Unlock()
-		return
-	}
+	var (
+		omiss  = c.buildMissList()
+		now    = time.Now()
+		resend []*writeBufferEntry
+	)
 
-	omiss := c.buildMissList()
-	hdr := e.pkt.Header()
-	if c.iSeq >= cInitialSeq {
-		hdr.Ack, hdr.HasAck = c.iSeq, true
+	for seq := c.oAckedSeq + 1; seq <= c.oSeq; seq++ {
+		e := c.writeBuffer[seq]
+		if e == nil || !c.resendDue(e, now) {
+			continue
+		}
+
+		if !c.prepareResend(e, omiss, now) {
+			c.mtx.Unlock()
+			c.breakWith(ErrResendLimit)
+			return
+		}
+		resend = append(resend, e)
 	}
-	if len(omiss) > 0 {
-		hdr.Miss, hdr.HasMiss = omiss, true
+
+	// back off while the resent packets remain unacked
+	if len(resend) > 0 {
+		c.rtt.timedOut()
+		c.cwnd.timedOut(c.oSeq)
+		c.stats.PacketsRetransmitted += uint64(len(resend))
+		c.x.countRetransmits(len(resend))
+		statChannelSndPktResend.Add(int64(len(resend)))
 	}
-	e.lastResend = time.Now()
+	c.tResend.Reset(c.rtt.rto())
 	c.mtx.Unlock()
 
-	err := c.x.deliverPacket(e.pkt, e.dst)
-	if err == nil {
-		statChannelSndPkt.Add(1)
+	for _, e := range resend {
+		err := c.x.deliverPacket(e.pkt, e.dst)
+		if err == nil {
+			statChannelSndPkt.Add(1)
+		}
 	}
 }
 
@@ -962,6 +1475,11 @@ func (c *Channel) autoDeliverAck() {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	if c.awaitsResend() {
+		c.requestResend()
+		return
+	}
+
 	c.deliverAck()
 	c.tAcker.Reset(10 * time.Second)
 }
@@ -974,14 +1492,18 @@ func (c *Channel) deliverAck() {
 	pkt := &lob.Packet{}
 	hdr := pkt.Header()
 	hdr.C, hdr.HasC = c.id, true
-	c.applyAckHeaders(pkt)
+	c.applyAckHeaders(pkt, cAckSize)
 	err := c.x.deliverPacket(pkt, nil)
 	if err == nil {
+		c.lastSent = time.Now()
+		c.stats.AcksSent++
 		statChannelSndAckAdHoc.Add(1)
 	}
 }
 
-func (c *Channel) applyAckHeaders(pkt *lob.Packet) {
+// applyAckHeaders sets the ack and miss headers of pkt, which is size bytes
+// large (see packetSizeAt). The miss list is left out when it doesn't fit.
+func (c *Channel) applyAckHeaders(pkt *lob.Packet, size int) {
 	if !c.reliable {
 		return
 	}
@@ -995,7 +1517,8 @@ func (c *Channel) applyAckHeaders(pkt *lob.Packet) {
 	if c.iSeq >= cInitialSeq {
 		hdr.Ack, hdr.HasAck = c.iSeq, true
 	}
-	if l := c.buildMissList(); len(l) > 0 {
+	hdr.Miss, hdr.HasMiss = nil, false
+	if l := c.buildMissList(); len(l) > 0 && missListFits(l, size) {
 		hdr.Miss, hdr.HasMiss = l, true
 	}
 
@@ -1016,14 +1539,21 @@ func (c *Channel) setCloseDeadline() {
 }
 
 func (c *Channel) onCloseDeadlineReached() {
+	c.breakWith(nil)
+}
+
+// breakWith breaks the channel. The pending and subsequent operations return
+// err or a BrokenChannelError when err is nil.
+func (c *Channel) breakWith(err error) {
 	c.mtx.Lock()
 
-	if c.broken {
+	if c.broken || c.lingering {
 		c.mtx.Unlock()
 		return
 	}
 
 	c.broken = true
+	c.brokenErr = err
 	c.closeDeadlineReached = true
 	c.unsetOpenDeadline()
 	c.unsetCloseDeadline()
@@ -1039,6 +1569,15 @@ func (c *Channel) onCloseDeadlineReached() {
 	c.channelHooks.Closed()
 }
 
+// brokenError returns the error of the operations on a broken channel. Must be
+// called with c.mtx held.
+func (c *Channel) brokenError() error {
+	if c.brokenErr != nil {
+		return c.brokenErr
+	}
+	return &BrokenChannelError{c.hashname, c.typ, c.id}
+}
+
 func (c *Channel) setOpenDeadline() {
 	if c.tOpenDeadline == nil {
 		if c.openDeadlineReached {
@@ -1059,6 +1598,8 @@ func (c *Channel) unsetTimers() {
 	c.unsetWriteDeadline()
 	c.unsetResender()
 	c.unsetAcker()
+	c.unsetHeartbeat()
+	c.unsetKeepalive()
 }
 
 func (c *Channel) unsetReadDeadline() {
@@ -1141,34 +1682,30 @@ func (c *Channel) Kill() {
 	c.channelHooks.Closed()
 }
 
-// Read implements the net.Conn Read method.
+// Read implements the net.Conn Read method. It reads the messages of the
+// channel (see ReadMessage); the part of a message which doesn't fit in b is
+// returned by the next Read.
 func (c *Channel) Read(b []byte) (int, error) {
-	pkt, err := c.ReadPacket()
-	if err != nil {
-		return 0, err
-	}
+	c.mtxReadMsg.Lock()
+	defer c.mtxReadMsg.Unlock()
 
-	n := len(pkt.Body(b[:0]))
-	if len(b) < n {
-		return 0, io.ErrShortBuffer
+	if len(c.readRemainder) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.readRemainder = msg
 	}
 
-	pkt.Free()
-
+	n := copy(b, c.readRemainder)
+	c.readRemainder = c.readRemainder[n:]
 	return n, nil
 }
 
-// Write implements the net.Conn Write method.
+// Write implements the net.Conn Write method. b is written as a message (see
+// WriteMessage).
 func (c *Channel) Write(b []byte) (int, error) {
-	n := len(b)
-	pkt := lob.New(b)
-
-	err := c.WritePacket(pkt)
-	if err != nil {
-		return 0, err
-	}
-
-	return n, nil
+	return c.writeMessage(b)
 }
 
 // SetDeadline implements the net.Conn SetDeadline method.
@@ -1291,7 +1828,7 @@ func (s readBufferSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s readBufferSlice) IndexOf(seq uint32) int {
 	l := len(s)
 	idx := sort.Search(l, func(i int) bool { return s[i].seq >= seq })
-	if idx == l {
+	if idx == l || s[idx].seq != seq {
 		return -1
 	}
 	return idx
@@ -0,0 +1,102 @@
+package e3x
+
+import (
+	"errors"
+	"os"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// ErrInvalidBatch is returned by WriteBatch when the batch can't be written as
+// a whole: it holds more packets than fit in the write buffer, a packet other
+// than the last one ends the channel, or the batch would be the first write of
+// a client channel (which must send its open packet on its own).
+var ErrInvalidBatch = errors.New("e3x: invalid batch")
+
+// WriteBatch writes pkts as consecutive packets on the channel. Writes from
+// other goroutines are never interleaved with the batch: WriteBatch waits
+// until the write buffer has room for all the packets and then writes them
+// without releasing the channel.
+//
+// When WriteBatch fails before the first packet is written none of the packets
+// are written (and no sequence numbers are allocated). Once the first packet
+// is written all packets are written; the first error is returned.
+func (c *Channel) WriteBatch(pkts []*lob.Packet) error {
+	if c == nil {
+		return os.ErrInvalid
+	}
+
+	if len(pkts) == 0 {
+		return nil
+	}
+	if len(pkts) > cWriteBufferSize {
+		return ErrInvalidBatch
+	}
+	for _, pkt := range pkts[:len(pkts)-1] {
+		if hdr := pkt.Header(); hdr.HasEnd && hdr.End {
+			return ErrInvalidBatch
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.serverside && c.oSeq == cBlankSeq && len(pkts) > 1 {
+		return ErrInvalidBatch
+	}
+
+	for c.blockBatch(len(pkts)) {
+		c.cndWrite.Wait()
+	}
+
+	// check all packets before any of them are written
+	seq := c.oSeq
+	for _, pkt := range pkts {
+		seq++
+		if _, err := c.checkPacketSizeAt(pkt, seq); err != nil {
+			return c.traceWriteError(pkt, nil, err)
+		}
+	}
+
+	var (
+		start    = c.oSeq
+		firstErr error
+	)
+	for _, pkt := range pkts {
+		err := c.write(pkt, nil)
+		if err != nil && c.oSeq == start {
+			// nothing was written
+			return err
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if !c.blockWrite() {
+		c.cndWrite.Signal()
+	}
+	if !c.blockRead() {
+		c.cndRead.Signal()
+	}
+
+	return firstErr
+}
+
+// blockBatch returns true when a batch of n packets must wait before it is
+// written.
+func (c *Channel) blockBatch(n int) bool {
+	if c.blockWrite() {
+		return true
+	}
+
+	if c.reliable && !c.writeDeadlineReached && !c.broken && c.receivedErr == nil &&
+		len(c.writeBuffer) > 0 && len(c.writeBuffer)+n > c.sendWindow() {
+		// wait until the whole batch fits in the write buffer (or the
+		// congestion window); a batch larger than the congestion window is
+		// written once all packets are acked.
+		return true
+	}
+
+	return false
+}
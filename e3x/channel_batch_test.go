@@ -0,0 +1,111 @@
+package e3x
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestWriteBatchIsNotInterleaved(t *testing.T) {
+	logs.ResetLogger()
+
+	const (
+		senders   = 8
+		batches   = 10
+		batchSize = 7
+	)
+
+	var (
+		assert = assert.New(t)
+		bodies = make(chan []string, 1)
+	)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	go func() {
+		var l []string
+		defer func() { bodies <- l }()
+
+		c, err := A.Listen("batch", true).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+		c.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+		_, err = c.ReadPacket()
+		assert.NoError(err)
+		assert.NoError(c.WritePacket(&lob.Packet{}))
+
+		for len(l) < senders*batches*batchSize {
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			l = append(l, string(pkt.Body(nil)))
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	c, err := B.Open(ident, "batch", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	// only the open packet may be written before the channel is answered
+	assert.Equal(ErrInvalidBatch, c.WriteBatch([]*lob.Packet{{}, {}}))
+	assert.NoError(c.WriteBatch([]*lob.Packet{{}}))
+	_, err = c.ReadPacket()
+	assert.NoError(err)
+
+	end := &lob.Packet{}
+	end.Header().End, end.Header().HasEnd = true, true
+	assert.Equal(ErrInvalidBatch, c.WriteBatch([]*lob.Packet{end, {}}))
+	assert.Equal(ErrInvalidBatch, c.WriteBatch(make([]*lob.Packet, cWriteBufferSize+1)))
+
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				var pkts []*lob.Packet
+				for i := 0; i < batchSize; i++ {
+					pkts = append(pkts, lob.New([]byte(fmt.Sprintf("%d/%d/%d", s, b, i))))
+				}
+				assert.NoError(c.WriteBatch(pkts))
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	l := <-bodies
+	if !assert.Equal(senders*batches*batchSize, len(l)) {
+		return
+	}
+	for i := 0; i < len(l); i += batchSize {
+		var s, b int
+		fmt.Sscanf(l[i], "%d/%d/0", &s, &b)
+		for j := 0; j < batchSize; j++ {
+			assert.Equal(fmt.Sprintf("%d/%d/%d", s, b, j), l[i+j])
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package e3x
+
+import (
+	"time"
+)
+
+const (
+	// cInitialCwnd is the congestion window of a new reliable channel (in
+	// packets).
+	cInitialCwnd = 10
+
+	// cMinCwnd is the smallest congestion window; the window collapses to it
+	// after a retransmission timeout.
+	cMinCwnd = 2
+
+	// cDupAckThreshold is the number of duplicate acks after which the oldest
+	// unacked packet is resent without waiting for the rto.
+	cDupAckThreshold = 3
+)
+
+// congestionWindow limits the number of unacked packets of a reliable channel
+// with additive increase and multiplicative decrease (like TCP Reno). The
+// window grows by one packet for every acked packet until it reaches ssthresh
+// (slow start) and by about one packet per round trip afterwards. It is halved
+// once for every window in which packets were lost and collapses to cMinCwnd
+// after a retransmission timeout. The window never exceeds cWriteBufferSize.
+type congestionWindow struct {
+	cwnd     float64 // in packets
+	ssthresh float64
+	dupAcks  int    // number of consecutive duplicate acks
+	recover  uint32 // the losses of the packets up to recover were handled
+}
+
+func newCongestionWindow() congestionWindow {
+	return congestionWindow{cwnd: cInitialCwnd, ssthresh: cWriteBufferSize}
+}
+
+// size returns the window in packets.
+func (w *congestionWindow) size() int {
+	n := int(w.cwnd)
+	if n > cWriteBufferSize {
+		n = cWriteBufferSize
+	}
+	return n
+}
+
+// acked grows the window for n newly acked packets.
+func (w *congestionWindow) acked(n int) {
+	w.dupAcks = 0
+
+	for ; n > 0; n-- {
+		if w.cwnd < w.ssthresh {
+			w.cwnd++
+		} else {
+			w.cwnd += 1 / w.cwnd
+		}
+	}
+
+	if w.cwnd > cWriteBufferSize {
+		w.cwnd = cWriteBufferSize
+	}
+}
+
+// duplicateAck records an ack which didn't ack new packets while packets were
+// outstanding. It returns true when the oldest unacked packet must be resent.
+func (w *congestionWindow) duplicateAck() bool {
+	w.dupAcks++
+	return w.dupAcks == cDupAckThreshold
+}
+
+// lost halves the window after a loss was detected. Only the first loss
+// detected while the packets up to oSeq are in flight shrinks the window;
+// acked is the highest acked seq. It returns true when the window shrunk.
+func (w *congestionWindow) lost(acked, oSeq uint32) bool {
+	if acked < w.recover {
+		return false
+	}
+
+	w.recover = oSeq
+	w.ssthresh = w.cwnd / 2
+	if w.ssthresh < cMinCwnd {
+		w.ssthresh = cMinCwnd
+	}
+	w.cwnd = w.ssthresh
+	return true
+}
+
+// timedOut collapses the window after a retransmission timeout.
+func (w *congestionWindow) timedOut(oSeq uint32) {
+	w.recover = oSeq
+	w.dupAcks = 0
+	w.ssthresh = w.cwnd / 2
+	if w.ssthresh < cMinCwnd {
+		w.ssthresh = cMinCwnd
+	}
+	w.cwnd = cMinCwnd
+}
+
+// sendWindow returns the maximum number of unacked packets; c.mtx must be held.
+func (c *Channel) sendWindow() int {
+	if !c.reliable {
+		return cWriteBufferSize
+	}
+	return c.cwnd.size()
+}
+
+// CongestionWindow returns the number of packets the channel may have in
+// flight (unacked) at once. It adapts to the acks and the losses observed on
+// the channel.
+func (c *Channel) CongestionWindow() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.sendWindow()
+}
+
+// fastRetransmit resends the oldest unacked packet and shrinks the window;
+// c.mtx must be held.
+func (c *Channel) fastRetransmit() {
+	e := c.writeBuffer[c.oAckedSeq+1]
+	if e == nil {
+		return
+	}
+
+	if e.received || !c.prepareResend(e, c.buildMissList(), time.Now()) {
+		// the resend limit is enforced by the rto
+		return
+	}
+	c.cwnd.lost(c.oAckedSeq, c.oSeq)
+
+	err := c.x.deliverPacket(e.pkt, e.dst)
+	if err == nil {
+		c.stats.PacketsRetransmitted++
+		c.stats.FastRetransmits++
+		c.x.countRetransmits(1)
+		statChannelSndPkt.Add(1)
+		statChannelSndPktResend.Add(1)
+	}
+}
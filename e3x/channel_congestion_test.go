@@ -0,0 +1,97 @@
+package e3x
+
+import (
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+func TestCongestionWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	w := newCongestionWindow()
+	assert.Equal(cInitialCwnd, w.size())
+
+	// slow start
+	w.acked(cInitialCwnd)
+	assert.Equal(2*cInitialCwnd, w.size())
+
+	// the window is halved once per window of lost packets
+	assert.True(w.lost(10, 30))
+	assert.Equal(cInitialCwnd, w.size())
+	assert.False(w.lost(20, 30))
+	assert.Equal(cInitialCwnd, w.size())
+
+	// congestion avoidance grows the window by about a packet per window
+	w.acked(cInitialCwnd + 1)
+	assert.Equal(cInitialCwnd+1, w.size())
+
+	assert.True(w.lost(30, 40))
+	assert.Equal((cInitialCwnd+1)/2, w.size())
+
+	w.timedOut(50)
+	assert.Equal(cMinCwnd, w.size())
+
+	// the window never exceeds the write buffer
+	w = newCongestionWindow()
+	w.acked(10 * cWriteBufferSize)
+	assert.Equal(cWriteBufferSize, w.size())
+}
+
+func TestFastRetransmit(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	x := &MockExchange{}
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	defer c.Kill()
+
+	open := lob.New(nil)
+	open.Header().C, open.Header().HasC = 3, true
+	open.Header().Seq, open.Header().HasSeq = 1, true
+	c.receivedPacket(open)
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	}
+
+	ack := func(seq uint32) {
+		pkt := &lob.Packet{}
+		pkt.Header().C, pkt.Header().HasC = 3, true
+		pkt.Header().Ack, pkt.Header().HasAck = seq, true
+		c.receivedPacket(pkt)
+	}
+
+	// the second packet is lost
+	ack(1)
+	assert.Equal(cInitialCwnd+1, c.CongestionWindow())
+	for i := 0; i < cDupAckThreshold; i++ {
+		ack(1)
+	}
+
+	s := c.Stats()
+	assert.Equal(uint64(1), s.FastRetransmits)
+	assert.Equal(uint64(1), s.PacketsRetransmitted)
+	assert.Equal((cInitialCwnd+1)/2, s.CongestionWindow)
+	assert.Equal(4, s.Outstanding)
+
+	// further duplicates don't resend the packet again
+	ack(1)
+	assert.Equal(uint64(1), c.Stats().FastRetransmits)
+
+	ack(5)
+	s = c.Stats()
+	assert.Equal(0, s.Outstanding)
+	assert.Equal(s.CongestionWindow, s.Window)
+}
@@ -0,0 +1,154 @@
+package e3x
+
+import (
+	"sort"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// ChannelDebugState is a snapshot of the internal state of a channel. It is
+// meant for diagnosing reliability problems and can be marshaled to JSON for
+// offline analysis.
+type ChannelDebugState struct {
+	ID         uint32
+	Type       string
+	Hashname   hashname.H
+	Reliable   bool
+	Unordered  bool
+	Serverside bool
+	Broken     bool
+
+	// the write stream
+	WriteSeq      uint32 // highest seq written
+	WriteAckedSeq uint32 // highest seq acked by the peer
+	WriteBuffer   []WriteBufferState
+	NeedsResend   bool
+
+	// the read stream
+	ReadSeq         uint32 // highest seq read in order
+	ReadBufferedSeq uint32 // highest seq buffered
+	ReadSeenSeq     uint32 // highest seq seen
+	ReadAckedSeq    uint32 // highest seq acked to the peer
+	ReadBuffer      []ReadBufferState
+	Missing         []uint32 // seqs up to ReadSeenSeq which were not received
+	ReceiveStalled  uint32   // highest seq dropped due to the receive buffer limit
+
+	DeliveredEnd bool
+	ReceivedEnd  bool
+	ReadEnd      bool
+
+	// timing
+	LastSent time.Time
+	LastRcvd time.Time
+	SRTT     time.Duration
+	RTTVar   time.Duration
+	RTO      time.Duration
+}
+
+// WriteBufferState describes a packet which was written but not yet dropped
+// from the write buffer.
+type WriteBufferState struct {
+	Seq        uint32
+	BodyLen    int
+	End        bool
+	Acked      bool
+	SentAt     time.Time
+	LastResend time.Time
+}
+
+// ReadBufferState describes a packet which was received but not yet dropped
+// from the read buffer.
+type ReadBufferState struct {
+	Seq     uint32
+	BodyLen int
+	End     bool
+	Read    bool
+}
+
+// DebugDump returns a consistent snapshot of the internal state of the
+// channel (taken while holding the channel lock).
+func (c *Channel) DebugDump() ChannelDebugState {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s := ChannelDebugState{
+		ID:         c.id,
+		Type:       c.typ,
+		Hashname:   c.hashname,
+		Reliable:   c.reliable,
+		Unordered:  c.unordered,
+		Serverside: c.serverside,
+		Broken:     c.broken,
+
+		WriteSeq:      c.oSeq,
+		WriteAckedSeq: c.oAckedSeq,
+		NeedsResend:   c.needsResend,
+
+		ReadSeq:         c.iSeq,
+		ReadBufferedSeq: c.iBufferedSeq,
+		ReadSeenSeq:     c.iSeenSeq,
+		ReadAckedSeq:    c.iAckedSeq,
+		ReceiveStalled:  c.rcvStalled,
+
+		DeliveredEnd: c.deliveredEnd,
+		ReceivedEnd:  c.receivedEnd,
+		ReadEnd:      c.readEnd,
+
+		LastSent: c.lastSent,
+		LastRcvd: c.lastRcvd,
+		SRTT:     c.rtt.srtt,
+		RTTVar:   c.rtt.rttvar,
+		RTO:      c.rtt.rto(),
+	}
+
+	for seq, e := range c.writeBuffer {
+		if e == nil {
+			continue
+		}
+		s.WriteBuffer = append(s.WriteBuffer, WriteBufferState{
+			Seq:        seq,
+			BodyLen:    bodyLen(e.pkt),
+			End:        e.end,
+			Acked:      seq <= c.oAckedSeq,
+			SentAt:     e.sentAt,
+			LastResend: e.lastResend,
+		})
+	}
+	sort.Sort(writeBufferStatesBySeq(s.WriteBuffer))
+
+	for _, e := range c.readBuffer {
+		s.ReadBuffer = append(s.ReadBuffer, ReadBufferState{
+			Seq:     e.seq,
+			BodyLen: bodyLen(e.pkt),
+			End:     e.end,
+			Read:    e.read,
+		})
+	}
+
+	buffered := make(map[uint32]bool, len(c.readBuffer))
+	for _, e := range c.readBuffer {
+		buffered[e.seq] = true
+	}
+	for seq := c.iSeq + 1; seq <= c.iSeenSeq; seq++ {
+		if !buffered[seq] {
+			s.Missing = append(s.Missing, seq)
+		}
+	}
+
+	return s
+}
+
+func bodyLen(pkt *lob.Packet) int {
+	if pkt == nil {
+		return 0
+	}
+	return pkt.BodyLen()
+}
+
+type writeBufferStatesBySeq []WriteBufferState
+
+func (s writeBufferStatesBySeq) Len() int           { return len(s) }
+func (s writeBufferStatesBySeq) Less(i, j int) bool { return s[i].Seq < s[j].Seq }
+func (s writeBufferStatesBySeq) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
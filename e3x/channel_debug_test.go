@@ -0,0 +1,79 @@
+package e3x
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+func TestChannelDebugDump(t *testing.T) {
+	logs.ResetLogger()
+
+	var (
+		assert = assert.New(t)
+		x      = &MockExchange{}
+	)
+
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	defer c.Kill()
+
+	received := func(seq uint32, ack uint32, body string) {
+		pkt := lob.New([]byte(body))
+		hdr := pkt.Header()
+		hdr.C, hdr.HasC = 3, true
+		hdr.Seq, hdr.HasSeq = seq, true
+		if ack > 0 {
+			hdr.Ack, hdr.HasAck = ack, true
+		}
+		c.receivedPacket(pkt)
+	}
+
+	received(1, 0, "open")
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	}
+
+	// seq 2 is lost; seq 3 acks the first written packet
+	received(3, 2, "late")
+
+	s := c.DebugDump()
+	assert.Equal(uint32(3), s.ID)
+	assert.Equal("test", s.Type)
+	assert.True(s.Reliable)
+
+	assert.Equal(uint32(3), s.WriteSeq)
+	assert.Equal(uint32(2), s.WriteAckedSeq)
+	if assert.Len(s.WriteBuffer, 1) {
+		assert.Equal(uint32(3), s.WriteBuffer[0].Seq)
+		assert.Equal(4, s.WriteBuffer[0].BodyLen)
+		assert.False(s.WriteBuffer[0].Acked)
+		assert.False(s.WriteBuffer[0].SentAt.IsZero())
+	}
+
+	assert.Equal(uint32(1), s.ReadSeq)
+	assert.Equal(uint32(3), s.ReadSeenSeq)
+	if assert.Len(s.ReadBuffer, 1) {
+		assert.Equal(uint32(3), s.ReadBuffer[0].Seq)
+		assert.Equal(4, s.ReadBuffer[0].BodyLen)
+		assert.False(s.ReadBuffer[0].Read)
+	}
+	if assert.Len(s.Missing, 1) {
+		assert.Equal(uint32(2), s.Missing[0])
+	}
+	assert.True(s.RTO > 0)
+
+	_, err = json.Marshal(s)
+	assert.NoError(err)
+}
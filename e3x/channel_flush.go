@@ -0,0 +1,51 @@
+package e3x
+
+import (
+	"context"
+	"os"
+)
+
+// Flush blocks until every packet written on the channel before Flush was
+// called is acknowledged by the peer, or until ctx is done (in which case the
+// error of ctx is returned). The peer acknowledges packets once they are read,
+// so a successful Flush means the peer has read everything written so far.
+//
+// Flush returns a BrokenChannelError as soon as the channel is torn down (for
+// example when it is killed or when the exchange breaks), ErrPeerGone when the
+// exchange is dropped (see Endpoint.DropExchange) and the *RemoteError when
+// the remote end closed the channel with an error, as the outstanding packets
+// will never be acknowledged in any of these cases.
+//
+// Flush returns immediately on unreliable channels as their packets are never
+// acknowledged.
+func (c *Channel) Flush(ctx context.Context) error {
+	if c == nil {
+		return os.ErrInvalid
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.reliable {
+		return nil
+	}
+
+	target := c.oSeq
+	defer wakeOnDone(ctx, &c.mtx, c.cndWrite)()
+
+	for {
+		if c.broken {
+			return c.brokenError()
+		}
+		if c.receivedErr != nil {
+			return c.receivedErr
+		}
+		if c.oAckedSeq >= target {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.cndWrite.Wait()
+	}
+}
@@ -0,0 +1,176 @@
+package e3x
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+func TestFlush(t *testing.T) {
+	logs.ResetLogger()
+
+	var (
+		assert = assert.New(t)
+		x      = &MockExchange{}
+	)
+
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	defer c.Kill()
+
+	open := lob.New(nil)
+	open.Header().C, open.Header().HasC = 3, true
+	open.Header().Seq, open.Header().HasSeq = 1, true
+	c.receivedPacket(open)
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+
+	ack := func(seq uint32) {
+		pkt := &lob.Packet{}
+		hdr := pkt.Header()
+		hdr.C, hdr.HasC = 3, true
+		hdr.Ack, hdr.HasAck = seq, true
+		c.receivedPacket(pkt)
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	}
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- c.Flush(context.Background()) }()
+
+	ack(2)
+	select {
+	case err := <-flushed:
+		t.Fatalf("flush returned before the last packet was acked: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ack(3)
+	select {
+	case err := <-flushed:
+		assert.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("flush did not return after the last packet was acked")
+	}
+
+	// nothing is outstanding
+	assert.NoError(c.Flush(context.Background()))
+
+	// the ack never arrives
+	assert.NoError(c.WritePacket(lob.New([]byte("lost"))))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(context.DeadlineExceeded, c.Flush(ctx))
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestBlockedOperationsWakeOnTeardown(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	open := func() *Channel {
+		x := &MockExchange{}
+		x.On("deliverPacket", mock.Anything).Return(nil)
+
+		c := newChannel(hashname.H("a"), "test", true, true, x)
+		c.id = 3
+
+		pkt := lob.New(nil)
+		pkt.Header().C, pkt.Header().HasC = 3, true
+		pkt.Header().Seq, pkt.Header().HasSeq = 1, true
+		c.receivedPacket(pkt)
+		_, err := c.ReadPacket()
+		assert.NoError(err)
+		return c
+	}
+
+	remoteError := func(c *Channel) {
+		pkt := &lob.Packet{}
+		hdr := pkt.Header()
+		hdr.C, hdr.HasC = 3, true
+		hdr.Seq, hdr.HasSeq = 2, true
+		hdr.SetString("err", "failed")
+		c.receivedPacket(pkt)
+	}
+
+	var (
+		broken  = &BrokenChannelError{hashname.H("a"), "test", 3}
+		actions = []struct {
+			name     string
+			teardown func(c *Channel)
+			err      error // the error returned by the blocked operations
+		}{
+			{"kill", (*Channel).Kill, broken},
+			{"break", (*Channel).onCloseDeadlineReached, broken},
+			{"remote error", remoteError, &RemoteError{Message: "failed"}},
+		}
+	)
+
+	// fill the congestion window; the packets are never acked
+	fill := func(c *Channel) {
+		for i := 0; i < cInitialCwnd; i++ {
+			assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+		}
+	}
+
+	ops := []struct {
+		name string
+		op   func(c *Channel) error
+	}{
+		{"flush", func(c *Channel) error {
+			fill(c)
+			return c.Flush(context.Background())
+		}},
+		{"write", func(c *Channel) error {
+			fill(c)
+			return c.WritePacket(lob.New([]byte("blocked")))
+		}},
+		{"batch", func(c *Channel) error {
+			fill(c)
+			return c.WriteBatch([]*lob.Packet{lob.New(nil), lob.New(nil)})
+		}},
+		{"read", func(c *Channel) error {
+			_, err := c.ReadPacket()
+			return err
+		}},
+	}
+
+	for _, a := range actions {
+		for _, o := range ops {
+			c := open()
+
+			done := make(chan error, 1)
+			go func() { done <- o.op(c) }()
+
+			select {
+			case err := <-done:
+				t.Fatalf("%s returned before the %s: %v", o.name, a.name, err)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			a.teardown(c)
+
+			select {
+			case err := <-done:
+				assert.Equal(a.err, err, "%s after %s", o.name, a.name)
+			case <-time.After(time.Second):
+				t.Errorf("%s was not woken by the %s", o.name, a.name)
+			}
+
+			c.Kill()
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package e3x
+
+import (
+	"errors"
+	"io"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+const (
+	// fragHeader is set on every fragment of a message but the last one; it
+	// counts the fragments which follow.
+	fragHeader = "frag"
+
+	// fragContinueHeader marks the body-less packet the server side of a
+	// channel responds with when the initial packet is the first fragment of a
+	// message; until then neither side can move on to the next fragment.
+	fragContinueHeader = "frag_continue"
+
+	// cFragmentSize is the body size of the fragments of a message. It leaves
+	// room for the channel headers within MaxPacketSize. Fragments shrink on
+	// paths with a smaller MTU (see PathMTUDiscovery), down to
+	// cMinFragmentSize.
+	cFragmentSize    = 1000
+	cMinFragmentSize = 256
+
+	// MaxMessageSize is the maximum size of a message read by ReadMessage.
+	MaxMessageSize = 1 << 20
+)
+
+// ErrMessageTooLarge is returned by WriteMessage for messages larger than
+// MaxMessageSize, and by ReadMessage when the fragments of a message add up to
+// more than MaxMessageSize. In the latter case the remaining fragments are not
+// read, so the channel should be closed.
+var ErrMessageTooLarge = errors.New("e3x: message too large")
+
+// WriteMessage writes b to the channel. Messages which don't fit in a single
+// packet are split into fragments, which ReadMessage on the remote end
+// reassembles. As fragments rely on the ordered delivery of reliable channels,
+// unreliable channels return ErrPacketTooLarge instead. Messages larger than
+// MaxMessageSize are rejected with ErrMessageTooLarge.
+//
+// The fragments of a message are not interleaved with those of other messages
+// written with WriteMessage (or Write), but they may be with packets written
+// with WritePacket at the same time. When a fragment can't be written the
+// remote end only received part of the message and the channel should be
+// closed.
+func (c *Channel) WriteMessage(b []byte) error {
+	_, err := c.writeMessage(b)
+	return err
+}
+
+// writeMessage writes b like WriteMessage and returns the number of bytes of b
+// which were written.
+func (c *Channel) writeMessage(b []byte) (int, error) {
+	if c.reliable {
+		c.mtxWriteMsg.Lock()
+		defer c.mtxWriteMsg.Unlock()
+	}
+
+	if c.reliable && len(b) > MaxMessageSize {
+		return 0, ErrMessageTooLarge
+	}
+
+	fragSize := c.fragmentSize()
+	if len(b) <= fragSize || !c.reliable {
+		if len(b) > MaxPacketSize {
+			return 0, ErrPacketTooLarge
+		}
+		if err := c.WritePacket(lob.New(b)); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	var (
+		n    int
+		more = uint32((len(b) - 1) / fragSize)
+	)
+	for ; len(b) > 0; more-- {
+		size := len(b)
+		if size > fragSize {
+			size = fragSize
+		}
+
+		pkt := lob.New(b[:size])
+		if more > 0 {
+			pkt.Header().SetUint32(fragHeader, more)
+		}
+		if err := c.WritePacket(pkt); err != nil {
+			return n, err
+		}
+
+		n += size
+		b = b[size:]
+	}
+
+	return n, nil
+}
+
+// fragmentSize returns the body size of the fragments written on the active
+// path of the exchange.
+func (c *Channel) fragmentSize() int {
+	size := cFragmentSize - (MaxPacketSize - c.x.pathMTU())
+	if size < cMinFragmentSize {
+		size = cMinFragmentSize
+	}
+	return size
+}
+
+// ReadMessage reads a message written with WriteMessage, reassembling its
+// fragments. The packets of the channel are read with ReadPacket, so the
+// headers of the packets are not returned. Packets written with WritePacket
+// are returned as single messages.
+//
+// When reading fails in the middle of a message (for example because the read
+// deadline was reached) the fragments which were read are kept, and the next
+// ReadMessage continues the message.
+func (c *Channel) ReadMessage() ([]byte, error) {
+	c.mtxReadMsg.Lock()
+	defer c.mtxReadMsg.Unlock()
+
+	return c.readMessage()
+}
+
+// readMessage reads a message like ReadMessage; c.mtxReadMsg must be held.
+func (c *Channel) readMessage() ([]byte, error) {
+	msg := c.readPartial
+	c.readPartial = nil
+
+	for {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			if msg != nil && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			c.readPartial = msg
+			return nil, err
+		}
+
+		if len(msg)+pkt.BodyLen() > MaxMessageSize {
+			pkt.Free()
+			return nil, ErrMessageTooLarge
+		}
+
+		if _, found := pkt.Header().GetBool(fragContinueHeader); found && msg == nil {
+			pkt.Free()
+			continue
+		}
+
+		_, more := pkt.Header().GetUint32(fragHeader)
+		msg = pkt.Body(msg)
+		pkt.Free()
+
+		if more && c.awaitsOpenResponse() {
+			cont := lob.New(nil)
+			cont.Header().SetBool(fragContinueHeader, true)
+			if err := c.WritePacket(cont); err != nil {
+				c.readPartial = msg
+				return nil, err
+			}
+		}
+
+		if !more {
+			if msg == nil {
+				msg = []byte{}
+			}
+			return msg, nil
+		}
+	}
+}
+
+// awaitsOpenResponse returns true when c is the server side of a channel which
+// didn't respond to the initial packet yet.
+func (c *Channel) awaitsOpenResponse() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.serverside && c.oSeq == cBlankSeq
+}
@@ -0,0 +1,153 @@
+package e3x
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestFragmentedMessages(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var (
+		large  = make([]byte, 5*cFragmentSize+123)
+		stream = make([]byte, 64*1024)
+	)
+	rand.Read(large)
+	rand.Read(stream)
+
+	l := A.Listen("test", true)
+	defer l.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		c, err := l.AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+
+		msg, err := c.ReadMessage()
+		if assert.NoError(err) {
+			assert.True(bytes.Equal(large, msg), "large message")
+		}
+		msg, err = c.ReadMessage()
+		if assert.NoError(err) {
+			assert.Equal("small", string(msg))
+		}
+
+		// Read returns the messages as a stream
+		buf := make([]byte, len(stream))
+		_, err = io.ReadFull(c, buf)
+		if assert.NoError(err) {
+			assert.True(bytes.Equal(stream, buf), "stream")
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	c, err := B.Open(ident, "test", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	assert.NoError(c.WriteMessage(large))
+	assert.NoError(c.WriteMessage([]byte("small")))
+	n, err := io.Copy(c, bytes.NewReader(stream))
+	assert.NoError(err)
+	assert.Equal(int64(len(stream)), n)
+	<-done
+
+	// unreliable channels don't fragment
+	u, err := B.Open(ident, "test", false)
+	if assert.NoError(err) {
+		defer u.Kill()
+		assert.Equal(ErrPacketTooLarge, u.WriteMessage(large))
+		_, err = u.Write(large)
+		assert.Equal(ErrPacketTooLarge, err)
+	}
+}
+
+func TestFragmentedMessageSurvivesDeadline(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	l := A.Listen("test", true)
+	defer l.Close()
+	timedOut := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		c, err := l.AcceptChannel()
+		if !assert.NoError(err) {
+			close(timedOut)
+			return
+		}
+		defer c.Kill()
+
+		// the deadline is reached after the first fragment
+		c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err = c.ReadMessage()
+		assert.Equal(ErrTimeout, err)
+		close(timedOut)
+
+		c.SetReadDeadline(time.Time{})
+		msg, err := c.ReadMessage()
+		if assert.NoError(err) {
+			assert.Equal("first second", string(msg))
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	c, err := B.Open(ident, "test", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	first := lob.New([]byte("first "))
+	first.Header().SetUint32(fragHeader, 1)
+	assert.NoError(c.WritePacket(first))
+	<-timedOut
+	assert.NoError(c.WritePacket(lob.New([]byte("second"))))
+	<-done
+
+	assert.Equal(ErrMessageTooLarge, c.WriteMessage(make([]byte, MaxMessageSize+1)))
+}
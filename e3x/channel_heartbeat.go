@@ -0,0 +1,88 @@
+package e3x
+
+import (
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// heartbeatHeader marks a heartbeat packet. Heartbeat packets carry no seq and
+// are never delivered to the application.
+const heartbeatHeader = "heartbeat"
+
+// SetHeartbeat enables an application level heartbeat on the channel. When no
+// packet was sent during the last interval a small header-only heartbeat
+// packet is sent to the remote end. When no packet at all (data, ack or
+// heartbeat) was received within timeout the channel is broken and all
+// pending and subsequent operations return a BrokenChannelError (unless the
+// channel is kept open, see KeepOpen).
+//
+// Both ends of the channel are expected to enable the heartbeat. As heartbeats
+// are only sent when the channel is idle, timeout should be at least a few
+// times interval. An interval of zero (or less) disables the heartbeat.
+func (c *Channel) SetHeartbeat(interval, timeout time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.unsetHeartbeat()
+
+	c.heartbeatInterval = interval
+	c.heartbeatTimeout = timeout
+
+	if interval <= 0 || c.broken {
+		return
+	}
+
+	c.lastRcvd = time.Now()
+	c.tHeartbeat = time.AfterFunc(interval, c.onHeartbeat)
+}
+
+func (c *Channel) onHeartbeat() {
+	c.mtx.Lock()
+
+	if c.broken || c.heartbeatInterval <= 0 {
+		c.mtx.Unlock()
+		return
+	}
+
+	now := time.Now()
+
+	if c.heartbeatTimeout > 0 && !c.keepOpen && now.Sub(c.lastRcvd) >= c.heartbeatTimeout {
+		c.broken = true
+		c.unsetTimers()
+
+		// broadcast
+		c.cndWrite.Broadcast()
+		c.cndRead.Broadcast()
+		c.cndClose.Broadcast()
+
+		c.mtx.Unlock()
+
+		c.channelHooks.Closed()
+		return
+	}
+
+	// a client channel can't send anything before the initial packet
+	if now.Sub(c.lastSent) >= c.heartbeatInterval && (c.serverside || c.oSeq != cBlankSeq) {
+		c.deliverHeartbeat()
+	}
+
+	c.tHeartbeat.Reset(c.heartbeatInterval)
+	c.mtx.Unlock()
+}
+
+func (c *Channel) deliverHeartbeat() {
+	pkt := &lob.Packet{}
+	hdr := pkt.Header()
+	hdr.C, hdr.HasC = c.id, true
+	hdr.SetBool(heartbeatHeader, true)
+	if c.x.deliverPacket(pkt, nil) == nil {
+		c.lastSent = time.Now()
+	}
+}
+
+func (c *Channel) unsetHeartbeat() {
+	if c.tHeartbeat != nil {
+		c.tHeartbeat.Stop()
+	}
+}
@@ -0,0 +1,88 @@
+package e3x
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+func TestHeartbeat(t *testing.T) {
+	logs.ResetLogger()
+
+	const (
+		interval = 20 * time.Millisecond
+		timeout  = 150 * time.Millisecond
+	)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		for _, reliable := range []bool{true, false} {
+			var (
+				assert = assert.New(t)
+				typ    = fmt.Sprintf("heartbeat-%v", reliable)
+				l      = A.Listen(typ, reliable)
+				stall  = make(chan bool)
+			)
+
+			go func() {
+				c, err := l.AcceptChannel()
+				if !assert.NoError(err) {
+					return
+				}
+				defer c.Kill()
+				c.SetHeartbeat(interval, timeout)
+
+				_, err = c.ReadPacket()
+				assert.NoError(err)
+
+				// stay idle for several timeouts
+				time.Sleep(3 * timeout)
+				assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+
+				// stop sending heartbeats
+				<-stall
+				c.SetHeartbeat(0, 0)
+				time.Sleep(5 * timeout)
+			}()
+
+			ident, err := A.LocalIdentity()
+			assert.NoError(err)
+
+			c, err := B.Open(ident, typ, reliable)
+			if !assert.NoError(err) {
+				continue
+			}
+			c.SetHeartbeat(interval, timeout)
+
+			assert.NoError(c.WritePacket(&lob.Packet{}))
+
+			// heartbeats keep the channel alive and are not delivered
+			pkt, err := c.ReadPacket()
+			if assert.NoError(err, typ) && assert.NotNil(pkt, typ) {
+				assert.Equal("data", string(pkt.Body(nil)), typ)
+			}
+
+			close(stall)
+
+			// the channel breaks when the heartbeats stop
+			done := make(chan error, 1)
+			go func() {
+				_, err := c.ReadPacket()
+				done <- err
+			}()
+
+			select {
+			case err := <-done:
+				assert.IsType(&BrokenChannelError{}, err, typ)
+			case <-time.After(3 * time.Second):
+				t.Fatalf("%s: channel was not closed", typ)
+			}
+
+			l.Close()
+		}
+	})
+}
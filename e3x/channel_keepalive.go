@@ -0,0 +1,69 @@
+package e3x
+
+import (
+	"time"
+)
+
+// SetKeepalive makes the channel send a body-less keepalive packet whenever
+// nothing was sent on the channel during interval. This keeps the path (and
+// any NAT mappings along it) alive without application visible traffic.
+//
+// On reliable channels the keepalive is a pure ack; otherwise (and before the
+// first packet was read) a header-only heartbeat packet is sent. Keepalive
+// packets are never delivered to the application and don't keep an otherwise
+// idle exchange from expiring. An interval of zero (or less) disables the
+// keepalive.
+func (c *Channel) SetKeepalive(interval time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.unsetKeepalive()
+
+	c.keepaliveInterval = interval
+
+	if interval <= 0 || c.broken {
+		return
+	}
+
+	c.tKeepalive = time.AfterFunc(interval, c.onKeepalive)
+}
+
+func (c *Channel) onKeepalive() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.broken || c.keepaliveInterval <= 0 {
+		return
+	}
+
+	// a client channel can't send anything before the initial packet
+	if time.Since(c.lastSent) >= c.keepaliveInterval && (c.serverside || c.oSeq != cBlankSeq) {
+		if c.reliable && c.iSeq >= cInitialSeq {
+			c.deliverAck()
+		} else {
+			c.deliverHeartbeat()
+		}
+	}
+
+	c.tKeepalive.Reset(c.keepaliveInterval)
+}
+
+func (c *Channel) unsetKeepalive() {
+	if c.tKeepalive != nil {
+		c.tKeepalive.Stop()
+	}
+}
+
+// KeepOpen exempts the channel from being reaped while it is idle. The channel
+// is no longer broken when the initial packet remains unanswered and, when a
+// heartbeat is set, when nothing was received within the heartbeat timeout.
+// Heartbeats and keepalives (when set) are still sent. Once kept open, a
+// channel only ends when it is closed (or killed) by either end or when its
+// exchange ends.
+func (c *Channel) KeepOpen() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.keepOpen = true
+	c.unsetOpenDeadline()
+}
@@ -0,0 +1,189 @@
+package e3x
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestKeepaliveKeepsNATMappingAlive(t *testing.T) {
+	logs.ResetLogger()
+
+	for _, keepalive := range []bool{false, true} {
+		var (
+			assert = assert.New(t)
+			typ    = fmt.Sprintf("keepalive-%v", keepalive)
+		)
+
+		A, err := Open(Transport(inproc.Config{}), Log(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := Open(Transport(&natConfig{Config: inproc.Config{}, ttl: 100 * time.Millisecond}), Log(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			c, err := A.Listen(typ, true).AcceptChannel()
+			if !assert.NoError(err) {
+				return
+			}
+			defer c.Kill()
+
+			_, err = c.ReadPacket()
+			assert.NoError(err)
+			assert.NoError(c.WritePacket(lob.New([]byte("first"))))
+
+			// wait for the NAT mapping to expire (unless it's kept alive)
+			time.Sleep(400 * time.Millisecond)
+			assert.NoError(c.WritePacket(lob.New([]byte("late"))))
+			time.Sleep(time.Second)
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, typ, true)
+		if !assert.NoError(err) {
+			continue
+		}
+		if keepalive {
+			c.SetKeepalive(30 * time.Millisecond)
+		}
+
+		assert.NoError(c.WritePacket(&lob.Packet{}))
+		_, err = c.ReadPacket()
+		assert.NoError(err)
+
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		pkt, err := c.ReadPacket()
+		if keepalive {
+			if assert.NoError(err, typ) && assert.NotNil(pkt, typ) {
+				assert.Equal("late", string(pkt.Body(nil)), typ)
+			}
+		} else {
+			assert.Equal(ErrTimeout, err, typ)
+		}
+
+		c.Kill()
+		assert.NoError(A.Close())
+		assert.NoError(B.Close())
+	}
+}
+
+func TestKeepOpenIdleChannelIsNotReaped(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	for _, keepOpen := range []bool{false, true} {
+		x := &MockExchange{}
+		x.On("deliverPacket", mock.Anything).Return(nil)
+
+		c := newChannel(hashname.H("a"), "test", true, false, x)
+		c.id = 1
+		if keepOpen {
+			c.KeepOpen()
+			assert.False(c.tOpenDeadline.Stop(), "the open deadline must be stopped")
+		}
+
+		// nothing is ever received
+		c.SetHeartbeat(10*time.Millisecond, 50*time.Millisecond)
+		assert.NoError(c.WritePacket(&lob.Packet{}))
+		time.Sleep(200 * time.Millisecond)
+
+		c.mtx.Lock()
+		broken := c.broken
+		c.mtx.Unlock()
+		assert.Equal(!keepOpen, broken, "keepOpen=%v", keepOpen)
+		c.Kill()
+
+		if keepOpen {
+			// heartbeats are still sent
+			var heartbeats int
+			for _, call := range x.Calls {
+				if pkt, ok := call.Arguments.Get(0).(*lob.Packet); ok {
+					if _, found := pkt.Header().Get(heartbeatHeader); found {
+						heartbeats++
+					}
+				}
+			}
+			assert.True(heartbeats > 0)
+		}
+	}
+}
+
+// natConfig simulates a NAT in front of a transport. Inbound packets are dropped
+// when nothing was sent during the last ttl.
+type natConfig struct {
+	transports.Config
+	ttl      time.Duration
+	lastSent int64 // unix nanoseconds
+}
+
+func (c *natConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreInt64(&c.lastSent, time.Now().UnixNano())
+	return &natTransport{t, c}, nil
+}
+
+type natTransport struct {
+	transports.Transport
+	config *natConfig
+}
+
+func (t *natTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &natConn{conn, t.config}, nil
+}
+
+func (t *natTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &natConn{conn, t.config}, nil
+}
+
+type natConn struct {
+	net.Conn
+	config *natConfig
+}
+
+func (c *natConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		if err != nil {
+			return n, err
+		}
+
+		lastSent := time.Unix(0, atomic.LoadInt64(&c.config.lastSent))
+		if time.Since(lastSent) <= c.config.ttl {
+			return n, nil
+		}
+		// drop: the mapping expired
+	}
+}
+
+func (c *natConn) Write(b []byte) (int, error) {
+	atomic.StoreInt64(&c.config.lastSent, time.Now().UnixNano())
+	return c.Conn.Write(b)
+}
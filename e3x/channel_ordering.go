@@ -0,0 +1,62 @@
+package e3x
+
+// SetOrdered controls whether packets on a reliable channel are read in the
+// order they were sent (the default). When ordered is false packets are read
+// as soon as they arrive, so a lost packet doesn't hold back the packets sent
+// after it. Lost packets are still retransmitted and every packet is read
+// exactly once.
+//
+// The initial packet and the final end packet are always read in order. On
+// unreliable channels packets are always read as they arrive.
+func (c *Channel) SetOrdered(ordered bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.unordered = !ordered
+
+	if !c.blockRead() {
+		c.cndRead.Broadcast()
+	}
+}
+
+// nextReadable returns the index in the read buffer of the next packet to be
+// read, or -1 when no packet is ready to be read.
+func (c *Channel) nextReadable() int {
+	if len(c.readBuffer) == 0 {
+		return -1
+	}
+
+	if c.readBuffer[0].seq == c.iSeq+1 {
+		return 0
+	}
+
+	if !c.unordered || c.iSeq < cInitialSeq {
+		return -1
+	}
+
+	for idx, e := range c.readBuffer {
+		if !e.read && !e.end {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// popReadEntries removes the next packet in sequence from the read buffer
+// along with the packets directly following it which were already read out
+// of order.
+func (c *Channel) popReadEntries() {
+	n := 0
+	for n < len(c.readBuffer) {
+		e := c.readBuffer[n]
+		if e.seq != c.iSeq+1 || (n > 0 && !e.read) {
+			break
+		}
+		c.iSeq = e.seq
+		n++
+	}
+
+	copy(c.readBuffer, c.readBuffer[n:])
+	c.readBuffer = c.readBuffer[:len(c.readBuffer)-n]
+}
@@ -0,0 +1,60 @@
+package e3x
+
+import (
+	"errors"
+
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// ErrInvalidPriority is returned when a channel priority is not a valid DSCP
+// value.
+var ErrInvalidPriority = errors.New("e3x: priority must be in the range 0-63")
+
+// Priority marks the packets of a channel with the DSCP (Differentiated
+// Services Code Point) value dscp. Packets are only marked when the transport
+// of the path supports it; otherwise the default marking of the transport is
+// used (see udp.Config.DSCP).
+//
+//	c, err := x.Open("voice", false, e3x.Priority(46)) // expedited forwarding
+func Priority(dscp int) ChannelOption {
+	return func(c *Channel) error {
+		if dscp < 0 || dscp > 63 {
+			return ErrInvalidPriority
+		}
+		c.priority = dscp
+		return nil
+	}
+}
+
+// channelPriority returns the priority of the channel with id cid (or zero when
+// there is no such channel).
+func (x *Exchange) channelPriority(cid uint32) int {
+	c := x.channels.Get(cid)
+	if c == nil {
+		return 0
+	}
+	return c.priority
+}
+
+// writePriority writes b marked with dscp when the connection supports it. When
+// the connection broke b is written once more over a new connection.
+func (p *Pipe) writePriority(b *bufpool.Buffer, dscp int) (int, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := p.dial()
+		if err != nil {
+			return 0, err
+		}
+
+		var n int
+		if pconn, ok := conn.(transports.PriorityConn); ok && dscp != 0 {
+			n, err = pconn.WritePriority(b.RawBytes(), dscp)
+		} else {
+			n, err = conn.Write(b.RawBytes())
+		}
+
+		if err == nil || attempt > 0 || !p.dropBroken(conn, err) {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package e3x
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestChannelPriority(t *testing.T) {
+	logs.ResetLogger()
+
+	var (
+		assert   = assert.New(t)
+		priority = &priorityConfig{Config: inproc.Config{}}
+	)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(priority), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	l := A.Listen("priority", false)
+	defer l.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	_, err = B.Open(ident, "priority", false, Priority(64))
+	assert.Equal(ErrInvalidPriority, err)
+
+	high, err := B.Open(ident, "priority", false, Priority(46))
+	if !assert.NoError(err) {
+		return
+	}
+	defer high.Kill()
+	low, err := B.Open(ident, "priority", false)
+	if !assert.NoError(err) {
+		return
+	}
+	defer low.Kill()
+
+	// other packets (like the caps advertisement) may be written concurrently
+	// but those are never marked.
+	priority.reset()
+	assert.NoError(high.WritePacket(lob.New([]byte("high"))))
+	assert.Equal(1, priority.count(46))
+
+	priority.reset()
+	assert.NoError(low.WritePacket(lob.New([]byte("low"))))
+	assert.Equal(0, priority.count(46))
+	assert.True(priority.count(0) > 0)
+}
+
+// priorityConfig records the DSCP values the packets written on a transport are
+// marked with.
+type priorityConfig struct {
+	transports.Config
+
+	mtx  sync.Mutex
+	dscp []int
+}
+
+func (c *priorityConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &priorityTransport{t, c}, nil
+}
+
+func (c *priorityConfig) reset() {
+	c.mtx.Lock()
+	c.dscp = nil
+	c.mtx.Unlock()
+}
+
+// count returns the number of packets marked with dscp.
+func (c *priorityConfig) count(dscp int) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	n := 0
+	for _, d := range c.dscp {
+		if d == dscp {
+			n++
+		}
+	}
+	return n
+}
+
+type priorityTransport struct {
+	transports.Transport
+	config *priorityConfig
+}
+
+func (t *priorityTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &priorityConn{conn, t.config}, nil
+}
+
+func (t *priorityTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &priorityConn{conn, t.config}, nil
+}
+
+type priorityConn struct {
+	net.Conn
+	config *priorityConfig
+}
+
+func (c *priorityConn) Write(b []byte) (int, error) {
+	return c.WritePriority(b, 0)
+}
+
+func (c *priorityConn) WritePriority(b []byte, dscp int) (int, error) {
+	c.config.mtx.Lock()
+	c.config.dscp = append(c.config.dscp, dscp)
+	c.config.mtx.Unlock()
+	return c.Conn.Write(b)
+}
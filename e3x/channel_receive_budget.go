@@ -0,0 +1,87 @@
+package e3x
+
+import (
+	"sync"
+)
+
+// receiveBudget limits the number of bytes buffered by all the channels of an
+// endpoint which were received but not yet read.
+type receiveBudget struct {
+	mtx  sync.Mutex
+	max  int
+	used int
+}
+
+func newReceiveBudget(max int) *receiveBudget {
+	return &receiveBudget{max: max}
+}
+
+// reserve takes n bytes from the budget and returns true when they are
+// available. When force is true the bytes are taken even when that exceeds the
+// budget.
+func (b *receiveBudget) reserve(n int, force bool) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if !force && b.used+n > b.max {
+		return false
+	}
+
+	b.used += n
+	return true
+}
+
+// release returns n bytes to the budget.
+func (b *receiveBudget) release(n int) {
+	if b == nil || n == 0 {
+		return
+	}
+
+	b.mtx.Lock()
+	b.used -= n
+	b.mtx.Unlock()
+}
+
+func (b *receiveBudget) buffered() int {
+	if b == nil {
+		return 0
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.used
+}
+
+// awaitsResend returns true when the reader read all buffered packets and waits
+// for packets which were dropped because the receive budget was exhausted.
+func (c *Channel) awaitsResend() bool {
+	return c.reliable && c.iSeq < c.rcvStalled && c.nextReadable() < 0
+}
+
+// requestResend acks the read packets; the miss list of the ack asks the sender
+// to resend the dropped packets. The request is repeated every rto until the
+// dropped packets arrived.
+func (c *Channel) requestResend() {
+	c.deliverAck()
+	c.tAcker.Reset(c.rtt.rto())
+}
+
+// releaseReadBuffer returns the bytes held by the unread packets in the read
+// buffer to the receive budget. It is called once the channel is closed.
+func (c *Channel) releaseReadBuffer() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, e := range c.readBuffer {
+		c.releaseEntry(e)
+	}
+}
+
+func (c *Channel) releaseEntry(e *readBufferEntry) {
+	c.rcvBudget.release(e.size)
+	e.size = 0
+}
@@ -0,0 +1,119 @@
+package e3x
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestReceiveBufferLimitStallsSender(t *testing.T) {
+	logs.ResetLogger()
+
+	const (
+		limit = 32 * 1024
+		size  = 1024
+	)
+
+	var (
+		assert  = assert.New(t)
+		resume  = make(chan struct{})
+		results = make(chan [][]byte, 1)
+	)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), ReceiveBufferLimit(limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	go func() {
+		var bodies [][]byte
+		defer func() { results <- bodies }()
+
+		c, err := A.Listen("budget", true).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+
+		_, err = c.ReadPacket()
+		assert.NoError(err)
+		assert.NoError(c.WritePacket(&lob.Packet{}))
+
+		// a slow handler
+		<-resume
+
+		c.SetReadDeadline(time.Now().Add(30 * time.Second))
+		for {
+			pkt, err := c.ReadPacket()
+			if err == io.EOF {
+				assert.NoError(c.Close())
+				return
+			}
+			if !assert.NoError(err) {
+				return
+			}
+			bodies = append(bodies, pkt.Body(nil))
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := B.Open(ident, "budget", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	assert.NoError(c.WritePacket(&lob.Packet{}))
+	_, err = c.ReadPacket()
+	assert.NoError(err)
+
+	// the congestion window would stall the sender before the budget does
+	c.mtx.Lock()
+	c.cwnd.cwnd = cWriteBufferSize
+	c.mtx.Unlock()
+
+	// write until the sender stalls
+	var written [][]byte
+	c.SetWriteDeadline(time.Now().Add(time.Second))
+	for {
+		body := bytes.Repeat([]byte(fmt.Sprintf("%04d", len(written))), size/4)
+		if err := c.WritePacket(lob.New(body)); err != nil {
+			assert.Equal(ErrTimeout, err)
+			break
+		}
+		written = append(written, body)
+	}
+	c.SetWriteDeadline(time.Time{})
+
+	// the next packet in sequence may exceed the limit
+	assert.True(A.rcvBudget.buffered() <= limit+size, "buffered %d bytes", A.rcvBudget.buffered())
+	assert.True(statChannelRcvPktOverBudget.String() != "0")
+
+	close(resume)
+	time.Sleep(100 * time.Millisecond) // let the reader catch up
+	assert.NoError(c.Close())
+
+	bodies := <-results
+	if assert.Equal(len(written), len(bodies)) {
+		for i := range written {
+			assert.True(bytes.Equal(written[i], bodies[i]), "packet %d", i)
+		}
+	}
+	assert.Equal(0, A.rcvBudget.buffered())
+}
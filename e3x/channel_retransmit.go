@@ -0,0 +1,132 @@
+package e3x
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// cMaxResends is the default number of times a packet is resent before
+	// the channel gives up on it (see MaxResends).
+	cMaxResends = 10
+
+	// cMaxResendBackoff caps the interval between the resends of a packet.
+	cMaxResendBackoff = 8 * time.Second
+)
+
+// ErrResendLimit is returned by the operations on a reliable channel which
+// broke because a packet was resent too many times without being acked (see
+// MaxResends).
+var ErrResendLimit = errors.New("e3x: resend limit reached")
+
+// MaxResends sets the number of times a packet of a reliable channel is resent
+// without hearing from the peer before the channel breaks with ErrResendLimit.
+// Any packet of the channel (including the acks of a receiver which doesn't
+// read) restores the budget. A packet is resent when the receiver reports it
+// missing (see the miss header) or when no ack arrived within the rto; the
+// interval between the resends of a packet doubles every time, up to 8s. A
+// limit of zero (or less) selects the default of 10 resends.
+func MaxResends(n int) EndpointOption {
+	return func(e *Endpoint) error {
+		e.maxResends = n
+		return nil
+	}
+}
+
+// resendLimit returns the number of times a packet may be resent.
+func (c *Channel) resendLimit() int {
+	if c.maxResends <= 0 {
+		return cMaxResends
+	}
+	return c.maxResends
+}
+
+// resendDue returns true when e must be resent at now; c.mtx must be held.
+// Packets which the receiver reported as buffered are never resent.
+func (c *Channel) resendDue(e *writeBufferEntry, now time.Time) bool {
+	if e.received {
+		return false
+	}
+	if e.lastResend.IsZero() {
+		return true
+	}
+
+	backoff := c.rtt.rto()
+	for i := 1; i < e.backoff && backoff < cMaxResendBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > cMaxResendBackoff {
+		backoff = cMaxResendBackoff
+	}
+
+	return now.Sub(e.lastResend) >= backoff
+}
+
+// prepareResend updates the headers of e before it is resent. It returns false
+// when e exhausted its resends; c.mtx must be held.
+func (c *Channel) prepareResend(e *writeBufferEntry, omiss []uint32, now time.Time) bool {
+	if e.resends >= c.resendLimit() {
+		return false
+	}
+
+	hdr := e.pkt.Header()
+	if c.iSeq >= cInitialSeq {
+		hdr.Ack, hdr.HasAck = c.iSeq, true
+	}
+	hdr.Miss, hdr.HasMiss = nil, false
+	if len(omiss) > 0 && missListFits(omiss, e.size) {
+		hdr.Miss, hdr.HasMiss = omiss, true
+	}
+	e.lastResend = now
+	e.resends++
+	e.backoff++
+	return true
+}
+
+// heardFromPeer restores the resend budget of the unacked packets when a
+// packet of the channel arrived; c.mtx must be held. Only the resends which
+// go unanswered count towards the limit: a receiver which doesn't read (or
+// withholds its acks, see receiveBudget) is still alive.
+func (c *Channel) heardFromPeer() {
+	if !c.reliable {
+		return
+	}
+	for _, e := range c.writeBuffer {
+		e.resends = 0
+	}
+}
+
+// ackDropped answers a packet which was dropped as a duplicate or over the
+// receive budget. The ack doesn't cover the packet; it tells the sender that
+// the channel is alive (see heardFromPeer). c.mtx must be held.
+func (c *Channel) ackDropped() {
+	if c.reliable && c.iSeq >= cInitialSeq {
+		c.deliverAck()
+	}
+}
+
+// markReceived records which of the unacked packets the receiver buffered,
+// based on the miss list of an ack: the packets between ack and the last
+// missing packet which are not missing were received. The last entry of the
+// list is the highest seq the receiver accepts and doesn't denote a missing
+// packet.
+func (c *Channel) markReceived(ack uint32, miss []uint32) {
+	if len(miss) < 2 {
+		return
+	}
+
+	var (
+		missing = make(map[uint32]bool, len(miss)-1)
+		last    = ack
+	)
+	for _, delta := range miss[:len(miss)-1] {
+		last += delta
+		missing[last] = true
+	}
+
+	for seq := ack + 1; seq < last; seq++ {
+		if e := c.writeBuffer[seq]; e != nil && !missing[seq] {
+			e.received = true
+		}
+	}
+}
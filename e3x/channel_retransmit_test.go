@@ -0,0 +1,255 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestSelectiveResend(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	x := &wireExchange{}
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	c.maxResends = 2
+	defer c.Kill()
+
+	open := lob.New(nil)
+	open.Header().C, open.Header().HasC = 3, true
+	open.Header().Seq, open.Header().HasSeq = 1, true
+	c.receivedPacket(open)
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	}
+
+	// sent returns the seqs of the packets sent since the last call
+	var n = len(x.packets())
+	sent := func() []uint32 {
+		var seqs []uint32
+		pkts := x.packets()
+		for _, buf := range pkts[n:] {
+			pkt, err := lob.Decode(bufpool.New().Set(buf))
+			if assert.NoError(err) && pkt.Header().HasSeq {
+				seqs = append(seqs, pkt.Header().Seq)
+			}
+		}
+		n = len(pkts)
+		return seqs
+	}
+
+	// 3 and 5 are missing
+	ack := &lob.Packet{}
+	ack.Header().C, ack.Header().HasC = 3, true
+	ack.Header().Ack, ack.Header().HasAck = 1, true
+	ack.Header().Miss, ack.Header().HasMiss = []uint32{2, 2, cReadBufferSize - 4}, true
+	c.receivedPacket(ack)
+	assert.Equal([]uint32{3, 5}, sent())
+
+	// the resends back off
+	c.receivedPacket(ack)
+	assert.Empty(sent())
+
+	// the packets the receiver buffered are not resent after a timeout
+	pretendRTO := func() {
+		c.mtx.Lock()
+		c.needsResend = true
+		for _, e := range c.writeBuffer {
+			if !e.lastResend.IsZero() {
+				e.lastResend = time.Now().Add(-cMaxResendBackoff)
+			}
+		}
+		c.mtx.Unlock()
+	}
+	pretendRTO()
+	c.resendUnackedPackets()
+	assert.Equal([]uint32{3, 5}, sent())
+
+	// the acks restored the budget; the resend limit is reached once the
+	// resends go unanswered
+	pretendRTO()
+	c.resendUnackedPackets()
+	assert.Equal([]uint32{3, 5}, sent())
+	pretendRTO()
+	c.resendUnackedPackets()
+	assert.Empty(sent())
+	assert.Equal(ErrResendLimit, c.WritePacket(lob.New([]byte("data"))))
+	_, err = c.ReadPacket()
+	assert.Equal(ErrResendLimit, err)
+}
+
+func TestReportGap(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	x := &wireExchange{}
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	defer c.Kill()
+
+	receive := func(seq uint32) {
+		pkt := lob.New([]byte("data"))
+		pkt.Header().C, pkt.Header().HasC = 3, true
+		pkt.Header().Seq, pkt.Header().HasSeq = seq, true
+		c.receivedPacket(pkt)
+	}
+
+	receive(1)
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+	assert.Empty(x.packets())
+
+	// 2 was lost
+	receive(3)
+	if pkts := x.packets(); assert.Len(pkts, 1) {
+		ack, err := lob.Decode(bufpool.New().Set(pkts[0]))
+		if assert.NoError(err) {
+			assert.Equal(uint32(1), ack.Header().Ack)
+			assert.Equal([]uint32{1, cReadBufferSize - 1}, ack.Header().Miss)
+		}
+	}
+
+	// the gap is reported once
+	receive(4)
+	assert.Len(x.packets(), 1)
+}
+
+func TestResendFitsMaxPacketSize(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	x := &wireExchange{}
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	defer c.Kill()
+
+	receive := func(seq uint32) {
+		pkt := lob.New([]byte("data"))
+		pkt.Header().C, pkt.Header().HasC = 3, true
+		pkt.Header().Seq, pkt.Header().HasSeq = seq, true
+		c.receivedPacket(pkt)
+	}
+
+	receive(1)
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+
+	// every other packet was lost, which makes for a long miss list
+	for seq := uint32(3); seq < cReadBufferSize; seq += 2 {
+		receive(seq)
+	}
+
+	// a packet of exactly MaxPacketSize, ack included
+	c.mtx.Lock()
+	n, err := c.packetSizeAt(lob.New(nil), c.oSeq+1)
+	c.mtx.Unlock()
+	assert.NoError(err)
+	assert.Equal(ErrPacketTooLarge, c.WritePacket(lob.New(make([]byte, MaxPacketSize-n+1))))
+	assert.NoError(c.WritePacket(lob.New(make([]byte, MaxPacketSize-n))))
+	assert.NoError(c.WritePacket(lob.New([]byte("small"))))
+
+	c.mtx.Lock()
+	c.needsResend = true
+	c.mtx.Unlock()
+	c.resendUnackedPackets()
+
+	var withMiss int
+	for _, buf := range x.packets() {
+		assert.True(len(buf) <= MaxPacketSize, "%d bytes", len(buf))
+
+		pkt, err := lob.Decode(bufpool.New().Set(buf))
+		if assert.NoError(err) && pkt.Header().HasSeq && pkt.Header().HasMiss {
+			withMiss++
+		}
+	}
+
+	// the miss list was only left out of the large packet
+	assert.Equal(1, withMiss)
+}
+
+func TestSlowReaderKeepsResendBudget(t *testing.T) {
+	logs.ResetLogger()
+
+	if testing.Short() {
+		t.Skip("this is a long running test.")
+	}
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), MaxResends(3), RTOBounds(50*time.Millisecond, 200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var (
+		l    = B.Listen("slow", true)
+		done = make(chan int, 1)
+	)
+	go func() {
+		var count int
+		defer func() { done <- count }()
+
+		c, err := l.AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+
+		_, err = c.ReadPacket()
+		if !assert.NoError(err) {
+			return
+		}
+		count++
+		assert.NoError(c.WritePacket(lob.New([]byte("ok"))))
+
+		// the reader is alive but busy
+		time.Sleep(5 * time.Second)
+
+		for count < 7 {
+			_, err = c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			count++
+		}
+	}()
+
+	b, err := B.LocalIdentity()
+	assert.NoError(err)
+	x, err := A.Dial(b)
+	if !assert.NoError(err) {
+		return
+	}
+	c, err := x.Open("slow", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	for i := 0; i < 6; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	}
+	time.Sleep(4 * time.Second)
+	assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	assert.Equal(7, <-done)
+}
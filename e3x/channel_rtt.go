@@ -0,0 +1,140 @@
+package e3x
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	minRTO     = 200 * time.Millisecond
+	maxRTO     = 1 * time.Second
+	initialRTO = 1 * time.Second
+
+	// maxRTOBackoff limits the number of times the rto is doubled.
+	maxRTOBackoff = 16
+)
+
+// ErrInvalidRTOBounds is returned by RTOBounds when min is larger than max.
+var ErrInvalidRTOBounds = errors.New("e3x: invalid rto bounds")
+
+// rttEstimator smooths round-trip time samples (as described in RFC 6298).
+type rttEstimator struct {
+	srtt    time.Duration // smoothed round-trip time
+	rttvar  time.Duration // round-trip time variation
+	backoff uint          // number of consecutive retransmission timeouts
+
+	min, max time.Duration // the rto bounds; minRTO and maxRTO when zero
+}
+
+// RTOBounds sets the floor and the ceiling of the retransmission timeout of
+// the reliable channels of the endpoint. The timeout adapts to the measured
+// round-trip time within these bounds and is doubled (up to max) for every
+// consecutive retransmission timeout. A bound of zero (or less) selects the
+// default (200ms and 1s respectively). ErrInvalidRTOBounds is returned when
+// min is larger than max.
+func RTOBounds(min, max time.Duration) EndpointOption {
+	return func(e *Endpoint) error {
+		r := rttEstimator{min: min, max: max}
+		if lo, hi := r.bounds(); lo > hi {
+			return ErrInvalidRTOBounds
+		}
+		e.rtoMin, e.rtoMax = min, max
+		return nil
+	}
+}
+
+func (r *rttEstimator) sample(d time.Duration) {
+	if d < 0 {
+		return
+	}
+
+	// a valid sample ends the backoff (see RFC 6298 section 5)
+	r.backoff = 0
+
+	if r.srtt == 0 {
+		r.srtt = d
+		r.rttvar = d / 2
+		return
+	}
+
+	delta := r.srtt - d
+	if delta < 0 {
+		delta = -delta
+	}
+	r.rttvar = (3*r.rttvar + delta) / 4
+	r.srtt = (7*r.srtt + d) / 8
+}
+
+// timedOut doubles the rto after a retransmission timeout. The rto is reset
+// by the next sample.
+func (r *rttEstimator) timedOut() {
+	if r.backoff < maxRTOBackoff {
+		r.backoff++
+	}
+}
+
+func (r *rttEstimator) bounds() (min, max time.Duration) {
+	min, max = r.min, r.max
+	if min <= 0 {
+		min = minRTO
+	}
+	if max <= 0 {
+		max = maxRTO
+	}
+	return min, max
+}
+
+// rto returns the retransmission timeout. It is 1s (within the bounds) until
+// the first sample was taken.
+func (r *rttEstimator) rto() time.Duration {
+	min, max := r.bounds()
+
+	rto := initialRTO
+	if r.srtt != 0 {
+		rto = r.srtt + 4*r.rttvar
+	}
+	if rto < min {
+		rto = min
+	}
+
+	for i := uint(0); i < r.backoff && rto < max; i++ {
+		rto *= 2
+	}
+	if rto > max {
+		rto = max
+	}
+	return rto
+}
+
+// RTT returns the smoothed round-trip time measured on the channel. It is
+// sampled from the time between sending a packet and receiving its ack;
+// retransmitted packets are not sampled. Zero is returned when no sample was
+// taken yet (or when the channel is unreliable).
+func (c *Channel) RTT() time.Duration {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.rtt.srtt
+}
+
+// RTT returns the smoothed round-trip time to the remote peer as measured on
+// the reliable channels of the exchange. Zero is returned when no sample was
+// taken yet.
+func (x *Exchange) RTT() time.Duration {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	return x.rtt.srtt
+}
+
+func (x *Exchange) sampleRTT(d time.Duration) {
+	x.mtx.Lock()
+	x.rtt.sample(d)
+	x.mtx.Unlock()
+
+	x.rtts.observe(d)
+}
+
+func (x *Exchange) countRetransmits(n int) {
+	x.traffic.addRetransmits(n)
+}
@@ -0,0 +1,285 @@
+package e3x
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestRTTTracksDelay(t *testing.T) {
+	logs.ResetLogger()
+
+	const delay = 50 * time.Millisecond
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(&delayConfig{inproc.Config{}, delay}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(&delayConfig{inproc.Config{}, delay}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	go func() {
+		c, err := A.Listen("ping", true).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Close()
+
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			if err = c.WritePacket(pkt); err != nil {
+				return
+			}
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := B.Open(ident, "ping", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	assert.Equal(time.Duration(0), c.RTT())
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+		_, err = c.ReadPacket()
+		assert.NoError(err)
+	}
+
+	// the packets are delayed in both directions
+	rtt := c.RTT()
+	assert.True(rtt >= 2*delay, "rtt=%s", rtt)
+	assert.True(rtt < 4*delay, "rtt=%s", rtt)
+
+	rtt = B.GetExchange(A.LocalHashname()).RTT()
+	assert.True(rtt >= 2*delay, "rtt=%s", rtt)
+	assert.True(rtt < 4*delay, "rtt=%s", rtt)
+}
+
+func TestRTTEstimator(t *testing.T) {
+	assert := assert.New(t)
+
+	var r rttEstimator
+	assert.Equal(maxRTO, r.rto())
+
+	r.sample(100 * time.Millisecond)
+	assert.Equal(100*time.Millisecond, r.srtt)
+	assert.Equal(300*time.Millisecond, r.rto())
+
+	for i := 0; i < 50; i++ {
+		r.sample(10 * time.Millisecond)
+	}
+	assert.True(r.srtt < 11*time.Millisecond)
+	assert.Equal(minRTO, r.rto())
+}
+
+func TestRTOBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	r := rttEstimator{min: 50 * time.Millisecond, max: 2 * time.Second}
+	assert.Equal(initialRTO, r.rto())
+
+	r.sample(100 * time.Millisecond)
+	assert.Equal(300*time.Millisecond, r.rto())
+
+	// repeated loss doubles the rto up to the ceiling
+	r.timedOut()
+	assert.Equal(600*time.Millisecond, r.rto())
+	r.timedOut()
+	assert.Equal(1200*time.Millisecond, r.rto())
+	r.timedOut()
+	assert.Equal(2*time.Second, r.rto())
+	for i := 0; i < 100; i++ {
+		r.timedOut()
+	}
+	assert.Equal(2*time.Second, r.rto())
+
+	// the next sample ends the backoff (the variation decreased)
+	r.sample(100 * time.Millisecond)
+	assert.Equal(250*time.Millisecond, r.rto())
+
+	// the floor
+	for i := 0; i < 50; i++ {
+		r.sample(time.Millisecond)
+	}
+	assert.Equal(50*time.Millisecond, r.rto())
+}
+
+func TestRTOBounds(t *testing.T) {
+	_, err := Open(RTOBounds(2*time.Second, time.Second), Log(nil))
+	assert.Equal(t, ErrInvalidRTOBounds, err)
+
+	// a default ceiling below the floor
+	_, err = Open(RTOBounds(2*time.Second, 0), Log(nil))
+	assert.Equal(t, ErrInvalidRTOBounds, err)
+}
+
+func TestRTOAdaptsToLatency(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	ping := func(delay time.Duration) time.Duration {
+		options := []EndpointOption{RTOBounds(10*time.Millisecond, 10*time.Second), Log(nil)}
+
+		A, err := Open(append(options, Transport(&delayConfig{inproc.Config{}, delay}))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer A.Close()
+		B, err := Open(append(options, Transport(&delayConfig{inproc.Config{}, delay}))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer B.Close()
+
+		go func() {
+			c, err := A.Listen("ping", true).AcceptChannel()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+
+			for {
+				pkt, err := c.ReadPacket()
+				if err != nil {
+					return
+				}
+				if err = c.WritePacket(pkt); err != nil {
+					return
+				}
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+		c, err := B.Open(ident, "ping", true)
+		if !assert.NoError(err) {
+			return 0
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		for i := 0; i < 5; i++ {
+			assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+			_, err = c.ReadPacket()
+			assert.NoError(err)
+		}
+		return c.DebugDump().RTO
+	}
+
+	lan := ping(0)
+	wan := ping(150 * time.Millisecond)
+
+	assert.True(lan < 100*time.Millisecond, "lan rto=%s", lan)
+	assert.True(wan >= 300*time.Millisecond, "wan rto=%s", wan)
+}
+
+func TestRTOBacksOffOnLoss(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	x := &MockExchange{}
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	c.rtt.max = time.Minute
+	defer c.Kill()
+
+	open := lob.New(nil)
+	open.Header().C, open.Header().HasC = 3, true
+	open.Header().Seq, open.Header().HasSeq = 1, true
+	c.receivedPacket(open)
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+
+	// all packets are lost
+	assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+
+	rto := func() time.Duration { return c.DebugDump().RTO }
+	assert.Equal(initialRTO, rto())
+
+	// the packet was sent since the timer was set
+	c.resendUnackedPackets()
+	assert.Equal(initialRTO, rto())
+
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		c.mtx.Lock()
+		c.writeBuffer[1].lastResend = time.Time{} // pretend a rto passed
+		c.mtx.Unlock()
+
+		c.resendUnackedPackets()
+		assert.Equal(want, rto())
+	}
+}
+
+// delayConfig delays all packets written on a transport.
+type delayConfig struct {
+	transports.Config
+	delay time.Duration
+}
+
+func (c *delayConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &delayTransport{t, c.delay}, nil
+}
+
+type delayTransport struct {
+	transports.Transport
+	delay time.Duration
+}
+
+func (t *delayTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &delayConn{conn, t.delay}, nil
+}
+
+func (t *delayTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &delayConn{conn, t.delay}, nil
+}
+
+type delayConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *delayConn) Write(b []byte) (int, error) {
+	p := append([]byte(nil), b...)
+	time.AfterFunc(c.delay, func() { c.Conn.Write(p) })
+	return len(b), nil
+}
@@ -0,0 +1,97 @@
+package e3x
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/tracer"
+)
+
+const (
+	// copyIDHeader carries the id shared by the copies of a packet sent with
+	// SendN. The receiving channel delivers the first copy and drops the
+	// others.
+	copyIDHeader = "copy_id"
+
+	copyIDTTL       = 1 * time.Minute
+	copyIDCacheSize = 256
+)
+
+// ErrSendNReliable is returned by SendN on reliable channels; they already
+// retransmit lost packets.
+var ErrSendNReliable = errors.New("e3x: SendN requires an unreliable channel")
+
+// SendN writes copies copies of pkt to an unreliable channel, spaced apart by
+// spacing, to improve the odds of delivery over a lossy link. The remote
+// channel delivers pkt at most once, no matter how many copies arrive.
+//
+// SendN returns once the first copy is written; the other copies are written
+// in the background.
+func (c *Channel) SendN(pkt *lob.Packet, copies int, spacing time.Duration) error {
+	if c == nil {
+		return os.ErrInvalid
+	}
+	if c.reliable {
+		return ErrSendNReliable
+	}
+
+	pkt.Header().SetString(copyIDHeader, newOpenNonce())
+
+	c.mtx.Lock()
+	for c.blockWrite() {
+		c.cndWrite.Wait()
+	}
+
+	err := c.send(pkt, nil)
+	if err == nil {
+		// the copies are written with the headers applied by send
+		hdr := *pkt.Header()
+		extra := make(map[string]interface{}, len(hdr.Extra))
+		for k, v := range hdr.Extra {
+			extra[k] = v
+		}
+		hdr.Extra = extra
+		body := pkt.Body(nil)
+		pkt.Free()
+
+		for i := 1; i < copies; i++ {
+			time.AfterFunc(time.Duration(i)*spacing, func() {
+				c.sendCopy(hdr, body)
+			})
+		}
+	}
+
+	if !c.blockWrite() {
+		c.cndWrite.Signal()
+	}
+	if !c.blockRead() {
+		c.cndRead.Signal()
+	}
+
+	c.mtx.Unlock()
+	return err
+}
+
+func (c *Channel) sendCopy(hdr lob.Header, body []byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.broken {
+		return
+	}
+
+	pkt := lob.New(body).SetHeader(hdr)
+	pkt.TID = tracer.NewID()
+	if err := c.x.deliverPacket(pkt, nil); err != nil {
+		c.traceWriteError(pkt, nil, err)
+	} else {
+		c.lastSent = time.Now()
+		c.stats.PacketsSent++
+		c.stats.BytesSent += uint64(len(body))
+		statChannelSndPkt.Add(1)
+		c.traceWrite(pkt, nil)
+	}
+	pkt.Free()
+}
@@ -0,0 +1,80 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+func TestSendN(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	reliable := newChannel(hashname.H("a"), "test", true, false, &wireExchange{})
+	assert.Equal(ErrSendNReliable, reliable.SendN(lob.New(nil), 3, 0))
+
+	var (
+		wire = &wireExchange{}
+		c    = newChannel(hashname.H("a"), "test", false, false, wire)
+		r    = newChannel(hashname.H("a"), "test", false, true, &wireExchange{})
+	)
+	c.id, r.id = 3, 3
+	defer c.Kill()
+	defer r.Kill()
+
+	assert.NoError(c.SendN(lob.New([]byte("hello")), 3, 5*time.Millisecond))
+	for i := 0; i < 100 && len(wire.packets()) < 3; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	copies := wire.packets()
+	if !assert.Len(copies, 3) {
+		return
+	}
+
+	decode := func(data []byte) *lob.Packet {
+		pkt, err := lob.Decode(bufpool.New().Set(data))
+		assert.NoError(err)
+		return pkt
+	}
+	receive := func(data []byte) {
+		if pkt := decode(data); pkt != nil {
+			r.receivedPacket(pkt)
+		}
+	}
+
+	// the copies share their id
+	id, found := decode(copies[0]).Header().GetString(copyIDHeader)
+	assert.True(found)
+	for _, data := range copies[1:] {
+		pkt := decode(data)
+		other, _ := pkt.Header().GetString(copyIDHeader)
+		assert.Equal(id, other)
+		assert.Equal("hello", string(pkt.Body(nil)))
+	}
+	read := func() string {
+		r.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		pkt, err := r.ReadPacket()
+		if err != nil {
+			return err.Error()
+		}
+		_, found := pkt.Header().Get(copyIDHeader)
+		assert.False(found)
+		return string(pkt.Body(nil))
+	}
+
+	// only the last copy arrives
+	receive(copies[2])
+	assert.Equal("hello", read())
+
+	// late copies are dropped
+	receive(copies[0])
+	receive(copies[1])
+	assert.Equal(ErrTimeout.Error(), read())
+}
@@ -24,11 +24,8 @@ func (set *channelSet) Get(id uint32) *Channel {
 }
 
 func (set *channelSet) All() []*Channel {
-	var (
-		s = make([]*Channel, 0, len(set.channels))
-	)
-
 	set.mtx.RLock()
+	s := make([]*Channel, 0, len(set.channels))
 	for _, c := range set.channels {
 		s = append(s, c)
 	}
@@ -88,6 +85,11 @@ func (p *channelSetAddPromise) Add(c *Channel) {
 	p.set.mtx.Unlock()
 }
 
+// Count is like channelSet.Count (the set is still locked).
+func (p *channelSetAddPromise) Count(skip ...string) int {
+	return p.set.count(skip)
+}
+
 func (p *channelSetAddPromise) Cancel() {
 	p.set.mtx.Unlock()
 }
@@ -108,6 +110,29 @@ func (set *channelSet) Add(id uint32, c *Channel) (ok bool) {
 	return true
 }
 
+// Count returns the number of channels in the set, ignoring those of the types
+// in skip.
+func (set *channelSet) Count(skip ...string) int {
+	set.mtx.RLock()
+	n := set.count(skip)
+	set.mtx.RUnlock()
+	return n
+}
+
+func (set *channelSet) count(skip []string) int {
+	n := 0
+next:
+	for _, c := range set.channels {
+		for _, typ := range skip {
+			if c.typ == typ {
+				continue next
+			}
+		}
+		n++
+	}
+	return n
+}
+
 func (set *channelSet) Idle() bool {
 	set.mtx.RLock()
 	idle := true
@@ -0,0 +1,47 @@
+package e3x
+
+// ChannelStats are the counters of a single channel (see Channel.Stats).
+type ChannelStats struct {
+	PacketsSent          uint64 // packets written (not counting retransmissions)
+	PacketsReceived      uint64 // packets accepted into the read buffer
+	PacketsRetransmitted uint64 // packets resent because they were not acked
+	BytesSent            uint64 // body bytes of the packets written
+	BytesReceived        uint64 // body bytes of the packets accepted
+	AcksSent             uint64 // acks sent (inline and ad hoc)
+	AcksReceived         uint64 // acks received (inline and ad hoc)
+	FastRetransmits      uint64 // packets resent after duplicate acks (see CongestionWindow)
+
+	// Window is the number of packets which can still be written before the
+	// writer blocks on the write buffer or the congestion window.
+	Window int
+
+	// CongestionWindow is the number of unacked packets the channel may have
+	// (see Channel.CongestionWindow).
+	CongestionWindow int
+
+	// Outstanding is the number of written packets which are not yet acked.
+	Outstanding int
+}
+
+// Stats returns the counters of the channel. The counters accumulate from the
+// moment the channel was opened or from the last call to ResetStats.
+func (c *Channel) Stats() ChannelStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s := c.stats
+	s.Outstanding = len(c.writeBuffer)
+	s.CongestionWindow = c.sendWindow()
+	s.Window = s.CongestionWindow - s.Outstanding
+	if s.Window < 0 {
+		s.Window = 0
+	}
+	return s
+}
+
+// ResetStats zeros the counters of the channel; use it to measure intervals.
+func (c *Channel) ResetStats() {
+	c.mtx.Lock()
+	c.stats = ChannelStats{}
+	c.mtx.Unlock()
+}
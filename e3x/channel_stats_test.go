@@ -0,0 +1,76 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+func TestChannelStats(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	x := &MockExchange{}
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	defer c.Kill()
+
+	open := lob.New([]byte("open"))
+	open.Header().C, open.Header().HasC = 3, true
+	open.Header().Seq, open.Header().HasSeq = 1, true
+	c.receivedPacket(open)
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+
+	assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	assert.NoError(c.WritePacket(lob.New([]byte("more data"))))
+	assert.Equal(ChannelStats{
+		PacketsSent:      2,
+		PacketsReceived:  1,
+		BytesSent:        13,
+		BytesReceived:    4,
+		AcksSent:         1, // piggybacked on the first packet
+		Window:           cInitialCwnd - 2,
+		CongestionWindow: cInitialCwnd,
+		Outstanding:      2,
+	}, c.Stats())
+
+	// both packets are lost
+	c.resendUnackedPackets()
+	c.mtx.Lock()
+	c.writeBuffer[1].lastResend = time.Time{} // pretend a rto passed
+	c.writeBuffer[2].lastResend = time.Time{}
+	c.mtx.Unlock()
+	c.resendUnackedPackets()
+	assert.Equal(uint64(2), c.Stats().PacketsRetransmitted)
+
+	ack := &lob.Packet{}
+	ack.Header().C, ack.Header().HasC = 3, true
+	ack.Header().Ack, ack.Header().HasAck = 2, true
+	c.receivedPacket(ack)
+
+	s := c.Stats()
+	assert.Equal(uint64(1), s.AcksReceived)
+	assert.Equal(0, s.Outstanding)
+	// the timeout collapsed the window; the acks grow it again (slow start)
+	assert.Equal(cMinCwnd+2, s.CongestionWindow)
+	assert.Equal(cMinCwnd+2, s.Window)
+
+	c.ResetStats()
+	assert.Equal(ChannelStats{Window: cMinCwnd + 2, CongestionWindow: cMinCwnd + 2}, c.Stats())
+
+	assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	s = c.Stats()
+	assert.Equal(uint64(1), s.PacketsSent)
+	assert.Equal(uint64(4), s.BytesSent)
+	assert.Equal(1, s.Outstanding)
+}
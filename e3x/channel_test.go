@@ -2,6 +2,9 @@ package e3x
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime"
@@ -9,8 +12,11 @@ import (
 	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
 
+	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/internal/util/logs"
 	"github.com/telehash/gogotelehash/transports/inproc"
 	"github.com/telehash/gogotelehash/transports/mux"
@@ -162,6 +168,174 @@ func TestPingPongReliable(t *testing.T) {
 	})
 }
 
+func TestOversizedHeader(t *testing.T) {
+	// t.Parallel()
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			c      *Channel
+			ident  *Identity
+			pkt    *lob.Packet
+			err    error
+		)
+
+		go func() {
+			c, err := A.Listen("ping", true).AcceptChannel()
+			if assert.NoError(err) && assert.NotNil(c) {
+				defer c.Close()
+
+				pkt, err = c.ReadPacket()
+				if assert.NoError(err) && assert.NotNil(pkt) {
+					v, _ := pkt.Header().GetString("small")
+					assert.Equal("ping", v)
+
+					err = c.WritePacket(lob.New([]byte("pong")))
+					assert.NoError(err)
+				}
+			}
+		}()
+
+		ident, err = A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err = B.Open(ident, "ping", true)
+		assert.NoError(err)
+		if assert.NotNil(c) {
+			pkt = &lob.Packet{}
+			pkt.Header().SetString("large", string(bytes.Repeat([]byte{'x'}, 2*MaxPacketSize)))
+			err = c.WritePacket(pkt)
+			assert.Equal(ErrPacketTooLarge, err)
+
+			pkt = &lob.Packet{}
+			pkt.Header().SetString("small", "ping")
+			err = c.WritePacket(pkt)
+			assert.NoError(err)
+
+			pkt, err = c.ReadPacket()
+			assert.NoError(err)
+			if assert.NotNil(pkt) {
+				assert.Equal("pong", string(pkt.Body(nil)))
+			}
+
+			err = c.Close()
+			assert.NoError(err)
+		}
+	})
+}
+
+func TestReadEndSemantics(t *testing.T) {
+	// t.Parallel()
+	logs.ResetLogger()
+
+	var tab = []struct {
+		name   string
+		server func(c *Channel) error
+		err    error
+	}{
+		{
+			name: "end-with-body",
+			server: func(c *Channel) error {
+				pkt := lob.New([]byte("bye"))
+				pkt.Header().End, pkt.Header().HasEnd = true, true
+				if err := c.WritePacket(pkt); err != nil {
+					return err
+				}
+				return c.Close()
+			},
+			err: io.EOF,
+		},
+		{
+			name: "body-then-end",
+			server: func(c *Channel) error {
+				if err := c.WritePacket(lob.New([]byte("bye"))); err != nil {
+					return err
+				}
+				return c.Close()
+			},
+			err: io.EOF,
+		},
+		{
+			name: "body-then-error",
+			server: func(c *Channel) error {
+				if err := c.WritePacket(lob.New([]byte("bye"))); err != nil {
+					return err
+				}
+				return c.Errorf("rejected")
+			},
+			err: &RemoteError{Message: "rejected"},
+		},
+		{
+			name: "body-then-structured-error",
+			server: func(c *Channel) error {
+				if err := c.WritePacket(lob.New([]byte("bye"))); err != nil {
+					return err
+				}
+				return c.Error(&RemoteError{
+					Code:    "not-found",
+					Message: "no such key",
+					Detail:  json.RawMessage(`{"key":"a","tries":3}`),
+				})
+			},
+			err: &RemoteError{
+				Code:    "not-found",
+				Message: "no such key",
+				Detail:  json.RawMessage(`{"key":"a","tries":3}`),
+			},
+		},
+	}
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		for _, reliable := range []bool{true, false} {
+			for _, e := range tab {
+				var (
+					assert = assert.New(t)
+					typ    = fmt.Sprintf("%s-%v", e.name, reliable)
+					l      = A.Listen(typ, reliable)
+					server = e.server
+				)
+
+				go func() {
+					c, err := l.AcceptChannel()
+					if assert.NoError(err) && assert.NotNil(c) {
+						_, err = c.ReadPacket()
+						assert.NoError(err)
+						assert.NoError(server(c))
+					}
+				}()
+
+				ident, err := A.LocalIdentity()
+				assert.NoError(err)
+
+				c, err := B.Open(ident, typ, reliable)
+				if !assert.NoError(err) {
+					continue
+				}
+				c.SetDeadline(time.Now().Add(10 * time.Second))
+
+				err = c.WritePacket(lob.New([]byte("hello")))
+				assert.NoError(err)
+
+				pkt, err := c.ReadPacket()
+				if assert.NoError(err, typ) && assert.NotNil(pkt) {
+					assert.Equal("bye", string(pkt.Body(nil)), typ)
+				}
+
+				// the final error is sticky
+				for i := 0; i < 2; i++ {
+					pkt, err = c.ReadPacket()
+					assert.Nil(pkt, typ)
+					assert.Equal(e.err, err, typ)
+				}
+
+				assert.NoError(c.Close(), typ)
+				l.Close()
+			}
+		}
+	})
+}
+
 func TestFloodReliable(t *testing.T) {
 	if testing.Short() {
 		t.Skip("this is a long running test.")
@@ -565,3 +739,551 @@ func BenchmarkChannelsReliable(b *testing.B) {
 		b.StopTimer()
 	})
 }
+
+func TestOpenWithID(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			l      = A.Listen("fixed", true)
+			ids    = make(chan uint32, 1)
+		)
+		defer l.Close()
+
+		go func() {
+			c, err := l.AcceptChannel()
+			if assert.NoError(err) {
+				ids <- c.id
+				c.Kill()
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+		x, err := B.Dial(ident)
+		if !assert.NoError(err) {
+			return
+		}
+
+		var id, wrong uint32 = 100, 101
+		if x.cipher.IsHigh() {
+			id, wrong = 101, 100
+		}
+
+		_, err = x.openWithID(context.Background(), "fixed", true, wrong)
+		assert.Equal(ErrInvalidChannelID, err)
+
+		c, err := x.openWithID(context.Background(), "fixed", true, id)
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+		assert.Equal(id, c.id)
+
+		_, err = x.openWithID(context.Background(), "fixed", true, id)
+		assert.Equal(ErrChannelIDInUse, err)
+
+		assert.NoError(c.WritePacket(&lob.Packet{}))
+		assert.Equal(id, <-ids)
+
+		c2, err := x.Open("fixed", true)
+		if assert.NoError(err) {
+			assert.NotEqual(id, c2.id)
+			c2.Kill()
+		}
+	})
+}
+
+func TestBlockedReadersAreWokenOnEnd(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		for _, reliable := range []bool{true, false} {
+			var (
+				assert = assert.New(t)
+				typ    = fmt.Sprintf("wake-%v", reliable)
+				l      = A.Listen(typ, reliable)
+				closed = make(chan bool)
+			)
+
+			go func() {
+				c, err := l.AcceptChannel()
+				if assert.NoError(err) {
+					_, err = c.ReadPacket()
+					assert.NoError(err)
+					<-closed
+					assert.NoError(c.Close())
+				}
+			}()
+
+			ident, err := A.LocalIdentity()
+			assert.NoError(err)
+
+			c, err := B.Open(ident, typ, reliable)
+			if !assert.NoError(err) {
+				continue
+			}
+			assert.NoError(c.WritePacket(&lob.Packet{}))
+
+			const n = 3
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				go func() {
+					_, err := c.ReadPacket()
+					errs <- err
+				}()
+			}
+
+			// let the readers block before the remote end closes the channel
+			time.Sleep(50 * time.Millisecond)
+			close(closed)
+
+			timeout := time.After(5 * time.Second)
+			for i := 0; i < n; i++ {
+				select {
+				case err := <-errs:
+					assert.Equal(io.EOF, err, typ)
+				case <-timeout:
+					t.Fatalf("%s: reader %d was not woken", typ, i)
+				}
+			}
+
+			assert.NoError(c.Close(), typ)
+			l.Close()
+		}
+	})
+}
+
+func TestDrain(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		for _, reliable := range []bool{true, false} {
+			var (
+				assert = assert.New(t)
+				typ    = fmt.Sprintf("drain-%v", reliable)
+				l      = A.Listen(typ, reliable)
+				resume = make(chan bool)
+			)
+
+			go func() {
+				c, err := l.AcceptChannel()
+				if !assert.NoError(err) {
+					return
+				}
+
+				_, err = c.ReadPacket()
+				assert.NoError(err)
+
+				for i := 0; i < 5; i++ {
+					assert.NoError(c.WritePacket(lob.New([]byte{byte('0' + i)})))
+				}
+
+				<-resume
+				assert.NoError(c.WritePacket(lob.New([]byte("5"))))
+				assert.NoError(c.Close())
+			}()
+
+			ident, err := A.LocalIdentity()
+			assert.NoError(err)
+
+			c, err := B.Open(ident, typ, reliable)
+			if !assert.NoError(err) {
+				continue
+			}
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+			assert.NoError(c.WritePacket(&lob.Packet{}))
+
+			for i := 0; i < 100 && buffered(c) < 5; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.Equal(5, c.Drain(), typ)
+			assert.Equal(0, c.Drain(), typ)
+
+			close(resume)
+
+			pkt, err := c.ReadPacket()
+			if assert.NoError(err, typ) && assert.NotNil(pkt, typ) {
+				assert.Equal("5", string(pkt.Body(nil)), typ)
+			}
+
+			_, err = c.ReadPacket()
+			assert.Equal(io.EOF, err, typ)
+			assert.NoError(c.Close(), typ)
+			l.Close()
+		}
+	})
+}
+
+func buffered(c *Channel) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.readBuffer)
+}
+
+func TestAckForOtherChannelIsIgnored(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	x := &MockExchange{}
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel(hashname.H("a"), "test", true, false, x)
+	c.id = 4
+	defer c.Kill()
+
+	assert.NoError(c.WritePacket(lob.New([]byte("a"))))
+	assert.Len(c.writeBuffer, 1)
+
+	ack := func(cid uint32) *lob.Packet {
+		pkt := &lob.Packet{}
+		hdr := pkt.Header()
+		hdr.C, hdr.HasC = cid, true
+		hdr.Ack, hdr.HasAck = 1, true
+		return pkt
+	}
+
+	c.receivedPacket(ack(6))
+	assert.Len(c.writeBuffer, 1)
+	assert.Equal(cBlankSeq, c.oAckedSeq)
+
+	c.receivedPacket(ack(4))
+	assert.Len(c.writeBuffer, 0)
+	assert.Equal(uint32(1), c.oAckedSeq)
+}
+
+func TestUnorderedDelivery(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	data := func(seq uint32) *lob.Packet {
+		pkt := lob.New([]byte{byte('0' + seq)})
+		hdr := pkt.Header()
+		hdr.C, hdr.HasC = 3, true
+		hdr.Seq, hdr.HasSeq = seq, true
+		return pkt
+	}
+
+	read := func(c *Channel) string {
+		c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return err.Error()
+		}
+		return string(pkt.Body(nil))
+	}
+
+	for _, ordered := range []bool{true, false} {
+		x := &MockExchange{}
+		x.On("deliverPacket", mock.Anything).Return(nil)
+
+		c := newChannel(hashname.H("a"), "test", true, true, x)
+		c.id = 3
+		c.SetOrdered(ordered)
+
+		c.receivedPacket(data(1))
+		assert.Equal("1", read(c))
+		assert.NoError(c.WritePacket(lob.New([]byte("ok"))))
+
+		// seq 2 is delayed
+		c.receivedPacket(data(3))
+		c.receivedPacket(data(4))
+		if ordered {
+			assert.Equal(ErrTimeout.Error(), read(c))
+		} else {
+			assert.Equal("3", read(c))
+			assert.Equal("4", read(c))
+			assert.Equal(cInitialSeq, c.iSeq)
+
+			// duplicates of packets read out of order are dropped
+			c.receivedPacket(data(3))
+			assert.Equal(ErrTimeout.Error(), read(c))
+		}
+
+		c.receivedPacket(data(2))
+		assert.Equal("2", read(c))
+		if ordered {
+			assert.Equal("3", read(c))
+			assert.Equal("4", read(c))
+		}
+		assert.Equal(uint32(4), c.iSeq)
+		assert.Len(c.readBuffer, 0)
+
+		// duplicates of packets read in order are dropped
+		c.receivedPacket(data(4))
+		assert.Len(c.readBuffer, 0)
+
+		c.Kill()
+	}
+}
+
+func TestReadPacketHeader(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			l      = A.Listen("header", true)
+		)
+		defer l.Close()
+
+		go func() {
+			c, err := l.AcceptChannel()
+			if !assert.NoError(err) {
+				return
+			}
+			defer c.Close()
+
+			_, err = c.ReadPacket()
+			assert.NoError(err)
+
+			for _, n := range []interface{}{1, "bad", 3} {
+				pkt := lob.New([]byte("body"))
+				pkt.Header().Set("n", n)
+				assert.NoError(c.WritePacket(pkt))
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "header", true)
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		assert.NoError(c.WritePacket(&lob.Packet{}))
+
+		var hdr struct {
+			N int `json:"n"`
+		}
+
+		pkt, err := c.ReadPacketHeader(&hdr)
+		if assert.NoError(err) {
+			assert.Equal(1, hdr.N)
+			assert.Equal("body", string(pkt.Body(nil)))
+		}
+
+		// a bad header doesn't lose the packet
+		pkt, err = c.ReadPacketHeader(&hdr)
+		if assert.IsType(&HeaderError{}, err) && assert.NotNil(pkt) {
+			assert.Equal("body", string(pkt.Body(nil)))
+			n, _ := pkt.Header().GetString("n")
+			assert.Equal("bad", n)
+		}
+
+		pkt, err = c.ReadPacketHeader(nil)
+		if assert.NoError(err) {
+			assert.Equal("body", string(pkt.Body(nil)))
+		}
+	})
+}
+
+func TestCloseWith(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			l      = A.Listen("final", true)
+			closed = make(chan error, 2)
+		)
+		defer l.Close()
+
+		type header struct {
+			Status string `json:"status"`
+		}
+
+		go func() {
+			for i := 0; i < 2; i++ {
+				c, err := l.AcceptChannel()
+				if !assert.NoError(err) {
+					return
+				}
+
+				var req header
+				_, err = c.ReadPacketHeader(&req)
+				assert.NoError(err)
+
+				if req.Status == "fail" {
+					closed <- c.ErrorWith(&RemoteError{Code: "failed", Message: "failed"}, header{"partial"}, []byte("last words"))
+				} else {
+					closed <- c.CloseWith(header{"done"}, []byte("bye"))
+				}
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		// the final body is read once, before io.EOF
+		c, err := B.Open(ident, "final", true)
+		if !assert.NoError(err) {
+			return
+		}
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		pkt := lob.New(nil)
+		pkt.Header().Encode(header{"ok"})
+		assert.NoError(c.WritePacket(pkt))
+
+		var resp header
+		pkt, err = c.ReadPacketHeader(&resp)
+		if assert.NoError(err) {
+			assert.Equal("bye", string(pkt.Body(nil)))
+			assert.Equal("done", resp.Status)
+		}
+		for i := 0; i < 2; i++ {
+			pkt, err = c.ReadPacket()
+			assert.Nil(pkt)
+			assert.Equal(io.EOF, err)
+		}
+		assert.NoError(c.Close())
+		assert.NoError(<-closed)
+
+		// the final body is read before the error
+		c, err = B.Open(ident, "final", true)
+		if !assert.NoError(err) {
+			return
+		}
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		pkt = lob.New(nil)
+		pkt.Header().Encode(header{"fail"})
+		assert.NoError(c.WritePacket(pkt))
+
+		pkt, err = c.ReadPacketHeader(&resp)
+		if assert.NoError(err) {
+			assert.Equal("last words", string(pkt.Body(nil)))
+			assert.Equal("partial", resp.Status)
+		}
+		_, err = c.ReadPacket()
+		if assert.IsType(&RemoteError{}, err) {
+			assert.Equal("failed", err.(*RemoteError).Code)
+		}
+		assert.NoError(<-closed)
+		c.Kill()
+	})
+
+	// nothing is sent when the header can't be encoded
+	c := newChannel(hashname.H("a"), "test", true, false, &MockExchange{})
+	defer c.Kill()
+	assert.Error(t, c.CloseWith(make(chan int), nil))
+}
+
+func TestResendOldestFirst(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	x := &MockExchange{}
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	defer c.Kill()
+
+	open := lob.New(nil)
+	open.Header().C, open.Header().HasC = 3, true
+	open.Header().Seq, open.Header().HasSeq = 1, true
+	c.receivedPacket(open)
+	_, err := c.ReadPacket()
+	assert.NoError(err)
+
+	// seqs 1-5 are lost
+	for i := 0; i < 5; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+	}
+	assert.Len(c.writeBuffer, 5)
+
+	resent := func() []uint32 {
+		n := len(x.Calls)
+		c.resendUnackedPackets()
+
+		var seqs []uint32
+		for _, call := range x.Calls[n:] {
+			seqs = append(seqs, call.Arguments.Get(0).(*lob.Packet).Header().Seq)
+		}
+		return seqs
+	}
+
+	// packets were sent since the timer was set
+	assert.Empty(resent())
+
+	assert.Equal("[1 2 3 4 5]", fmt.Sprint(resent()))
+
+	// packets resent during the last rto are skipped
+	assert.Empty(resent())
+}
+
+func TestUnreliableChannel(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		cwire = &wireExchange{}
+		rwire = &wireExchange{}
+		c     = newChannel(hashname.H("a"), "test", false, false, cwire)
+		r     = newChannel(hashname.H("a"), "test", false, true, rwire)
+	)
+	c.id, r.id = 3, 3
+	defer c.Kill()
+	defer r.Kill()
+
+	decode := func(data []byte) *lob.Packet {
+		pkt, err := lob.Decode(bufpool.New().Set(data))
+		assert.NoError(err)
+		return pkt
+	}
+	read := func(c *Channel) string {
+		c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return err.Error()
+		}
+		return string(pkt.Body(nil))
+	}
+
+	// the initial packet is answered
+	assert.NoError(c.WritePacket(lob.New([]byte("open"))))
+	r.receivedPacket(decode(cwire.packets()[0]))
+	assert.Equal("open", read(r))
+	assert.NoError(r.WritePacket(lob.New([]byte("reply"))))
+	c.receivedPacket(decode(rwire.packets()[0]))
+	assert.Equal("reply", read(c))
+
+	for _, body := range []string{"a", "b", "c"} {
+		assert.NoError(c.WritePacket(lob.New([]byte(body))))
+	}
+
+	// nothing is kept for retransmission
+	assert.Empty(c.DebugDump().WriteBuffer)
+
+	// packets carry no seq, ack or miss headers
+	sent := cwire.packets()
+	if !assert.Len(sent, 4) {
+		return
+	}
+	for _, data := range append(sent, rwire.packets()...) {
+		hdr := decode(data).Header()
+		assert.False(hdr.HasSeq)
+		assert.False(hdr.HasAck)
+		assert.False(hdr.HasMiss)
+	}
+
+	// a lost packet doesn't hold up the packets after it
+	r.receivedPacket(decode(sent[1]))
+	r.receivedPacket(decode(sent[3]))
+	assert.Equal("a", read(r))
+	assert.Equal("c", read(r))
+	assert.Equal(ErrTimeout.Error(), read(r))
+
+	// the receiver never acks
+	assert.Len(rwire.packets(), 1)
+}
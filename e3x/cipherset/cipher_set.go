@@ -15,6 +15,7 @@ var (
 	ErrInvalidPacket  = errors.New("cipherset: invalid packet")
 )
 
+// Cipher is implemented by the cipher sets (see Register).
 type Cipher interface {
 	CSID() uint8
 
@@ -51,6 +52,24 @@ type State interface {
 
 	EncryptPacket(pkt *lob.Packet) (*lob.Packet, error)
 	DecryptPacket(pkt *lob.Packet) (*lob.Packet, error)
+
+	// ExportKey derives length bytes bound to the current line from the line
+	// secret and label (see ExportKey). Both ends derive the same value.
+	ExportKey(label string, length int) ([]byte, error)
+}
+
+// Rekeyer can be implemented by a State which can replace its local line key
+// while the line is in use.
+type Rekeyer interface {
+	// Rekey generates a new local line key. The new key is announced in the
+	// messages (and handshakes) encrypted after Rekey returns but packets are
+	// still encrypted and decrypted with the current line keys.
+	Rekey() error
+
+	// Cutover switches to the line keys derived from the key generated by
+	// Rekey. It must be called once the remote end applied a handshake
+	// carrying the new key. Cutover is a noop when there is no pending key.
+	Cutover()
 }
 
 type Handshake interface {
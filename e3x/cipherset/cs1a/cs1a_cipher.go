@@ -23,6 +23,7 @@ import (
 var (
 	_ cipherset.Cipher    = (*cipher)(nil)
 	_ cipherset.State     = (*state)(nil)
+	_ cipherset.Rekeyer   = (*state)(nil)
 	_ cipherset.Key       = (*key)(nil)
 	_ cipherset.Handshake = (*handshake)(nil)
 )
@@ -253,11 +254,13 @@ type state struct {
 	localKey          *key
 	remoteKey         *key
 	localLineKey      *key
+	pendingLineKey    *key // generated by Rekey; used after Cutover
 	remoteLineKey     *key
 	localToken        *cipherset.Token
 	remoteToken       *cipherset.Token
 	lineEncryptionKey []byte
 	lineDecryptionKey []byte
+	lineSecret        []byte
 }
 
 func (*state) CSID() uint8 { return 0x1a }
@@ -270,6 +273,9 @@ func (s *state) IsHigh() bool {
 }
 
 func (s *state) LocalToken() cipherset.Token {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
 	if s.localToken != nil {
 		return *s.localToken
 	}
@@ -277,6 +283,9 @@ func (s *state) LocalToken() cipherset.Token {
 }
 
 func (s *state) RemoteToken() cipherset.Token {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
 	if s.remoteToken != nil {
 		return *s.remoteToken
 	}
@@ -343,6 +352,8 @@ func (s *state) update() {
 		sha.Write(s.remoteLineKey.Public())
 		sha.Write(s.localLineKey.Public())
 		s.lineDecryptionKey = fold(sha.Sum(nil), 16)
+
+		s.lineSecret = sharedKey
 	}
 }
 
@@ -385,8 +396,10 @@ func (s *state) EncryptMessage(in []byte) ([]byte, error) {
 		panic("unable to encrypt message")
 	}
 
+	lineKey := s.messageLineKey()
+
 	// copy public senderLineKey
-	copy(raw[:21], s.localLineKey.Public())
+	copy(raw[:21], lineKey.Public())
 
 	// copy the nonce
 	_, err := io.ReadFull(rand.Reader, raw[21:21+4])
@@ -398,7 +411,7 @@ func (s *state) EncryptMessage(in []byte) ([]byte, error) {
 		shared := ecdh.ComputeShared(
 			secp160r1.P160(),
 			s.remoteKey.pub.x, s.remoteKey.pub.y,
-			s.localLineKey.prv.d)
+			lineKey.prv.d)
 		if shared == nil {
 			return nil, cipherset.ErrInvalidMessage
 		}
@@ -470,6 +483,7 @@ func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
 		s.remoteToken = nil
 		s.lineDecryptionKey = nil
 		s.lineEncryptionKey = nil
+		s.lineSecret = nil
 	}
 
 	s.setRemoteLineKey(hs.lineKey)
@@ -479,6 +493,62 @@ func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
 	return true
 }
 
+func (s *state) Rekey() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.localLineKey == nil {
+		return cipherset.ErrInvalidState
+	}
+
+	k, err := generateKey()
+	if err != nil {
+		return err
+	}
+
+	s.pendingLineKey = k
+	return nil
+}
+
+func (s *state) Cutover() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.pendingLineKey == nil {
+		return
+	}
+
+	s.localLineKey, s.pendingLineKey = s.pendingLineKey, nil
+	s.localToken = nil
+	s.lineEncryptionKey = nil
+	s.lineDecryptionKey = nil
+	s.lineSecret = nil
+	s.update()
+}
+
+// messageLineKey returns the line key announced in messages; this is the
+// pending line key while a rekey is in progress.
+func (s *state) messageLineKey() *key {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.pendingLineKey != nil {
+		return s.pendingLineKey
+	}
+	return s.localLineKey
+}
+
+func (s *state) ExportKey(label string, length int) ([]byte, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.lineSecret == nil {
+		return nil, cipherset.ErrInvalidState
+	}
+
+	return cipherset.ExportKey(s.lineSecret, s.localLineKey.Public(), s.remoteLineKey.Public(), label, length)
+}
+
 func (s *state) EncryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
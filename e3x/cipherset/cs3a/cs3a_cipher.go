@@ -21,6 +21,7 @@ import (
 var (
 	_ cipherset.Cipher    = (*cipher)(nil)
 	_ cipherset.State     = (*state)(nil)
+	_ cipherset.Rekeyer   = (*state)(nil)
 	_ cipherset.Key       = (*key)(nil)
 	_ cipherset.Handshake = (*handshake)(nil)
 )
@@ -245,12 +246,14 @@ type state struct {
 	localKey          *key
 	remoteKey         *key
 	localLineKey      *key
+	pendingLineKey    *key // generated by Rekey; used after Cutover
 	remoteLineKey     *key
 	localToken        *cipherset.Token
 	remoteToken       *cipherset.Token
 	macKeyBase        *[lenKey]byte
 	lineEncryptionKey *[lenKey]byte
 	lineDecryptionKey *[lenKey]byte
+	lineSecret        []byte
 	nonce             *[lenNonce]byte
 	pktNoncePrefix    *[16]byte
 	pktNonceSuffix    uint64
@@ -266,6 +269,9 @@ func (s *state) IsHigh() bool {
 }
 
 func (s *state) LocalToken() cipherset.Token {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
 	if s.localToken != nil {
 		return *s.localToken
 	}
@@ -273,6 +279,9 @@ func (s *state) LocalToken() cipherset.Token {
 }
 
 func (s *state) RemoteToken() cipherset.Token {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
 	if s.remoteToken != nil {
 		return *s.remoteToken
 	}
@@ -356,6 +365,8 @@ func (s *state) update() {
 		sha.Write(s.remoteLineKey.pub[:])
 		sha.Write(s.localLineKey.pub[:])
 		sha.Sum((*s.lineDecryptionKey)[:0])
+
+		s.lineSecret = sharedKey[:]
 	}
 }
 
@@ -454,14 +465,16 @@ func (s *state) EncryptMessage(in []byte) ([]byte, error) {
 		panic("unable to encrypt message")
 	}
 
+	lineKey := s.messageLineKey()
+
 	// copy public senderLineKey
-	copy(raw[:lenKey], (*s.localLineKey.pub)[:])
+	copy(raw[:lenKey], (*lineKey.pub)[:])
 
 	// copy the nonce
 	copy(raw[lenKey:lenKey+lenNonce], s.nonce[:lenNonce])
 
 	// make the agreedKey
-	box.Precompute(&agreedKey, s.remoteKey.pub, s.localLineKey.prv)
+	box.Precompute(&agreedKey, s.remoteKey.pub, lineKey.prv)
 
 	// encrypt p
 	ctLen = len(box.SealAfterPrecomputation(raw[lenKey+lenNonce:lenKey+lenNonce], in, s.nonce, &agreedKey))
@@ -503,6 +516,7 @@ func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
 		s.remoteToken = nil
 		s.lineDecryptionKey = nil
 		s.lineEncryptionKey = nil
+		s.lineSecret = nil
 	}
 
 	s.setRemoteLineKey(hs.lineKey)
@@ -512,6 +526,62 @@ func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
 	return true
 }
 
+func (s *state) Rekey() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.localLineKey == nil {
+		return cipherset.ErrInvalidState
+	}
+
+	k, err := generateKey()
+	if err != nil {
+		return err
+	}
+
+	s.pendingLineKey = k
+	return nil
+}
+
+func (s *state) Cutover() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.pendingLineKey == nil {
+		return
+	}
+
+	s.localLineKey, s.pendingLineKey = s.pendingLineKey, nil
+	s.localToken = nil
+	s.lineEncryptionKey = nil
+	s.lineDecryptionKey = nil
+	s.lineSecret = nil
+	s.update()
+}
+
+// messageLineKey returns the line key announced in messages; this is the
+// pending line key while a rekey is in progress.
+func (s *state) messageLineKey() *key {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.pendingLineKey != nil {
+		return s.pendingLineKey
+	}
+	return s.localLineKey
+}
+
+func (s *state) ExportKey(label string, length int) ([]byte, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.lineSecret == nil {
+		return nil, cipherset.ErrInvalidState
+	}
+
+	return cipherset.ExportKey(s.lineSecret, s.localLineKey.pub[:], s.remoteLineKey.pub[:], label, length)
+}
+
 func (s *state) EncryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
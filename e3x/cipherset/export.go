@@ -0,0 +1,56 @@
+package cipherset
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// ErrInvalidLength is returned by ExportKey when the requested length can't be
+// derived.
+var ErrInvalidLength = errors.New("cipherset: invalid export length")
+
+const exportLabelPrefix = "telehash exporter "
+
+// ExportKey derives length bytes from the shared line secret and label using
+// HKDF-SHA256 (RFC 5869). The public line keys of both ends are used as the
+// salt (so the value is bound to the line) and are ordered so that both ends
+// derive the same value. length must be between 1 and 8160.
+func ExportKey(secret, localLineKey, remoteLineKey []byte, label string, length int) ([]byte, error) {
+	if length <= 0 || length > 255*sha256.Size {
+		return nil, ErrInvalidLength
+	}
+	if len(secret) == 0 {
+		return nil, ErrInvalidState
+	}
+
+	salt := make([]byte, 0, len(localLineKey)+len(remoteLineKey))
+	if bytes.Compare(localLineKey, remoteLineKey) < 0 {
+		salt = append(append(salt, localLineKey...), remoteLineKey...)
+	} else {
+		salt = append(append(salt, remoteLineKey...), localLineKey...)
+	}
+
+	// extract
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(secret)
+	prk := mac.Sum(nil)
+
+	// expand
+	var (
+		out  = make([]byte, 0, length+sha256.Size)
+		prev []byte
+	)
+	mac = hmac.New(sha256.New, prk)
+	for i := byte(1); len(out) < length; i++ {
+		mac.Reset()
+		mac.Write(prev)
+		mac.Write([]byte(exportLabelPrefix + label))
+		mac.Write([]byte{i})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+
+	return out[:length], nil
+}
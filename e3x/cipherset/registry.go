@@ -6,6 +6,12 @@ import (
 
 var ciphers = map[uint8]Cipher{}
 
+// Register makes the cipher set c available under csid. Cipher sets register
+// themselves from an init function; importing the package of a cipher set (see
+// e3x/default_ciphersets.go) is enough to use it for key generation and line
+// establishment. The handshakes, messages and line states of an exchange are
+// dispatched to the cipher set by their csid. Register panics when csid is
+// already registered.
 func Register(csid uint8, c Cipher) {
 	if ciphers[csid] != nil {
 		panic("CSID is already registered")
@@ -0,0 +1,77 @@
+package cipherset
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+const stubCSID = 0xfe
+
+var errStub = errors.New("stub")
+
+// stubCipher records the calls dispatched to it by the registry.
+type stubCipher struct {
+	calls []string
+}
+
+func (c *stubCipher) CSID() uint8 { return stubCSID }
+
+func (c *stubCipher) DecodeKeyBytes(pub, prv []byte) (Key, error) {
+	c.calls = append(c.calls, "DecodeKeyBytes")
+	return opaqueKey{stubCSID, pub, prv}, nil
+}
+
+func (c *stubCipher) GenerateKey() (Key, error) {
+	c.calls = append(c.calls, "GenerateKey")
+	return opaqueKey{csid: stubCSID}, nil
+}
+
+func (c *stubCipher) DecryptMessage(localKey, remoteKey Key, p []byte) ([]byte, error) {
+	c.calls = append(c.calls, "DecryptMessage")
+	return nil, errStub
+}
+
+func (c *stubCipher) DecryptHandshake(localKey Key, p []byte) (Handshake, error) {
+	c.calls = append(c.calls, "DecryptHandshake")
+	return nil, errStub
+}
+
+func (c *stubCipher) NewState(localKey Key) (State, error) {
+	c.calls = append(c.calls, "NewState")
+	return nil, errStub
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	stub := &stubCipher{}
+	Register(stubCSID, stub)
+	defer delete(ciphers, stubCSID)
+
+	assert.Panics(func() { Register(stubCSID, &stubCipher{}) })
+
+	key, err := GenerateKey(stubCSID)
+	assert.NoError(err)
+	assert.Equal(uint8(stubCSID), key.CSID())
+
+	_, err = DecodeKeyBytes(stubCSID, []byte("pub"), nil)
+	assert.NoError(err)
+	_, err = DecryptMessage(stubCSID, key, key, nil)
+	assert.Equal(errStub, err)
+	_, err = DecryptHandshake(stubCSID, key, nil)
+	assert.Equal(errStub, err)
+	_, err = NewState(stubCSID, key)
+	assert.Equal(errStub, err)
+
+	assert.Equal([]string{
+		"GenerateKey", "DecodeKeyBytes", "DecryptMessage", "DecryptHandshake", "NewState",
+	}, stub.calls)
+
+	// unregistered cipher sets
+	_, err = GenerateKey(0xfd)
+	assert.Equal(ErrUnknownCSID, err)
+	_, err = NewState(0xfd, key)
+	assert.Equal(ErrUnknownCSID, err)
+}
@@ -241,6 +241,139 @@ func (s *cipherTestSuite) TestPacketEncryption() {
 	assert.Equal([]byte("Bye world!"), pkt.Body(nil))
 }
 
+func (s *cipherTestSuite) TestExportKey() {
+	var (
+		assert = s.Assertions
+		c      = s.cipher
+	)
+
+	ka, err := c.GenerateKey()
+	assert.NoError(err)
+	kb, err := c.GenerateKey()
+	assert.NoError(err)
+
+	sa, err := c.NewState(ka)
+	assert.NoError(err)
+	sb, err := c.NewState(kb)
+	assert.NoError(err)
+
+	_, err = sa.ExportKey("test", 32)
+	assert.Equal(cipherset.ErrInvalidState, err)
+
+	assert.NoError(sa.SetRemoteKey(kb))
+	box, err := sa.EncryptHandshake(1, nil)
+	assert.NoError(err)
+	hb, err := c.DecryptHandshake(kb, box)
+	assert.NoError(err)
+	assert.True(sb.ApplyHandshake(hb))
+	box, err = sb.EncryptHandshake(1, nil)
+	assert.NoError(err)
+	ha, err := c.DecryptHandshake(ka, box)
+	assert.NoError(err)
+	assert.True(sa.ApplyHandshake(ha))
+
+	a, err := sa.ExportKey("test", 48)
+	assert.NoError(err)
+	assert.Len(a, 48)
+	b, err := sb.ExportKey("test", 48)
+	assert.NoError(err)
+	assert.Equal(a, b)
+
+	other, err := sa.ExportKey("other", 48)
+	assert.NoError(err)
+	assert.False(bytes.Equal(a, other))
+
+	short, err := sa.ExportKey("test", 16)
+	assert.NoError(err)
+	assert.Equal(a[:16], short)
+
+	_, err = sa.ExportKey("test", 0)
+	assert.Equal(cipherset.ErrInvalidLength, err)
+}
+
+func (s *cipherTestSuite) TestRekey() {
+	var (
+		assert = s.Assertions
+		c      = s.cipher
+	)
+
+	ka, err := c.GenerateKey()
+	assert.NoError(err)
+	kb, err := c.GenerateKey()
+	assert.NoError(err)
+
+	sa, err := c.NewState(ka)
+	assert.NoError(err)
+	sb, err := c.NewState(kb)
+	assert.NoError(err)
+
+	ra, ok := sa.(cipherset.Rekeyer)
+	if !ok {
+		return // rekeying is optional
+	}
+
+	// a packet from a to b and back
+	roundtrip := func(body string) {
+		pkt, err := sa.EncryptPacket(lob.New([]byte(body)))
+		if assert.NoError(err) {
+			pkt, err = sb.DecryptPacket(pkt)
+			if assert.NoError(err) {
+				assert.Equal(body, string(pkt.Body(nil)))
+			}
+		}
+
+		pkt, err = sb.EncryptPacket(lob.New([]byte(body)))
+		if assert.NoError(err) {
+			pkt, err = sa.DecryptPacket(pkt)
+			if assert.NoError(err) {
+				assert.Equal(body, string(pkt.Body(nil)))
+			}
+		}
+	}
+
+	assert.NoError(sa.SetRemoteKey(kb))
+	box, err := sa.EncryptHandshake(1, nil)
+	assert.NoError(err)
+	hb, err := c.DecryptHandshake(kb, box)
+	assert.NoError(err)
+	assert.True(sb.ApplyHandshake(hb))
+	box, err = sb.EncryptHandshake(1, nil)
+	assert.NoError(err)
+	ha, err := c.DecryptHandshake(ka, box)
+	assert.NoError(err)
+	assert.True(sa.ApplyHandshake(ha))
+	roundtrip("before")
+
+	oldToken := sa.LocalToken()
+	assert.NoError(ra.Rekey())
+
+	// the old line is used until the cutover
+	roundtrip("pending")
+	assert.Equal(oldToken, sa.LocalToken())
+
+	box, err = sa.EncryptHandshake(3, nil)
+	assert.NoError(err)
+	hb, err = c.DecryptHandshake(kb, box)
+	assert.NoError(err)
+	assert.True(sb.ApplyHandshake(hb))
+	box, err = sb.EncryptHandshake(3, nil)
+	assert.NoError(err)
+	ha, err = c.DecryptHandshake(ka, box)
+	assert.NoError(err)
+	assert.True(sa.ApplyHandshake(ha))
+	ra.Cutover()
+
+	assert.NotEqual(oldToken, sa.LocalToken())
+	assert.Equal(sa.LocalToken(), sb.RemoteToken())
+	roundtrip("after")
+
+	a, err := sa.ExportKey("test", 32)
+	assert.NoError(err)
+	b, err := sb.ExportKey("test", 32)
+	assert.NoError(err)
+	assert.Equal(a, b)
+}
+
 func BenchmarkPacketEncryption(b *testing.B, c cipherset.Cipher) {
 	pkt := lob.New(bytes.Repeat([]byte{'x'}, 1024))
 
@@ -0,0 +1,26 @@
+package e3x
+
+import (
+	"context"
+	"sync"
+)
+
+// wakeOnDone broadcasts cnd (while holding l) once ctx is done, so the waiters
+// on cnd can check ctx.Err(). stop must be called once the waiting is over.
+func wakeOnDone(ctx context.Context, l sync.Locker, cnd *sync.Cond) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Lock()
+			cnd.Broadcast()
+			l.Unlock()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
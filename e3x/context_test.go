@@ -0,0 +1,90 @@
+package e3x
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestReadPacketContext(t *testing.T) {
+	logs.ResetLogger()
+
+	var (
+		assert = assert.New(t)
+		x      = &MockExchange{}
+	)
+
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel(hashname.H("a"), "test", true, true, x)
+	c.id = 3
+	defer c.Kill()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.ReadPacketContext(ctx)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	// the channel is still usable
+	pkt := lob.New([]byte("data"))
+	pkt.Header().C, pkt.Header().HasC = 3, true
+	pkt.Header().Seq, pkt.Header().HasSeq = 1, true
+	c.receivedPacket(pkt)
+	pkt, err = c.ReadPacketContext(ctx)
+	if assert.NoError(err) {
+		assert.Equal([]byte("data"), pkt.Body(nil))
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), MaxPendingHandshakes(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	// peers which are gone never answer the handshake
+	var idents []*Identity
+	for i := 0; i < 2; i++ {
+		B, err := Open(Transport(inproc.Config{}), Log(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ident, err := B.LocalIdentity()
+		assert.NoError(err)
+		idents = append(idents, ident)
+		B.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = A.DialContext(ctx, idents[0])
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.True(time.Since(start) < time.Second)
+
+	// the abandoned handshake still holds the only dial slot
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = A.OpenContext(ctx, idents[1], "test", true)
+	assert.Equal(context.Canceled, err)
+
+	x, err := A.CreateExchange(idents[1])
+	if assert.NoError(err) {
+		x.mtx.Lock()
+		assert.Equal(ExchangeInitialising, x.state, "an abandoned dial leaves nothing behind")
+		x.mtx.Unlock()
+	}
+}
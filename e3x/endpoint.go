@@ -1,12 +1,14 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/telehash/gogotelehash/e3x/cipherset"
 	"github.com/telehash/gogotelehash/internal/hashname"
@@ -36,12 +38,36 @@ type Endpoint struct {
 	state endpointState
 	err   error
 
-	hashname        hashname.H
-	keys            cipherset.Keys
-	log             *logs.Logger
-	transportConfig transports.Config
-	transport       transports.Transport
-	modules         map[interface{}]Module
+	identMtx         sync.RWMutex
+	hashname         hashname.H
+	keys             cipherset.Keys
+	drain            *identityDrain
+	draining         uint32 // set (atomically) while drain is not nil
+	log              *logs.Logger
+	transportConfig  transports.Config
+	transport        transports.Transport
+	modules          map[interface{}]Module
+	lineFilter       LineFilterFunc
+	peerRateLimits   map[hashname.H]int
+	handshakeLimiter *rateLimiter
+	rcvBudget        *receiveBudget
+	dialLimiter      *dialLimiter
+	addrPolicy       AddressFamilyPolicy
+	checkPeerSupport bool
+	inboundTap       InboundTapFunc
+	traffic          *traffic
+	events           *eventBus
+	rtts             *rttHistogram
+	rtoMin, rtoMax   time.Duration
+	channelLinger    time.Duration
+	channelKeepalive time.Duration
+	lineKeepalive    time.Duration
+	lineIdleTimeout  time.Duration
+	rekeyInterval    time.Duration
+	rekeyBytes       uint64
+	maxChannels      int
+	maxResends       int
+	csids            []uint8
 
 	endpointHooks EndpointHooks
 	exchangeHooks ExchangeHooks
@@ -49,17 +75,28 @@ type Endpoint struct {
 
 	tokens      map[cipherset.Token]*Exchange
 	hashnames   map[hashname.H]*Exchange
+	dormant     map[hashname.H]*Identity // see LineIdleTimeout
 	listenerSet *listenerSet
 }
 
 type EndpointOption func(e *Endpoint) error
 
+// LineFilterFunc is consulted for every handshake an endpoint receives. addr
+// is the address the handshake was received from and hn is the hashname
+// presented by the remote peer. When a LineFilterFunc returns a non-nil error
+// the handshake is dropped and no exchange is established.
+type LineFilterFunc func(addr net.Addr, hn hashname.H) error
+
 func Open(options ...EndpointOption) (*Endpoint, error) {
 	e := &Endpoint{
 		TID:       tracer.NewID(),
 		modules:   make(map[interface{}]Module),
 		tokens:    make(map[cipherset.Token]*Exchange),
 		hashnames: make(map[hashname.H]*Exchange),
+		dormant:   make(map[hashname.H]*Identity),
+		traffic:   &traffic{},
+		events:    newEventBus(),
+		rtts:      &rttHistogram{},
 	}
 
 	e.listenerSet = newListenerSet()
@@ -74,10 +111,14 @@ func Open(options ...EndpointOption) (*Endpoint, error) {
 	e.exchangeHooks.endpoint = e
 	e.channelHooks.endpoint = e
 	e.exchangeHooks.Register(ExchangeHook{OnClosed: e.onExchangeClosed})
+	e.exchangeHooks.Register(ExchangeHook{OnOpened: e.publishExchangeOpened, OnClosed: e.publishExchangeClosed})
+	e.exchangeHooks.Register(ExchangeHook{OnOpened: e.forgetIdleLine, OnClosed: e.rememberIdleLine})
+	e.channelHooks.Register(ChannelHook{OnOpened: e.publishChannelOpened, OnClosed: e.publishChannelClosed})
 
 	err := e.setOptions(
 		RegisterModule(modTransportsKey, &modTransports{e}),
-		RegisterModule(modNetwatchKey, &modNetwatch{endpoint: e}))
+		RegisterModule(modNetwatchKey, &modNetwatch{endpoint: e}),
+		RegisterModule(modCapsKey, &modCaps{endpoint: e}))
 	if err != nil {
 		return nil, e.traceError(err)
 	}
@@ -245,7 +286,7 @@ func defaultRandomKeys(e *Endpoint) error {
 		return nil
 	}
 
-	keys, err := cipherset.GenerateKeys()
+	keys, err := cipherset.GenerateKeys(e.csids...)
 	if err != nil {
 		return err
 	}
@@ -253,6 +294,16 @@ func defaultRandomKeys(e *Endpoint) error {
 	return Keys(keys)(e)
 }
 
+// CipherSets limits the keys which are generated for the endpoint to the cipher
+// sets identified by csids; by default a key is generated for every registered
+// cipher set. It has no effect when the keys are given with Keys.
+func CipherSets(csids ...uint8) EndpointOption {
+	return func(e *Endpoint) error {
+		e.csids = csids
+		return nil
+	}
+}
+
 func Log(w io.Writer) EndpointOption {
 	if w == nil {
 		w = os.Stderr
@@ -267,6 +318,18 @@ func Log(w io.Writer) EndpointOption {
 	}
 }
 
+// LogSink makes the endpoint log to s (see logs.SetSink for routing single
+// modules).
+func LogSink(s logs.Sink) EndpointOption {
+	return func(e *Endpoint) error {
+		e.log = logs.NewWithSink(s).Module("e3x")
+		if e.hashname != "" {
+			e.log = e.log.From(e.hashname)
+		}
+		return nil
+	}
+}
+
 func DisableLog() EndpointOption {
 	return func(e *Endpoint) error {
 		e.log = nil
@@ -274,6 +337,69 @@ func DisableLog() EndpointOption {
 	}
 }
 
+// LineFilter installs f as the line filter of the endpoint. It can be used to
+// implement allow and deny lists based on source address or hashname.
+func LineFilter(f LineFilterFunc) EndpointOption {
+	return func(e *Endpoint) error {
+		e.lineFilter = f
+		return nil
+	}
+}
+
+// HandshakeRateLimit limits the number of handshakes (from unknown lines) the
+// endpoint decrypts to perSec per second, regardless of their source. Excess
+// handshakes are dropped before any expensive crypto is performed. A perSec of
+// zero (or less) removes the limit.
+func HandshakeRateLimit(perSec int) EndpointOption {
+	return func(e *Endpoint) error {
+		e.handshakeLimiter = nil
+		if perSec > 0 {
+			e.handshakeLimiter = newHandshakeLimiter(perSec)
+		}
+		return nil
+	}
+}
+
+// MaxPendingHandshakes limits the number of exchanges which are dialing at
+// once (see Exchange.Dial); additional dials are queued until one of the pending
+// dials completed. Unlike HandshakeRateLimit it applies to outbound handshakes.
+// A limit of zero (or less) removes the cap.
+func MaxPendingHandshakes(n int) EndpointOption {
+	return func(e *Endpoint) error {
+		e.dialLimiter = nil
+		if n > 0 {
+			e.dialLimiter = newDialLimiter(n)
+		}
+		return nil
+	}
+}
+
+// ReceiveBufferLimit caps the number of bytes held by packets which were
+// received, on any channel of the endpoint, but not yet read. When the cap is
+// reached incoming packets are dropped without being acked, so the senders on
+// channels whose readers don't keep up stall until those readers caught up.
+// A limit of zero (or less) removes the cap.
+func ReceiveBufferLimit(bytes int) EndpointOption {
+	return func(e *Endpoint) error {
+		e.rcvBudget = nil
+		if bytes > 0 {
+			e.rcvBudget = newReceiveBudget(bytes)
+		}
+		return nil
+	}
+}
+
+// CheckPeerSupport makes Open fail fast with ErrUnsupportedChannelType when
+// the peer advertised the channel types it listens for and the requested type
+// isn't one of them (see PeerSupports). Channels are opened as usual while the
+// peer's channel types are unknown.
+func CheckPeerSupport() EndpointOption {
+	return func(e *Endpoint) error {
+		e.checkPeerSupport = true
+		return nil
+	}
+}
+
 func Transport(config transports.Config) EndpointOption {
 	return func(e *Endpoint) error {
 		if e.transportConfig != nil {
@@ -304,11 +430,19 @@ func (e *Endpoint) Listen(typ string, reliable bool) *Listener {
 }
 
 func (e *Endpoint) LocalHashname() hashname.H {
+	e.identMtx.RLock()
+	defer e.identMtx.RUnlock()
 	return e.hashname
 }
 
 func (e *Endpoint) LocalIdentity() (*Identity, error) {
-	return NewIdentity(e.keys, nil, e.transport.Addrs())
+	return NewIdentity(e.localKeys(), nil, e.transport.Addrs())
+}
+
+func (e *Endpoint) localKeys() cipherset.Keys {
+	e.identMtx.RLock()
+	defer e.identMtx.RUnlock()
+	return e.keys
 }
 
 func (e *Endpoint) start() error {
@@ -333,7 +467,7 @@ func (e *Endpoint) start() error {
 		e.err = err
 		return err
 	}
-	e.transport = t
+	e.transport = &trafficTransport{t, e.traffic}
 	go e.acceptConnections()
 
 	for _, mod := range e.modules {
@@ -376,6 +510,7 @@ func (e *Endpoint) close() error {
 	e.mtx.Lock()
 
 	e.transport.Close() //TODO handle err
+	e.events.close()
 
 	if e.state == endpointStateRunning {
 		e.state = endpointStateTerminated
@@ -401,13 +536,8 @@ func (e *Endpoint) acceptConnections() {
 }
 
 func (e *Endpoint) accept(conn net.Conn) {
-	var (
-		token cipherset.Token
-		msg   = bufpool.New()
-		err   error
-		n     int
-	)
-	n, err = conn.Read(msg.RawBytes()[:1500])
+	msg := bufpool.New()
+	n, err := conn.Read(msg.RawBytes()[:1500])
 	if err != nil {
 		msg.Free()
 		conn.Close()
@@ -415,6 +545,16 @@ func (e *Endpoint) accept(conn net.Conn) {
 	}
 	msg.SetLen(n)
 
+	e.acceptMessage(msg, conn)
+}
+
+// acceptMessage routes msg, which was read from conn, to its exchange.
+func (e *Endpoint) acceptMessage(msg *bufpool.Buffer, conn net.Conn) {
+	var (
+		token cipherset.Token
+		err   error
+	)
+
 	// msg is either a handshake or a channel packet
 	// when msg is a handshake decrypt it and pass it to the associated exchange
 	// when msg is a channel packet lookup the exchange and pass it the msg
@@ -462,7 +602,7 @@ func (e *Endpoint) accept(conn net.Conn) {
 
 	var (
 		csid = msg.RawBytes()[2]
-		key  = e.keys[csid]
+		key  = localIdent.keys[csid]
 	)
 	if key == nil {
 		if e.endpointHooks.DropPacket(msg.Get(nil), conn, nil) != ErrStopPropagation {
@@ -472,8 +612,26 @@ func (e *Endpoint) accept(conn net.Conn) {
 		return // no key for csid
 	}
 
+	if !e.handshakeLimiter.allow(1) {
+		statEndpointRcvHandshakeLimited.Add(1)
+		if e.endpointHooks.DropPacket(msg.Get(nil), conn, nil) != ErrStopPropagation {
+			conn.Close()
+		}
+		e.traceDroppedPacket(msg.Get(nil), conn, "handshake rate limit")
+		msg.Free()
+		return // drop
+	}
+
 	handshake, err := cipherset.DecryptHandshake(csid, key, msg.RawBytes()[3:])
 	if err != nil {
+		// the handshake may be addressed to an identity which was rotated
+		if x := e.decryptDrainingHandshake(csid, msg); x != nil {
+			e.receivedExchangeHandshake(x, msg, conn)
+			return
+		}
+
+		e.traffic.addHandshakeFailure()
+		statEndpointRcvHandshakeFailed.Add(1)
 		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
 			conn.Close()
 		}
@@ -485,6 +643,8 @@ func (e *Endpoint) accept(conn net.Conn) {
 	hn, err := hashname.FromKeyAndIntermediates(csid,
 		handshake.PublicKey().Public(), handshake.Parts())
 	if err != nil {
+		e.traffic.addHandshakeFailure()
+		statEndpointRcvHandshakeFailed.Add(1)
 		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
 			conn.Close()
 		}
@@ -493,29 +653,28 @@ func (e *Endpoint) accept(conn net.Conn) {
 		return // drop
 	}
 
-	exchange = e.hashnames[hn]
-	if exchange != nil {
-		oldLocalToken := exchange.LocalToken()
-		oldRemoteToken := exchange.RemoteToken()
-		exchange.received(newMessage(msg, newPipe(e.transport, conn, nil, exchange)))
-		newLocalToken := exchange.LocalToken()
-		newRemoteToken := exchange.RemoteToken()
-
-		if oldLocalToken != newLocalToken {
-			delete(e.tokens, oldLocalToken)
-			e.tokens[newLocalToken] = exchange
-		}
-
-		if oldRemoteToken != newRemoteToken {
-			delete(e.tokens, oldRemoteToken)
-			e.tokens[newRemoteToken] = exchange
+	if e.lineFilter != nil {
+		if err := e.lineFilter(conn.RemoteAddr(), hn); err != nil {
+			statEndpointRcvHandshakeFiltered.Add(1)
+			if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
+				conn.Close()
+			}
+			e.traceDroppedPacket(msg.Get(nil), conn, err.Error())
+			msg.Free()
+			return // drop
 		}
+	}
 
+	exchange = e.hashnames[hn]
+	if exchange != nil {
+		e.receivedExchangeHandshake(exchange, msg, conn)
 		return
 	}
 
 	exchange, err = newExchange(localIdent, nil, handshake, e.log, registerEndpoint(e))
 	if err != nil {
+		e.traffic.addHandshakeFailure()
+		statEndpointRcvHandshakeFailed.Add(1)
 		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
 			conn.Close()
 		}
@@ -524,6 +683,7 @@ func (e *Endpoint) accept(conn net.Conn) {
 		return // drop
 	}
 
+	e.applyPeerRateLimit(hn, exchange)
 	e.hashnames[hn] = exchange
 	e.tokens[exchange.LocalToken()] = exchange
 	e.tokens[exchange.RemoteToken()] = exchange
@@ -531,16 +691,47 @@ func (e *Endpoint) accept(conn net.Conn) {
 	exchange.received(newMessage(msg, newPipe(e.transport, conn, nil, exchange)))
 }
 
+// receivedExchangeHandshake passes a handshake to an existing exchange and
+// updates the tokens the exchange is registered under. Must be called with
+// e.mtx held.
+func (e *Endpoint) receivedExchangeHandshake(exchange *Exchange, msg *bufpool.Buffer, conn net.Conn) {
+	oldLocalToken := exchange.LocalToken()
+	oldRemoteToken := exchange.RemoteToken()
+	exchange.received(newMessage(msg, newPipe(e.transport, conn, nil, exchange)))
+	newLocalToken := exchange.LocalToken()
+	newRemoteToken := exchange.RemoteToken()
+
+	if oldLocalToken != newLocalToken {
+		delete(e.tokens, oldLocalToken)
+		e.tokens[newLocalToken] = exchange
+	}
+
+	if oldRemoteToken != newRemoteToken {
+		delete(e.tokens, oldRemoteToken)
+		e.tokens[newRemoteToken] = exchange
+	}
+}
+
 func (e *Endpoint) onExchangeClosed(_ *Endpoint, x *Exchange, reason error) error {
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
 
 	if x.remoteIdent != nil {
-		delete(e.hashnames, x.remoteIdent.Hashname())
+		hn := x.remoteIdent.Hashname()
+		if e.hashnames[hn] == x {
+			delete(e.hashnames, hn)
+		}
+		if e.isDraining(x) {
+			delete(e.drain.exchanges, hn)
+		}
 	}
 
-	delete(e.tokens, x.LocalToken())
-	delete(e.tokens, x.RemoteToken())
+	// the exchange may have been registered under the tokens of older lines
+	for token, y := range e.tokens {
+		if y == x {
+			delete(e.tokens, token)
+		}
+	}
 
 	return nil
 }
@@ -682,6 +873,12 @@ func (e *Endpoint) Identify(i Identifier) (*Identity, error) {
 // Dial will lookup the identity of identifier, get the exchange for the identity
 // and dial the exchange.
 func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
+	return e.DialContext(context.Background(), identifier)
+}
+
+// DialContext is like Dial. Once ctx is done ctx.Err() is returned; the
+// handshake continues in the background (see Exchange.DialContext).
+func (e *Endpoint) DialContext(ctx context.Context, identifier Identifier) (*Exchange, error) {
 	if identifier == nil || e == nil {
 		return nil, os.ErrInvalid
 	}
@@ -702,7 +899,7 @@ func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
 		return nil, err
 	}
 
-	err = x.Dial()
+	err = x.DialContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -717,6 +914,35 @@ func (e *Endpoint) GetExchange(hashname hashname.H) *Exchange {
 	return e.hashnames[hashname]
 }
 
+// DropExchange tears down the exchange with hashname. The channels of the
+// exchange are closed; their pending and subsequent operations return
+// ErrPeerGone. false is returned when there is no exchange with hashname.
+func (e *Endpoint) DropExchange(hashname hashname.H) bool {
+	x := e.GetExchange(hashname)
+	if x == nil {
+		return false
+	}
+
+	x.drop()
+	return true
+}
+
+// ExportKey derives length bytes from the line with hashname and label (see
+// Exchange.ExportKey). false is returned when there is no open exchange with
+// hashname.
+func (e *Endpoint) ExportKey(hashname hashname.H, label string, length int) ([]byte, bool) {
+	x := e.GetExchange(hashname)
+	if x == nil {
+		return nil, false
+	}
+
+	p, err := x.ExportKey(label, length)
+	if err != nil {
+		return nil, false
+	}
+	return p, true
+}
+
 func (e *Endpoint) GetExchanges() []*Exchange {
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
@@ -761,6 +987,7 @@ func (e *Endpoint) CreateExchange(identity *Identity) (*Exchange, error) {
 	}
 
 	// register the new exchange
+	e.applyPeerRateLimit(identity.hashname, x)
 	e.tokens[x.LocalToken()] = x
 	e.hashnames[identity.hashname] = x
 
@@ -0,0 +1,215 @@
+package e3x
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsModule is implemented by modules which contribute metrics to
+// Endpoint.MetricsHandler.
+type MetricsModule interface {
+	Module
+
+	// WriteMetrics writes the metrics of the module to w. It is called for
+	// every scrape.
+	WriteMetrics(w *MetricsWriter)
+}
+
+// MetricsWriter writes metrics in the Prometheus text format. For
+// Endpoint.MetricsSnapshot it collects the values instead.
+type MetricsWriter struct {
+	w    io.Writer
+	vars map[string]interface{}
+}
+
+// Counter writes a counter. By convention the name of a counter ends with
+// _total.
+func (w *MetricsWriter) Counter(name, help string, value float64) {
+	w.metric(name, help, "counter", value)
+}
+
+// Gauge writes a gauge.
+func (w *MetricsWriter) Gauge(name, help string, value float64) {
+	w.metric(name, help, "gauge", value)
+}
+
+// CounterVec writes a counter with a sample for each value of label.
+func (w *MetricsWriter) CounterVec(name, help, label string, values map[string]float64) {
+	w.metricVec(name, help, "counter", label, values)
+}
+
+// GaugeVec writes a gauge with a sample for each value of label.
+func (w *MetricsWriter) GaugeVec(name, help, label string, values map[string]float64) {
+	w.metricVec(name, help, "gauge", label, values)
+}
+
+func (w *MetricsWriter) metric(name, help, typ string, value float64) {
+	if w.vars != nil {
+		w.vars[name] = value
+		return
+	}
+
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+		name, help, name, typ, name, formatFloat(value))
+}
+
+func (w *MetricsWriter) metricVec(name, help, typ, label string, values map[string]float64) {
+	if w.vars != nil {
+		m := make(map[string]float64, len(values))
+		for k, v := range values {
+			m[k] = v
+		}
+		w.vars[name] = m
+		return
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, k := range keys {
+		fmt.Fprintf(w.w, "%s{%s=\"%s\"} %s\n", name, label, labelEscaper.Replace(k), formatFloat(values[k]))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// rttBuckets are the upper bounds of the buckets of the round-trip time
+// histogram.
+var rttBuckets = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+}
+
+// rttHistogram counts the round-trip time samples taken on the reliable
+// channels of an endpoint. It is updated atomically.
+type rttHistogram struct {
+	buckets [len(rttBuckets)]uint64
+	count   uint64
+	sum     int64 // nanoseconds
+}
+
+func (h *rttHistogram) observe(d time.Duration) {
+	if h == nil || d < 0 {
+		return
+	}
+
+	for i, le := range rttBuckets {
+		if d <= le {
+			atomic.AddUint64(&h.buckets[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *rttHistogram) write(w *MetricsWriter, name, help string) {
+	var (
+		cumulative uint64
+		count      = atomic.LoadUint64(&h.count)
+		sum        = time.Duration(atomic.LoadInt64(&h.sum))
+	)
+
+	if w.vars != nil {
+		w.vars[name] = map[string]float64{"count": float64(count), "sum": sum.Seconds()}
+		return
+	}
+
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, le := range rttBuckets {
+		cumulative += atomic.LoadUint64(&h.buckets[i])
+		fmt.Fprintf(w.w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(le.Seconds()), cumulative)
+	}
+	fmt.Fprintf(w.w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w.w, "%s_sum %s\n", name, formatFloat(sum.Seconds()))
+	fmt.Fprintf(w.w, "%s_count %d\n", name, count)
+}
+
+// MetricsHandler returns a http.Handler which exposes the metrics of the
+// endpoint (and of the modules implementing MetricsModule) in the Prometheus
+// text format.
+func (e *Endpoint) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var buf bytes.Buffer
+		e.writeMetrics(&MetricsWriter{w: &buf})
+
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		buf.WriteTo(rw)
+	})
+}
+
+// MetricsSnapshot returns the current values of the metrics exposed by
+// MetricsHandler, keyed by metric name. Labeled metrics map to a map of their
+// label values; histograms only report their count and sum. The snapshot can
+// be published with expvar.
+func (e *Endpoint) MetricsSnapshot() map[string]interface{} {
+	w := &MetricsWriter{vars: make(map[string]interface{})}
+	e.writeMetrics(w)
+	return w.vars
+}
+
+func (e *Endpoint) writeMetrics(w *MetricsWriter) {
+	var (
+		rawSent, rawRcvd = e.Traffic()
+		appSent, appRcvd = e.ApplicationTraffic()
+		exchanges        = e.GetExchanges()
+		netSent, netRcvd = e.traffic.networkPackets()
+		open             int
+		channels         int
+	)
+
+	for _, x := range exchanges {
+		if x.State().IsOpen() {
+			open++
+		}
+		channels += len(x.channels.All())
+	}
+
+	w.Counter("telehash_sent_packets_total", "Datagrams written to the transports.",
+		float64(atomic.LoadUint64(&e.traffic.rawSentPkts)))
+	w.Counter("telehash_received_packets_total", "Datagrams read from the transports.",
+		float64(atomic.LoadUint64(&e.traffic.rawRcvdPkts)))
+	w.CounterVec("telehash_transport_sent_packets_total", "Datagrams written per network.",
+		"network", netSent)
+	w.CounterVec("telehash_transport_received_packets_total", "Datagrams read per network.",
+		"network", netRcvd)
+	w.Counter("telehash_sent_bytes_total", "Bytes written to the transports.", float64(rawSent))
+	w.Counter("telehash_received_bytes_total", "Bytes read from the transports.", float64(rawRcvd))
+	w.Counter("telehash_application_sent_bytes_total", "Channel packet body bytes sent.", float64(appSent))
+	w.Counter("telehash_application_received_bytes_total", "Channel packet body bytes received.", float64(appRcvd))
+	w.Counter("telehash_retransmitted_packets_total", "Channel packets resent because they were not acked.",
+		float64(atomic.LoadUint64(&e.traffic.retransmits)))
+	w.Counter("telehash_handshake_failures_total", "Received handshakes which could not be decrypted or applied.",
+		float64(atomic.LoadUint64(&e.traffic.handshakeFailures)))
+	w.Gauge("telehash_exchanges", "Exchanges known to the endpoint.", float64(len(exchanges)))
+	w.Gauge("telehash_open_exchanges", "Open exchanges.", float64(open))
+	w.Gauge("telehash_channels", "Channels on the exchanges of the endpoint.", float64(channels))
+	e.rtts.write(w, "telehash_rtt_seconds", "Round-trip times sampled on reliable channels.")
+
+	for _, mod := range e.modules {
+		if mod, ok := mod.(MetricsModule); ok {
+			mod.WriteMetrics(w)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package e3x
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	l := A.Listen("echo", true)
+	go func() {
+		c, err := l.AcceptChannel()
+		if err != nil {
+			return
+		}
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			c.WritePacket(lob.New(pkt.Body(nil)))
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	c, err := B.Open(ident, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+	for i := 0; i < 5; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+		_, err = c.ReadPacket()
+		assert.NoError(err)
+	}
+
+	srv := httptest.NewServer(B.MetricsHandler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if !assert.NoError(err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal("text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(err)
+	body := string(data)
+
+	for _, name := range []string{
+		"telehash_sent_packets_total",
+		"telehash_received_packets_total",
+		"telehash_sent_bytes_total",
+		"telehash_received_bytes_total",
+		"telehash_application_sent_bytes_total",
+		"telehash_application_received_bytes_total",
+		"telehash_exchanges",
+		"telehash_open_exchanges",
+		"telehash_channels",
+	} {
+		assert.Contains(body, "# TYPE "+name+" ")
+		assert.NotContains(body, "\n"+name+" 0\n", name)
+	}
+
+	assert.Contains(body, "# TYPE telehash_rtt_seconds histogram\n")
+	assert.Contains(body, "telehash_rtt_seconds_bucket{le=\"0.005\"} ")
+	assert.Contains(body, "telehash_rtt_seconds_bucket{le=\"+Inf\"} ")
+	assert.False(strings.Contains(body, "\ntelehash_rtt_seconds_count 0\n"), "rtts are sampled")
+	assert.Contains(body, "\ntelehash_transport_sent_packets_total{network=\"inproc\"} ")
+	assert.Contains(body, "\ntelehash_transport_received_packets_total{network=\"inproc\"} ")
+	assert.Contains(body, "# TYPE telehash_retransmitted_packets_total counter\n")
+	assert.Contains(body, "\ntelehash_handshake_failures_total 0\n")
+
+	snapshot := B.MetricsSnapshot()
+	assert.Equal(float64(1), snapshot["telehash_open_exchanges"])
+	if sent, ok := snapshot["telehash_transport_sent_packets_total"].(map[string]float64); assert.True(ok) {
+		assert.True(sent["inproc"] > 0)
+	}
+	if rtts, ok := snapshot["telehash_rtt_seconds"].(map[string]float64); assert.True(ok) {
+		assert.True(rtts["count"] > 0)
+	}
+}
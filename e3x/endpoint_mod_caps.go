@@ -0,0 +1,150 @@
+package e3x
+
+import (
+	"sort"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+const (
+	modCapsKey       = pivateModKey("caps")
+	capsChannelType  = "caps"
+	capsReadDeadline = 10 * time.Second
+)
+
+var (
+	_ Module = (*modCaps)(nil)
+)
+
+// modCaps advertises the channel types the endpoint listens for to every peer
+// it opens an exchange with, and records the types advertised by those peers.
+type modCaps struct {
+	endpoint *Endpoint
+	listener *Listener
+}
+
+func (mod *modCaps) Init() error {
+	mod.endpoint.DefaultExchangeHooks().Register(ExchangeHook{
+		OnOpened: mod.advertise,
+	})
+	return nil
+}
+
+func (mod *modCaps) Start() error {
+	mod.listener = mod.endpoint.Listen(capsChannelType, false)
+	go mod.accept()
+	return nil
+}
+
+func (mod *modCaps) Stop() error {
+	mod.listener.Close()
+	return nil
+}
+
+func (mod *modCaps) advertise(e *Endpoint, x *Exchange) error {
+	go func() {
+		c, err := x.Open(capsChannelType, false)
+		if err != nil {
+			return
+		}
+		defer c.Kill()
+
+		pkt := &lob.Packet{}
+		pkt.Header().Set("types", x.listenerSet.types())
+		c.WritePacket(pkt)
+	}()
+	return nil
+}
+
+func (mod *modCaps) accept() {
+	for {
+		c, err := mod.listener.AcceptChannel()
+		if err != nil {
+			return
+		}
+
+		go mod.handle(c)
+	}
+}
+
+func (mod *modCaps) handle(c *Channel) {
+	defer c.Kill()
+
+	x := c.Exchange()
+	if x == nil {
+		return
+	}
+
+	c.SetReadDeadline(time.Now().Add(capsReadDeadline))
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		return
+	}
+
+	v, _ := pkt.Header().Get("types")
+	types := map[string]bool{}
+	switch l := v.(type) {
+	case []string:
+		for _, typ := range l {
+			types[typ] = true
+		}
+	case []interface{}:
+		for _, y := range l {
+			if typ, ok := y.(string); ok {
+				types[typ] = true
+			}
+		}
+	}
+
+	x.mtx.Lock()
+	x.remoteCaps = types
+	x.mtx.Unlock()
+}
+
+// types returns the (sorted) channel types of the listeners in the set and its
+// parents.
+func (set *listenerSet) types() []string {
+	seen := map[string]bool{}
+	for ; set != nil; set = set.parent {
+		set.mtx.RLock()
+		for typ := range set.listeners {
+			seen[typ] = true
+		}
+		set.mtx.RUnlock()
+	}
+
+	types := make([]string, 0, len(seen))
+	for typ := range seen {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// PeerSupports returns true when the peer with hashname hn advertised that it
+// listens for channels of type typ. The channel types are advertised once when
+// an exchange is opened; false is returned when there is no exchange with hn or
+// when the peer didn't advertise its channel types (yet).
+func (e *Endpoint) PeerSupports(hn hashname.H, typ string) bool {
+	x := e.GetExchange(hn)
+	if x == nil {
+		return false
+	}
+
+	supported, _ := x.peerSupports(typ)
+	return supported
+}
+
+// peerSupports returns whether the peer supports typ and whether the peer
+// advertised its channel types at all.
+func (x *Exchange) peerSupports(typ string) (supported, known bool) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	if x.remoteCaps == nil {
+		return false, false
+	}
+	return x.remoteCaps[typ], true
+}
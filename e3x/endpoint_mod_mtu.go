@@ -0,0 +1,336 @@
+package e3x
+
+import (
+	"net"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+const (
+	modMTUKey      = pivateModKey("mtu")
+	mtuChannelType = "mtu"
+
+	// cMinPathMTU is the smallest MTU which is probed; paths which can't
+	// carry a packet of this size are left at MaxPacketSize.
+	cMinPathMTU = 512
+
+	// cMTUPrecision is the precision of the binary search; the discovered MTU
+	// is at most this much below the real one.
+	cMTUPrecision = 16
+
+	// cMTUProbeAttempts is the number of times a probe of a size is sent
+	// before the size is considered too large; a late ack of an earlier
+	// attempt still counts.
+	cMTUProbeAttempts = 3
+	cMTUProbeTimeout  = 500 * time.Millisecond
+
+	// cMTUReprobeInterval is the time after which the MTU of a path may be
+	// probed again (when the exchange reconnects or the path becomes active).
+	cMTUReprobeInterval = 10 * time.Minute
+
+	cMTUReadDeadline  = 10 * time.Second
+	mtuProbeHeader    = "probe"
+	mtuProbeAckHeader = "probe_ack"
+)
+
+var (
+	_ Module = (*modMTU)(nil)
+)
+
+// PathMTUDiscovery makes the endpoint probe the MTU of every path to its peers.
+// A path is probed when an exchange opens and when it becomes the active path,
+// at most once every cMTUReprobeInterval.
+// The probes are padded packets of the size under test, which the peer
+// acknowledges; the largest acknowledged size is found with a binary search
+// between cMinPathMTU and MaxPacketSize. Both endpoints must use
+// PathMTUDiscovery.
+//
+// The discovered MTU is reported by Exchange.PathMTU and makes the channels of
+// the exchange write smaller fragments (see Channel.WriteMessage).
+func PathMTUDiscovery() EndpointOption {
+	return func(e *Endpoint) error {
+		return RegisterModule(modMTUKey, &modMTU{endpoint: e})(e)
+	}
+}
+
+// modMTU probes the MTU of the paths of the exchanges of the endpoint and
+// acknowledges the probes of its peers.
+type modMTU struct {
+	endpoint *Endpoint
+	listener *Listener
+	events   *Subscription
+}
+
+func (mod *modMTU) Init() error {
+	return nil
+}
+
+func (mod *modMTU) Start() error {
+	mod.listener = mod.endpoint.Listen(mtuChannelType, false)
+	mod.events = mod.endpoint.Subscribe("peer.connected", "path.changed")
+	go mod.accept()
+	go mod.watch()
+	return nil
+}
+
+func (mod *modMTU) Stop() error {
+	mod.events.Close()
+	mod.listener.Close()
+	return nil
+}
+
+func (mod *modMTU) watch() {
+	for ev := range mod.events.Events() {
+		switch ev := ev.(type) {
+
+		case PeerConnectedEvent:
+			go mod.probe(ev.Exchange, ev.Exchange.KnownPipes())
+
+		case PathChangedEvent:
+			if ev.To == nil || !ev.Exchange.State().IsOpen() {
+				continue
+			}
+			if p := ev.Exchange.addressBook.PipeToAddr(ev.To); p != nil {
+				go mod.probe(ev.Exchange, []*Pipe{p})
+			}
+
+		}
+	}
+}
+
+// probe discovers the MTU of the pipes of x which were not probed recently.
+func (mod *modMTU) probe(x *Exchange, pipes []*Pipe) {
+	var (
+		claimed []*Pipe
+		now     = time.Now()
+	)
+	for _, p := range pipes {
+		if x.addressBook.claimMTUProbe(p, now) {
+			claimed = append(claimed, p)
+		}
+	}
+	if len(claimed) == 0 {
+		return
+	}
+
+	c := mod.open(x, claimed[0])
+	if c == nil {
+		return
+	}
+	defer c.Kill()
+
+	for _, p := range claimed {
+		if mtu := mod.search(c, p); mtu > 0 {
+			x.addressBook.setMTU(p, mtu)
+		}
+	}
+}
+
+// open opens a probe channel to x with a probe of cMinPathMTU over p. A client
+// channel can't write before its first packet was answered, so a channel
+// whose first probe is lost is replaced by a new one.
+func (mod *modMTU) open(x *Exchange, p *Pipe) *Channel {
+	for attempt := 0; attempt < cMTUProbeAttempts; attempt++ {
+		c, err := x.Open(mtuChannelType, false)
+		if err != nil {
+			return nil
+		}
+		if mod.probeSize(c, p, cMinPathMTU, 1) {
+			return c
+		}
+		c.Kill()
+	}
+	return nil
+}
+
+// search returns the MTU of p, or 0 when not even a probe of cMinPathMTU was
+// acknowledged.
+func (mod *modMTU) search(c *Channel, p *Pipe) int {
+	if !mod.probeSize(c, p, cMinPathMTU, cMTUProbeAttempts) {
+		return 0
+	}
+
+	lo, hi := cMinPathMTU, MaxPacketSize
+	if mod.probeSize(c, p, hi, cMTUProbeAttempts) {
+		return hi
+	}
+
+	for hi-lo > cMTUPrecision {
+		mid := (lo + hi) / 2
+		if mod.probeSize(c, p, mid, cMTUProbeAttempts) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo
+}
+
+// probeSize returns true when a packet of size bytes (once encoded by the
+// channel) made it to the peer over p. The probe is sent up to attempts times
+// as the channel is unreliable.
+func (mod *modMTU) probeSize(c *Channel, p *Pipe, size, attempts int) bool {
+	var hdr lob.Header
+	hdr.SetInt(mtuProbeHeader, size)
+
+	defer c.SetDeadline(time.Time{})
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		// the overhead depends on the sequence number of every attempt
+		c.mtx.Lock()
+		seq := c.oSeq + 1
+		c.mtx.Unlock()
+
+		tmp := lob.New(nil).SetHeader(hdr)
+		n, err := c.packetSizeAt(tmp, seq)
+		tmp.Free()
+		if err != nil || n > size {
+			return false
+		}
+
+		c.SetDeadline(time.Now().Add(cMTUProbeTimeout))
+
+		err = c.WritePacketTo(lob.New(make([]byte, size-n)).SetHeader(hdr), p)
+		if err != nil {
+			return false
+		}
+
+		if mod.readProbeAck(c, size) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readProbeAck returns true when the ack of a probe of size bytes is read
+// before the deadline of c.
+func (mod *modMTU) readProbeAck(c *Channel, size int) bool {
+	for {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return false
+		}
+
+		ack, _ := pkt.Header().GetInt(mtuProbeAckHeader)
+		pkt.Free()
+		if ack == size {
+			return true
+		}
+		// an ack of an earlier (timed out) probe
+	}
+}
+
+func (mod *modMTU) accept() {
+	for {
+		c, err := mod.listener.AcceptChannel()
+		if err != nil {
+			return
+		}
+
+		go mod.handle(c)
+	}
+}
+
+// handle acknowledges the probes on c until the peer stops sending them.
+func (mod *modMTU) handle(c *Channel) {
+	defer c.Kill()
+
+	for {
+		c.SetReadDeadline(time.Now().Add(cMTUReadDeadline))
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+
+		size, found := pkt.Header().GetInt(mtuProbeHeader)
+		pkt.Free()
+		if !found {
+			continue
+		}
+
+		ack := &lob.Packet{}
+		ack.Header().SetInt(mtuProbeAckHeader, size)
+		if err := c.WritePacket(ack); err != nil {
+			return
+		}
+	}
+}
+
+// PathMTU returns the size of the largest channel packet (see MaxPacketSize)
+// which is known to make it to the peer over the path to addr. MaxPacketSize
+// is returned when the MTU of the path wasn't discovered (see
+// PathMTUDiscovery).
+func (x *Exchange) PathMTU(addr net.Addr) int {
+	return x.addressBook.MTU(addr)
+}
+
+// pathMTU returns the MTU of the active path.
+func (x *Exchange) pathMTU() int {
+	return x.addressBook.ActiveMTU()
+}
+
+// claimMTUProbe returns true when the MTU of p must be probed; it returns true
+// at most once every cMTUReprobeInterval for every pipe.
+func (book *addressBook) claimMTUProbe(p *Pipe, now time.Time) bool {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	idx := book.indexOfPipe(p)
+	if idx < 0 {
+		return false
+	}
+
+	e := book.known[idx]
+	if !e.mtuProbedAt.IsZero() && now.Sub(e.mtuProbedAt) < cMTUReprobeInterval {
+		return false
+	}
+
+	e.mtuProbedAt = now
+	return true
+}
+
+func (book *addressBook) setMTU(p *Pipe, mtu int) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	idx := book.indexOfPipe(p)
+	if idx < 0 {
+		return
+	}
+
+	e := book.known[idx]
+	e.mtu = mtu
+	book.log.Printf("\x1B[32mDiscovered path MTU\x1B[0m %s (mtu=\x1B[33m%d\x1B[0m)", e, mtu)
+}
+
+func (book *addressBook) MTU(addr net.Addr) int {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	idx := book.indexOf(addr)
+	if idx < 0 {
+		return MaxPacketSize
+	}
+	return book.known[idx].MTU()
+}
+
+func (book *addressBook) ActiveMTU() int {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	if book.active == nil {
+		return MaxPacketSize
+	}
+	return book.active.MTU()
+}
+
+// MTU returns the discovered MTU of the path, or MaxPacketSize.
+func (a *addressBookEntry) MTU() int {
+	if a.mtu == 0 {
+		return MaxPacketSize
+	}
+	return a.mtu
+}
@@ -0,0 +1,149 @@
+package e3x
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestPathMTUDiscovery(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var tests = []struct {
+		limit int
+		lossy bool
+	}{
+		{0, false},
+		{900, false},
+		{900, true},
+	}
+
+	for _, test := range tests {
+		limit := test.limit
+		A, err := Open(Transport(&mtuConfig{inproc.Config{}, limit, test.lossy}), Log(nil), PathMTUDiscovery())
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := Open(Transport(&mtuConfig{inproc.Config{}, limit, test.lossy}), Log(nil), PathMTUDiscovery())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := B.LocalIdentity()
+		assert.NoError(err)
+		x, err := A.Dial(b)
+		if !assert.NoError(err) {
+			A.Close()
+			B.Close()
+			continue
+		}
+
+		var (
+			addr     = x.ActivePipe().RemoteAddr()
+			deadline = time.Now().Add(20 * time.Second)
+		)
+		for x.PathMTU(addr) == MaxPacketSize && limit > 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		mtu := x.PathMTU(addr)
+		if limit == 0 {
+			assert.Equal(MaxPacketSize, mtu)
+		} else {
+			// the limit applies to the encrypted packets
+			assert.True(mtu > cMinPathMTU, "mtu=%d", mtu)
+			assert.True(mtu < limit, "mtu=%d", mtu)
+		}
+		assert.Equal(mtu, x.pathMTU())
+
+		c, err := x.Open("test", true)
+		if assert.NoError(err) {
+			assert.Equal(cFragmentSize-(MaxPacketSize-mtu), c.fragmentSize())
+			c.Kill()
+		}
+
+		A.Close()
+		B.Close()
+	}
+}
+
+func TestClaimMTUProbe(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		book = newAddressBook(logs.Module("test"), AnyAddressFamily.pathFamily())
+		p    = &Pipe{raddr: &familyAddr{nil, net.ParseIP("192.0.2.1")}}
+		now  = time.Now()
+	)
+	book.AddPipe(p)
+
+	assert.True(book.claimMTUProbe(p, now))
+	assert.False(book.claimMTUProbe(p, now.Add(time.Minute)))
+	assert.True(book.claimMTUProbe(p, now.Add(cMTUReprobeInterval)))
+	assert.False(book.claimMTUProbe(&Pipe{raddr: &familyAddr{nil, net.ParseIP("192.0.2.2")}}, now))
+}
+
+// mtuConfig drops the messages larger than limit bytes (unless limit is 0).
+// When lossy is set every other message of at least cMinPathMTU bytes is
+// dropped too.
+type mtuConfig struct {
+	transports.Config
+	limit int
+	lossy bool
+}
+
+func (c *mtuConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &mtuTransport{t, c.limit, c.lossy}, nil
+}
+
+type mtuTransport struct {
+	transports.Transport
+	limit int
+	lossy bool
+}
+
+func (t *mtuTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &mtuConn{Conn: conn, limit: t.limit, lossy: t.lossy}, nil
+}
+
+func (t *mtuTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &mtuConn{Conn: conn, limit: t.limit, lossy: t.lossy}, nil
+}
+
+type mtuConn struct {
+	net.Conn
+	limit int
+	lossy bool
+	large int32 // atomic; the number of large messages written
+}
+
+func (c *mtuConn) Write(b []byte) (int, error) {
+	if c.limit > 0 && len(b) > c.limit {
+		return len(b), nil
+	}
+	if c.lossy && len(b) >= cMinPathMTU && atomic.AddInt32(&c.large, 1)%2 == 1 {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,101 @@
+package e3x
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// Request opens a reliable channel of type typ with the peer identified by i,
+// sends a single request and waits for the first response. The custom headers
+// of the request are taken from reqHdr (see lob.Header.Encode) and those of the
+// response are decoded into respHdr (see ReadPacketHeader); either may be nil.
+// The channel is closed before Request returns.
+//
+// When the peer closes the channel without responding the response body is
+// empty. When the peer closes the channel with an error the *RemoteError is
+// returned. Once ctx is done the channel is killed and ctx.Err() is returned.
+func (e *Endpoint) Request(ctx context.Context, i Identifier, typ string, reqHdr interface{}, reqBody []byte, respHdr interface{}) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		body []byte
+		err  error
+	}
+
+	var (
+		mtx      sync.Mutex
+		c        *Channel
+		canceled bool
+		results  = make(chan result, 1)
+	)
+
+	go func() {
+		ch, err := e.Open(i, typ, true)
+		if err != nil {
+			results <- result{nil, err}
+			return
+		}
+
+		mtx.Lock()
+		if canceled {
+			mtx.Unlock()
+			ch.Kill()
+			return
+		}
+		c = ch
+		mtx.Unlock()
+
+		body, err := ch.request(reqHdr, reqBody, respHdr)
+		results <- result{body, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.body, r.err
+	case <-ctx.Done():
+		mtx.Lock()
+		canceled = true
+		if c != nil {
+			c.Kill()
+		}
+		mtx.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Channel) request(reqHdr interface{}, reqBody []byte, respHdr interface{}) ([]byte, error) {
+	pkt := lob.New(reqBody)
+	if reqHdr != nil {
+		if err := pkt.Header().Encode(reqHdr); err != nil {
+			c.Kill()
+			return nil, err
+		}
+	}
+
+	if err := c.WritePacket(pkt); err != nil {
+		c.Kill()
+		return nil, err
+	}
+
+	resp, err := c.ReadPacketHeader(respHdr)
+	if err == io.EOF {
+		return nil, c.Close()
+	}
+	if _, ok := err.(*HeaderError); ok {
+		c.Close()
+		return resp.Body(nil), err
+	}
+	if err != nil {
+		c.Kill()
+		return nil, err
+	}
+
+	body := resp.Body(nil)
+	c.Close()
+	return body, nil
+}
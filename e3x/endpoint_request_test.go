@@ -0,0 +1,101 @@
+package e3x
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestRequest(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	type header struct {
+		Op    string `json:"op"`
+		Count int    `json:"count"`
+	}
+
+	l := A.Listen("rpc", true)
+	go func() {
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+
+			go func(c *Channel) {
+				var req header
+				pkt, err := c.ReadPacketHeader(&req)
+				if err != nil {
+					c.Kill()
+					return
+				}
+
+				switch req.Op {
+				case "echo":
+					resp := lob.New(pkt.Body(nil))
+					resp.Header().Encode(header{Op: "echoed", Count: req.Count + 1})
+					c.WritePacket(resp)
+					c.Close()
+				case "fail":
+					c.Error(&RemoteError{Code: "bad_op", Message: "failed"})
+				case "close":
+					c.Close()
+				case "ignore":
+					// never respond
+					c.SetReadDeadline(time.Now().Add(time.Second))
+					c.ReadPacket()
+					c.Kill()
+				}
+			}(c)
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	// a full round trip
+	var resp header
+	body, err := B.Request(context.Background(), ident, "rpc", header{Op: "echo", Count: 41}, []byte("hello"), &resp)
+	if assert.NoError(err) {
+		assert.Equal("hello", string(body))
+		assert.Equal("echoed", resp.Op)
+		assert.Equal(42, resp.Count)
+	}
+
+	// the remote error is propagated
+	_, err = B.Request(context.Background(), ident, "rpc", header{Op: "fail"}, nil, nil)
+	if rerr, ok := err.(*RemoteError); assert.True(ok, "got %v", err) {
+		assert.Equal("bad_op", rerr.Code)
+		assert.Equal("failed", rerr.Message)
+	}
+
+	// the peer closes without responding
+	body, err = B.Request(context.Background(), ident, "rpc", header{Op: "close"}, nil, nil)
+	assert.NoError(err)
+	assert.Empty(body)
+
+	// the context bounds the request
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = B.Request(ctx, ident, "rpc", header{Op: "ignore"}, nil, nil)
+	assert.Equal(context.DeadlineExceeded, err)
+}
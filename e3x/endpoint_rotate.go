@@ -0,0 +1,199 @@
+package e3x
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// identityDrain holds the keys of a rotated identity and the exchanges which
+// were opened under it. Handshakes addressed to the old keys are only accepted
+// for these exchanges. The exchanges are no longer registered by hashname (but
+// still by token); dialing their peers opens new exchanges.
+type identityDrain struct {
+	keys      cipherset.Keys
+	exchanges map[hashname.H]*Exchange
+	timer     *time.Timer
+}
+
+// RotateIdentity replaces the keys (and therefore the hashname) of the
+// endpoint. All exchanges opened after the rotation use the new identity.
+//
+// The exchanges opened under the old identity keep working for drain, after
+// which they are closed. Until then the peers of these exchanges keep talking
+// to the old identity; dialing a peer (or being dialed by a peer) during the
+// drain opens a new exchange with the new identity. When drain <= 0 they are
+// closed immediately. Only the most recent old identity is drained; rotating
+// again closes the exchanges of an earlier rotation which are still draining.
+func (e *Endpoint) RotateIdentity(keys cipherset.Keys, drain time.Duration) error {
+	if len(keys) == 0 {
+		return ErrNoKeys
+	}
+
+	hn, err := hashname.FromKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	e.mtx.Lock()
+
+	var (
+		prev  = e.drain
+		stale = make(map[hashname.H]*Exchange, len(e.hashnames))
+	)
+	for remote, x := range e.hashnames {
+		stale[remote] = x
+		delete(e.hashnames, remote)
+	}
+
+	e.identMtx.Lock()
+	oldKeys := e.keys
+	e.keys = keys
+	e.hashname = hn
+	e.identMtx.Unlock()
+
+	e.drain = nil
+	if drain > 0 && len(stale) > 0 {
+		d := &identityDrain{keys: oldKeys, exchanges: stale}
+		d.timer = time.AfterFunc(drain, func() { e.endDrain(d) })
+		e.drain = d
+		atomic.StoreUint32(&e.draining, 1)
+	} else {
+		atomic.StoreUint32(&e.draining, 0)
+	}
+
+	e.mtx.Unlock()
+
+	if prev != nil {
+		prev.timer.Stop()
+		prev.close()
+	}
+	if drain <= 0 {
+		for _, x := range stale {
+			x.expire(nil)
+		}
+	}
+
+	return nil
+}
+
+// endDrain closes the exchanges of d unless d was already replaced by a later
+// rotation.
+func (e *Endpoint) endDrain(d *identityDrain) {
+	e.mtx.Lock()
+	if e.drain != d {
+		e.mtx.Unlock()
+		return
+	}
+	e.drain = nil
+	atomic.StoreUint32(&e.draining, 0)
+	e.mtx.Unlock()
+
+	d.close()
+}
+
+func (d *identityDrain) close() {
+	for _, x := range d.exchanges {
+		x.expire(nil)
+	}
+}
+
+// decryptDrainingHandshake attempts to decrypt a handshake with the keys of the
+// draining identity. It returns the draining exchange the handshake belongs to.
+// Must be called with e.mtx held.
+func (e *Endpoint) decryptDrainingHandshake(csid uint8, msg *bufpool.Buffer) *Exchange {
+	d := e.drain
+	if d == nil || d.keys[csid] == nil {
+		return nil
+	}
+
+	handshake, err := cipherset.DecryptHandshake(csid, d.keys[csid], msg.RawBytes()[3:])
+	if err != nil {
+		return nil
+	}
+
+	hn, err := hashname.FromKeyAndIntermediates(csid,
+		handshake.PublicKey().Public(), handshake.Parts())
+	if err != nil {
+		return nil
+	}
+
+	return d.exchanges[hn]
+}
+
+// isDraining returns true when x is one of the exchanges of the draining
+// identity. Must be called with e.mtx held.
+func (e *Endpoint) isDraining(x *Exchange) bool {
+	return e.drain != nil && x.remoteIdent != nil && e.drain.exchanges[x.remoteIdent.Hashname()] == x
+}
+
+// rerouteDrained passes msg to the exchange it belongs to when it arrived on a
+// pipe of x by mistake. While an identity drains, the draining exchange and the
+// new exchange with the same peer may share a connection; each of them may
+// read the messages of the other. It returns false when x must handle msg.
+func (x *Exchange) rerouteDrained(msg message) bool {
+	e, ok := x.endpoint.(*Endpoint)
+	if !ok || atomic.LoadUint32(&e.draining) == 0 || msg.Pipe == nil || x.remoteIdent == nil {
+		return false
+	}
+
+	msg.Pipe.mtx.RLock()
+	conn := msg.Pipe.conn
+	msg.Pipe.mtx.RUnlock()
+	if _, rerouted := conn.(drainedConn); rerouted || conn == nil {
+		return false
+	}
+
+	var (
+		raw    = msg.Data.RawBytes()
+		target *Exchange
+	)
+
+	e.mtx.Lock()
+	if e.drain == nil || e.drain.exchanges[x.remoteIdent.Hashname()] == nil {
+		e.mtx.Unlock()
+		return false
+	}
+	if !msg.IsHandshake {
+		target = e.tokens[cipherset.ExtractToken(raw)]
+		if target == nil || target == x {
+			e.mtx.Unlock()
+			return false
+		}
+	}
+	e.mtx.Unlock()
+
+	if target != nil {
+		if p := target.addressBook.PipeToAddr(msg.Pipe.raddr); p != nil {
+			msg.Pipe = p
+		}
+		target.received(msg)
+		return true
+	}
+
+	// the handshakes for x are encrypted with the keys of its identity
+	if csid := raw[2]; x.localIdent.keys[csid] != nil {
+		if _, err := cipherset.DecryptHandshake(csid, x.localIdent.keys[csid], raw[3:]); err == nil {
+			return false
+		}
+	}
+
+	e.acceptMessage(msg.Data, drainedConn{conn})
+	return true
+}
+
+// drainedConn is a connection shared with another exchange as seen by the
+// exchange a rerouted handshake belongs to (see rerouteDrained). The pipe of
+// the other exchange keeps reading from the connection; drainedConn can only
+// be written to. The new pipe dials the address again once its reader stopped.
+type drainedConn struct {
+	net.Conn
+}
+
+func (drainedConn) Read(b []byte) (int, error) { return 0, io.EOF }
+func (drainedConn) Close() error               { return nil }
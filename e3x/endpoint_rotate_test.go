@@ -0,0 +1,229 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestRotateIdentity(t *testing.T) {
+	logs.ResetLogger()
+
+	var (
+		assert = assert.New(t)
+		closed = make(chan hashname.H, 10)
+	)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+	C, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer C.Close()
+
+	A.DefaultExchangeHooks().Register(ExchangeHook{
+		OnClosed: func(e *Endpoint, x *Exchange, reason error) error {
+			closed <- x.localIdent.Hashname()
+			return nil
+		},
+	})
+
+	l := A.Listen("echo", true)
+	go func() {
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Kill()
+				for {
+					pkt, err := c.ReadPacket()
+					if err != nil {
+						return
+					}
+					if c.WritePacket(lob.New(pkt.Body(nil))) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	roundtrip := func(c *Channel, body string) string {
+		if err := c.WritePacket(lob.New([]byte(body))); err != nil {
+			return err.Error()
+		}
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return err.Error()
+		}
+		return string(pkt.Body(nil))
+	}
+
+	oldHashname := A.LocalHashname()
+	oldIdent, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	old, err := B.Open(oldIdent, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("before", roundtrip(old, "before"))
+
+	keys, err := cipherset.GenerateKeys()
+	assert.NoError(err)
+	newHashname, err := hashname.FromKeys(keys)
+	assert.NoError(err)
+
+	assert.Equal(ErrNoKeys, A.RotateIdentity(nil, 0))
+	assert.NoError(A.RotateIdentity(keys, 500*time.Millisecond))
+	assert.Equal(newHashname, A.LocalHashname())
+	assert.NotEqual(oldHashname, A.LocalHashname())
+
+	newIdent, err := A.LocalIdentity()
+	assert.NoError(err)
+	assert.Equal(newHashname, newIdent.Hashname())
+
+	// new peers reach the new identity
+	c, err := C.Open(newIdent, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("new", roundtrip(c, "new"))
+	assert.Equal(newHashname, c.Exchange().RemoteHashname())
+
+	// the channels of the old identity keep working while draining
+	assert.Equal("draining", roundtrip(old, "draining"))
+
+	// the exchanges of the old identity are closed once drained
+	select {
+	case hn := <-closed:
+		assert.Equal(oldHashname, hn)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the old exchange wasn't closed")
+	}
+	select {
+	case hn := <-closed:
+		t.Errorf("unexpected close of an exchange of %s", hn)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.Equal("after", roundtrip(c, "after"))
+	assert.Nil(A.GetExchange(B.LocalHashname()))
+
+	// once drained, known peers open a new exchange with the new identity
+	x, err := B.Dial(newIdent)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.True(old.Exchange() != x, "a new exchange is opened")
+	assert.Equal(newHashname, x.RemoteHashname())
+	assert.Equal(newHashname, A.GetExchange(B.LocalHashname()).localIdent.Hashname())
+}
+
+func TestRotateIdentityRedial(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	l := A.Listen("echo", true)
+	go func() {
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Kill()
+				for {
+					pkt, err := c.ReadPacket()
+					if err != nil {
+						return
+					}
+					if c.WritePacket(lob.New(pkt.Body(nil))) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	roundtrip := func(c *Channel, body string) string {
+		if err := c.WritePacket(lob.New([]byte(body))); err != nil {
+			return err.Error()
+		}
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return err.Error()
+		}
+		return string(pkt.Body(nil))
+	}
+
+	oldIdent, err := A.LocalIdentity()
+	assert.NoError(err)
+	bIdent, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	old, err := B.Open(oldIdent, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("before", roundtrip(old, "before"))
+
+	keys, err := cipherset.GenerateKeys()
+	assert.NoError(err)
+	assert.NoError(A.RotateIdentity(keys, time.Second))
+	newIdent, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	// while draining, the peer re-dials the new identity
+	c, err := B.Open(newIdent, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("new", roundtrip(c, "new"))
+	assert.True(old.Exchange() != c.Exchange(), "a new exchange is opened")
+
+	// and dialing the peer yields the exchange of the new identity
+	x, err := A.Dial(bIdent)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(newIdent.Hashname(), x.localIdent.Hashname())
+	assert.True(A.GetExchange(B.LocalHashname()) == x)
+
+	// the old line keeps working until it is drained
+	assert.Equal("draining", roundtrip(old, "draining"))
+
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal("after", roundtrip(c, "after"))
+	assert.True(A.GetExchange(B.LocalHashname()) == x)
+}
@@ -0,0 +1,63 @@
+package e3x
+
+import (
+	"net"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// InboundInfo describes a decrypted inbound channel packet. It is a copy; an
+// InboundTapFunc can't modify the packet through it.
+type InboundInfo struct {
+	Hashname hashname.H // the peer which sent the packet
+	Addr     net.Addr   // the address the packet was received from
+	Header   lob.Header
+	BodyLen  int
+}
+
+// InboundTapFunc is called for every inbound channel packet after it was
+// decrypted and before it is dispatched to its channel. When it returns false
+// the packet is dropped.
+type InboundTapFunc func(info *InboundInfo) bool
+
+// InboundTap installs f as the inbound tap of the endpoint. f is called
+// without holding any locks of the endpoint, but it is called from the
+// goroutine which reads packets from the peer; a slow tap delays the packets
+// which follow.
+func InboundTap(f InboundTapFunc) EndpointOption {
+	return func(e *Endpoint) error {
+		e.inboundTap = f
+		return nil
+	}
+}
+
+func (x *Exchange) tapInbound(pkt *lob.Packet, p *Pipe) bool {
+	if x.inboundTap == nil {
+		return true
+	}
+
+	info := &InboundInfo{
+		Hashname: x.remoteIdent.Hashname(),
+		Header:   *pkt.Header(),
+		BodyLen:  pkt.BodyLen(),
+	}
+	if p != nil {
+		info.Addr = p.RemoteAddr()
+	}
+	if len(info.Header.Bytes) > 0 {
+		info.Header.Bytes = append([]byte(nil), info.Header.Bytes...)
+	}
+	if len(info.Header.Miss) > 0 {
+		info.Header.Miss = append([]uint32(nil), info.Header.Miss...)
+	}
+	if info.Header.Extra != nil {
+		extra := make(map[string]interface{}, len(info.Header.Extra))
+		for k, v := range info.Header.Extra {
+			extra[k] = v
+		}
+		info.Header.Extra = extra
+	}
+
+	return x.inboundTap(info)
+}
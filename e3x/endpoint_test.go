@@ -1,13 +1,20 @@
 package e3x
 
 import (
+	"context"
+	"errors"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
 
 	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
 	"github.com/telehash/gogotelehash/transports/inproc"
 	"github.com/telehash/gogotelehash/transports/mux"
 	"github.com/telehash/gogotelehash/transports/udp"
@@ -66,3 +73,419 @@ func TestSimpleEndpoint(t *testing.T) {
 	err = eb.Close()
 	assert.NoError(err)
 }
+
+func TestLineFilter(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	ea, err := Open(
+		Transport(inproc.Config{}),
+		Log(nil))
+	assert.NoError(err)
+
+	identA, err := ea.LocalIdentity()
+	assert.NoError(err)
+
+	var errDenied = errors.New("denied")
+	eb, err := Open(
+		Transport(inproc.Config{}),
+		Log(nil),
+		LineFilter(func(addr net.Addr, hn hashname.H) error {
+			for _, denied := range identA.Addresses() {
+				if transports.EqualAddr(addr, denied) {
+					return errDenied
+				}
+			}
+			return nil
+		}))
+	assert.NoError(err)
+
+	identB, err := eb.LocalIdentity()
+	assert.NoError(err)
+
+	filtered := statEndpointRcvHandshakeFiltered.Value()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ea.Dial(identB)
+		done <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for statEndpointRcvHandshakeFiltered.Value() == filtered && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(statEndpointRcvHandshakeFiltered.Value() > filtered)
+	assert.Nil(eb.GetExchange(ea.LocalHashname()))
+
+	assert.NoError(ea.Close())
+	assert.Error(<-done)
+	assert.Nil(eb.GetExchange(ea.LocalHashname()))
+
+	assert.NoError(eb.Close())
+}
+
+func TestTraffic(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		sent, rcvd := A.Traffic()
+		assert.Equal(uint64(0), sent)
+		assert.Equal(uint64(0), rcvd)
+
+		done := make(chan bool, 1)
+		go func() {
+			defer func() { done <- true }()
+
+			c, err := A.Listen("traffic", true).AcceptChannel()
+			if !assert.NoError(err) {
+				return
+			}
+			_, err = c.ReadPacket()
+			assert.NoError(err)
+			assert.NoError(c.WritePacket(lob.New(make([]byte, 50))))
+			assert.NoError(c.Close())
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "traffic", true)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.NoError(c.WritePacket(lob.New(make([]byte, 100))))
+		_, err = c.ReadPacket()
+		assert.NoError(err)
+		assert.NoError(c.Close())
+		<-done
+
+		appSent, appRcvd := B.ApplicationTraffic()
+		assert.True(appSent >= 100, "app sent = %d", appSent)
+		assert.True(appRcvd >= 50, "app rcvd = %d", appRcvd)
+
+		sent, rcvd = B.Traffic()
+		assert.True(sent > appSent, "raw sent = %d", sent)
+		assert.True(rcvd > appRcvd, "raw rcvd = %d", rcvd)
+
+		aSent, aRcvd := A.Traffic()
+		assert.True(aSent > 0)
+		assert.True(aRcvd > 0)
+	})
+}
+
+func TestExportKey(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		_, ok := B.ExportKey(A.LocalHashname(), "test", 32)
+		assert.False(ok)
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+		_, err = B.Dial(ident)
+		assert.NoError(err)
+
+		b, ok := B.ExportKey(A.LocalHashname(), "test", 32)
+		assert.True(ok)
+		assert.Len(b, 32)
+
+		a, ok := A.ExportKey(B.LocalHashname(), "test", 32)
+		assert.True(ok)
+		assert.Equal(a, b)
+	})
+}
+
+func TestPeerSupports(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil), CheckPeerSupport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	A.Listen("supported", true)
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// the channel types are advertised just after the exchange is opened
+	for i := 0; i < 100 && !B.PeerSupports(A.LocalHashname(), capsChannelType); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(B.PeerSupports(A.LocalHashname(), "supported"))
+	assert.False(B.PeerSupports(A.LocalHashname(), "unsupported"))
+	assert.False(A.PeerSupports(hashname.H("unknown"), "supported"))
+
+	c, err := x.Open("supported", true)
+	if assert.NoError(err) {
+		c.Kill()
+	}
+
+	// rather than a channel which A drops
+	_, err = x.Open("unsupported", true)
+	assert.Equal(ErrUnsupportedChannelType, err)
+}
+
+func TestInboundTap(t *testing.T) {
+	logs.ResetLogger()
+
+	var (
+		assert = assert.New(t)
+		mtx    sync.Mutex
+		infos  []InboundInfo
+	)
+
+	tap := func(info *InboundInfo) bool {
+		mtx.Lock()
+		infos = append(infos, *info)
+		mtx.Unlock()
+
+		_, drop := info.Header.Get("drop")
+		return !drop
+	}
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), InboundTap(tap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		c, err := A.Listen("tap", false).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+		c.SetReadDeadline(time.Now().Add(time.Second))
+
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) {
+			assert.Equal("one", string(pkt.Body(nil)))
+		}
+		assert.NoError(c.WritePacket(&lob.Packet{}))
+
+		// "two" was dropped by the tap
+		pkt, err = c.ReadPacket()
+		if assert.NoError(err) {
+			assert.Equal("three", string(pkt.Body(nil)))
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	c, err := B.Open(ident, "tap", false)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	assert.NoError(c.WritePacket(lob.New([]byte("one"))))
+	_, err = c.ReadPacket()
+	assert.NoError(err)
+
+	drop := lob.New([]byte("two"))
+	drop.Header().SetBool("drop", true)
+	assert.NoError(c.WritePacket(drop))
+	assert.NoError(c.WritePacket(lob.New([]byte("three"))))
+	<-done
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	var seen int
+	for _, info := range infos {
+		if info.Header.Type == "tap" || info.Header.C == c.id {
+			seen++
+			assert.Equal(B.LocalHashname(), info.Hashname)
+			assert.NotNil(info.Addr)
+		}
+	}
+	assert.True(seen >= 3, "seen=%d", seen)
+}
+
+func TestDropExchange(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	// A accepts the channel but never reads from it
+	l := A.Listen("sink", true)
+	defer l.Close()
+	go l.AcceptChannel()
+
+	assert.False(B.DropExchange(A.LocalHashname()))
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	c, err := B.Open(ident, "sink", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	var (
+		errs = make(chan error, 3)
+		ctx  = context.Background()
+	)
+	go func() {
+		for {
+			if err := c.WritePacket(lob.New([]byte("data"))); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		_, err := c.ReadPacket()
+		errs <- err
+	}()
+	go func() {
+		errs <- c.Flush(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	assert.True(B.DropExchange(A.LocalHashname()))
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			assert.Equal(ErrPeerGone, err)
+		case <-time.After(time.Second):
+			t.Fatal("a blocked operation was not woken")
+		}
+	}
+
+	assert.Equal(ErrPeerGone, c.WritePacket(lob.New([]byte("late"))))
+	assert.Nil(B.GetExchange(A.LocalHashname()))
+}
+
+func TestExpiredExchangeBreaksChannels(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	l := A.Listen("sink", true)
+	defer l.Close()
+	go l.AcceptChannel()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	c, err := B.Open(ident, "sink", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	x := B.GetExchange(A.LocalHashname())
+	assert.Equal(1, x.ChannelCount())
+	assert.Equal(0, x.ChannelCount("sink"))
+
+	// only dropped exchanges fail their channels with ErrPeerGone
+	x.expire(nil)
+	err = c.WritePacket(lob.New([]byte("late")))
+	assert.IsType(&BrokenChannelError{}, err)
+}
+
+func TestCipherSetNegotiation(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	open := func(csids ...uint8) *Endpoint {
+		keys, err := cipherset.GenerateKeys(csids...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := Open(Transport(inproc.Config{}), Log(nil), Keys(keys))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	csid := func(x *Exchange) uint8 {
+		x.mtx.Lock()
+		defer x.mtx.Unlock()
+		return x.csid
+	}
+
+	var (
+		A = open(0x1a)       // an embedded peer which only speaks CS1a
+		B = open(0x1a, 0x3a) // prefers CS3a
+		C = open(0x3a)
+	)
+	defer A.Close()
+	defer B.Close()
+	defer C.Close()
+
+	identA, err := A.LocalIdentity()
+	assert.NoError(err)
+	identB, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	// the best mutual cipher set is used by both ends
+	x, err := B.Dial(identA)
+	if assert.NoError(err) {
+		assert.Equal(uint8(0x1a), csid(x))
+		if y := A.GetExchange(B.LocalHashname()); assert.NotNil(y) {
+			assert.Equal(uint8(0x1a), csid(y))
+		}
+	}
+
+	x, err = C.Dial(identB)
+	if assert.NoError(err) {
+		assert.Equal(uint8(0x3a), csid(x))
+	}
+
+	// A and C have no cipher set in common
+	_, err = C.Dial(identA)
+	assert.Equal(ErrNoMutualCipherSet, err)
+}
@@ -0,0 +1,183 @@
+package e3x
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// traffic holds the byte counters of an endpoint. The counters are updated
+// atomically so they can be used from the hot paths without locking.
+type traffic struct {
+	rawSent           uint64
+	rawRcvd           uint64
+	rawSentPkts       uint64
+	rawRcvdPkts       uint64
+	appSent           uint64
+	appRcvd           uint64
+	retransmits       uint64
+	handshakeFailures uint64
+
+	mtx      sync.Mutex
+	networks map[string]*networkTraffic
+}
+
+// networkTraffic counts the datagrams of the connections of a single network
+// (as in net.Addr.Network).
+type networkTraffic struct {
+	sentPkts uint64
+	rcvdPkts uint64
+}
+
+// network returns the counters of the named network.
+func (t *traffic) network(name string) *networkTraffic {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	n := t.networks[name]
+	if n == nil {
+		if t.networks == nil {
+			t.networks = make(map[string]*networkTraffic)
+		}
+		n = &networkTraffic{}
+		t.networks[name] = n
+	}
+	return n
+}
+
+// networkPackets returns the datagrams sent and received per network.
+func (t *traffic) networkPackets() (sent, rcvd map[string]float64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	sent = make(map[string]float64, len(t.networks))
+	rcvd = make(map[string]float64, len(t.networks))
+	for name, n := range t.networks {
+		sent[name] = float64(atomic.LoadUint64(&n.sentPkts))
+		rcvd[name] = float64(atomic.LoadUint64(&n.rcvdPkts))
+	}
+	return sent, rcvd
+}
+
+func (t *traffic) addRawSent(n int) {
+	atomic.AddUint64(&t.rawSent, uint64(n))
+	if n > 0 {
+		atomic.AddUint64(&t.rawSentPkts, 1)
+	}
+}
+
+func (t *traffic) addRawRcvd(n int) {
+	atomic.AddUint64(&t.rawRcvd, uint64(n))
+	if n > 0 {
+		atomic.AddUint64(&t.rawRcvdPkts, 1)
+	}
+}
+
+func (t *traffic) addAppSent(n int) {
+	if t != nil {
+		atomic.AddUint64(&t.appSent, uint64(n))
+	}
+}
+
+func (t *traffic) addAppRcvd(n int) {
+	if t != nil {
+		atomic.AddUint64(&t.appRcvd, uint64(n))
+	}
+}
+
+func (t *traffic) addRetransmits(n int) {
+	if t != nil {
+		atomic.AddUint64(&t.retransmits, uint64(n))
+	}
+}
+
+func (t *traffic) addHandshakeFailure() {
+	if t != nil {
+		atomic.AddUint64(&t.handshakeFailures, 1)
+	}
+}
+
+// Traffic returns the total number of bytes sent and received by the endpoint.
+// These are the raw datagram bytes (including handshakes, encryption overhead
+// and packets which were dropped) as seen by the transport.
+func (e *Endpoint) Traffic() (sentBytes, rcvdBytes uint64) {
+	return atomic.LoadUint64(&e.traffic.rawSent), atomic.LoadUint64(&e.traffic.rawRcvd)
+}
+
+// ApplicationTraffic returns the total number of channel packet body bytes
+// sent and received by the endpoint. Received bytes are counted after
+// decryption; retransmissions are counted every time they are sent.
+func (e *Endpoint) ApplicationTraffic() (sentBytes, rcvdBytes uint64) {
+	return atomic.LoadUint64(&e.traffic.appSent), atomic.LoadUint64(&e.traffic.appRcvd)
+}
+
+// trafficTransport counts the bytes read from and written to the connections of
+// the wrapped transport.
+type trafficTransport struct {
+	transports.Transport
+	traffic *traffic
+}
+
+func (t *trafficTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return t.wrap(conn), nil
+}
+
+func (t *trafficTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return t.wrap(conn), nil
+}
+
+func (t *trafficTransport) wrap(conn net.Conn) net.Conn {
+	network := "unknown"
+	if addr := conn.RemoteAddr(); addr != nil {
+		network = addr.Network()
+	}
+	return &trafficConn{conn, t.traffic, t.traffic.network(network)}
+}
+
+type trafficConn struct {
+	net.Conn
+	traffic *traffic
+	network *networkTraffic
+}
+
+func (c *trafficConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.traffic.addRawRcvd(n)
+	if n > 0 {
+		atomic.AddUint64(&c.network.rcvdPkts, 1)
+	}
+	return n, err
+}
+
+func (c *trafficConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.traffic.addRawSent(n)
+	if n > 0 {
+		atomic.AddUint64(&c.network.sentPkts, 1)
+	}
+	return n, err
+}
+
+func (c *trafficConn) WritePriority(b []byte, dscp int) (int, error) {
+	pconn, ok := c.Conn.(transports.PriorityConn)
+	if !ok {
+		return c.Write(b)
+	}
+
+	n, err := pconn.WritePriority(b, dscp)
+	c.traffic.addRawSent(n)
+	if n > 0 {
+		atomic.AddUint64(&c.network.sentPkts, 1)
+	}
+	return n, err
+}
@@ -0,0 +1,16 @@
+package e3x
+
+import (
+	"context"
+)
+
+// WaitForExchange returns once the exchange with the peer identified by i is
+// open. The exchange is dialed when it isn't open yet (use HashnameIdentifier to
+// wait for a peer which is already known to the endpoint). An exchange which is
+// already open is returned immediately, even when ctx is done.
+//
+// The error of the handshake is returned when the exchange breaks. Once ctx is
+// done ctx.Err() is returned (see Exchange.DialContext).
+func (e *Endpoint) WaitForExchange(ctx context.Context, i Identifier) (*Exchange, error) {
+	return e.DialContext(ctx, i)
+}
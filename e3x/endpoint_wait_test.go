@@ -0,0 +1,63 @@
+package e3x
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestWaitForExchange(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	// unknown peers can't be identified by their hashname
+	_, err = B.WaitForExchange(context.Background(), HashnameIdentifier(A.LocalHashname()))
+	assert.Equal(ErrUnidentifiable, err)
+
+	// a new exchange is established
+	x, err := B.WaitForExchange(context.Background(), ident)
+	if assert.NoError(err) {
+		assert.True(x.State().IsOpen())
+		assert.Equal(A.LocalHashname(), x.RemoteHashname())
+	}
+
+	// an open exchange is returned immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	y, err := B.WaitForExchange(ctx, HashnameIdentifier(A.LocalHashname()))
+	if assert.NoError(err) {
+		assert.True(x == y)
+	}
+
+	// a peer which never responds
+	keys, err := cipherset.GenerateKeys()
+	assert.NoError(err)
+	silent, err := NewIdentity(keys, nil, nil)
+	assert.NoError(err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = B.WaitForExchange(ctx, silent)
+	assert.Equal(context.DeadlineExceeded, err)
+}
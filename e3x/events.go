@@ -0,0 +1,213 @@
+package e3x
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// cEventBufferSize is the number of events a subscription buffers before it
+// starts dropping them.
+const cEventBufferSize = 64
+
+// Event is a lifecycle notification published on the event bus of an endpoint
+// (see Endpoint.Subscribe). Modules can define their own events and publish
+// them with Endpoint.Publish.
+type Event interface {
+	// EventType names the kind of event, for example "peer.connected".
+	EventType() string
+}
+
+// PeerConnectedEvent is published when an exchange with a peer is opened.
+type PeerConnectedEvent struct {
+	Exchange *Exchange
+}
+
+// PeerDisconnectedEvent is published when an exchange is closed. Reason is
+// nil when the exchange expired or was dropped (see Endpoint.DropExchange).
+type PeerDisconnectedEvent struct {
+	Exchange *Exchange
+	Reason   error
+}
+
+// LineEstablishedEvent is published when a line (the session keys of an
+// exchange) is established, both when the exchange opens and every time it
+// is rekeyed.
+type LineEstablishedEvent struct {
+	Exchange *Exchange
+	Rekeyed  bool
+}
+
+// LineExpiredEvent is published when the line of an idle exchange expired.
+type LineExpiredEvent struct {
+	Exchange *Exchange
+}
+
+// ChannelOpenedEvent is published when a channel is opened, by either side.
+type ChannelOpenedEvent struct {
+	Exchange *Exchange
+	Channel  *Channel
+}
+
+// ChannelClosedEvent is published when a channel is closed.
+type ChannelClosedEvent struct {
+	Exchange *Exchange
+	Channel  *Channel
+}
+
+// PathChangedEvent is published when the active path of an exchange changes.
+// From or To is nil when there was or is no usable path.
+type PathChangedEvent struct {
+	Exchange *Exchange
+	From     net.Addr
+	To       net.Addr
+}
+
+func (PeerConnectedEvent) EventType() string    { return "peer.connected" }
+func (PeerDisconnectedEvent) EventType() string { return "peer.disconnected" }
+func (LineEstablishedEvent) EventType() string  { return "line.established" }
+func (LineExpiredEvent) EventType() string      { return "line.expired" }
+func (ChannelOpenedEvent) EventType() string    { return "channel.opened" }
+func (ChannelClosedEvent) EventType() string    { return "channel.closed" }
+func (PathChangedEvent) EventType() string      { return "path.changed" }
+
+// Subscription receives the events published on an endpoint.
+type Subscription struct {
+	bus     *eventBus
+	c       chan Event
+	types   map[string]bool
+	dropped uint64
+}
+
+// Events returns the channel the events are delivered on. It is closed when
+// the subscription or the endpoint is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Dropped returns the number of events which were dropped because the
+// subscriber didn't keep up.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the delivery of events.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+type eventBus struct {
+	mtx    sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*Subscription]struct{})}
+}
+
+func (b *eventBus) subscribe(types []string) *Subscription {
+	s := &Subscription{bus: b, c: make(chan Event, cEventBufferSize)}
+	if len(types) > 0 {
+		s.types = make(map[string]bool, len(types))
+		for _, typ := range types {
+			s.types[typ] = true
+		}
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.closed {
+		close(s.c)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *Subscription) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if _, found := b.subs[s]; found {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// publish delivers ev to the matching subscriptions. It never blocks, so it
+// can be called while holding locks; slow subscribers miss events.
+func (b *eventBus) publish(ev Event) {
+	if b == nil {
+		return
+	}
+
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	for s := range b.subs {
+		if s.types != nil && !s.types[ev.EventType()] {
+			continue
+		}
+		select {
+		case s.c <- ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func (b *eventBus) close() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// Subscribe returns a subscription to the events of the endpoint. When types
+// are given only the events of those types are delivered.
+func (e *Endpoint) Subscribe(types ...string) *Subscription {
+	return e.events.subscribe(types)
+}
+
+// Publish publishes ev to the subscribers of the endpoint.
+func (e *Endpoint) Publish(ev Event) {
+	e.events.publish(ev)
+}
+
+func (e *Endpoint) publishExchangeOpened(_ *Endpoint, x *Exchange) error {
+	if !atomic.CompareAndSwapUint32(&x.announced, 0, 1) {
+		return nil
+	}
+	e.events.publish(PeerConnectedEvent{Exchange: x})
+	e.events.publish(LineEstablishedEvent{Exchange: x})
+	return nil
+}
+
+// publishExchangeClosed only reports the exchanges which were reported as
+// connected; exchanges which failed to open are not.
+func (e *Endpoint) publishExchangeClosed(_ *Endpoint, x *Exchange, reason error) error {
+	if !atomic.CompareAndSwapUint32(&x.announced, 1, 2) {
+		return nil
+	}
+	if atomic.LoadUint32(&x.idleExpired) == 1 {
+		e.events.publish(LineExpiredEvent{Exchange: x})
+	}
+	e.events.publish(PeerDisconnectedEvent{Exchange: x, Reason: reason})
+	return nil
+}
+
+func (e *Endpoint) publishChannelOpened(_ *Endpoint, x *Exchange, c *Channel) error {
+	e.events.publish(ChannelOpenedEvent{Exchange: x, Channel: c})
+	return nil
+}
+
+func (e *Endpoint) publishChannelClosed(_ *Endpoint, x *Exchange, c *Channel) error {
+	e.events.publish(ChannelClosedEvent{Exchange: x, Channel: c})
+	return nil
+}
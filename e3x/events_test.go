@@ -0,0 +1,133 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+// nextEvent returns the next event of type typ, skipping the others. Any type
+// matches when typ is empty.
+func nextEvent(t *testing.T, s *Subscription, typ string) Event {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev, ok := <-s.Events():
+			if !ok {
+				t.Fatalf("subscription closed while waiting for %s", typ)
+			}
+			if typ == "" || ev.EventType() == typ {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", typ)
+		}
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	s := B.Subscribe()
+	defer s.Close()
+
+	l := A.Listen("test", true)
+	defer l.Close()
+	go func() {
+		c, err := l.AcceptChannel()
+		if err == nil {
+			c.Close()
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	c, err := B.Open(ident, "test", true)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// the events of the exchange may be published after those of the channel
+	var (
+		seen   = map[string]Event{}
+		opened bool
+	)
+	for !opened || seen["peer.connected"] == nil || seen["line.established"] == nil {
+		ev := nextEvent(t, s, "")
+		seen[ev.EventType()] = ev
+		if ev, ok := ev.(ChannelOpenedEvent); ok && ev.Channel == c {
+			opened = true
+		}
+	}
+
+	if path, ok := seen["path.changed"].(PathChangedEvent); assert.True(ok) {
+		assert.Nil(path.From)
+		assert.NotNil(path.To)
+	}
+	connected := seen["peer.connected"].(PeerConnectedEvent)
+	assert.Equal(A.LocalHashname(), connected.Exchange.RemoteHashname())
+	assert.False(seen["line.established"].(LineEstablishedEvent).Rekeyed)
+
+	c.Kill()
+	for {
+		closed := nextEvent(t, s, "channel.closed").(ChannelClosedEvent)
+		if closed.Channel == c {
+			break
+		}
+	}
+
+	B.DropExchange(A.LocalHashname())
+	disconnected := nextEvent(t, s, "peer.disconnected").(PeerDisconnectedEvent)
+	assert.True(connected.Exchange == disconnected.Exchange)
+	assert.NoError(disconnected.Reason)
+}
+
+func TestSubscriptionFilterAndClose(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := A.Subscribe("peer.connected")
+	for i := 0; i < cEventBufferSize+3; i++ {
+		A.Publish(ChannelOpenedEvent{})
+		A.Publish(PeerConnectedEvent{})
+	}
+	assert.Equal(uint64(3), s.Dropped())
+
+	n := 0
+	for len(s.Events()) > 0 {
+		ev := <-s.Events()
+		assert.Equal("peer.connected", ev.EventType())
+		n++
+	}
+	assert.Equal(cEventBufferSize, n)
+
+	assert.NoError(A.Close())
+	_, ok := <-s.Events()
+	assert.False(ok, "closing the endpoint closes the subscriptions")
+
+	_, ok = <-A.Subscribe().Events()
+	assert.False(ok)
+}
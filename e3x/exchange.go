@@ -1,12 +1,14 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/telehash/gogotelehash/e3x/cipherset"
@@ -20,6 +22,23 @@ import (
 
 var ErrInvalidHandshake = errors.New("e3x: invalid handshake")
 
+// ErrInvalidChannelID is returned when a channel is opened with an id that
+// can't be used by the local side of the exchange.
+var ErrInvalidChannelID = errors.New("e3x: invalid channel id")
+
+// ErrChannelIDInUse is returned when a channel is opened with the id of a live
+// channel.
+var ErrChannelIDInUse = errors.New("e3x: channel id in use")
+
+// ErrUnsupportedChannelType is returned by Open when the endpoint checks peer
+// support (see CheckPeerSupport) and the peer advertised that it doesn't listen
+// for channels of the requested type.
+var ErrUnsupportedChannelType = errors.New("e3x: channel type not supported by peer")
+
+// ErrNoMutualCipherSet is returned when an exchange is created with a peer
+// which supports none of the cipher sets of the local endpoint.
+var ErrNoMutualCipherSet = errors.New("e3x: no mutual cipher set")
+
 type BrokenExchangeError hashname.H
 
 func (err BrokenExchangeError) Error() string {
@@ -81,10 +100,38 @@ type Exchange struct {
 	cipher        cipherset.State
 	nextChannelID uint32
 	channels      *channelSet
+	openNonces    *nonceCache
 	addressBook   *addressBook
+	rateLimiter   *rateLimiter
+	throttled     []throttledPacket // packets waiting for the rate limiter
+	draining      bool              // a writer drains throttled
+	rcvBudget     *receiveBudget
+	dialLimiter   *dialLimiter
+	addrPolicy    AddressFamilyPolicy
+	rtt           rttEstimator
+	rtoMin        time.Duration
+	rtoMax        time.Duration
+	channelLinger time.Duration
+	keepalive     time.Duration
+	lineKeepalive time.Duration
+	idleTimeout   time.Duration
+	maxChannels   int
+	maxResends    int
+	remoteCaps    map[string]bool // nil until the peer advertised its channel types
+	checkCaps     bool
+	inboundTap    InboundTapFunc
+	rekeyAt       uint32 // seq of the handshake announcing a pending rekey
+	rekeyInterval time.Duration
+	rekeyBytes    uint64
+	lineBytes     uint64 // bytes encrypted with the current line key
 	err           error
 
 	endpoint      endpointI
+	traffic       *traffic
+	events        *eventBus
+	announced     uint32 // see Endpoint.publishExchangeClosed
+	idleExpired   uint32 // set when the idle timer expired the exchange
+	rtts          *rttHistogram
 	listenerSet   *listenerSet
 	log           *logs.Logger
 	exchangeHooks ExchangeHooks
@@ -94,6 +141,9 @@ type Exchange struct {
 	tExpire           *time.Timer
 	tBreak            *time.Timer
 	tDeliverHandshake *time.Timer
+	tKeepalive        *time.Timer
+	tRekey            *time.Timer
+	lastSent          time.Time
 }
 
 type ExchangeOption func(e *Exchange) error
@@ -113,14 +163,24 @@ func newExchange(
 		localIdent:  localIdent,
 		remoteIdent: remoteIdent,
 		channels:    &channelSet{},
+		openNonces:  newNonceCache(openNonceTTL, openNonceCacheSize),
 	}
 	x.traceNew()
 
+	if localIdent != nil && remoteIdent != nil &&
+		cipherset.SelectCSID(localIdent.keys, remoteIdent.keys) == 0 {
+		return nil, x.traceError(ErrNoMutualCipherSet)
+	}
+
 	x.cndState = sync.NewCond(&x.mtx)
 
 	x.tBreak = time.AfterFunc(2*60*time.Second, x.onBreak)
 	x.tExpire = time.AfterFunc(60*time.Second, x.onExpire)
 	x.tDeliverHandshake = time.AfterFunc(60*time.Second, x.onDeliverHandshake)
+	x.tKeepalive = time.AfterFunc(60*time.Second, x.onKeepalive)
+	x.tKeepalive.Stop()
+	x.tRekey = time.AfterFunc(60*time.Second, x.autoRekey)
+	x.tRekey.Stop()
 	x.resetExpire()
 	x.rescheduleHandshake()
 
@@ -134,6 +194,7 @@ func newExchange(
 	if remoteIdent != nil {
 		x.log = log.To(remoteIdent.Hashname())
 
+		// the best (highest) cipher set supported by both ends is used
 		csid := cipherset.SelectCSID(localIdent.keys, remoteIdent.keys)
 		cipher, err := cipherset.NewState(csid, localIdent.keys[csid])
 		if err != nil {
@@ -145,7 +206,8 @@ func newExchange(
 			return nil, x.traceError(err)
 		}
 
-		x.addressBook = newAddressBook(x.log)
+		x.addressBook = newAddressBook(x.log, x.addrPolicy.pathFamily())
+		x.addressBook.onChange = x.pathChanged
 		x.cipher = cipher
 		x.csid = csid
 
@@ -175,12 +237,18 @@ func newExchange(
 		x.log = log.To(hn)
 		x.cipher = cipher
 		x.csid = csid
-		x.addressBook = newAddressBook(x.log)
+		x.addressBook = newAddressBook(x.log, x.addrPolicy.pathFamily())
+		x.addressBook.onChange = x.pathChanged
 	}
 
 	return x, nil
 }
 
+// pathChanged is called by the address book when the active path changed.
+func (x *Exchange) pathChanged(from, to net.Addr) {
+	x.events.publish(PathChangedEvent{Exchange: x, From: from, To: to})
+}
+
 func (x *Exchange) setOptions(options ...ExchangeOption) error {
 	for _, option := range options {
 		if err := option(x); err != nil {
@@ -193,6 +261,22 @@ func (x *Exchange) setOptions(options ...ExchangeOption) error {
 func registerEndpoint(e *Endpoint) ExchangeOption {
 	return func(x *Exchange) error {
 		x.endpoint = e
+		x.traffic = e.traffic
+		x.events = e.events
+		x.rtts = e.rtts
+		x.rtoMin, x.rtoMax = e.rtoMin, e.rtoMax
+		x.channelLinger = e.channelLinger
+		x.keepalive = e.channelKeepalive
+		x.lineKeepalive = e.lineKeepalive
+		x.idleTimeout = e.lineIdleTimeout
+		x.rekeyInterval, x.rekeyBytes = e.rekeyInterval, e.rekeyBytes
+		x.maxChannels = e.maxChannels
+		x.maxResends = e.maxResends
+		x.rcvBudget = e.rcvBudget
+		x.dialLimiter = e.dialLimiter
+		x.addrPolicy = e.addrPolicy
+		x.checkCaps = e.checkPeerSupport
+		x.inboundTap = e.inboundTap
 		x.listenerSet = e.listenerSet.Inherit()
 		x.exchangeHooks = e.exchangeHooks
 		x.channelHooks = e.channelHooks
@@ -323,16 +407,58 @@ func (x *Exchange) traceReceivedPacket(msg message, pkt *lob.Packet) {
 
 // Dial exchanges the initial handshakes. It will timeout after 2 minutes.
 func (x *Exchange) Dial() error {
+	return x.DialContext(context.Background())
+}
+
+// DialContext exchanges the initial handshakes (like Dial). Once ctx is done
+// ctx.Err() is returned. A dial which was still waiting for a dial slot (see
+// DialLimit) is abandoned; otherwise the handshake continues in the background.
+// An exchange which is already open is returned immediately, even when ctx is
+// done.
+func (x *Exchange) DialContext(ctx context.Context) error {
 	x.mtx.Lock()
 	defer x.mtx.Unlock()
 
-	if x.state == 0 {
-		x.state = ExchangeDialing
-		x.deliverHandshake()
-		x.rescheduleHandshake()
+	if x.state.IsOpen() {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	for x.state == ExchangeDialing {
+	defer wakeOnDone(ctx, &x.mtx, x.cndState)()
+	for {
+		if x.state == 0 {
+			x.state = ExchangeDialing
+
+			// wait for a dial slot; the peer may open the exchange meanwhile
+			x.mtx.Unlock()
+			err := x.dialLimiter.acquireContext(ctx)
+			x.mtx.Lock()
+			if err != nil {
+				if x.state == ExchangeDialing {
+					// nothing was sent; the other dialers start over
+					x.state = ExchangeInitialising
+					x.cndState.Broadcast()
+				}
+				return err
+			}
+
+			if x.state == ExchangeDialing {
+				x.deliverInitialHandshake()
+				x.rescheduleHandshake()
+			}
+			// the slot is held until the handshake settles, even when ctx is
+			// done before that
+			go x.releaseDialSlot()
+		}
+
+		if x.state != ExchangeDialing {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		x.cndState.Wait()
 	}
 
@@ -343,6 +469,17 @@ func (x *Exchange) Dial() error {
 	return nil
 }
 
+// releaseDialSlot returns the dial slot of x once x is no longer dialing.
+func (x *Exchange) releaseDialSlot() {
+	x.mtx.Lock()
+	for x.state == ExchangeDialing {
+		x.cndState.Wait()
+	}
+	x.mtx.Unlock()
+
+	x.dialLimiter.release()
+}
+
 // RemoteHashname returns the hashname of the remote peer.
 func (x *Exchange) RemoteHashname() hashname.H {
 	hn := x.remoteIdent.Hashname()
@@ -357,6 +494,22 @@ func (x *Exchange) RemoteIdentity() *Identity {
 	return ident
 }
 
+// ExportKey derives length bytes from the secret of the current line and label
+// (using HKDF-SHA256). Both ends of the exchange derive the same value, which
+// allows applications to bind their own tokens or keys to the line. The value
+// changes when the line is re-established. A BrokenExchangeError is returned
+// when the exchange is not open.
+func (x *Exchange) ExportKey(label string, length int) ([]byte, error) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	if !x.state.IsOpen() || x.cipher == nil {
+		return nil, BrokenExchangeError(x.remoteIdent.Hashname())
+	}
+
+	return x.cipher.ExportKey(label, length)
+}
+
 // ActivePath returns the path that is currently used for channel packets.
 func (x *Exchange) ActivePath() net.Addr {
 	return x.addressBook.ActiveConnection().RemoteAddr()
@@ -381,7 +534,24 @@ func (x *Exchange) dialDialerAddr(addr dialerAddr) (net.Conn, error) {
 	return addr.Dial(x.endpoint.(*Endpoint), x)
 }
 
+// reconnected sends a handshake over p, which dialed a new connection; the peer
+// only learns the new connection from a handshake.
+func (x *Exchange) reconnected(p *Pipe) {
+	go func() {
+		x.mtx.Lock()
+		defer x.mtx.Unlock()
+
+		if x.state.IsOpen() {
+			x.deliverHandshakeTo([]*Pipe{p})
+		}
+	}()
+}
+
 func (x *Exchange) received(msg message) {
+	if x.rerouteDrained(msg) {
+		return
+	}
+
 	if msg.IsHandshake {
 		x.receivedHandshake(msg)
 	} else {
@@ -400,19 +570,17 @@ func (x *Exchange) onDeliverHandshake() {
 }
 
 func (x *Exchange) deliverHandshake() error {
-	var (
-		pktData *bufpool.Buffer
-		err     error
-	)
-
 	x.addressBook.NextHandshakeEpoch()
+	return x.deliverHandshakeTo(x.addressBook.HandshakePipes())
+}
 
-	pktData, err = x.generateHandshake(0)
+func (x *Exchange) deliverHandshakeTo(pipes []*Pipe) error {
+	pktData, err := x.generateHandshake(0)
 	if err != nil {
 		return err
 	}
 
-	for _, pipe := range x.addressBook.HandshakePipes() {
+	for _, pipe := range pipes {
 		_, err := pipe.Write(pktData)
 		if err == nil {
 			x.addressBook.SentHandshake(pipe)
@@ -448,6 +616,9 @@ func (x *Exchange) receivedPacket(msg message) {
 		dropMissingChannelID      = "missing channel id header"
 		dropMissingChannelType    = "missing channel type header"
 		dropMissingChannelHandler = "missing channel handler"
+		dropReplayedOpen          = "replayed open packet"
+		dropTooManyChannels       = "too many channels"
+		dropByInboundTap          = "dropped by inbound tap"
 	)
 
 	{
@@ -477,6 +648,7 @@ func (x *Exchange) receivedPacket(msg message) {
 		return // drop
 	}
 	pkt2.TID = msg.TID
+	x.traffic.addAppRcvd(pkt2.BodyLen())
 	var (
 		hdr          = pkt2.Header()
 		cid, hasC    = hdr.C, hdr.HasC
@@ -485,7 +657,22 @@ func (x *Exchange) receivedPacket(msg message) {
 		c            *Channel
 	)
 
+	nonce, hasNonce := hdr.GetString(openNonceHeader)
+	if hasNonce {
+		delete(hdr.Extra, openNonceHeader)
+	}
+
+	if !x.tapInbound(pkt2, msg.Pipe) {
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
+		x.traceDroppedPacket(msg, pkt2, dropByInboundTap)
+		return
+	}
+
 	if !hasC {
+		if isLineKeepalive(pkt2) {
+			return
+		}
+
 		// drop: missing "c"
 		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
 		x.traceDroppedPacket(msg, pkt2, dropMissingChannelID)
@@ -503,14 +690,35 @@ func (x *Exchange) receivedPacket(msg message) {
 				return // drop (missing typ)
 			}
 
-			listener := x.listenerSet.Get(typ)
+			var (
+				listener = x.listenerSet.Get(typ)
+				fallback FallbackFunc
+			)
 			if listener == nil {
+				fallback = x.listenerSet.getFallback()
+			}
+			if listener == nil && fallback == nil {
 				addPromise.Cancel()
 				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
 				x.traceDroppedPacket(msg, pkt2, dropMissingChannelHandler)
 				return // drop (no handler)
 			}
 
+			if hasNonce && !x.openNonces.add(nonce, time.Now()) {
+				// the channel was opened (and closed) before
+				addPromise.Cancel()
+				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
+				x.traceDroppedPacket(msg, pkt2, dropReplayedOpen)
+				return // drop (replayed open)
+			}
+
+			if x.tooManyChannels(typ, addPromise.Count(internalChannelTypes...)) {
+				addPromise.Cancel()
+				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
+				x.traceDroppedPacket(msg, pkt2, dropTooManyChannels)
+				return // drop (too many channels)
+			}
+
 			c = newChannel(
 				x.remoteIdent.Hashname(),
 				typ,
@@ -520,16 +728,25 @@ func (x *Exchange) receivedPacket(msg message) {
 				registerExchange(x),
 			)
 			c.id = cid
+			c.openedVia = msg.Pipe
 			addPromise.Add(c)
 
 			x.mtx.Lock()
 			x.resetExpire()
 			x.mtx.Unlock()
 
+			if x.keepalive > 0 {
+				c.SetKeepalive(x.keepalive)
+			}
+
 			x.log.Printf("\x1B[32mOpened channel\x1B[0m %q %d", typ, cid)
 			c.channelHooks.Opened()
 
-			listener.handle(c)
+			if listener != nil {
+				listener.handle(c)
+			} else {
+				go fallback(c)
+			}
 		}
 	}
 
@@ -543,32 +760,90 @@ func (x *Exchange) deliverPacket(pkt *lob.Packet, p *Pipe) error {
 		x.cndState.Wait()
 	}
 	if !x.state.IsOpen() {
+		x.mtx.Unlock()
 		return BrokenExchangeError(x.remoteIdent.Hashname())
 	}
+	limiter := x.rateLimiter
+	priority := x.channelPriority(pkt.Header().C)
 	x.mtx.Unlock()
 
 	if p == nil {
 		p = x.addressBook.ActiveConnection()
 	}
 
-	pkt2, err := x.cipher.EncryptPacket(pkt)
+	if limiter != nil && !isAckOnly(pkt) {
+		if ack := splitAck(pkt); ack != nil {
+			// the acks are never throttled
+			x.writePacket(ack, p, priority)
+		}
+		return x.queueThrottled(pkt, p, priority)
+	}
+
+	return x.writePacket(pkt, p, priority)
+}
+
+// writePacket encrypts pkt and writes it to p right away.
+func (x *Exchange) writePacket(pkt *lob.Packet, p *Pipe, priority int) error {
+	msg, err := x.encryptPacket(pkt)
 	if err != nil {
 		return err
 	}
 
+	_, err = p.writePriority(msg, priority)
+	msg.Free()
+	if err == nil {
+		x.sentPacket(pkt.BodyLen())
+	}
+
+	return err
+}
+
+// encryptPacket encrypts and encodes pkt.
+func (x *Exchange) encryptPacket(pkt *lob.Packet) (*bufpool.Buffer, error) {
+	pkt2, err := x.cipher.EncryptPacket(pkt)
+	if err != nil {
+		return nil, err
+	}
+
 	msg, err := lob.Encode(pkt2)
 	pkt2.Free()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = p.Write(msg)
-	msg.Free()
+	x.mtx.Lock()
+	rekey := x.sentOnLine(msg.Len())
+	x.mtx.Unlock()
 
-	return err
+	if rekey {
+		go x.autoRekey()
+	}
+
+	return msg, nil
+}
+
+// sentPacket records that a packet with a body of n bytes was written.
+func (x *Exchange) sentPacket(n int) {
+	x.traffic.addAppSent(n)
+
+	x.mtx.Lock()
+	x.lastSent = time.Now()
+	x.mtx.Unlock()
 }
 
 func (x *Exchange) expire(err error) {
+	x.teardown(err, nil)
+}
+
+// drop tears down x like expire; the pending and subsequent operations on its
+// channels return ErrPeerGone.
+func (x *Exchange) drop() {
+	x.teardown(nil, ErrPeerGone)
+}
+
+// teardown expires (err is nil) or breaks x. Its channels are broken with
+// chanErr (see Channel.breakWith).
+func (x *Exchange) teardown(err, chanErr error) {
 	x.mtx.Lock()
 	if x.state == ExchangeExpired || x.state == ExchangeBroken {
 		x.mtx.Unlock()
@@ -588,11 +863,13 @@ func (x *Exchange) expire(err error) {
 	x.tBreak.Stop()
 	x.tExpire.Stop()
 	x.tDeliverHandshake.Stop()
+	x.tKeepalive.Stop()
+	x.tRekey.Stop()
 
 	x.mtx.Unlock()
 
 	for _, c := range x.channels.All() {
-		c.onCloseDeadlineReached()
+		c.breakWith(chanErr)
 	}
 
 	for _, p := range x.addressBook.KnownPipes() {
@@ -647,6 +924,10 @@ func (x *Exchange) onExpire() {
 	if x == nil {
 		return
 	}
+	if x.keepIdle() {
+		return
+	}
+	atomic.StoreUint32(&x.idleExpired, 1)
 	x.expire(nil)
 }
 
@@ -664,7 +945,7 @@ func (x *Exchange) resetExpire() {
 		x.tExpire.Stop()
 	} else {
 		if x.state.IsOpen() {
-			x.tExpire.Reset(2 * 60 * time.Second)
+			x.tExpire.Reset(x.lineIdleTimeout())
 		}
 	}
 
@@ -686,15 +967,27 @@ func (x *Exchange) resetBreak() {
 }
 
 func (x *Exchange) unregisterChannel(_ *Endpoint, _ *Exchange, c *Channel) error {
+	if x.channelLinger > 0 {
+		if c.linger() {
+			time.AfterFunc(x.channelLinger, func() { x.removeChannel(c) })
+		}
+		return nil
+	}
+
+	x.removeChannel(c)
+	return nil
+}
+
+func (x *Exchange) removeChannel(c *Channel) {
 	if x.channels.Remove(c.id) {
+		c.releaseReadBuffer()
+
 		x.mtx.Lock()
 		x.resetExpire()
 		x.mtx.Unlock()
 
 		x.log.Printf("\x1B[31mClosed channel\x1B[0m %q %d", c.typ, c.id)
 	}
-
-	return nil
 }
 
 func (x *Exchange) getNextChannelID() uint32 {
@@ -730,7 +1023,22 @@ func (x *Exchange) waitDone() {
 }
 
 // Open a channel.
-func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
+func (x *Exchange) Open(typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	return x.openWithID(context.Background(), typ, reliable, 0, options...)
+}
+
+// OpenContext opens a channel (like Open). Once ctx is done while the exchange
+// is still dialing ctx.Err() is returned.
+func (x *Exchange) OpenContext(ctx context.Context, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	return x.openWithID(ctx, typ, reliable, 0, options...)
+}
+
+// openWithID opens a channel with an explicit channel id. This allows tests and
+// interop tooling to reference a known channel. The id must be valid for the
+// local side of the exchange (odd when the local key is high, even otherwise)
+// and must not be used by a live channel. When id is zero the next free id is
+// used.
+func (x *Exchange) openWithID(ctx context.Context, typ string, reliable bool, id uint32, options ...ChannelOption) (*Channel, error) {
 	var (
 		c *Channel
 	)
@@ -744,25 +1052,76 @@ func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
 		registerExchange(x),
 	)
 
+	if err := c.setOptions(options...); err != nil {
+		return nil, err
+	}
+
 	x.mtx.Lock()
-	for x.state == ExchangeDialing {
-		x.cndState.Wait()
+	if x.state == ExchangeDialing {
+		stop := wakeOnDone(ctx, &x.mtx, x.cndState)
+		for x.state == ExchangeDialing && ctx.Err() == nil {
+			x.cndState.Wait()
+		}
+		stop()
+	}
+	if x.state == ExchangeDialing {
+		x.mtx.Unlock()
+		return nil, ctx.Err()
 	}
 	if !x.state.IsOpen() {
 		x.mtx.Unlock()
 		return nil, BrokenExchangeError(x.remoteIdent.Hashname())
 	}
 
-	c.id = x.getNextChannelID()
-	x.channels.Add(c.id, c)
+	if x.checkCaps && x.remoteCaps != nil && !x.remoteCaps[typ] {
+		x.mtx.Unlock()
+		return nil, ErrUnsupportedChannelType
+	}
+
+	if id == 0 {
+		id = x.getNextChannelID()
+		for x.channels.Get(id) != nil {
+			id = x.getNextChannelID()
+		}
+	} else if !x.isLocalChannelID(id) {
+		x.mtx.Unlock()
+		return nil, ErrInvalidChannelID
+	}
+
+	if x.tooManyChannels(typ, x.channels.Count(internalChannelTypes...)) {
+		x.mtx.Unlock()
+		return nil, ErrTooManyChannels
+	}
+
+	c.id = id
+	if !x.channels.Add(c.id, c) {
+		x.mtx.Unlock()
+		return nil, ErrChannelIDInUse
+	}
 	x.resetExpire()
 	x.mtx.Unlock()
 
+	if x.keepalive > 0 {
+		c.SetKeepalive(x.keepalive)
+	}
+
 	x.log.Printf("\x1B[32mOpened channel\x1B[0m %q %d", typ, c.id)
 	c.channelHooks.Opened()
 	return c, nil
 }
 
+// isLocalChannelID returns true when id may be used by the local side to open
+// a channel.
+func (x *Exchange) isLocalChannelID(id uint32) bool {
+	if id == 0 {
+		return false
+	}
+	if x.cipher.IsHigh() {
+		return id%2 == 1
+	}
+	return id%2 == 0
+}
+
 // LocalToken returns the token identifying the local side of the exchange.
 func (x *Exchange) LocalToken() cipherset.Token {
 	return x.cipher.LocalToken()
@@ -785,6 +1144,23 @@ func (x *Exchange) AddPathCandidate(addr net.Addr) {
 	}
 }
 
+// PunchHole adds addr as a path candidate and sends a handshake to it right
+// away. When two endpoints behind NATs punch each others public addresses at
+// about the same time, the NATs let the handshakes through and the exchange
+// gains a direct path.
+func (x *Exchange) PunchHole(addr net.Addr) error {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	p := x.addressBook.PipeToAddr(addr)
+	if p == nil {
+		p = newPipe(x.endpoint.getTransport(), nil, addr, x)
+		x.addressBook.AddPipe(p)
+	}
+
+	return x.deliverHandshakeTo([]*Pipe{p})
+}
+
 // GenerateHandshake can be used to generate a new handshake packet.
 // This is useful when the exchange doesn't know where to send the handshakes yet.
 func (x *Exchange) GenerateHandshake() (*bufpool.Buffer, error) {
@@ -873,11 +1249,17 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 		return nil, false
 	}
 
+	oldRemoteToken := x.cipher.RemoteToken()
 	if !x.cipher.ApplyHandshake(handshake) {
 		// drop; handshake was rejected by the cipherset
 		return nil, false
 	}
 
+	// the peer replaced its line key
+	rekeyed := x.state.IsOpen() &&
+		oldRemoteToken != cipherset.ZeroToken &&
+		oldRemoteToken != x.cipher.RemoteToken()
+
 	if x.remoteIdent == nil {
 		ident, err := NewIdentity(
 			cipherset.Keys{handshake.CSID(): handshake.PublicKey()},
@@ -894,6 +1276,18 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 	if x.isLocalSeq(seq) {
 		x.resetBreak()
 		x.addressBook.ReceivedHandshake(pipe)
+		if x.state == ExchangeDialing {
+			// the first path to respond is used
+			x.addressBook.MakeActive(pipe)
+		}
+
+		oldLocalToken := x.cipher.LocalToken()
+		if x.cutover(seq) {
+			rekeyed = true
+			if e, ok := x.endpoint.(*Endpoint); ok {
+				go e.retireToken(x, oldLocalToken)
+			}
+		}
 
 	} else {
 		x.addressBook.AddPipe(pipe)
@@ -910,15 +1304,36 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 
 		x.state = ExchangeIdle
 		x.resetExpire()
+		x.startKeepalive()
+		x.scheduleRekey()
 		x.cndState.Broadcast()
 
 		go x.exchangeHooks.Opened()
 	}
 
+	if rekeyed {
+		x.events.publish(LineEstablishedEvent{Exchange: x, Rekeyed: true})
+		go x.resendUnacked()
+	}
+
 	return response, true
 }
 
 func (x *Exchange) receivedHandshake(msg message) bool {
+	ok, reason := x.applyReceivedHandshake(msg)
+	if !ok {
+		// the hooks are called without holding the lock; they may call back
+		// into the exchange
+		x.traffic.addHandshakeFailure()
+		statEndpointRcvHandshakeFailed.Add(1)
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, reason)
+	}
+	return ok
+}
+
+// applyReceivedHandshake applies the handshake in msg. When the handshake is
+// dropped ok is false and reason may hold the cause.
+func (x *Exchange) applyReceivedHandshake(msg message) (ok bool, reason error) {
 	x.mtx.Lock()
 	defer x.mtx.Unlock()
 
@@ -931,38 +1346,33 @@ func (x *Exchange) receivedHandshake(msg message) bool {
 	)
 
 	if !msg.IsHandshake {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
 		x.traceDroppedHandshake(msg, nil, "invalid packet")
-		return false
+		return false, nil
 	}
 
 	pkt, err = lob.Decode(msg.Data)
 	if err != nil {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, err)
 		x.traceDroppedHandshake(msg, nil, err.Error())
-		return false
+		return false, err
 	}
 
 	hdr := pkt.Header()
 	if !hdr.IsBinary() && len(hdr.Bytes) != 1 {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
 		x.traceDroppedHandshake(msg, nil, "invalid header")
-		return false
+		return false, nil
 	}
 	csid = uint8(hdr.Bytes[0])
 
 	handshake, err = cipherset.DecryptHandshake(csid, x.localIdent.keys[csid], pkt.Body(buf[:0]))
 	if err != nil {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, err)
 		x.traceDroppedHandshake(msg, nil, err.Error())
-		return false
+		return false, err
 	}
 
 	resp, ok := x.applyHandshake(handshake, msg.Pipe)
 	if !ok {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
 		x.traceDroppedHandshake(msg, handshake, "failed to apply")
-		return false
+		return false, nil
 	}
 
 	x.lastRemoteSeq = handshake.At()
@@ -972,5 +1382,5 @@ func (x *Exchange) receivedHandshake(msg message) bool {
 	}
 
 	x.traceReceivedHandshake(msg, handshake)
-	return true
+	return true, nil
 }
@@ -16,7 +16,11 @@ const (
 )
 
 type addressBook struct {
-	log *logs.Logger
+	log    *logs.Logger
+	family int // the preferred address family (4 or 6) or 0
+
+	// onChange is called (with mtx held) when the active path changed
+	onChange func(from, to net.Addr)
 
 	mtx         sync.RWMutex
 	active      *addressBookEntry
@@ -40,10 +44,13 @@ type addressBookEntry struct {
 
 	latency time.Duration
 	ewma    time.Duration
+
+	mtu         int       // the discovered MTU or 0 (see PathMTUDiscovery)
+	mtuProbedAt time.Time // when the last MTU probe of the path started
 }
 
-func newAddressBook(log *logs.Logger) *addressBook {
-	return &addressBook{log: log.Module("addrbook")}
+func newAddressBook(log *logs.Logger, family int) *addressBook {
+	return &addressBook{log: log.Module("addrbook"), family: family}
 }
 
 func (book *addressBook) ActiveConnection() *Pipe {
@@ -106,7 +113,7 @@ func (book *addressBook) NextHandshakeEpoch() {
 	)
 
 	if len(book.known) == 0 {
-		book.active = nil
+		book.changeActive(nil)
 		return
 	}
 
@@ -145,7 +152,7 @@ func (book *addressBook) NextHandshakeEpoch() {
 	}
 
 	// sort by state and latency
-	sort.Sort(sortedAddressBookEntries(book.known))
+	sort.Sort(&sortedAddressBookEntries{book.known, book.family})
 
 	// trim
 	if len(book.known) > cMaxAddressBookEntries {
@@ -153,14 +160,10 @@ func (book *addressBook) NextHandshakeEpoch() {
 	}
 
 	// update active
-	var oldActive = book.active
 	if book.known[0].Reachable {
-		book.active = book.known[0]
+		book.changeActive(book.known[0])
 	} else {
-		book.active = nil
-	}
-	if book.active != oldActive {
-		book.log.Printf("\x1B[32mChanged path\x1B[0m from %s to %s", oldActive, book.active)
+		book.changeActive(nil)
 	}
 
 	// update fallbacks
@@ -196,6 +199,11 @@ func (book *addressBook) AddPipe(p *Pipe) {
 	book.mtx.Lock()
 	defer book.mtx.Unlock()
 
+	book.addPipe(p)
+}
+
+// addPipe adds p to the known pipes; book.mtx must be held.
+func (book *addressBook) addPipe(p *Pipe) {
 	var (
 		now = time.Now()
 		idx = book.indexOfPipe(p)
@@ -216,9 +224,38 @@ func (book *addressBook) AddPipe(p *Pipe) {
 	book.known = append(book.known, e)
 	book.log.Printf("\x1B[32mDiscovered path\x1B[0m %s (latency=\x1B[33m%s\x1B[0m, emwa=\x1B[33m%s\x1B[0m)", e, e.latency, e.ewma)
 
-	if book.active == nil {
-		book.active = e
-		book.log.Printf("\x1B[32mChanged path\x1B[0m from %s to %s", (*addressBookEntry)(nil), book.active)
+	if book.active == nil || book.active.Pipe.disconnected() {
+		// a peer which lost the connection of the active path reconnects
+		// over a new one
+		book.changeActive(e)
+	}
+}
+
+// MakeActive makes pipe the active path.
+func (book *addressBook) MakeActive(pipe *Pipe) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	idx := book.indexOfPipe(pipe)
+	if idx < 0 || book.active == book.known[idx] {
+		return
+	}
+
+	book.changeActive(book.known[idx])
+}
+
+// changeActive makes e the active path; book.mtx must be held.
+func (book *addressBook) changeActive(e *addressBookEntry) {
+	old := book.active
+	if e == old {
+		return
+	}
+
+	book.active = e
+	book.log.Printf("\x1B[32mChanged path\x1B[0m from %s to %s", old, e)
+
+	if book.onChange != nil {
+		book.onChange(old.addr(), e.addr())
 	}
 }
 
@@ -248,7 +285,7 @@ func (book *addressBook) ReceivedHandshake(p *Pipe) {
 	)
 
 	if idx < 0 {
-		book.AddPipe(p)
+		book.addPipe(p)
 		return
 	}
 
@@ -276,6 +313,14 @@ func (book *addressBook) indexOfPipe(pipe *Pipe) int {
 	return -1
 }
 
+// addr returns the address of the entry, or nil for a nil entry.
+func (a *addressBookEntry) addr() net.Addr {
+	if a == nil {
+		return nil
+	}
+	return a.Address
+}
+
 func (a *addressBookEntry) String() string {
 	if a == nil {
 		return "<nil>"
@@ -293,18 +338,25 @@ func (a *addressBookEntry) InitSamples() {
 	a.ewma = 125 * time.Millisecond
 }
 
-type sortedAddressBookEntries []*addressBookEntry
+type sortedAddressBookEntries struct {
+	entries []*addressBookEntry
+	family  int
+}
+
+func (s *sortedAddressBookEntries) Len() int { return len(s.entries) }
+func (s *sortedAddressBookEntries) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+func (s *sortedAddressBookEntries) Less(i, j int) bool {
+	a, b := s.entries[i], s.entries[j]
 
-func (s sortedAddressBookEntries) Len() int      { return len(s) }
-func (s sortedAddressBookEntries) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
-func (s sortedAddressBookEntries) Less(i, j int) bool {
-	if s[i].Reachable && !s[j].Reachable {
+	if a.Reachable && !b.Reachable {
 		return true
 	}
 
-	if !s[i].Reachable && s[j].Reachable {
+	if !a.Reachable && b.Reachable {
 		return false
 	}
 
-	return s[i].ewma < s[j].ewma
+	return pathCost(a, s.family) < pathCost(b, s.family)
 }
@@ -0,0 +1,35 @@
+package e3x
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+func TestReceivedHandshakeFromUnknownPipe(t *testing.T) {
+	var (
+		book = newAddressBook(logs.Module("test"), AnyAddressFamily.pathFamily())
+		p    = &Pipe{raddr: &familyAddr{nil, net.ParseIP("192.0.2.1")}}
+		done = make(chan struct{})
+	)
+
+	// a handshake through a punched hole arrives on a pipe the book doesn't
+	// know yet
+	go func() {
+		book.ReceivedHandshake(p)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ReceivedHandshake deadlocked")
+	}
+
+	assert.Equal(t, []*Pipe{p}, book.KnownPipes())
+	assert.Equal(t, p, book.ActiveConnection())
+}
@@ -0,0 +1,150 @@
+package e3x
+
+import (
+	"net"
+	"time"
+)
+
+// AddressFamilyPolicy determines the order in which the addresses of a peer
+// which advertises both IPv4 and IPv6 addresses are tried when an exchange is
+// dialed.
+type AddressFamilyPolicy int
+
+const (
+	// AnyAddressFamily sends the initial handshakes to all addresses at once
+	// (this is the default).
+	AnyAddressFamily AddressFamilyPolicy = iota
+
+	// PreferIPv4 sends the initial handshakes to the IPv4 addresses first.
+	// The IPv6 addresses are tried when no IPv4 address responded within 2s.
+	PreferIPv4
+
+	// PreferIPv6 sends the initial handshakes to the IPv6 addresses first.
+	// The IPv4 addresses are tried when no IPv6 address responded within 2s.
+	PreferIPv6
+
+	// HappyEyeballs races the address families: the IPv6 addresses are tried
+	// first and the IPv4 addresses 250ms later. The address which responded
+	// first is used.
+	HappyEyeballs
+)
+
+const (
+	familyFallbackDelay = 2 * time.Second
+	happyEyeballsDelay  = 250 * time.Millisecond
+
+	// familyPathPenalty is the factor the latency of a path of the other
+	// address family is multiplied with when paths are ranked.
+	familyPathPenalty = 1.25
+)
+
+// AddressFamilyPreference sets the policy used to order the addresses of a
+// peer when an exchange is dialed. Addresses without an IP address (like
+// bridged paths) are always tried first.
+//
+// The policy also applies once the exchange is open: a working path of the
+// preferred family stays the active path unless a path of the other family is
+// at least 25% faster. HappyEyeballs prefers IPv6 paths.
+func AddressFamilyPreference(policy AddressFamilyPolicy) EndpointOption {
+	return func(e *Endpoint) error {
+		e.addrPolicy = policy
+		return nil
+	}
+}
+
+// preferredFamily returns the address family (4 or 6) which is tried first and
+// the delay after which the other family is tried.
+func (policy AddressFamilyPolicy) preferredFamily() (family int, delay time.Duration) {
+	switch policy {
+	case PreferIPv4:
+		return 4, familyFallbackDelay
+	case PreferIPv6:
+		return 6, familyFallbackDelay
+	case HappyEyeballs:
+		return 6, happyEyeballsDelay
+	default:
+		return 0, 0
+	}
+}
+
+// pathFamily returns the address family (4 or 6) which is preferred when the
+// paths of an exchange are ranked, or 0 when there is no preference.
+func (policy AddressFamilyPolicy) pathFamily() int {
+	family, _ := policy.preferredFamily()
+	return family
+}
+
+// pathCost returns the latency of e used to rank the paths of an exchange.
+// Paths of the address family other than family are penalized.
+func pathCost(e *addressBookEntry, family int) time.Duration {
+	if f := addressFamily(e.Address); family != 0 && f != 0 && f != family {
+		return time.Duration(float64(e.ewma) * familyPathPenalty)
+	}
+	return e.ewma
+}
+
+// addressFamily returns 4 or 6 for IP addresses and 0 for all other addresses.
+func addressFamily(addr net.Addr) int {
+	var ip net.IP
+
+	switch a := addr.(type) {
+	case interface {
+		GetIP() net.IP
+	}:
+		ip = a.GetIP()
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	}
+
+	switch {
+	case ip == nil:
+		return 0
+	case ip.To4() != nil:
+		return 4
+	default:
+		return 6
+	}
+}
+
+// deliverInitialHandshake sends the first handshakes of a dial according to
+// the address family policy. The handshakes to the other family are sent after
+// the delay of the policy, unless the exchange was opened before.
+func (x *Exchange) deliverInitialHandshake() error {
+	family, delay := x.addrPolicy.preferredFamily()
+	if family == 0 {
+		return x.deliverHandshake()
+	}
+
+	x.addressBook.NextHandshakeEpoch()
+
+	var first, rest []*Pipe
+	for _, pipe := range x.addressBook.HandshakePipes() {
+		if f := addressFamily(pipe.RemoteAddr()); f == 0 || f == family {
+			first = append(first, pipe)
+		} else {
+			rest = append(rest, pipe)
+		}
+	}
+	if len(first) == 0 {
+		first, rest = rest, nil
+	}
+
+	if err := x.deliverHandshakeTo(first); err != nil {
+		return err
+	}
+
+	if len(rest) > 0 {
+		time.AfterFunc(delay, func() {
+			x.mtx.Lock()
+			defer x.mtx.Unlock()
+
+			if x.state == ExchangeDialing {
+				x.deliverHandshakeTo(rest)
+			}
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package e3x
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+	"github.com/telehash/gogotelehash/transports/mux"
+)
+
+func TestAddressFamilyPreference(t *testing.T) {
+	logs.ResetLogger()
+
+	for _, test := range []struct {
+		policy    AddressFamilyPolicy
+		delays    map[int]time.Duration
+		attempted []int
+		active    int
+	}{
+		// only the preferred family is attempted when it responds
+		{PreferIPv4, nil, []int{4}, 4},
+		{PreferIPv6, nil, []int{6}, 6},
+		// the other family is attempted when the preferred family doesn't respond
+		{PreferIPv6, map[int]time.Duration{6: 10 * time.Second}, []int{6, 4}, 4},
+		// racing picks the faster path
+		{HappyEyeballs, map[int]time.Duration{6: time.Second}, []int{6, 4}, 4},
+		{HappyEyeballs, map[int]time.Duration{6: 500 * time.Millisecond, 4: time.Second}, []int{6, 4}, 6},
+		{HappyEyeballs, nil, []int{6}, 6},
+		// all families at once
+		{AnyAddressFamily, map[int]time.Duration{4: time.Second}, []int{4, 6}, 6},
+	} {
+		assert := assert.New(t)
+		config := &familyConfig{Config: inproc.Config{}, delays: test.delays}
+
+		A, err := Open(Transport(config), Log(nil), AddressFamilyPreference(test.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := Open(Transport(mux.Config{inproc.Config{}, inproc.Config{}}), Log(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ident, err := B.LocalIdentity()
+		assert.NoError(err)
+		addrs := ident.Addresses()
+		if !assert.Len(addrs, 2) {
+			return
+		}
+
+		// B advertises an IPv4 and an IPv6 address
+		ident = ident.withPaths([]net.Addr{
+			&familyAddr{addrs[0], net.ParseIP("192.0.2.1")},
+			&familyAddr{addrs[1], net.ParseIP("2001:db8::1")},
+		})
+
+		x, err := A.Dial(ident)
+		if assert.NoError(err, "policy %d", test.policy) {
+			assert.Equal(fmt.Sprint(test.attempted), fmt.Sprint(config.attempted()), "policy %d", test.policy)
+			assert.Equal(test.active, addressFamily(x.ActivePath()), "policy %d", test.policy)
+		}
+
+		A.Close()
+		B.Close()
+	}
+}
+
+func TestPathFamilyRanking(t *testing.T) {
+	var (
+		v4 = &familyAddr{nil, net.ParseIP("192.0.2.1")}
+		v6 = &familyAddr{nil, net.ParseIP("2001:db8::1")}
+	)
+
+	for _, test := range []struct {
+		policy     AddressFamilyPolicy
+		v4, v6     time.Duration
+		v6Broken   bool
+		activeIPv6 bool
+	}{
+		// without a preference the fastest path wins
+		{AnyAddressFamily, 100 * time.Millisecond, 110 * time.Millisecond, false, false},
+		// a slightly slower path of the preferred family wins
+		{PreferIPv6, 100 * time.Millisecond, 110 * time.Millisecond, false, true},
+		{HappyEyeballs, 100 * time.Millisecond, 110 * time.Millisecond, false, true},
+		{PreferIPv4, 110 * time.Millisecond, 100 * time.Millisecond, false, false},
+		// a much faster path of the other family wins
+		{PreferIPv6, 100 * time.Millisecond, 200 * time.Millisecond, false, false},
+		// broken paths never win
+		{PreferIPv6, 100 * time.Millisecond, 10 * time.Millisecond, true, false},
+	} {
+		book := newAddressBook(logs.Module("test"), test.policy.pathFamily())
+		book.known = []*addressBookEntry{
+			{Address: v4, Reachable: true, ewma: test.v4, ExpireAt: time.Now().Add(time.Minute)},
+			{Address: v6, Reachable: !test.v6Broken, ewma: test.v6, ExpireAt: time.Now().Add(time.Minute)},
+		}
+		book.NextHandshakeEpoch()
+
+		if assert.NotNil(t, book.active, "policy %d", test.policy) {
+			assert.Equal(t, test.activeIPv6, book.active.Address == v6, "policy %d v4=%s v6=%s", test.policy, test.v4, test.v6)
+		}
+	}
+}
+
+// familyAddr gives an inproc address an IP address (and family).
+type familyAddr struct {
+	net.Addr
+	ip net.IP
+}
+
+func (a *familyAddr) GetIP() net.IP {
+	return a.ip
+}
+
+func (a *familyAddr) String() string {
+	return a.ip.String() + "/" + a.Addr.String()
+}
+
+// familyConfig records the address families handshakes are sent to and delays
+// the packets sent to a family.
+type familyConfig struct {
+	transports.Config
+	delays map[int]time.Duration
+
+	mtx      sync.Mutex
+	families []int
+}
+
+func (c *familyConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &familyTransport{t, c}, nil
+}
+
+// attempted returns the families in the order they were first written to.
+func (c *familyConfig) attempted() []int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]int(nil), c.families...)
+}
+
+func (c *familyConfig) wrote(family int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, f := range c.families {
+		if f == family {
+			return
+		}
+	}
+	c.families = append(c.families, family)
+}
+
+type familyTransport struct {
+	transports.Transport
+	config *familyConfig
+}
+
+func (t *familyTransport) Dial(addr net.Addr) (net.Conn, error) {
+	a, ok := addr.(*familyAddr)
+	if !ok {
+		return t.Transport.Dial(addr)
+	}
+
+	conn, err := t.Transport.Dial(a.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &familyConn{conn, a, t.config}, nil
+}
+
+type familyConn struct {
+	net.Conn
+	addr   *familyAddr
+	config *familyConfig
+}
+
+func (c *familyConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *familyConn) Write(b []byte) (int, error) {
+	family := addressFamily(c.addr)
+	c.config.wrote(family)
+
+	delay := c.config.delays[family]
+	if delay <= 0 {
+		return c.Conn.Write(b)
+	}
+
+	b = append([]byte(nil), b...)
+	time.AfterFunc(delay, func() { c.Conn.Write(b) })
+	return len(b), nil
+}
@@ -0,0 +1,56 @@
+package e3x
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTooManyChannels is returned by Open when the exchange already has the
+// maximum number of channels (see MaxChannels).
+var ErrTooManyChannels = errors.New("e3x: too many channels")
+
+// MaxChannels caps the number of channels an exchange of the endpoint has at
+// once; lingering channels (see ChannelLinger) count as well. Once the cap is
+// reached Open returns ErrTooManyChannels and channels opened by the peer are
+// dropped. The internal channels of the endpoint are exempt. A limit of zero
+// (or less) removes the cap.
+func MaxChannels(n int) EndpointOption {
+	return func(e *Endpoint) error {
+		e.maxChannels = n
+		return nil
+	}
+}
+
+// ChannelKeepalive sets the keepalive interval (see Channel.SetKeepalive) of
+// every channel of the endpoint, including those opened by peers. By default
+// no keepalives are sent.
+func ChannelKeepalive(interval time.Duration) EndpointOption {
+	return func(e *Endpoint) error {
+		e.channelKeepalive = interval
+		return nil
+	}
+}
+
+// internalChannelTypes are the channel types used by the endpoint itself; they
+// don't count towards MaxChannels.
+var internalChannelTypes = []string{capsChannelType, mtuChannelType}
+
+// ChannelCount returns the number of channels of x, leaving out the internal
+// channels of the endpoint and those of the types in skip.
+func (x *Exchange) ChannelCount(skip ...string) int {
+	return x.channels.Count(append(skip[:len(skip):len(skip)], internalChannelTypes...)...)
+}
+
+// tooManyChannels returns true when a channel of type typ can't be added to the
+// n (non-internal) channels of x.
+func (x *Exchange) tooManyChannels(typ string, n int) bool {
+	if x.maxChannels <= 0 || n < x.maxChannels {
+		return false
+	}
+	for _, internal := range internalChannelTypes {
+		if typ == internal {
+			return false
+		}
+	}
+	return true
+}
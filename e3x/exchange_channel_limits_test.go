@@ -0,0 +1,103 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestMaxChannels(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), MaxChannels(1), ChannelKeepalive(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	accepted := make(chan *Channel, 2)
+	for _, e := range []*Endpoint{A, B} {
+		l := e.Listen("test", true)
+		defer l.Close()
+		go func(l *Listener) {
+			for {
+				c, err := l.AcceptChannel()
+				if err != nil {
+					return
+				}
+				accepted <- c
+			}
+		}(l)
+	}
+
+	b, err := B.LocalIdentity()
+	assert.NoError(err)
+	a, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := A.Open(b, "test", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+	c.mtx.Lock()
+	assert.Equal(time.Second, c.keepaliveInterval)
+	c.mtx.Unlock()
+
+	_, err = A.Open(b, "test", true)
+	assert.Equal(ErrTooManyChannels, err)
+
+	// channels opened by the peer are dropped as well
+	d, err := B.Open(a, "test", true)
+	if assert.NoError(err) {
+		defer d.Kill()
+		assert.NoError(d.WritePacket(lob.New([]byte("hello"))))
+		select {
+		case c := <-accepted:
+			t.Fatalf("accepted a channel beyond the limit: %d", c.id)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	// the slot is freed when the channel is closed
+	c.Kill()
+	c, err = A.Open(b, "test", true)
+	if assert.NoError(err) {
+		c.Kill()
+	}
+}
+
+func TestCipherSets(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), CipherSets(0x3a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	ident, err := A.LocalIdentity()
+	if assert.NoError(err) {
+		keys := ident.Keys()
+		assert.Len(keys, 1)
+		assert.NotNil(keys[0x3a])
+	}
+
+	_, err = Open(Transport(inproc.Config{}), Log(nil), CipherSets(0xff))
+	assert.Equal(cipherset.ErrUnknownCSID, err)
+}
@@ -0,0 +1,77 @@
+package e3x
+
+import (
+	"context"
+	"sync"
+)
+
+// dialLimiter limits the number of exchanges of an endpoint which are dialing
+// (sending their initial handshakes) at once. Dials beyond the limit wait
+// for a slot in the order they arrived.
+type dialLimiter struct {
+	mtx     sync.Mutex
+	cnd     *sync.Cond
+	max     int
+	pending int
+	peak    int
+}
+
+func newDialLimiter(max int) *dialLimiter {
+	l := &dialLimiter{max: max}
+	l.cnd = sync.NewCond(&l.mtx)
+	return l
+}
+
+// acquire blocks until a dial slot is available.
+func (l *dialLimiter) acquire() {
+	l.acquireContext(context.Background())
+}
+
+// acquireContext blocks until a dial slot is available or ctx is done (in
+// which case ctx.Err() is returned and no slot was taken).
+func (l *dialLimiter) acquireContext(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.pending >= l.max {
+		defer wakeOnDone(ctx, &l.mtx, l.cnd)()
+	}
+	for l.pending >= l.max {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cnd.Wait()
+	}
+
+	l.pending++
+	if l.pending > l.peak {
+		l.peak = l.pending
+	}
+	return nil
+}
+
+func (l *dialLimiter) release() {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	l.pending--
+	l.mtx.Unlock()
+	l.cnd.Signal()
+}
+
+// peakPending returns the largest number of dials which were pending at once.
+func (l *dialLimiter) peakPending() int {
+	if l == nil {
+		return 0
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.peak
+}
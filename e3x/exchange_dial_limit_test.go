@@ -0,0 +1,59 @@
+package e3x
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestMaxPendingHandshakes(t *testing.T) {
+	logs.ResetLogger()
+
+	const (
+		limit = 2
+		peers = 6
+	)
+
+	var (
+		assert = assert.New(t)
+		wg     sync.WaitGroup
+	)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), MaxPendingHandshakes(limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	var idents []*Identity
+	for i := 0; i < peers; i++ {
+		// slow peers keep the handshakes of A pending for a while
+		B, err := Open(Transport(&delayConfig{inproc.Config{}, 50 * time.Millisecond}), Log(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer B.Close()
+
+		ident, err := B.LocalIdentity()
+		assert.NoError(err)
+		idents = append(idents, ident)
+	}
+
+	for _, ident := range idents {
+		wg.Add(1)
+		go func(ident *Identity) {
+			defer wg.Done()
+			_, err := A.Dial(ident)
+			assert.NoError(err)
+		}(ident)
+	}
+	wg.Wait()
+
+	assert.Equal(limit, A.dialLimiter.peakPending())
+	assert.Len(A.GetExchanges(), peers)
+}
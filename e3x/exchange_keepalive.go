@@ -0,0 +1,142 @@
+package e3x
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+const (
+	cDefaultLineIdleTimeout = 2 * time.Minute
+
+	// cMaxDormantLines is the number of idle expired lines the endpoint
+	// remembers (see LineIdleTimeout).
+	cMaxDormantLines = 256
+)
+
+// ErrKeepAlive is returned by an OnIdle hook to keep an idle exchange open
+// (see LineIdleTimeout).
+var ErrKeepAlive = errors.New("e3x: keep the line alive")
+
+// LineKeepalive makes every open exchange of the endpoint send a keepalive when
+// nothing was sent on its line for interval. Keepalives keep the NAT mappings
+// and the firewall state of the active path alive between handshakes. A
+// keepalive is an encrypted packet without headers or body, which the peer
+// ignores. By default no keepalives are sent.
+func LineKeepalive(interval time.Duration) EndpointOption {
+	return func(e *Endpoint) error {
+		e.lineKeepalive = interval
+		return nil
+	}
+}
+
+// LineIdleTimeout sets how long an exchange without channels stays open; the
+// default is 2 minutes. When the timeout expires the OnIdle hooks of the
+// exchange are called; the exchange is closed unless one of them returns
+// ErrKeepAlive (which restarts the timeout). The endpoint remembers the
+// identity and the paths of the peer, so dialing HashnameIdentifier(hn)
+// re-opens the line when there is traffic again.
+func LineIdleTimeout(d time.Duration) EndpointOption {
+	return func(e *Endpoint) error {
+		e.lineIdleTimeout = d
+		return nil
+	}
+}
+
+func (x *Exchange) lineIdleTimeout() time.Duration {
+	if x.idleTimeout <= 0 {
+		return cDefaultLineIdleTimeout
+	}
+	return x.idleTimeout
+}
+
+// keepIdle returns true when an OnIdle hook wants to keep x open.
+func (x *Exchange) keepIdle() bool {
+	x.mtx.Lock()
+	open := x.state.IsOpen()
+	x.mtx.Unlock()
+
+	if !open || x.exchangeHooks.Idle() != ErrKeepAlive {
+		return false
+	}
+
+	x.mtx.Lock()
+	x.resetExpire()
+	x.mtx.Unlock()
+	return true
+}
+
+// startKeepalive starts sending keepalives; x.mtx must be held.
+func (x *Exchange) startKeepalive() {
+	if x.lineKeepalive > 0 {
+		x.tKeepalive.Reset(x.lineKeepalive)
+	}
+}
+
+func (x *Exchange) onKeepalive() {
+	x.mtx.Lock()
+	if !x.state.IsOpen() {
+		x.mtx.Unlock()
+		return
+	}
+	next := x.lineKeepalive - time.Since(x.lastSent)
+	x.mtx.Unlock()
+
+	if next <= 0 {
+		if x.addressBook.ActiveConnection() != nil {
+			x.deliverPacket(&lob.Packet{}, nil)
+		}
+		next = x.lineKeepalive
+	}
+
+	x.tKeepalive.Reset(next)
+}
+
+// isLineKeepalive returns true when pkt is a keepalive (see LineKeepalive).
+func isLineKeepalive(pkt *lob.Packet) bool {
+	hdr := pkt.Header()
+	return pkt.BodyLen() == 0 && hdr.IsZero()
+}
+
+// rememberIdleLine remembers the identity of the peer of an exchange which
+// expired while idle, so it can be dialed by its hashname.
+func (e *Endpoint) rememberIdleLine(_ *Endpoint, x *Exchange, reason error) error {
+	if reason != nil || atomic.LoadUint32(&x.idleExpired) == 0 || x.remoteIdent == nil {
+		return nil
+	}
+
+	ident := x.RemoteIdentity()
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if len(e.dormant) >= cMaxDormantLines {
+		for hn := range e.dormant {
+			delete(e.dormant, hn)
+			break
+		}
+	}
+	e.dormant[ident.Hashname()] = ident
+	return nil
+}
+
+func (e *Endpoint) forgetIdleLine(_ *Endpoint, x *Exchange) error {
+	hn := x.RemoteHashname()
+
+	e.mtx.Lock()
+	delete(e.dormant, hn)
+	e.mtx.Unlock()
+	return nil
+}
+
+// dormantIdentity returns the identity of the peer with hashname hn when its
+// line expired while idle.
+func (e *Endpoint) dormantIdentity(hn hashname.H) *Identity {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return e.dormant[hn]
+}
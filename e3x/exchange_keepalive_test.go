@@ -0,0 +1,118 @@
+package e3x
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestLineKeepalive(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), LineKeepalive(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var dropped uint32
+	B.DefaultExchangeHooks().Register(ExchangeHook{
+		OnDropPacket: func(*Endpoint, *Exchange, []byte, *Pipe, error) error {
+			atomic.AddUint32(&dropped, 1)
+			return nil
+		},
+	})
+
+	b, err := B.LocalIdentity()
+	assert.NoError(err)
+	_, err = A.Dial(b)
+	assert.NoError(err)
+
+	// wait for the caps exchange
+	time.Sleep(100 * time.Millisecond)
+
+	_, rcvd := B.Traffic()
+	time.Sleep(300 * time.Millisecond)
+	_, rcvd2 := B.Traffic()
+
+	assert.True(rcvd2 > rcvd, "no keepalives were received")
+	assert.Equal(uint32(0), atomic.LoadUint32(&dropped))
+}
+
+func TestLineIdleTimeout(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), LineIdleTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	b, err := B.LocalIdentity()
+	assert.NoError(err)
+	x, err := A.Dial(b)
+	assert.NoError(err)
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(ExchangeExpired, x.State())
+	assert.Nil(A.GetExchange(b.Hashname()))
+
+	// the line is re-opened by hashname
+	x, err = A.Dial(HashnameIdentifier(b.Hashname()))
+	if assert.NoError(err) {
+		assert.True(x.State().IsOpen())
+	}
+}
+
+func TestLineIdleHook(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil), LineIdleTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var idle uint32
+	A.DefaultExchangeHooks().Register(ExchangeHook{
+		OnIdle: func(*Endpoint, *Exchange) error {
+			atomic.AddUint32(&idle, 1)
+			return ErrKeepAlive
+		},
+	})
+
+	b, err := B.LocalIdentity()
+	assert.NoError(err)
+	x, err := A.Dial(b)
+	assert.NoError(err)
+
+	time.Sleep(350 * time.Millisecond)
+	assert.True(x.State().IsOpen())
+	assert.True(atomic.LoadUint32(&idle) >= 2)
+}
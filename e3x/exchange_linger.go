@@ -0,0 +1,37 @@
+package e3x
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidChannelLinger is returned by ChannelLinger when d is negative.
+var ErrInvalidChannelLinger = errors.New("e3x: invalid channel linger")
+
+// ChannelLinger keeps closed channels registered with their exchange for d
+// (like the TIME_WAIT state of TCP). Packets which arrive late, like the ack of
+// the final packet, are still handed to the channel instead of being dropped
+// for an unknown channel id. Channels which were closed with an error only
+// process acks. By default closed channels are dropped immediately.
+func ChannelLinger(d time.Duration) EndpointOption {
+	return func(e *Endpoint) error {
+		if d < 0 {
+			return ErrInvalidChannelLinger
+		}
+		e.channelLinger = d
+		return nil
+	}
+}
+
+// linger marks c as closed but still registered. It returns false when c was
+// already lingering.
+func (c *Channel) linger() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.lingering {
+		return false
+	}
+	c.lingering = true
+	return true
+}
@@ -0,0 +1,85 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestChannelLinger(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	_, err := Open(Transport(inproc.Config{}), Log(nil), ChannelLinger(-time.Second))
+	assert.Equal(ErrInvalidChannelLinger, err)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil), ChannelLinger(500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var (
+		l        = B.Listen("linger", true)
+		accepted = make(chan *Channel, 1)
+	)
+	defer l.Close()
+	go func() {
+		c, err := l.AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		_, err = c.ReadPacket()
+		assert.NoError(err)
+
+		// the error is sent in a final packet; the channel is closed at once
+		assert.NoError(c.ErrorWith(&RemoteError{Code: "failed", Message: "failed"}, nil, nil))
+		accepted <- c
+	}()
+
+	ident, err := B.LocalIdentity()
+	assert.NoError(err)
+	c, err := A.Open(ident, "linger", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	// reading the error acks the final packet
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.ReadPacket()
+	assert.IsType(&RemoteError{}, err)
+
+	srv := <-accepted
+	x := B.GetExchange(A.LocalHashname())
+	if !assert.NotNil(x) {
+		return
+	}
+
+	// the trailing ack reaches the closed channel
+	acked := false
+	for i := 0; i < 100 && !acked; i++ {
+		acked = len(srv.DebugDump().WriteBuffer) == 0
+		if !acked {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	assert.True(acked, "the final packet is acked")
+	assert.True(x.channels.Get(srv.id) == srv, "the channel lingers")
+
+	// the channel is dropped after the linger period
+	time.Sleep(600 * time.Millisecond)
+	assert.Nil(x.channels.Get(srv.id))
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/internal/util/tracer"
@@ -18,6 +19,8 @@ type Pipe struct {
 	transport transports.Transport
 	raddr     net.Addr
 	conn      net.Conn
+	connected bool  // the pipe had a connection before
+	lost      int32 // atomic; set from losing a connection until the next dial
 }
 
 type message struct {
@@ -30,6 +33,10 @@ type message struct {
 type pipeDelegate interface {
 	received(msg message)
 	dialDialerAddr(dialerAddr) (net.Conn, error)
+
+	// reconnected is called after p dialed a new connection to replace one
+	// which was lost.
+	reconnected(p *Pipe)
 }
 
 type dialerAddr interface {
@@ -48,7 +55,7 @@ func newMessage(msg *bufpool.Buffer, p *Pipe) message {
 }
 
 func newPipe(t transports.Transport, conn net.Conn, addr net.Addr, delegate pipeDelegate) *Pipe {
-	p := &Pipe{transport: t, conn: conn, raddr: addr, delegate: delegate}
+	p := &Pipe{transport: t, conn: conn, raddr: addr, delegate: delegate, connected: conn != nil}
 
 	if p.conn == nil && p.raddr == nil {
 		panic("no connection information")
@@ -68,10 +75,11 @@ func newPipe(t transports.Transport, conn net.Conn, addr net.Addr, delegate pipe
 
 func (p *Pipe) dial() (net.Conn, error) {
 	var (
-		conn   net.Conn
-		closed bool
-		dialed bool
-		err    error
+		conn     net.Conn
+		closed   bool
+		dialed   bool
+		redialed bool
+		err      error
 	)
 
 	p.mtx.RLock()
@@ -99,6 +107,9 @@ func (p *Pipe) dial() (net.Conn, error) {
 		if err == nil {
 			p.conn = conn
 			dialed = true
+			redialed = p.connected
+			p.connected = true
+			atomic.StoreInt32(&p.lost, 0)
 		}
 	}
 	conn = p.conn
@@ -110,7 +121,10 @@ func (p *Pipe) dial() (net.Conn, error) {
 
 	if dialed {
 		p.wg.Add(1)
-		go p.reader(p.conn)
+		go p.reader(conn)
+	}
+	if redialed {
+		p.delegate.reconnected(p)
 	}
 
 	return conn, nil
@@ -121,12 +135,31 @@ func (p *Pipe) RemoteAddr() net.Addr {
 }
 
 func (p *Pipe) Write(b *bufpool.Buffer) (int, error) {
-	conn, err := p.dial()
-	if err != nil {
-		return 0, err
+	return p.writePriority(b, 0)
+}
+
+// dropBroken closes conn after writing to it failed with err, so the next write
+// dials the remote address again. It returns false when the pipe is closed or
+// err is a timeout.
+func (p *Pipe) dropBroken(conn net.Conn, err error) bool {
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return false
 	}
 
-	return conn.Write(b.RawBytes())
+	p.mtx.Lock()
+	closed := p.closed
+	if !closed && p.conn == conn {
+		p.conn = nil
+		atomic.StoreInt32(&p.lost, 1)
+	} else {
+		conn = nil
+	}
+	p.mtx.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	return !closed
 }
 
 func (p *Pipe) Close() error {
@@ -157,11 +190,18 @@ func (p *Pipe) Close() error {
 	return err
 }
 
+// disconnected returns true when the pipe lost its connection and didn't dial a
+// new one yet.
+func (p *Pipe) disconnected() bool {
+	return atomic.LoadInt32(&p.lost) != 0
+}
+
 func (p *Pipe) reader(conn net.Conn) {
 	defer func() {
 		p.mtx.Lock()
 		if p.conn == conn {
 			p.conn = nil
+			atomic.StoreInt32(&p.lost, 1)
 		}
 		p.mtx.Unlock()
 
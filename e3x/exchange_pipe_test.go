@@ -0,0 +1,86 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/tcp"
+)
+
+func TestPipeRedialsBrokenTCPConnection(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(tcp.Config{Addr: "127.0.0.1:0"}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(tcp.Config{Addr: "127.0.0.1:0"}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	l := B.Listen("echo", true)
+	go func() {
+		c, err := l.AcceptChannel()
+		if err != nil {
+			return
+		}
+		defer c.Kill()
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			// a reply written before B learned the new connection fails
+			// but is resent
+			c.WritePacket(lob.New(pkt.Body(nil)))
+		}
+	}()
+
+	roundtrip := func(c *Channel, body string) string {
+		if err := c.WritePacket(lob.New([]byte(body))); err != nil {
+			return err.Error()
+		}
+		c.SetReadDeadline(time.Now().Add(10 * time.Second))
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return err.Error()
+		}
+		return string(pkt.Body(nil))
+	}
+
+	ident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := A.Open(ident, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+	assert.Equal("before", roundtrip(c, "before"))
+
+	// B drops the accepted TCP connection
+	x := B.GetExchange(A.LocalHashname())
+	if !assert.NotNil(x) {
+		return
+	}
+	p := x.ActivePipe()
+	p.mtx.RLock()
+	conn := p.conn
+	p.mtx.RUnlock()
+	if !assert.NotNil(conn) {
+		return
+	}
+	assert.NoError(conn.Close())
+
+	// A's pipe dials B again and the channel carries on
+	assert.Equal("after", roundtrip(c, "after"))
+}
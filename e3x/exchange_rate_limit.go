@@ -0,0 +1,251 @@
+package e3x
+
+import (
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// cMaxThrottledPackets is the number of queued packets (see queueThrottled)
+// beyond which the packets of unreliable channels are dropped.
+const cMaxThrottledPackets = cWriteBufferSize
+
+// rateLimiter is a token bucket which limits the number of bytes per second
+// that are written to a peer. Writes which exceed the rate are delayed (not
+// dropped) until enough tokens are available.
+type rateLimiter struct {
+	mtx    sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64 // max tokens
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int) *rateLimiter {
+	burst := float64(bytesPerSec) / 10
+	if burst < bufpool.Size {
+		burst = bufpool.Size
+	}
+
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// newHandshakeLimiter returns a token bucket which allows perSec handshakes
+// per second (with a burst of a tenth of a second).
+func newHandshakeLimiter(perSec int) *rateLimiter {
+	burst := float64(perSec) / 10
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   float64(perSec),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be written.
+func (r *rateLimiter) wait(n int) {
+	if r == nil {
+		return
+	}
+
+	r.mtx.Lock()
+
+	r.refill()
+
+	// reserve the tokens; a negative balance is paid back by sleeping.
+	r.tokens -= float64(n)
+	var d time.Duration
+	if r.tokens < 0 {
+		d = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+
+	r.mtx.Unlock()
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// allow takes n tokens and returns true when they are available. Unlike wait
+// allow never blocks; when not enough tokens are available no tokens are taken.
+func (r *rateLimiter) allow(n int) bool {
+	if r == nil {
+		return true
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.refill()
+
+	if r.tokens < float64(n) {
+		return false
+	}
+	r.tokens -= float64(n)
+	return true
+}
+
+// refill adds the tokens accumulated since the last call. The lock must be
+// held.
+func (r *rateLimiter) refill() {
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+}
+
+// throttledPacket is an encrypted packet waiting for the rate limiter.
+type throttledPacket struct {
+	msg      *bufpool.Buffer
+	pipe     *Pipe
+	priority int
+	bodyLen  int
+}
+
+// isAckOnly returns true when pkt only carries acks (or other channel control
+// headers) and no data.
+func isAckOnly(pkt *lob.Packet) bool {
+	hdr := pkt.Header()
+	return hdr.HasC && !hdr.HasSeq && !hdr.HasType && !hdr.HasEnd && pkt.BodyLen() == 0
+}
+
+// splitAck moves the ack and miss headers of pkt to a new ack-only packet. It
+// returns nil when pkt carries no acks. The channel rewrites the acks of a
+// packet when it is resent.
+func splitAck(pkt *lob.Packet) *lob.Packet {
+	hdr := pkt.Header()
+	if !hdr.HasAck {
+		return nil
+	}
+
+	ack := &lob.Packet{}
+	ahdr := ack.Header()
+	ahdr.C, ahdr.HasC = hdr.C, hdr.HasC
+	ahdr.Ack, ahdr.HasAck = hdr.Ack, true
+	ahdr.Miss, ahdr.HasMiss = hdr.Miss, hdr.HasMiss
+
+	hdr.Ack, hdr.HasAck = 0, false
+	hdr.Miss, hdr.HasMiss = nil, false
+	return ack
+}
+
+// queueThrottled encrypts pkt and queues it behind the packets which wait for
+// the rate limiter. The queue is drained by a writer goroutine so the channels
+// never wait for the limiter. The packets of reliable channels are always
+// queued; their send window bounds the number of queued packets. The packets
+// of unreliable channels are dropped once cMaxThrottledPackets are queued.
+func (x *Exchange) queueThrottled(pkt *lob.Packet, p *Pipe, priority int) error {
+	x.mtx.Lock()
+	full := len(x.throttled) >= cMaxThrottledPackets
+	x.mtx.Unlock()
+	if full && !pkt.Header().HasSeq {
+		return nil // drop
+	}
+
+	msg, err := x.encryptPacket(pkt)
+	if err != nil {
+		return err
+	}
+
+	x.mtx.Lock()
+	x.throttled = append(x.throttled, throttledPacket{msg, p, priority, pkt.BodyLen()})
+	start := !x.draining
+	x.draining = true
+	x.mtx.Unlock()
+
+	if start {
+		go x.drainThrottled()
+	}
+	return nil
+}
+
+// drainThrottled writes the queued packets at the rate of the rate limiter. It
+// returns once the queue is empty; the queued packets are dropped when the
+// exchange is closed.
+func (x *Exchange) drainThrottled() {
+	for {
+		x.mtx.Lock()
+		if len(x.throttled) == 0 || !x.state.IsOpen() {
+			queue := x.throttled
+			x.throttled = nil
+			x.draining = false
+			x.mtx.Unlock()
+
+			for _, q := range queue {
+				q.msg.Free()
+			}
+			return
+		}
+		q := x.throttled[0]
+		x.throttled[0] = throttledPacket{}
+		x.throttled = x.throttled[1:]
+		limiter := x.rateLimiter
+		x.mtx.Unlock()
+
+		limiter.wait(q.msg.Len())
+
+		_, err := q.pipe.writePriority(q.msg, q.priority)
+		q.msg.Free()
+		if err == nil {
+			x.sentPacket(q.bodyLen)
+		}
+	}
+}
+
+// SetRateLimit limits the rate at which packets are sent to the remote peer
+// to bytesPerSec. Packets exceeding the rate are queued; acks are never
+// delayed. A bytesPerSec of zero (or less) removes the limit.
+func (x *Exchange) SetRateLimit(bytesPerSec int) {
+	var r *rateLimiter
+	if bytesPerSec > 0 {
+		r = newRateLimiter(bytesPerSec)
+	}
+
+	x.mtx.Lock()
+	x.rateLimiter = r
+	x.mtx.Unlock()
+}
+
+// SetPeerRateLimit limits the rate at which packets are sent to the peer with
+// hashname hn to bytesPerSec. The limit applies to the current exchange with
+// the peer and to any future exchanges. A bytesPerSec of zero (or less) removes
+// the limit.
+func (e *Endpoint) SetPeerRateLimit(hn hashname.H, bytesPerSec int) {
+	e.mtx.Lock()
+	if bytesPerSec > 0 {
+		if e.peerRateLimits == nil {
+			e.peerRateLimits = make(map[hashname.H]int)
+		}
+		e.peerRateLimits[hn] = bytesPerSec
+	} else {
+		delete(e.peerRateLimits, hn)
+	}
+	x := e.hashnames[hn]
+	e.mtx.Unlock()
+
+	if x != nil {
+		x.SetRateLimit(bytesPerSec)
+	}
+}
+
+// applyPeerRateLimit applies the configured rate limit for hn to x.
+// The endpoint lock must be held.
+func (e *Endpoint) applyPeerRateLimit(hn hashname.H, x *Exchange) {
+	if r, found := e.peerRateLimits[hn]; found {
+		x.SetRateLimit(r)
+	}
+}
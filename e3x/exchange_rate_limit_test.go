@@ -0,0 +1,237 @@
+package e3x
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestPeerRateLimit(t *testing.T) {
+	logs.ResetLogger()
+
+	if testing.Short() {
+		t.Skip("this is a long running test.")
+	}
+
+	const (
+		rate    = 40000
+		pktSize = 1000
+		numPkts = 60
+	)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		A.setOptions(DisableLog())
+		B.setOptions(DisableLog())
+
+		var (
+			assert = assert.New(t)
+			body   = bytes.Repeat([]byte{'x'}, pktSize)
+		)
+
+		A.SetPeerRateLimit(B.LocalHashname(), rate)
+
+		go func() {
+			c, err := A.Listen("flood", true).AcceptChannel()
+			if assert.NoError(err) && assert.NotNil(c) {
+				defer c.Close()
+
+				_, err = c.ReadPacket()
+				assert.NoError(err)
+
+				for i := 0; i < numPkts; i++ {
+					err = c.WritePacket(lob.New(body))
+					assert.NoError(err)
+				}
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "flood", true)
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Close()
+
+		start := time.Now()
+		err = c.WritePacket(lob.New(nil))
+		assert.NoError(err)
+
+		var n int
+		for {
+			pkt, err := c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(err) {
+				break
+			}
+			n += pkt.BodyLen()
+			pkt.Free()
+		}
+		elapsed := time.Since(start)
+
+		assert.Equal(numPkts*pktSize, n)
+
+		// the first burst is sent without delay
+		measured := float64(n-bufferBurst(rate)) / elapsed.Seconds()
+		t.Logf("measured rate: %.0f bytes/sec (limit=%d)", measured, rate)
+		assert.True(measured < rate*1.1, "rate exceeds the limit")
+		assert.True(measured > rate*0.5, "rate is far below the limit")
+	})
+}
+
+func TestThrottledChannelAcks(t *testing.T) {
+	logs.ResetLogger()
+
+	if testing.Short() {
+		t.Skip("this is a long running test.")
+	}
+
+	const (
+		rate    = 2000
+		pktSize = 1000
+		numPkts = 20
+	)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		A.setOptions(DisableLog())
+		B.setOptions(DisableLog())
+
+		var (
+			assert = assert.New(t)
+			body   = bytes.Repeat([]byte{'x'}, pktSize)
+			done   = make(chan struct{})
+		)
+
+		A.SetPeerRateLimit(B.LocalHashname(), rate)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("flood", true).AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+			defer c.Kill()
+
+			// flood the throttled peer while reading its packets
+			go func() {
+				for i := 0; i < numPkts; i++ {
+					if c.WritePacket(lob.New(body)) != nil {
+						return
+					}
+				}
+			}()
+
+			for i := 0; i < numPkts+1; i++ {
+				pkt, err := c.ReadPacket()
+				if !assert.NoError(err) {
+					return
+				}
+				pkt.Free()
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "flood", true)
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+
+		start := time.Now()
+		for i := 0; i < numPkts+1; i++ {
+			if !assert.NoError(c.WritePacket(lob.New([]byte("ping")))) {
+				return
+			}
+		}
+
+		// the flood takes numPkts*pktSize/rate = 10s; the acks must not wait
+		// for it.
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			c.mtx.Lock()
+			unacked := len(c.writeBuffer)
+			c.mtx.Unlock()
+			if unacked == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d packets are still unacked after %s", unacked, time.Since(start))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		<-done
+	})
+}
+
+func bufferBurst(rate int) int {
+	return int(newRateLimiter(rate).burst)
+}
+
+func TestHandshakeRateLimit(t *testing.T) {
+	logs.ResetLogger()
+
+	const (
+		rate     = 50
+		numPkts  = 200
+		duration = time.Second
+	)
+
+	assert := assert.New(t)
+
+	e, err := Open(Transport(inproc.Config{}), Log(nil), HandshakeRateLimit(rate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	var csid uint8
+	for id := range e.keys {
+		csid = id
+	}
+
+	limited := statEndpointRcvHandshakeLimited.Value()
+	for i := 0; i < numPkts; i++ {
+		// a bogus handshake; it is dropped either by the limiter or when it
+		// fails to decrypt.
+		e.accept(&handshakeConn{p: append([]byte{0, 1, csid}, bytes.Repeat([]byte{'x'}, 64)...)})
+		time.Sleep(duration / numPkts)
+	}
+	limited = statEndpointRcvHandshakeLimited.Value() - limited
+
+	accepted := numPkts - int(limited)
+	assert.True(accepted >= rate*8/10, "accepted=%d", accepted)
+	assert.True(accepted <= rate*13/10, "accepted=%d", accepted)
+}
+
+// handshakeConn is a net.Conn which yields a single message.
+type handshakeConn struct {
+	net.Conn
+	p []byte
+}
+
+func (c *handshakeConn) Read(b []byte) (int, error) {
+	if c.p == nil {
+		return 0, io.EOF
+	}
+	n := copy(b, c.p)
+	c.p = nil
+	return n, nil
+}
+
+func (c *handshakeConn) Close() error         { return nil }
+func (c *handshakeConn) RemoteAddr() net.Addr { return nil }
@@ -0,0 +1,195 @@
+package e3x
+
+import (
+	"errors"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+)
+
+// lineGrace is the time the token of a replaced line keeps routing packets to
+// its exchange (see retireToken).
+var lineGrace = 60 * time.Second
+
+// ErrRekeyNotSupported is returned by Rekey when the cipher set of the
+// exchange can't replace its line keys.
+var ErrRekeyNotSupported = errors.New("e3x: cipher set doesn't support rekeying")
+
+// RekeyInterval makes every exchange of the endpoint replace its local line key
+// (see Exchange.Rekey) when the key is older than d. Each end of an exchange
+// rotates its own key on its own schedule. By default line keys are not
+// rotated.
+func RekeyInterval(d time.Duration) EndpointOption {
+	return func(e *Endpoint) error {
+		e.rekeyInterval = d
+		return nil
+	}
+}
+
+// RekeyAfterBytes makes every exchange of the endpoint replace its local line
+// key (see Exchange.Rekey) once n bytes were encrypted with it. The count
+// includes the overhead of the encrypted packets. By default line keys are
+// not rotated.
+func RekeyAfterBytes(n uint64) EndpointOption {
+	return func(e *Endpoint) error {
+		e.rekeyBytes = n
+		return nil
+	}
+}
+
+// Rekey replaces the local line key of the exchange without interrupting its
+// channels.
+//
+// The new key is announced with a handshake (which is retried until the peer
+// responds). The cutover happens in two steps:
+//
+//   - the peer switches to the new line as soon as it applies the handshake;
+//     from then on it encrypts with, and only decrypts, the new line keys.
+//   - the local end switches to the new line when it receives the peer's
+//     response to that handshake (or to a later one). Until then packets are
+//     encrypted with the old line keys.
+//
+// Packets sent on the old line after the peer switched (and packets the peer
+// sent on the new line before the local end switched) are dropped. To recover
+// them both ends resend all the unacknowledged packets of their reliable
+// channels, encrypted with the new line keys, the moment they switch. Reliable
+// channels discard duplicate packets so no packets are lost or delivered twice;
+// packets of unreliable channels sent during the cutover may be lost.
+func (x *Exchange) Rekey() error {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	return x.rekey()
+}
+
+// rekey starts replacing the local line key; x.mtx must be held.
+func (x *Exchange) rekey() error {
+	if !x.state.IsOpen() {
+		return BrokenExchangeError(x.remoteIdent.Hashname())
+	}
+
+	r, ok := x.cipher.(cipherset.Rekeyer)
+	if !ok {
+		return ErrRekeyNotSupported
+	}
+
+	if err := r.Rekey(); err != nil {
+		return err
+	}
+
+	// retry soon when the handshake gets lost
+	x.nextHandshake = 0
+	x.rescheduleHandshake()
+
+	if err := x.deliverHandshake(); err != nil {
+		return err
+	}
+	x.rekeyAt = x.lastLocalSeq
+	x.lineBytes = 0
+	x.scheduleRekey()
+
+	x.log.Printf("rekeying (at=%d)", x.rekeyAt)
+	return nil
+}
+
+// scheduleRekey (re)starts the rekey interval; x.mtx must be held.
+func (x *Exchange) scheduleRekey() {
+	if x.rekeyInterval > 0 {
+		x.tRekey.Reset(x.rekeyInterval)
+	}
+}
+
+// sentOnLine counts n bytes encrypted with the current line key; x.mtx must be
+// held. It returns true when the line key must be replaced.
+func (x *Exchange) sentOnLine(n int) bool {
+	x.lineBytes += uint64(n)
+	return x.rekeyBytes > 0 && x.lineBytes >= x.rekeyBytes && x.rekeyAt == 0
+}
+
+// autoRekey replaces the line key when the rekey interval expired or the byte
+// limit was reached. Rekeys which are still pending are not interrupted.
+func (x *Exchange) autoRekey() {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	if x.rekeyAt != 0 {
+		x.scheduleRekey()
+		return
+	}
+
+	err := x.rekey()
+	switch {
+	case err == ErrRekeyNotSupported:
+		x.rekeyInterval, x.rekeyBytes = 0, 0
+	case err != nil && x.state.IsOpen():
+		x.log.Printf("failed to rekey: %s", err)
+		x.scheduleRekey()
+	}
+}
+
+// cutover switches to the pending line keys once the peer responded to the
+// handshake with seq. It returns true when the line keys were replaced.
+func (x *Exchange) cutover(seq uint32) bool {
+	if x.rekeyAt == 0 || seq < x.rekeyAt {
+		return false
+	}
+
+	x.cipher.(cipherset.Rekeyer).Cutover()
+	x.rekeyAt = 0
+	return true
+}
+
+// retireToken registers the local token of the new line of x and unregisters
+// token, the local token of the line x replaced, once lineGrace passed. Until
+// then the packets which were in flight on the old line still reach x (which
+// drops them).
+func (e *Endpoint) retireToken(x *Exchange, token cipherset.Token) {
+	e.mtx.Lock()
+	if e.hashnames[x.RemoteHashname()] == x || e.isDraining(x) {
+		e.tokens[x.LocalToken()] = x
+	}
+	e.mtx.Unlock()
+
+	time.AfterFunc(lineGrace, func() {
+		e.mtx.Lock()
+		defer e.mtx.Unlock()
+
+		if e.tokens[token] == x && x.LocalToken() != token {
+			delete(e.tokens, token)
+		}
+	})
+}
+
+// resendUnacked resends the unacknowledged packets of all the reliable channels
+// with the current line keys.
+func (x *Exchange) resendUnacked() {
+	for _, c := range x.channels.All() {
+		c.resendUnacked()
+	}
+}
+
+// resendUnacked resends all the packets which were not acknowledged yet.
+func (c *Channel) resendUnacked() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.reliable {
+		return
+	}
+
+	now := time.Now()
+	for seq := c.oAckedSeq + 1; seq <= c.oSeq; seq++ {
+		e := c.writeBuffer[seq]
+		if e == nil {
+			continue
+		}
+
+		c.applyAckHeaders(e.pkt, e.size)
+		e.lastResend = now
+
+		err := c.x.deliverPacket(e.pkt, e.dst)
+		if err == nil {
+			statChannelSndPkt.Add(1)
+		}
+	}
+}
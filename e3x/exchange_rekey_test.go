@@ -0,0 +1,282 @@
+package e3x
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestRekeyKeepsChannelsOpen(t *testing.T) {
+	logs.ResetLogger()
+
+	const n = 300
+
+	var (
+		assert  = assert.New(t)
+		results = make(chan []string, 1)
+	)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	lines := B.Subscribe("line.established")
+
+	go func() {
+		var bodies []string
+		defer func() { results <- bodies }()
+
+		c, err := A.Listen("rekey", true).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+
+		c.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for {
+			pkt, err := c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(err) {
+				return
+			}
+			bodies = append(bodies, string(pkt.Body(nil)))
+
+			// traffic in the other direction
+			assert.NoError(c.WritePacket(lob.New(pkt.Body(nil))))
+		}
+		assert.NoError(c.Close())
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+	oldToken := x.LocalToken()
+
+	c, err := x.Open("rekey", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	echoes := make(chan int, 1)
+	go func() {
+		var count int
+		defer func() { echoes <- count }()
+
+		c.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for count < n {
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			assert.Equal(fmt.Sprintf("packet %d", count), string(pkt.Body(nil)))
+			count++
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		if i == n/3 {
+			assert.NoError(x.Rekey())
+		}
+		assert.NoError(c.WritePacket(lob.New([]byte(fmt.Sprintf("packet %d", i)))))
+	}
+
+	// Close discards unread packets; wait for all the echoes first
+	assert.Equal(n, <-echoes)
+	assert.NoError(c.Close())
+
+	bodies := <-results
+	if assert.Equal(n, len(bodies)) {
+		for i, body := range bodies {
+			assert.Equal(fmt.Sprintf("packet %d", i), body)
+		}
+	}
+
+	assert.NotEqual(oldToken, x.LocalToken(), "the line was replaced")
+	assert.Equal(x.LocalToken(), A.GetExchange(B.LocalHashname()).RemoteToken())
+
+	var rekeyed bool
+	for len(lines.Events()) > 0 {
+		if ev := <-lines.Events(); ev.(LineEstablishedEvent).Rekeyed {
+			rekeyed = true
+		}
+	}
+	assert.True(rekeyed, "the new line is published")
+}
+
+func TestRekeyAfterBytes(t *testing.T) {
+	logs.ResetLogger()
+
+	const n = 100
+
+	var (
+		assert  = assert.New(t)
+		padding = strings.Repeat("x", 100)
+	)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil), RekeyAfterBytes(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	received := make(chan int, 1)
+	go func() {
+		var count int
+		defer func() { received <- count }()
+
+		c, err := A.Listen("rekey", true).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+
+		c.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for count < n {
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			assert.Equal(fmt.Sprintf("packet %d %s", count, padding), string(pkt.Body(nil)))
+			if count == 0 {
+				// answer the open
+				assert.NoError(c.WritePacket(lob.New([]byte("ok"))))
+			}
+			count++
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+	oldToken := x.LocalToken()
+
+	c, err := x.Open("rekey", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	for i := 0; i < n; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte(fmt.Sprintf("packet %d %s", i, padding)))))
+	}
+
+	assert.Equal(n, <-received)
+	assert.NotEqual(oldToken, x.LocalToken(), "the line was replaced")
+}
+
+func TestRekeyInterval(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil), RekeyInterval(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+	oldToken := x.LocalToken()
+
+	time.Sleep(500 * time.Millisecond)
+	assert.NotEqual(oldToken, x.LocalToken(), "the line was replaced")
+	assert.Equal(x.LocalToken(), A.GetExchange(B.LocalHashname()).RemoteToken())
+}
+
+func TestRekeyRetiresTokens(t *testing.T) {
+	logs.ResetLogger()
+
+	oldGrace := lineGrace
+	lineGrace = 200 * time.Millisecond
+	defer func() { lineGrace = oldGrace }()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil), RekeyInterval(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+	oldToken := x.LocalToken()
+
+	tokens := func() (n int, current bool) {
+		B.mtx.Lock()
+		defer B.mtx.Unlock()
+		for token, y := range B.tokens {
+			if y == x {
+				n++
+				current = current || token == x.LocalToken()
+			}
+		}
+		return n, current
+	}
+
+	// the line is replaced about ten times while the old tokens are retired
+	time.Sleep(500 * time.Millisecond)
+	n, current := tokens()
+	assert.NotEqual(oldToken, x.LocalToken(), "the line was replaced")
+	assert.True(current, "the token of the current line is registered")
+	assert.True(n <= 1+2*int(lineGrace/(50*time.Millisecond))+1, "tokens=%d", n)
+
+	// once the rekeys stop only the tokens of the current line remain
+	x.mtx.Lock()
+	x.rekeyInterval = 0
+	x.tRekey.Stop()
+	x.mtx.Unlock()
+
+	time.Sleep(2 * lineGrace)
+	n, current = tokens()
+	assert.True(current, "the token of the current line is registered")
+	assert.True(n <= 2, "tokens=%d", n)
+}
@@ -0,0 +1,74 @@
+package e3x
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// openNonceHeader carries a random nonce in the packet which opens a
+	// channel. The receiving exchange remembers the nonce so a replay of the
+	// open packet can't recreate the channel.
+	openNonceHeader = "open_nonce"
+
+	openNonceLen       = 8 // bytes (before hex encoding)
+	openNonceTTL       = 5 * time.Minute
+	openNonceCacheSize = 1024
+)
+
+// nonceCache remembers the nonces received from a single peer (the nonces of
+// open packets or the ids of SendN copies). Nonces are forgotten after ttl and,
+// once the cache is full, oldest first.
+type nonceCache struct {
+	mtx   sync.Mutex
+	ttl   time.Duration
+	max   int
+	seen  map[string]time.Time
+	order []string
+}
+
+func newNonceCache(ttl time.Duration, max int) *nonceCache {
+	return &nonceCache{
+		ttl:  ttl,
+		max:  max,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// add records nonce and returns false when nonce was already recorded (and
+// was not yet forgotten).
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// forget expired nonces
+	n := 0
+	for n < len(c.order) && now.Sub(c.seen[c.order[n]]) >= c.ttl {
+		delete(c.seen, c.order[n])
+		n++
+	}
+	c.order = c.order[n:]
+
+	if _, found := c.seen[nonce]; found {
+		return false
+	}
+
+	if len(c.order) >= c.max {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	c.seen[nonce] = now
+	c.order = append(c.order, nonce)
+	return true
+}
+
+func newOpenNonce() string {
+	var buf [openNonceLen]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
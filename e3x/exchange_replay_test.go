@@ -0,0 +1,161 @@
+package e3x
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestReplayedOpenIsDropped(t *testing.T) {
+	logs.ResetLogger()
+
+	var (
+		assert   = assert.New(t)
+		capture  = &captureConfig{Config: inproc.Config{}}
+		accepted = make(chan *Channel, 10)
+	)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(capture), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	l := A.Listen("replay", true)
+	go func() {
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := B.Open(ident, "replay", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	s := <-accepted
+	pkt, err := s.ReadPacket()
+	if assert.NoError(err) {
+		assert.Equal("hello", string(pkt.Body(nil)))
+		_, found := pkt.Header().Get(openNonceHeader)
+		assert.False(found, "the nonce is not delivered")
+	}
+	s.Kill()
+	c.Kill()
+
+	// replay everything B sent (including the open packet)
+	capture.replay()
+
+	select {
+	case <-accepted:
+		t.Error("a replayed open packet created a channel")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cache  = newNonceCache(time.Minute, 2)
+		now    = time.Now()
+	)
+
+	assert.True(cache.add("a", now))
+	assert.False(cache.add("a", now))
+	assert.True(cache.add("b", now))
+
+	// the cache is full; "a" is forgotten first
+	assert.True(cache.add("c", now))
+	assert.True(cache.add("a", now))
+	assert.False(cache.add("c", now))
+
+	// nonces are forgotten after the ttl
+	assert.True(cache.add("c", now.Add(time.Minute)))
+}
+
+// captureConfig records the packets written on a transport so they can be
+// replayed.
+type captureConfig struct {
+	transports.Config
+
+	mtx     sync.Mutex
+	written []captured
+}
+
+type captured struct {
+	conn net.Conn
+	data []byte
+}
+
+func (c *captureConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &captureTransport{t, c}, nil
+}
+
+func (c *captureConfig) replay() {
+	c.mtx.Lock()
+	written := c.written
+	c.written = nil
+	c.mtx.Unlock()
+
+	for _, w := range written {
+		w.conn.Write(w.data)
+	}
+}
+
+type captureTransport struct {
+	transports.Transport
+	config *captureConfig
+}
+
+func (t *captureTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{conn, t.config}, nil
+}
+
+func (t *captureTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{conn, t.config}, nil
+}
+
+type captureConn struct {
+	net.Conn
+	config *captureConfig
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	c.config.mtx.Lock()
+	c.config.written = append(c.config.written, captured{c.Conn, append([]byte(nil), b...)})
+	c.config.mtx.Unlock()
+	return c.Conn.Write(b)
+}
@@ -5,14 +5,20 @@ import (
 )
 
 var (
-	statsMap                = expvar.NewMap("e3x")
-	statChannelRcvPkt       *expvar.Int
-	statChannelRcvPktDrop   *expvar.Int
-	statChannelRcvAckInline *expvar.Int
-	statChannelRcvAckAdHoc  *expvar.Int
-	statChannelSndPkt       *expvar.Int
-	statChannelSndAckInline *expvar.Int
-	statChannelSndAckAdHoc  *expvar.Int
+	statsMap                    = expvar.NewMap("e3x")
+	statChannelRcvPkt           *expvar.Int
+	statChannelRcvPktDrop       *expvar.Int
+	statChannelRcvPktOverBudget *expvar.Int
+	statChannelRcvAckInline     *expvar.Int
+	statChannelRcvAckAdHoc      *expvar.Int
+	statChannelSndPkt           *expvar.Int
+	statChannelSndPktResend     *expvar.Int
+	statChannelSndAckInline     *expvar.Int
+	statChannelSndAckAdHoc      *expvar.Int
+
+	statEndpointRcvHandshakeFiltered *expvar.Int
+	statEndpointRcvHandshakeLimited  *expvar.Int
+	statEndpointRcvHandshakeFailed   *expvar.Int
 )
 
 func init() {
@@ -24,17 +30,27 @@ func resetStats() {
 
 	statChannelRcvPkt = new(expvar.Int)
 	statChannelRcvPktDrop = new(expvar.Int)
+	statChannelRcvPktOverBudget = new(expvar.Int)
 	statChannelRcvAckInline = new(expvar.Int)
 	statChannelRcvAckAdHoc = new(expvar.Int)
 	statChannelSndPkt = new(expvar.Int)
+	statChannelSndPktResend = new(expvar.Int)
 	statChannelSndAckInline = new(expvar.Int)
 	statChannelSndAckAdHoc = new(expvar.Int)
+	statEndpointRcvHandshakeFiltered = new(expvar.Int)
+	statEndpointRcvHandshakeLimited = new(expvar.Int)
+	statEndpointRcvHandshakeFailed = new(expvar.Int)
 
 	statsMap.Set("channel.rcv.pkt", statChannelRcvPkt)
 	statsMap.Set("channel.rcv.pkt.drop", statChannelRcvPktDrop)
+	statsMap.Set("channel.rcv.pkt.drop.budget", statChannelRcvPktOverBudget)
 	statsMap.Set("channel.rcv.ack.inline", statChannelRcvAckInline)
 	statsMap.Set("channel.rcv.ack.ad-hoc", statChannelRcvAckAdHoc)
 	statsMap.Set("channel.snd.pkt", statChannelSndPkt)
+	statsMap.Set("channel.snd.pkt.resend", statChannelSndPktResend)
 	statsMap.Set("channel.snd.ack.inline", statChannelSndAckInline)
 	statsMap.Set("channel.snd.ack.ad-hoc", statChannelSndAckAdHoc)
+	statsMap.Set("endpoint.rcv.handshake.filtered", statEndpointRcvHandshakeFiltered)
+	statsMap.Set("endpoint.rcv.handshake.limited", statEndpointRcvHandshakeLimited)
+	statsMap.Set("endpoint.rcv.handshake.failed", statEndpointRcvHandshakeFailed)
 }
@@ -32,6 +32,10 @@ type ExchangeHook struct {
 	OnOpened     func(*Endpoint, *Exchange) error
 	OnClosed     func(*Endpoint, *Exchange, error) error
 	OnDropPacket func(e *Endpoint, x *Exchange, msg []byte, pipe *Pipe, reason error) error
+
+	// OnIdle is called when the line idle timeout of the exchange expired; the
+	// exchange stays open when it returns ErrKeepAlive.
+	OnIdle func(*Endpoint, *Exchange) error
 }
 
 type ChannelHook struct {
@@ -126,6 +130,15 @@ func (s *ExchangeHooks) Closed(reason error) error {
 	})
 }
 
+func (s *ExchangeHooks) Idle() error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnIdle == nil {
+			return nil
+		}
+		return o.OnIdle(s.endpoint, s.exchange)
+	})
+}
+
 func (s *ExchangeHooks) DropPacket(msg []byte, pipe *Pipe, reason error) error {
 	return s.trigger(func(o ExchangeHook) error {
 		if o.OnDropPacket == nil {
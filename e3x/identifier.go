@@ -27,8 +27,12 @@ func HashnameIdentifier(hn hashname.H) Identifier {
 
 func (i hashnameIdentifier) String() string { return string(i) }
 func (i hashnameIdentifier) Identify(endpoint *Endpoint) (*Identity, error) {
-	x := endpoint.hashnames[hashname.H(i)]
+	x := endpoint.GetExchange(hashname.H(i))
 	if x == nil {
+		// the line may have expired while idle
+		if ident := endpoint.dormantIdentity(hashname.H(i)); ident != nil {
+			return ident, nil
+		}
 		return nil, ErrUnidentifiable
 	}
 
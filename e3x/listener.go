@@ -21,6 +21,7 @@ type listenerSet struct {
 	mtx       sync.RWMutex
 	parent    *listenerSet
 	listeners map[string]*Listener
+	fallback  FallbackFunc
 }
 
 var (
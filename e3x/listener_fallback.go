@@ -0,0 +1,37 @@
+package e3x
+
+// FallbackFunc is called (on its own goroutine) for each channel a peer opens
+// when no listener is registered for the channel's type.
+type FallbackFunc func(c *Channel)
+
+// SetFallback replaces the fallback handler of the endpoint. Only the channels
+// which are opened after the swap are passed to f; the channels passed to the
+// previous handler are not affected. When f is nil channels without a listener
+// are dropped (this is the default).
+//
+// Swapping in a handler which kills every channel puts an endpoint in
+// maintenance mode; it can then be drained before it is closed.
+func (e *Endpoint) SetFallback(f FallbackFunc) {
+	e.listenerSet.setFallback(f)
+}
+
+func (set *listenerSet) setFallback(f FallbackFunc) {
+	set.mtx.Lock()
+	set.fallback = f
+	set.mtx.Unlock()
+}
+
+// getFallback returns the fallback handler of the set or of its nearest parent
+// which has one.
+func (set *listenerSet) getFallback() FallbackFunc {
+	for ; set != nil; set = set.parent {
+		set.mtx.RLock()
+		f := set.fallback
+		set.mtx.RUnlock()
+
+		if f != nil {
+			return f
+		}
+	}
+	return nil
+}
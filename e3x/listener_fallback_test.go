@@ -0,0 +1,94 @@
+package e3x
+
+import (
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestSetFallback(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	// echo replies to every packet with its own tag
+	echo := func(tag string) FallbackFunc {
+		return func(c *Channel) {
+			defer c.Kill()
+			for {
+				_, err := c.ReadPacket()
+				if err != nil {
+					return
+				}
+				if c.WritePacket(lob.New([]byte(tag))) != nil {
+					return
+				}
+			}
+		}
+	}
+
+	roundtrip := func(c *Channel) string {
+		if err := c.WritePacket(&lob.Packet{}); err != nil {
+			return err.Error()
+		}
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return err.Error()
+		}
+		return string(pkt.Body(nil))
+	}
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	A.SetFallback(echo("first"))
+
+	c1, err := B.Open(ident, "unknown", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c1.Kill()
+	assert.Equal("first", roundtrip(c1))
+
+	A.SetFallback(echo("second"))
+
+	c2, err := B.Open(ident, "unknown", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c2.Kill()
+	assert.Equal("second", roundtrip(c2))
+
+	// the channel opened before the swap keeps its handler
+	assert.Equal("first", roundtrip(c1))
+
+	// a registered listener takes precedence over the fallback
+	l := A.Listen("known", true)
+	go func() {
+		c, err := l.AcceptChannel()
+		if err == nil {
+			echo("listener")(c)
+		}
+	}()
+	c3, err := B.Open(ident, "known", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c3.Kill()
+	assert.Equal("listener", roundtrip(c3))
+}
@@ -1,7 +1,9 @@
 package e3x
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
 
@@ -23,11 +25,46 @@ func (m *MockExchange) deliverPacket(pkt *lob.Packet, dst *Pipe) error {
 	return args.Error(0)
 }
 
+func (m *MockExchange) sampleRTT(d time.Duration) {}
+
+func (m *MockExchange) countRetransmits(n int) {}
+
+func (m *MockExchange) pathMTU() int { return MaxPacketSize }
+
 func (m *MockExchange) RemoteIdentity() *Identity {
 	args := m.Called()
 	return args.Get(0).(*Identity)
 }
 
+// wireExchange records the encoded packets delivered by a channel.
+type wireExchange struct {
+	mtx  sync.Mutex
+	sent [][]byte
+}
+
+func (x *wireExchange) getTID() tracer.ID         { return tracer.ID(0) }
+func (x *wireExchange) sampleRTT(d time.Duration) {}
+func (x *wireExchange) countRetransmits(n int)    {}
+func (x *wireExchange) pathMTU() int              { return MaxPacketSize }
+func (x *wireExchange) RemoteIdentity() *Identity { return nil }
+func (x *wireExchange) deliverPacket(pkt *lob.Packet, dst *Pipe) error {
+	buf, err := lob.Encode(pkt)
+	if err != nil {
+		return err
+	}
+	x.mtx.Lock()
+	x.sent = append(x.sent, buf.Get(nil))
+	x.mtx.Unlock()
+	buf.Free()
+	return nil
+}
+
+func (x *wireExchange) packets() [][]byte {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	return x.sent
+}
+
 func dumpExpVar(tb testing.TB) {
 	tb.Logf("stat: %s", statsMap)
 	resetStats()
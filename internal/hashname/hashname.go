@@ -27,13 +27,22 @@ var ErrInvalidKey = errors.New("hashname: invalid key")
 // H represents a hashname.
 type H string
 
-// Valid returns true when h is a valid hashname. A hashname must match [a-z2-7]{52}.
+// Valid returns true when h is a valid hashname. A hashname must match
+// [a-z2-7]{52} and be the canonical encoding of 32 bytes (the unused low bits
+// of the last character are zero, so it is either 'a' or 'q').
 func (h H) Valid() bool {
-	if len(h) != 52 {
+	return validDigest(string(h))
+}
+
+// validDigest returns true when s is the (unpadded, lower case) base32 encoding
+// of a sha256 digest, as used for hashnames and intermediate parts.
+func validDigest(s string) bool {
+	if len(s) != 52 || !base32util.ValidString(s) {
 		return false
 	}
 
-	return base32util.ValidString(string(h))
+	last := s[51]
+	return last == 'a' || last == 'q'
 }
 
 func (h H) Network() string {
@@ -44,6 +53,23 @@ func (h H) String() string {
 	return string(h)
 }
 
+// ShortLen is the number of characters in the short form of a hashname.
+const ShortLen = 4
+
+// Short returns the first ShortLen characters of h. Hashnames that are
+// shorter than ShortLen are returned unchanged.
+func (h H) Short() string {
+	if len(h) <= ShortLen {
+		return string(h)
+	}
+	return string(h[:ShortLen])
+}
+
+// Full returns the complete string form of h.
+func (h H) Full() string {
+	return string(h)
+}
+
 // FromIntermediates derives a hashname from its intermediate parts.
 func FromIntermediates(parts cipherset.Parts) (H, error) {
 	if len(parts) == 0 {
@@ -65,7 +91,7 @@ func FromIntermediates(parts cipherset.Parts) (H, error) {
 
 		// decode intermediate part
 		partString := parts[uint8(id)]
-		if len(partString) != 52 {
+		if !validDigest(partString) {
 			return "", ErrInvalidIntermediatePart
 		}
 		part, err := base32util.DecodeString(partString)
@@ -89,8 +115,15 @@ func FromIntermediates(parts cipherset.Parts) (H, error) {
 	return H(base32util.EncodeToString(buf[:32])), nil
 }
 
-// FromKeys derives a hashname from its public keys.
+// FromKeys derives a hashname from its public keys. ErrInvalidKey is returned
+// when one of the keys has no public part.
 func FromKeys(keys cipherset.Keys) (H, error) {
+	for _, key := range keys {
+		if key == nil || len(key.Public()) == 0 {
+			return "", ErrInvalidKey
+		}
+	}
+
 	intermediates := PartsFromKeys(keys)
 	return FromIntermediates(intermediates)
 }
@@ -116,6 +149,10 @@ func PartsFromKeys(keys cipherset.Keys) cipherset.Parts {
 
 // FromKeyAndIntermediates derives a hasname from a public key and some intermediate parts.
 func FromKeyAndIntermediates(id uint8, key []byte, intermediates cipherset.Parts) (H, error) {
+	if len(key) == 0 {
+		return "", ErrInvalidKey
+	}
+
 	var (
 		all          = make(cipherset.Parts, len(intermediates)+1)
 		sum          = sha256.Sum256(key)
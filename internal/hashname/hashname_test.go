@@ -59,6 +59,53 @@ func TestSpecification(t *testing.T) {
 	}
 }
 
+func TestValid(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(H("27ywx5e5ylzxfzxrhptowvwntqrd3jhksyxrfkzi6jfn64d3lwxa").Valid())
+	assert.True(H("27ywx5e5ylzxfzxrhptowvwntqrd3jhksyxrfkzi6jfn64d3lwxq").Valid())
+
+	assert.False(H("").Valid())
+	assert.False(H("27ywx5e5ylzxfzxrhptowvwntqrd3jhksyxrfkzi6jfn64d3lwx").Valid(), "too short")
+	assert.False(H("27ywx5e5ylzxfzxrhptowvwntqrd3jhksyxrfkzi6jfn64d3lwxb").Valid(), "not canonical")
+	assert.False(H("27YWX5E5YLZXFZXRHPTOWVWNTQRD3JHKSYXRFKZI6JFN64D3LWXA").Valid(), "upper case")
+	assert.False(H("17ywx5e5ylzxfzxrhptowvwntqrd3jhksyxrfkzi6jfn64d3lwxa").Valid(), "not base32")
+}
+
+func TestInvalidInput(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := FromIntermediates(nil)
+	assert.Equal(ErrNoIntermediateParts, err)
+
+	_, err = FromIntermediates(cipherset.Parts{
+		0x1a: "ym7p66flpzyncnwkzxv2qk5dtosgnnstgfhw6xj2wvbvm7oz5oab",
+	})
+	assert.Equal(ErrInvalidIntermediatePart, err)
+
+	_, err = FromKeys(cipherset.Keys{0x3a: nil})
+	assert.Equal(ErrInvalidKey, err)
+
+	_, err = FromKeyAndIntermediates(0x3a, nil, nil)
+	assert.Equal(ErrInvalidKey, err)
+}
+
+func TestShortAndFull(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		h      = H("nzf4f6j7ylv53z3m4egrwltv2t2yks4rtpaimeg3avwqsoshqxba")
+	)
+
+	assert.Equal("nzf4", h.Short())
+	assert.Len(h.Short(), ShortLen)
+	assert.Equal(string(h), h.Full())
+
+	assert.Equal("", H("").Short())
+	assert.Equal("nz", H("nz").Short())
+	assert.Equal("nzf4", H("nzf4").Short())
+	assert.Equal("nzf4", H("nzf4f").Short())
+}
+
 func mustHex(s string) []byte {
 	d, err := hex.DecodeString(s)
 	if err != nil {
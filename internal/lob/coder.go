@@ -11,6 +11,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
@@ -20,6 +21,9 @@ import (
 // ErrInvalidPacket is returned by Decode
 var ErrInvalidPacket = errors.New("lob: invalid packet")
 
+// ErrPacketTooLarge is returned by Encode when the encoded packet does not fit in a buffer
+var ErrPacketTooLarge = errors.New("lob: packet too large")
+
 var pktPool = sync.Pool{
 	New: func() interface{} { return new(Packet) },
 }
@@ -192,6 +196,12 @@ func Encode(pkt *Packet) (*bufpool.Buffer, error) {
 		pkt.body.WriteTo(buf)
 	}
 
+	if buf.Len() > bufpool.Size {
+		buf.Reset()
+		byteBufferPool.Put(buf)
+		return nil, ErrPacketTooLarge
+	}
+
 	p = bufpool.New()
 	p.Set(buf.Bytes())
 	binary.BigEndian.PutUint16(p.RawBytes(), uint16(hdrLen))
@@ -203,6 +213,52 @@ func Encode(pkt *Packet) (*bufpool.Buffer, error) {
 }
 
 func (h *Header) writeTo(buf *bytes.Buffer) error {
+	if h.isJustAck() {
+		h.writeAckTo(buf)
+		return nil
+	}
+	return h.writeFieldsTo(buf)
+}
+
+// isJustAck returns true for the headers of ack-only packets (which only have
+// the c, ack and miss headers). Acks dominate the packet counts of busy
+// channels.
+func (h *Header) isJustAck() bool {
+	return h.HasC && h.HasAck && !h.HasType && !h.HasEnd && !h.HasSeq && len(h.Extra) == 0
+}
+
+// writeAckTo is the fast path of writeTo for ack-only headers. It produces the
+// same output as writeFieldsTo without going through fmt or encoding/json.
+func (h *Header) writeAckTo(buf *bytes.Buffer) {
+	var scratch [64]byte
+
+	b := append(scratch[:0], '{')
+	b = append(b, hdrC...)
+	b = append(b, ':')
+	b = strconv.AppendUint(b, uint64(h.C), 10)
+	b = append(b, ',')
+	b = append(b, hdrAck...)
+	b = append(b, ':')
+	b = strconv.AppendUint(b, uint64(h.Ack), 10)
+
+	if h.HasMiss && len(h.Miss) > 0 {
+		b = append(b, ',')
+		b = append(b, hdrMiss...)
+		b = append(b, ':', '[')
+		for i, m := range h.Miss {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendUint(b, uint64(m), 10)
+		}
+		b = append(b, ']')
+	}
+
+	b = append(b, '}')
+	buf.Write(b)
+}
+
+func (h *Header) writeFieldsTo(buf *bytes.Buffer) error {
 	var first = true
 
 	buf.WriteByte('{')
@@ -220,7 +276,7 @@ func (h *Header) writeTo(buf *bytes.Buffer) error {
 		}
 		buf.Write(hdrType)
 		buf.WriteByte(':')
-		fmt.Fprintf(buf, "%q", h.Type)
+		writeString(buf, h.Type)
 		first = false
 	}
 
@@ -282,7 +338,7 @@ func (h *Header) writeTo(buf *bytes.Buffer) error {
 				buf.WriteByte(',')
 			}
 
-			fmt.Fprintf(buf, "%q", k)
+			writeString(buf, k)
 			buf.WriteByte(':')
 			err := enc.Encode(v)
 			if err != nil {
@@ -296,6 +352,89 @@ func (h *Header) writeTo(buf *bytes.Buffer) error {
 	return nil
 }
 
+// writeString writes s as a JSON string.
+func writeString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	buf.Write(data)
+}
+
+// EncodedLen returns the length of the header once it is encoded by Encode
+// (without the length prefix). The length is computed without encoding the
+// header; only the values of the custom headers are JSON encoded.
+func (h *Header) EncodedLen() (int, error) {
+	if h.IsZero() {
+		return 0, nil
+	}
+	if h.IsBinary() {
+		return len(h.Bytes), nil
+	}
+
+	var (
+		n      = 2 // braces
+		fields = 0
+	)
+
+	field := func(keyLen, valueLen int) {
+		if fields > 0 {
+			n++ // comma
+		}
+		n += keyLen + 1 + valueLen
+		fields++
+	}
+
+	if h.HasC {
+		field(len(hdrC), uintLen(uint64(h.C)))
+	}
+	if h.HasType {
+		field(len(hdrType), stringLen(h.Type))
+	}
+	if h.HasEnd {
+		if h.End {
+			field(len(hdrEnd), len(tokenTrue))
+		} else {
+			field(len(hdrEnd), len(tokenFalse))
+		}
+	}
+	if h.HasSeq {
+		field(len(hdrSeq), uintLen(uint64(h.Seq)))
+	}
+	if h.HasAck {
+		field(len(hdrAck), uintLen(uint64(h.Ack)))
+	}
+	if h.HasMiss && len(h.Miss) > 0 {
+		l := 2 + len(h.Miss) - 1 // brackets and commas
+		for _, m := range h.Miss {
+			l += uintLen(uint64(m))
+		}
+		field(len(hdrMiss), l)
+	}
+	for k, v := range h.Extra {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		// json.Encoder terminates each value with a newline
+		field(stringLen(k), len(data)+1)
+	}
+
+	return n, nil
+}
+
+func uintLen(v uint64) int {
+	n := 1
+	for v >= 10 {
+		v /= 10
+		n++
+	}
+	return n
+}
+
+// stringLen returns the length of s as a JSON string.
+func stringLen(s string) int {
+	data, _ := json.Marshal(s)
+	return len(data)
+}
+
 // IsZero returns true when the header is the zero value or equivalent.
 func (h *Header) IsZero() bool {
 	return !h.HasC && !h.HasEnd && !h.HasType && !h.HasSeq && !h.HasAck && (!h.HasMiss || len(h.Miss) == 0) && len(h.Extra) == 0 && len(h.Bytes) == 0
@@ -325,6 +464,44 @@ func (h *Header) Set(k string, v interface{}) {
 	h.Extra[k] = v
 }
 
+// Decode decodes the custom headers into v (as if they were a JSON object). v
+// must be a pointer. The header itself is left untouched.
+func (h *Header) Decode(v interface{}) error {
+	var extra map[string]interface{}
+	if h != nil {
+		extra = h.Extra
+	}
+	if extra == nil {
+		extra = map[string]interface{}{}
+	}
+
+	data, err := json.Marshal(extra)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Encode sets the custom headers to the fields of v (as if v was encoded as a
+// JSON object). v must encode to a JSON object. The headers which are not in v
+// are left untouched.
+func (h *Header) Encode(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var extra map[string]interface{}
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+
+	for k, v := range extra {
+		h.Set(k, v)
+	}
+	return nil
+}
+
 // GetString returns the string value for key k. found is false if k is not present.
 func (h *Header) GetString(k string) (v string, found bool) {
 	y, ok := h.Get(k)
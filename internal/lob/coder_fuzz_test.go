@@ -0,0 +1,71 @@
+package lob
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// FuzzDecode feeds arbitrary bytes (up to the size of a buffer) to Decode.
+// The seed corpus consists of the packets from fuzzSeedPackets and the regression
+// inputs in testdata/fuzz/FuzzDecode. The following invariants are asserted:
+//
+//   - Decode never panics;
+//   - a packet returned by Decode can be encoded again;
+//   - decoding the re-encoded packet yields the same header and body.
+func FuzzDecode(f *testing.F) {
+	for _, pkt := range fuzzSeedPackets() {
+		buf, err := Encode(pkt)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Get(nil))
+		buf.Free()
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > bufpool.Size {
+			t.Skip()
+		}
+
+		in := bufpool.New().Set(data)
+		pkt, err := Decode(in)
+		in.Free()
+		if err != nil {
+			return
+		}
+
+		buf, err := Encode(pkt)
+		if err != nil {
+			t.Fatalf("failed to re-encode decoded packet: %s", err)
+		}
+
+		pkt2, err := Decode(buf)
+		buf.Free()
+		if err != nil {
+			t.Fatalf("failed to decode re-encoded packet: %s", err)
+		}
+
+		if !reflect.DeepEqual(pkt.Header(), pkt2.Header()) {
+			t.Fatalf("header mismatch: %#v != %#v", pkt.Header(), pkt2.Header())
+		}
+		if !bytes.Equal(pkt.Body(nil), pkt2.Body(nil)) {
+			t.Fatalf("body mismatch: %q != %q", pkt.Body(nil), pkt2.Body(nil))
+		}
+	})
+}
+
+func fuzzSeedPackets() []*Packet {
+	return []*Packet{
+		New(nil),
+		New([]byte("world")),
+		New([]byte("world")).SetHeader(Header{Bytes: []byte("h")}),
+		New(nil).SetHeader(Header{Extra: map[string]interface{}{"hello": 5}}),
+		New(nil).SetHeader(Header{Extra: map[string]interface{}{"see": []string{"a", "b"}}}),
+		New([]byte("world")).SetHeader(Header{HasC: true, C: 123, HasSeq: true, Seq: 1, HasType: true, Type: "foo"}),
+		New(nil).SetHeader(Header{HasAck: true, Ack: 123, HasMiss: true, Miss: []uint32{1, 2, 100}}),
+		New(nil).SetHeader(Header{HasEnd: true, End: true, Extra: map[string]interface{}{"err": "failed \"badly\""}}),
+	}
+}
@@ -1,6 +1,8 @@
 package lob
 
 import (
+	"bytes"
+
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"testing"
@@ -39,6 +41,99 @@ func TestCoding(t *testing.T) {
 	}
 }
 
+func TestEncodeTooLarge(t *testing.T) {
+	assert := assert.New(t)
+
+	pkt := New(nil)
+	pkt.Header().SetString("large", string(bytes.Repeat([]byte{'x'}, bufpool.Size)))
+
+	data, err := Encode(pkt)
+	assert.Equal(ErrPacketTooLarge, err)
+	assert.Nil(data)
+}
+
+func TestEncodeAck(t *testing.T) {
+	assert := assert.New(t)
+
+	var tab = []Header{
+		{HasC: true, C: 1, HasAck: true, Ack: 0},
+		{HasC: true, C: 4294967295, HasAck: true, Ack: 4294967295},
+		{HasC: true, C: 7, HasAck: true, Ack: 1234, HasMiss: true, Miss: []uint32{1, 3, 100}},
+		{HasC: true, C: 7, HasAck: true, Ack: 1234, HasMiss: true},
+	}
+
+	for i, h := range tab {
+		if !assert.True(h.isJustAck(), "%d", i) {
+			continue
+		}
+
+		var fast, slow bytes.Buffer
+		h.writeAckTo(&fast)
+		assert.NoError(h.writeFieldsTo(&slow))
+		assert.Equal(slow.String(), fast.String(), "%d", i)
+
+		data, err := Encode(New(nil).SetHeader(h))
+		if assert.NoError(err) {
+			o, err := Decode(data)
+			if assert.NoError(err) {
+				assert.Equal(h.C, o.Header().C)
+				assert.Equal(h.Ack, o.Header().Ack)
+				assert.Equal(len(h.Miss), len(o.Header().Miss))
+				o.Free()
+			}
+			data.Free()
+		}
+	}
+
+	assert.False((&Header{HasC: true, HasAck: true, HasSeq: true}).isJustAck())
+	assert.False((&Header{HasC: true, HasAck: true, Extra: map[string]interface{}{"a": 1}}).isJustAck())
+}
+
+func TestEncodedLen(t *testing.T) {
+	assert := assert.New(t)
+
+	var tab = []Header{
+		{},
+		{Bytes: []byte("hello!")},
+		{HasC: true, C: 1, HasAck: true, Ack: 0},
+		{HasC: true, C: 4294967295, HasSeq: true, Seq: 4294967295, HasAck: true, Ack: 4294967295},
+		{HasC: true, C: 7, HasAck: true, Ack: 1234, HasMiss: true, Miss: []uint32{1, 3, 100}},
+		{HasC: true, C: 7, HasType: true, Type: "a\"<b>", HasEnd: true, End: true},
+		{HasEnd: true, HasSeq: true, Seq: 10, Extra: map[string]interface{}{"hello": 5, "w\u00f6rld": []int{1, 2}, "x": "<&>"}},
+	}
+
+	for i, h := range tab {
+		n, err := h.EncodedLen()
+		if !assert.NoError(err, "%d", i) {
+			continue
+		}
+
+		data, err := Encode(New(nil).SetHeader(h))
+		if assert.NoError(err, "%d", i) {
+			assert.Equal(data.Len()-2, n, "%d", i)
+			data.Free()
+		}
+	}
+}
+
+var benchAck = Header{HasC: true, C: 3, HasAck: true, Ack: 81723, HasMiss: true, Miss: []uint32{1, 2, 5, 100}}
+
+func BenchmarkEncodeAck(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		benchAck.writeAckTo(&buf)
+		buf.Reset()
+	}
+}
+
+func BenchmarkEncodeAckGeneric(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		benchAck.writeFieldsTo(&buf)
+		buf.Reset()
+	}
+}
+
 func BenchmarkEncode(b *testing.B) {
 	var tab = []*Packet{
 		New([]byte("world")).SetHeader(Header{Bytes: []byte("h")}),
@@ -335,6 +335,10 @@ func parseString(p []byte) (string, []byte, bool) {
 		}
 	}
 
+	if !utf8.Valid(v[:dst]) {
+		return "", p, false
+	}
+
 	return string(v[:dst]), p, true
 }
 
@@ -0,0 +1,23 @@
+package lob
+
+// BodyPacket decodes the body of p as a packet, like the inner packets of
+// handshakes and of the connect channel of the bridge.
+func (p *Packet) BodyPacket() (*Packet, error) {
+	if p.body.Len() == 0 {
+		return nil, ErrInvalidPacket
+	}
+
+	return Decode(p.body)
+}
+
+// SetBodyPacket encodes inner as the body of p. inner can be freed afterwards.
+func (p *Packet) SetBodyPacket(inner *Packet) error {
+	body, err := Encode(inner)
+	if err != nil {
+		return err
+	}
+
+	p.body.Free()
+	p.body = body
+	return nil
+}
@@ -0,0 +1,79 @@
+package lob
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// Encoder writes packets to a stream. Each packet is prefixed with its length
+// as a 16-bit big endian integer (like the tcp and unix transports frame their
+// messages).
+type Encoder struct {
+	w   io.Writer
+	hdr [2]byte
+}
+
+// NewEncoder returns an encoder which writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes pkt to the stream.
+func (e *Encoder) Encode(pkt *Packet) error {
+	data, err := Encode(pkt)
+	if err != nil {
+		return err
+	}
+	defer data.Free()
+
+	binary.BigEndian.PutUint16(e.hdr[:], uint16(data.Len()))
+	if _, err := e.w.Write(e.hdr[:]); err != nil {
+		return err
+	}
+
+	_, err = data.WriteTo(e.w)
+	return err
+}
+
+// Decoder reads the packets written by an Encoder from a stream. A single
+// buffer is reused for all the frames it reads.
+type Decoder struct {
+	r   io.Reader
+	hdr [2]byte
+	buf *bufpool.Buffer
+}
+
+// NewDecoder returns a decoder which reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next packet from the stream. io.EOF is returned when the
+// stream ends between two packets and io.ErrUnexpectedEOF when it ends within
+// a packet.
+func (d *Decoder) Decode() (*Packet, error) {
+	if _, err := io.ReadFull(d.r, d.hdr[:]); err != nil {
+		return nil, err
+	}
+
+	n := int(binary.BigEndian.Uint16(d.hdr[:]))
+	if n > bufpool.Size {
+		return nil, ErrPacketTooLarge
+	}
+
+	if d.buf == nil {
+		d.buf = bufpool.New()
+	}
+	d.buf.SetLen(n)
+
+	if _, err := io.ReadFull(d.r, d.buf.RawBytes()); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return Decode(d.buf)
+}
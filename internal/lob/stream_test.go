@@ -0,0 +1,69 @@
+package lob
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		buf bytes.Buffer
+		enc = NewEncoder(&buf)
+		tab = []*Packet{
+			New([]byte("world")).SetHeader(Header{Bytes: []byte("h")}),
+			New(nil).SetHeader(Header{HasC: true, C: 123, HasType: true, Type: "foo"}),
+			New([]byte("world")).SetHeader(Header{Extra: map[string]interface{}{"hello": 5}}),
+		}
+	)
+
+	for _, pkt := range tab {
+		assert.NoError(enc.Encode(pkt))
+	}
+
+	dec := NewDecoder(&buf)
+	for _, pkt := range tab {
+		o, err := dec.Decode()
+		if assert.NoError(err) {
+			assert.Equal(pkt, o)
+		}
+	}
+
+	_, err := dec.Decode()
+	assert.Equal(io.EOF, err)
+
+	// truncated within a packet
+	assert.NoError(enc.Encode(tab[0]))
+	buf.Truncate(buf.Len() - 1)
+	_, err = dec.Decode()
+	assert.Equal(io.ErrUnexpectedEOF, err)
+}
+
+func TestBodyPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := New([]byte("key")).SetHeader(Header{Bytes: []byte{0x3a}})
+	outer := New(nil).SetHeader(Header{HasType: true, Type: "connect"})
+	assert.NoError(outer.SetBodyPacket(inner))
+
+	data, err := Encode(outer)
+	if !assert.NoError(err) {
+		return
+	}
+	outer, err = Decode(data)
+	if !assert.NoError(err) {
+		return
+	}
+
+	o, err := outer.BodyPacket()
+	if assert.NoError(err) {
+		assert.Equal(inner, o)
+	}
+
+	_, err = New(nil).BodyPacket()
+	assert.Equal(ErrInvalidPacket, err)
+}
@@ -0,0 +1,202 @@
+// Package alias maps application chosen names to hashnames. Aliases are
+// registered locally and can optionally be published into the DHT so other
+// endpoints can resolve them.
+package alias
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+// ErrInvalidAlias is returned by RegisterAlias when name is empty or hn is not
+// a valid hashname.
+var ErrInvalidAlias = errors.New("alias: invalid alias")
+
+const (
+	defaultReplicas     = 3
+	defaultMaxPublished = 4096
+)
+
+type Config struct {
+	// Publish publishes every registered alias to the Replicas peers closest
+	// to the key of its name. The dht module must be registered as well.
+	// Published aliases are not authenticated; a later publication of the
+	// same name replaces an earlier one.
+	Publish bool
+
+	// Replicas is the number of peers an alias is published to and the number
+	// of peers which are asked to resolve a name. Defaults to 3.
+	Replicas int
+
+	// MaxPublished is the maximum number of aliases other peers can publish to
+	// the local endpoint. Publications of new names are dropped once it is
+	// reached; names which are already published can still be replaced.
+	// Defaults to 4096.
+	MaxPublished int
+
+	// Resolver resolves the names which have no local alias. Defaults to a
+	// resolver which asks the peers closest to the key of the name (see
+	// DHTResolver).
+	Resolver Resolver
+}
+
+// Resolver resolves a name to a hashname.
+type Resolver interface {
+	Resolve(name string) (hashname.H, bool)
+}
+
+type Directory interface {
+	// RegisterAlias maps name to hn (and publishes the mapping when
+	// Config.Publish is set).
+	RegisterAlias(name string, hn hashname.H) error
+
+	// Lookup resolves name; the local aliases are consulted first, then the
+	// aliases published to the local endpoint by other peers and finally the
+	// configured Resolver.
+	Lookup(name string) (hashname.H, bool)
+}
+
+type module struct {
+	mtx       sync.RWMutex
+	e         *e3x.Endpoint
+	config    Config
+	aliases   map[string]hashname.H
+	published map[string]hashname.H
+	listener  *e3x.Listener
+	log       *logs.Logger
+}
+
+type moduleKeyType string
+
+const moduleKey = moduleKeyType("alias")
+
+func Module(config Config) e3x.EndpointOption {
+	return func(e *e3x.Endpoint) error {
+		return e3x.RegisterModule(moduleKey, newDirectory(e, config))(e)
+	}
+}
+
+func FromEndpoint(e *e3x.Endpoint) Directory {
+	mod := e.Module(moduleKey)
+	if mod == nil {
+		return nil
+	}
+	return mod.(*module)
+}
+
+func newDirectory(e *e3x.Endpoint, config Config) *module {
+	if config.Replicas <= 0 {
+		config.Replicas = defaultReplicas
+	}
+	if config.MaxPublished <= 0 {
+		config.MaxPublished = defaultMaxPublished
+	}
+
+	mod := &module{
+		e:         e,
+		config:    config,
+		aliases:   make(map[string]hashname.H),
+		published: make(map[string]hashname.H),
+	}
+
+	if mod.config.Resolver == nil {
+		mod.config.Resolver = DHTResolver(e, config.Replicas)
+	}
+
+	return mod
+}
+
+func (mod *module) Init() error {
+	mod.log = logs.Module("alias").From(mod.e.LocalHashname())
+	return nil
+}
+
+func (mod *module) Start() error {
+	mod.listener = mod.e.Listen(channelType, false)
+	go mod.accept()
+	return nil
+}
+
+func (mod *module) Stop() error {
+	mod.listener.Close()
+	return nil
+}
+
+func (mod *module) RegisterAlias(name string, hn hashname.H) error {
+	if name == "" || !hn.Valid() {
+		return ErrInvalidAlias
+	}
+
+	mod.mtx.Lock()
+	mod.aliases[name] = hn
+	mod.mtx.Unlock()
+
+	if mod.config.Publish {
+		go mod.publish(name, hn)
+	}
+
+	return nil
+}
+
+func (mod *module) Lookup(name string) (hashname.H, bool) {
+	if hn, found := mod.lookupLocal(name); found {
+		return hn, true
+	}
+
+	return mod.config.Resolver.Resolve(name)
+}
+
+// lookupLocal returns the local alias for name or the alias published to the
+// local endpoint.
+func (mod *module) lookupLocal(name string) (hashname.H, bool) {
+	mod.mtx.RLock()
+	defer mod.mtx.RUnlock()
+
+	if hn, found := mod.aliases[name]; found {
+		return hn, true
+	}
+	if hn, found := mod.published[name]; found {
+		return hn, true
+	}
+	return "", false
+}
+
+// storePublished stores the alias name published by another peer. It reports
+// whether the alias is stored.
+func (mod *module) storePublished(name string, hn hashname.H) bool {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	if _, found := mod.published[name]; !found && len(mod.published) >= mod.config.MaxPublished {
+		return false
+	}
+	mod.published[name] = hn
+	return true
+}
+
+func (mod *module) accept() {
+	for {
+		c, err := mod.listener.AcceptChannel()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		go mod.handle(c)
+	}
+}
+
+// Key returns the DHT key of name; aliases are published to the peers which
+// are closest to the key.
+func Key(name string) hashname.H {
+	sum := sha256.Sum256([]byte(name))
+	return hashname.H(base32util.EncodeToString(sum[:]))
+}
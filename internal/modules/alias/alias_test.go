@@ -0,0 +1,120 @@
+package alias
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/modules/dht"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+type staticResolver map[string]hashname.H
+
+func (r staticResolver) Resolve(name string) (hashname.H, bool) {
+	hn, found := r[name]
+	return hn, found
+}
+
+func TestLocalAlias(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		printer = hashname.H("3q7xvvukuwgjjmhbvgr3ftu5wmyhexi6mhs5eqhn5apbzcgaqr7a")
+		scanner = hashname.H("x4ptrhmkyrrxrg4ui4r7bs3eq4dg3g7kp5skbgwyeylfuoxynb7a")
+		A       = openEndpoint(t, Module(Config{Resolver: staticResolver{"scanner": scanner}}))
+	)
+	defer A.Close()
+
+	dir := FromEndpoint(A)
+
+	_, found := dir.Lookup("printer")
+	assert.False(found)
+
+	assert.NoError(dir.RegisterAlias("printer", printer))
+	hn, found := dir.Lookup("printer")
+	assert.True(found)
+	assert.Equal(printer, hn)
+
+	// names without a local alias are passed to the resolver
+	hn, found = dir.Lookup("scanner")
+	assert.True(found)
+	assert.Equal(scanner, hn)
+
+	assert.Equal(ErrInvalidAlias, dir.RegisterAlias("", printer))
+	assert.Equal(ErrInvalidAlias, dir.RegisterAlias("fax", "not-a-hashname"))
+}
+
+func TestPublishedAlias(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		B = openEndpoint(t, dht.Module(dht.Config{}), Module(Config{}))
+		A = openEndpoint(t, dht.Module(dht.Config{}), Module(Config{Publish: true}))
+		C = openEndpoint(t, dht.Module(dht.Config{}), Module(Config{}))
+	)
+	defer A.Close()
+	defer B.Close()
+	defer C.Close()
+
+	Bident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	_, err = A.Dial(Bident)
+	assert.NoError(err)
+	_, err = C.Dial(Bident)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	_, found := FromEndpoint(C).Lookup("printer")
+	assert.False(found)
+
+	// A publishes the alias to B (the peer closest to the key)
+	assert.NoError(FromEndpoint(A).RegisterAlias("printer", A.LocalHashname()))
+	time.Sleep(100 * time.Millisecond)
+
+	// C resolves it through B
+	hn, found := FromEndpoint(C).Lookup("printer")
+	assert.True(found)
+	assert.Equal(A.LocalHashname(), hn)
+}
+
+func openEndpoint(t *testing.T, options ...e3x.EndpointOption) *e3x.Endpoint {
+	e, err := e3x.Open(append([]e3x.EndpointOption{
+		e3x.Log(nil),
+		e3x.Transport(udp.Config{}),
+	}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestMaxPublished(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		printer = hashname.H("3q7xvvukuwgjjmhbvgr3ftu5wmyhexi6mhs5eqhn5apbzcgaqr7a")
+		scanner = hashname.H("x4ptrhmkyrrxrg4ui4r7bs3eq4dg3g7kp5skbgwyeylfuoxynb7a")
+		mod     = newDirectory(nil, Config{MaxPublished: 1, Resolver: staticResolver{}})
+	)
+
+	assert.True(mod.storePublished("printer", printer))
+	assert.False(mod.storePublished("scanner", scanner))
+
+	// a published name can still be replaced
+	assert.True(mod.storePublished("printer", scanner))
+	hn, found := mod.lookupLocal("printer")
+	assert.True(found)
+	assert.Equal(scanner, hn)
+	_, found = mod.lookupLocal("scanner")
+	assert.False(found)
+}
@@ -0,0 +1,138 @@
+package alias
+
+import (
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/modules/dht"
+)
+
+const (
+	channelType = "alias"
+	readTimeout = 10 * time.Second
+)
+
+// dhtResolver asks the peers closest to the key of a name which are linked to
+// the local endpoint.
+type dhtResolver struct {
+	e        *e3x.Endpoint
+	replicas int
+}
+
+// DHTResolver returns a Resolver which asks the replicas peers closest to the
+// key of a name (as known by the dht module of e) to resolve it. The peers
+// answer with their local and published aliases.
+func DHTResolver(e *e3x.Endpoint, replicas int) Resolver {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	return &dhtResolver{e: e, replicas: replicas}
+}
+
+func (r *dhtResolver) Resolve(name string) (hashname.H, bool) {
+	for _, x := range closestExchanges(r.e, name, r.replicas) {
+		if hn, err := resolveVia(x, name); err == nil && hn.Valid() {
+			return hn, true
+		}
+	}
+	return "", false
+}
+
+// closestExchanges returns the exchanges with the n peers closest to the key of
+// name.
+func closestExchanges(e *e3x.Endpoint, name string, n int) []*e3x.Exchange {
+	d := dht.FromEndpoint(e)
+	if d == nil {
+		return nil
+	}
+
+	var l []*e3x.Exchange
+	for _, hn := range d.Closest(Key(name), n) {
+		if x := e.GetExchange(hn); x != nil {
+			l = append(l, x)
+		}
+	}
+	return l
+}
+
+func (mod *module) publish(name string, hn hashname.H) {
+	exchanges := closestExchanges(mod.e, name, mod.config.Replicas)
+	if len(exchanges) == 0 {
+		mod.log.Printf("publish %q: no peers", name)
+		return
+	}
+
+	for _, x := range exchanges {
+		if err := publishVia(x, name, hn); err != nil {
+			mod.log.Printf("publish %q via %s failed: %s", name, x.RemoteHashname().Short(), err)
+		}
+	}
+}
+
+func publishVia(x *e3x.Exchange, name string, hn hashname.H) error {
+	c, err := x.Open(channelType, false)
+	if err != nil {
+		return err
+	}
+	defer c.Kill()
+
+	pkt := &lob.Packet{}
+	pkt.Header().SetString("name", name)
+	pkt.Header().SetString("hashname", string(hn))
+	return c.WritePacket(pkt)
+}
+
+func resolveVia(x *e3x.Exchange, name string) (hashname.H, error) {
+	c, err := x.Open(channelType, false)
+	if err != nil {
+		return "", err
+	}
+	defer c.Kill()
+
+	pkt := &lob.Packet{}
+	pkt.Header().SetString("name", name)
+	if err := c.WritePacket(pkt); err != nil {
+		return "", err
+	}
+
+	c.SetReadDeadline(time.Now().Add(readTimeout))
+	pkt, err = c.ReadPacket()
+	if err != nil {
+		return "", err
+	}
+
+	hn, _ := pkt.Header().GetString("hashname")
+	return hashname.H(hn), nil
+}
+
+func (mod *module) handle(c *e3x.Channel) {
+	defer c.Kill()
+
+	c.SetReadDeadline(time.Now().Add(readTimeout))
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		return
+	}
+
+	name, _ := pkt.Header().GetString("name")
+	if name == "" {
+		return
+	}
+
+	// a publication
+	if s, found := pkt.Header().GetString("hashname"); found {
+		if hn := hashname.H(s); hn.Valid() && !mod.storePublished(name, hn) {
+			mod.log.Printf("drop publication %q: too many published aliases", name)
+		}
+		return
+	}
+
+	// a lookup; answer from the local directory only (no recursion)
+	reply := &lob.Packet{}
+	if hn, found := mod.lookupLocal(name); found {
+		reply.Header().SetString("hashname", string(hn))
+	}
+	c.WritePacket(reply)
+}
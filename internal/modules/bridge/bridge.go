@@ -1,6 +1,7 @@
 package bridge
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
@@ -16,11 +17,38 @@ type Config struct {
 	DisableRouter bool
 	AllowPeer     func(from, to hashname.H) bool
 	AllowConnect  func(from, via hashname.H) bool
+
+	// BridgeRate limits the number of bytes per second the router forwards for
+	// each bridged peer. Packets over the limit are dropped. The zero value
+	// disables the limit.
+	BridgeRate int
+
+	// BridgeIdleTimeout is how long the router keeps a bridge which forwarded
+	// no packets. Defaults to 5 minutes.
+	BridgeIdleTimeout time.Duration
 }
 
+const (
+	defaultBridgeIdleTimeout = 5 * time.Minute
+
+	// maintenanceInterval is the interval at which idle bridges are torn down
+	// and broken exchanges fall back to a bridge.
+	maintenanceInterval = 5 * time.Second
+)
+
 type Bridge interface {
 	RouteToken(token cipherset.Token, source *e3x.Exchange)
 	BreakRoute(token cipherset.Token)
+
+	// Introduce asks the peer at the other end of via to introduce the local
+	// endpoint to the peer to. It returns the exchange with to once the peers
+	// have exchanged handshakes. Concurrent introductions to the same peer
+	// through different routers share the first exchange to complete.
+	Introduce(via *e3x.Exchange, to hashname.H) (*e3x.Exchange, error)
+
+	// IntroduceContext is like Introduce but stops waiting for the exchange
+	// once ctx is done.
+	IntroduceContext(ctx context.Context, via *e3x.Exchange, to hashname.H) (*e3x.Exchange, error)
 }
 
 type module struct {
@@ -30,8 +58,11 @@ type module struct {
 	peerListener    *e3x.Listener
 	connectListener *e3x.Listener
 	pending         map[hashname.H]*pendingIntroduction
-	packetRoutes    map[cipherset.Token]*e3x.Exchange
+	packetRoutes    map[cipherset.Token]*route
 	connections     map[*e3x.Exchange]map[cipherset.Token]*connection
+	routers         map[hashname.H]map[hashname.H]bool // the routers which introduced a peer
+	done            chan struct{}
+	stopOnce        sync.Once
 	log             *logs.Logger
 }
 
@@ -40,6 +71,7 @@ type pendingIntroduction struct {
 	cnd          *sync.Cond
 	mod          *module
 	hashname     hashname.H
+	attempts     int // the routers which are still asked to introduce
 	done         bool
 	x            *e3x.Exchange
 	err          error
@@ -65,11 +97,17 @@ func FromEndpoint(e *e3x.Endpoint) Bridge {
 }
 
 func newBridge(e *e3x.Endpoint, config Config) *module {
+	if config.BridgeIdleTimeout <= 0 {
+		config.BridgeIdleTimeout = defaultBridgeIdleTimeout
+	}
+
 	return &module{
 		e:            e,
 		config:       config,
 		pending:      make(map[hashname.H]*pendingIntroduction),
-		packetRoutes: make(map[cipherset.Token]*e3x.Exchange),
+		packetRoutes: make(map[cipherset.Token]*route),
+		routers:      make(map[hashname.H]map[hashname.H]bool),
+		done:         make(chan struct{}),
 	}
 }
 
@@ -90,28 +128,51 @@ func (mod *module) Start() error {
 
 	go mod.acceptPeerChannels()
 	go mod.acceptConnectChannels()
+	go mod.runMaintenance()
 
 	return nil
 }
 
 func (mod *module) Stop() error {
-	mod.peerListener.Close()
-	mod.connectListener.Close()
+	mod.stopOnce.Do(func() {
+		mod.peerListener.Close()
+		mod.connectListener.Close()
+		close(mod.done)
+	})
 
 	return nil
 }
 
-func (mod *module) registerIntroduction(dst hashname.H) (i *pendingIntroduction, dial bool) {
+func (mod *module) runMaintenance() {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mod.done:
+			return
+		case now := <-ticker.C:
+			mod.expireRoutes(now)
+			mod.fallbackToBridges()
+		}
+	}
+}
+
+// registerIntroduction returns the pending introduction to dst and counts an
+// attempt for it. The attempt must end with abandon when it fails.
+func (mod *module) registerIntroduction(dst hashname.H) *pendingIntroduction {
 	mod.mtx.Lock()
-	i = mod.pending[dst]
+	i := mod.pending[dst]
 	if i == nil {
-		dial = true
 		i = newPendingIntroduction(mod, dst, 2*time.Minute)
 		mod.pending[dst] = i
 	}
+	i.mtx.Lock()
+	i.attempts++
+	i.mtx.Unlock()
 	mod.mtx.Unlock()
 
-	return i, dial
+	return i
 }
 
 func (mod *module) getIntroduction(dst hashname.H) *pendingIntroduction {
@@ -129,19 +190,56 @@ func newPendingIntroduction(mod *module, hn hashname.H, timeout time.Duration) *
 	return i
 }
 
-func (i *pendingIntroduction) wait() (*e3x.Exchange, error) {
+// wait waits for the introduction to be resolved. When ctx is done first the
+// attempt of the caller is abandoned.
+func (i *pendingIntroduction) wait(ctx context.Context) (*e3x.Exchange, error) {
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				i.mtx.Lock()
+				i.cnd.Broadcast()
+				i.mtx.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+
 	i.mtx.Lock()
 
-	for !i.done {
+	for !i.done && ctx.Err() == nil {
 		i.cnd.Wait()
 	}
 
+	if !i.done {
+		i.mtx.Unlock()
+		i.abandon(ctx.Err())
+		return nil, ctx.Err()
+	}
+
 	i.cnd.Signal()
 	i.mtx.Unlock()
 
 	return i.x, i.err
 }
 
+// abandon ends an attempt which failed with err. The introduction only fails
+// when none of its attempts is left.
+func (i *pendingIntroduction) abandon(err error) {
+	i.mod.mtx.Lock()
+	i.mtx.Lock()
+
+	i.attempts--
+	if i.attempts == 0 {
+		i.resolveLocked(nil, err)
+	}
+
+	i.mtx.Unlock()
+	i.mod.mtx.Unlock()
+}
+
 func (i *pendingIntroduction) timeout() {
 	i.resolve(nil, e3x.ErrTimeout)
 }
@@ -153,8 +251,16 @@ func (i *pendingIntroduction) resolve(x *e3x.Exchange, err error) {
 
 	i.mod.mtx.Lock()
 	i.mtx.Lock()
+	i.resolveLocked(x, err)
+	i.mtx.Unlock()
+	i.mod.mtx.Unlock()
+}
 
-	delete(i.mod.pending, i.hashname)
+// resolveLocked resolves the introduction; i.mod.mtx and i.mtx must be held.
+func (i *pendingIntroduction) resolveLocked(x *e3x.Exchange, err error) {
+	if i.mod.pending[i.hashname] == i {
+		delete(i.mod.pending, i.hashname)
+	}
 
 	if !i.done {
 		if i.timeoutTimer != nil {
@@ -167,9 +273,6 @@ func (i *pendingIntroduction) resolve(x *e3x.Exchange, err error) {
 
 		i.cnd.Signal()
 	}
-
-	i.mtx.Unlock()
-	i.mod.mtx.Unlock()
 }
 
 func (mod *module) acceptPeerChannels() {
@@ -198,9 +301,107 @@ func (mod *module) acceptConnectChannels() {
 	}
 }
 
+func (mod *module) Introduce(via *e3x.Exchange, to hashname.H) (*e3x.Exchange, error) {
+	return mod.IntroduceContext(context.Background(), via, to)
+}
+
+func (mod *module) IntroduceContext(ctx context.Context, via *e3x.Exchange, to hashname.H) (*e3x.Exchange, error) {
+	if x := mod.e.GetExchange(to); x != nil {
+		return x, nil
+	}
+
+	// every router is asked to relay, even when an introduction through
+	// another router is already pending; the first handshake to arrive resolves
+	// all of them. A router which fails only fails the others when it was the
+	// last one left.
+	i := mod.registerIntroduction(to)
+	if err := mod.introduceVia(via, to); err != nil {
+		i.abandon(err)
+		return nil, err
+	}
+
+	x, err := i.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mod.addRouter(to, via.RemoteHashname())
+	return x, nil
+}
+
+// addRouter remembers that router introduced the local endpoint to peer. The
+// exchange with peer falls back to a bridge through router when it loses all
+// its paths.
+func (mod *module) addRouter(peer, router hashname.H) {
+	mod.mtx.Lock()
+	routers := mod.routers[peer]
+	if routers == nil {
+		routers = make(map[hashname.H]bool)
+		mod.routers[peer] = routers
+	}
+	routers[router] = true
+	mod.mtx.Unlock()
+}
+
+// fallbackToBridges asks the routers which introduced a peer to bridge the
+// exchange with that peer when the exchange has no working path left.
+func (mod *module) fallbackToBridges() {
+	var peers = make(map[hashname.H][]hashname.H)
+
+	mod.mtx.RLock()
+	for peer, routers := range mod.routers {
+		for router := range routers {
+			peers[peer] = append(peers[peer], router)
+		}
+	}
+	mod.mtx.RUnlock()
+
+	for peer, routers := range peers {
+		x := mod.e.GetExchange(peer)
+		if x == nil || !x.State().IsOpen() || x.ActivePath() != nil {
+			continue
+		}
+
+		mod.fallbackToBridge(x, routers)
+	}
+}
+
+// fallbackToBridge bridges x through routers. The routers tear down bridges
+// which were idle for BridgeIdleTimeout, so a handshake is sent to the peer in
+// a new peer request first; the router bridges the exchange again when it sees
+// the handshakes of both ends.
+func (mod *module) fallbackToBridge(x *e3x.Exchange, routers []hashname.H) {
+	peer := x.RemoteHashname()
+
+	for _, router := range routers {
+		rx := mod.e.GetExchange(router)
+		if rx == nil {
+			continue
+		}
+
+		pkt, err := x.GenerateHandshake()
+		if err != nil {
+			return
+		}
+
+		mod.log.To(peer).Printf("falling back to bridge via %s", router.Short())
+		if err := mod.peerVia(rx, peer, pkt); err != nil {
+			mod.log.To(peer).Printf("peer request via %s failed: %s", router.Short(), err)
+			continue
+		}
+		x.PunchHole(&peerAddr{router})
+	}
+}
+
 func (mod *module) RouteToken(token cipherset.Token, source *e3x.Exchange) {
+	now := time.Now()
+
 	mod.mtx.Lock()
-	mod.packetRoutes[token] = source
+	if r := mod.packetRoutes[token]; r != nil && r.x == source {
+		r.touch(now)
+	} else {
+		mod.packetRoutes[token] = newRoute(source, mod.config.BridgeRate, now)
+	}
 	mod.mtx.Unlock()
 }
 
@@ -210,13 +411,27 @@ func (mod *module) BreakRoute(token cipherset.Token) {
 	mod.mtx.Unlock()
 }
 
-func (mod *module) lookupToken(token cipherset.Token) (source *e3x.Exchange) {
+func (mod *module) lookupToken(token cipherset.Token) (r *route) {
 	mod.mtx.RLock()
-	source = mod.packetRoutes[token]
+	r = mod.packetRoutes[token]
 	mod.mtx.RUnlock()
 	return
 }
 
+// expireRoutes tears down the bridges which forwarded no packets for longer
+// than the idle timeout.
+func (mod *module) expireRoutes(now time.Time) {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	for token, r := range mod.packetRoutes {
+		if r.idle(mod.config.BridgeIdleTimeout, now) {
+			delete(mod.packetRoutes, token)
+			mod.log.Printf("\x1B[35mBRK %x idle\x1B[0m", token)
+		}
+	}
+}
+
 func (mod *module) registerConnection(x *e3x.Exchange, token cipherset.Token, conn *connection) {
 	mod.mtx.Lock()
 
@@ -289,11 +504,12 @@ func (mod *module) lookupConnection(x *e3x.Exchange, token cipherset.Token) *con
 func (mod *module) on_exchange_closed(e *e3x.Endpoint, x *e3x.Exchange, reason error) error {
 	mod.mtx.Lock()
 
-	for token, exchange := range mod.packetRoutes {
-		if exchange == x {
+	for token, r := range mod.packetRoutes {
+		if r.x == x {
 			delete(mod.packetRoutes, token)
 		}
 	}
+	delete(mod.routers, x.RemoteHashname())
 
 	var connections []*connection
 	if tokens := mod.connections[x]; tokens != nil {
@@ -329,20 +545,26 @@ func (mod *module) on_dropped_packet(e *e3x.Endpoint, x *e3x.Exchange, msg []byt
 func (mod *module) forwardMessage(e *e3x.Endpoint, x *e3x.Exchange, msg []byte, pipe *e3x.Pipe, reason error) error {
 	var (
 		token = cipherset.ExtractToken(msg)
-		ex    = mod.lookupToken(token)
+		r     = mod.lookupToken(token)
 	)
 
 	// not a bridged message
-	if ex == nil {
+	if r == nil {
 		return nil
 	}
 
 	// handle bridged message
+	ex := r.x
 	dst := ex.ActivePipe()
-	if dst == pipe {
+	if dst == pipe || dst == nil {
 		return nil
 	}
 
+	if !r.forward(len(msg), mod.config.BridgeRate, time.Now()) {
+		mod.log.To(ex.RemoteHashname()).Printf("\x1B[35mFWD %x %s dropped: rate limited\x1B[0m", token, dst.RemoteAddr())
+		return e3x.ErrStopPropagation
+	}
+
 	buf := bufpool.New().Set(msg)
 	_, err := dst.Write(buf)
 	buf.Free()
@@ -1,12 +1,18 @@
 package bridge
 
 import (
+	"context"
+	"errors"
 	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
 
 	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/logs"
 	"github.com/telehash/gogotelehash/transports"
@@ -164,3 +170,380 @@ func TestBridge(t *testing.T) {
 	assert.NoError(B.Close())
 	assert.NoError(R.Close())
 }
+
+func TestIntroduce(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var endpoints []*e3x.Endpoint
+	for i := 0; i < 3; i++ {
+		e, err := e3x.Open(
+			e3x.Log(nil),
+			e3x.Transport(udp.Config{}),
+			Module(Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+		endpoints = append(endpoints, e)
+	}
+
+	var (
+		A = endpoints[0]
+		R = endpoints[1]
+		C = endpoints[2]
+	)
+
+	Rident, err := R.LocalIdentity()
+	assert.NoError(err)
+
+	ARex, err := A.Dial(Rident)
+	assert.NoError(err)
+	_, err = C.Dial(Rident)
+	assert.NoError(err)
+
+	x, err := FromEndpoint(A).Introduce(ARex, C.LocalHashname())
+	if assert.NoError(err) && assert.NotNil(x) {
+		assert.Equal(C.LocalHashname(), x.RemoteHashname())
+	}
+}
+
+func TestHolePunch(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var endpoints []*e3x.Endpoint
+	for i := 0; i < 3; i++ {
+		e, err := e3x.Open(
+			e3x.Log(nil),
+			e3x.Transport(udp.Config{Addr: "127.0.0.1:0"}),
+			Module(Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+		endpoints = append(endpoints, e)
+	}
+
+	var (
+		A = endpoints[0]
+		R = endpoints[1]
+		C = endpoints[2]
+	)
+
+	Rident, err := R.LocalIdentity()
+	assert.NoError(err)
+
+	ARex, err := A.Dial(Rident)
+	assert.NoError(err)
+	_, err = C.Dial(Rident)
+	assert.NoError(err)
+
+	// A and C only know each other's hashname; the router tells them where the
+	// other end is
+	_, err = FromEndpoint(A).Introduce(ARex, C.LocalHashname())
+	if !assert.NoError(err) {
+		return
+	}
+
+	direct := func(x *e3x.Exchange, to *e3x.Endpoint) bool {
+		ident, err := to.LocalIdentity()
+		if err != nil || x == nil {
+			return false
+		}
+		for _, path := range x.KnownPaths() {
+			for _, addr := range ident.Addresses() {
+				if transports.EqualAddr(path, addr) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if direct(A.GetExchange(C.LocalHashname()), C) && direct(C.GetExchange(A.LocalHashname()), A) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	assert.True(direct(A.GetExchange(C.LocalHashname()), C), "A has no direct path to C")
+	assert.True(direct(C.GetExchange(A.LocalHashname()), A), "C has no direct path to A")
+}
+
+func TestBridgeFallback(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	// A and C drop each other's packets; only the router gets through
+	var (
+		mtx     sync.Mutex
+		blocked []net.Addr
+	)
+	rule := fw.RuleFunc(func(src net.Addr) bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, addr := range blocked {
+			if transports.EqualAddr(addr, src) {
+				return false
+			}
+		}
+		return true
+	})
+
+	var endpoints []*e3x.Endpoint
+	for i := 0; i < 3; i++ {
+		e, err := e3x.Open(
+			e3x.Log(nil),
+			e3x.Transport(fw.Config{Config: udp.Config{Addr: "127.0.0.1:0"}, Allow: rule}),
+			Module(Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+		endpoints = append(endpoints, e)
+	}
+
+	var (
+		A = endpoints[0]
+		R = endpoints[1]
+		C = endpoints[2]
+	)
+
+	Aident, err := A.LocalIdentity()
+	assert.NoError(err)
+	Cident, err := C.LocalIdentity()
+	assert.NoError(err)
+	Rident, err := R.LocalIdentity()
+	assert.NoError(err)
+
+	ARex, err := A.Dial(Rident)
+	assert.NoError(err)
+	_, err = C.Dial(Rident)
+	assert.NoError(err)
+
+	mtx.Lock()
+	blocked = append(blocked, Aident.Addresses()...)
+	blocked = append(blocked, Cident.Addresses()...)
+	mtx.Unlock()
+
+	x, err := FromEndpoint(A).Introduce(ARex, C.LocalHashname())
+	if !assert.NoError(err) {
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		c, err := C.Listen("ping", true).AcceptChannel()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+
+		if _, err = c.ReadPacket(); err == nil {
+			err = c.WritePacket(&lob.Packet{})
+		}
+		done <- err
+	}()
+
+	ch, err := x.Open("ping", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer ch.Close()
+
+	assert.NoError(ch.WritePacket(&lob.Packet{}))
+	_, err = ch.ReadPacket()
+	assert.NoError(err)
+	assert.NoError(<-done)
+
+	_, bridged := x.ActivePath().(*peerAddr)
+	assert.True(bridged, "active path: %s", x.ActivePath())
+}
+
+func TestBridgeFallbackAfterIdleTimeout(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		mtx     sync.Mutex
+		blocked []net.Addr
+	)
+	rule := fw.RuleFunc(func(src net.Addr) bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, addr := range blocked {
+			if transports.EqualAddr(addr, src) {
+				return false
+			}
+		}
+		return true
+	})
+
+	var endpoints []*e3x.Endpoint
+	for i := 0; i < 3; i++ {
+		e, err := e3x.Open(
+			e3x.Log(nil),
+			e3x.Transport(fw.Config{Config: udp.Config{Addr: "127.0.0.1:0"}, Allow: rule}),
+			Module(Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+		endpoints = append(endpoints, e)
+	}
+
+	var (
+		A = endpoints[0]
+		R = endpoints[1]
+		C = endpoints[2]
+	)
+
+	Aident, err := A.LocalIdentity()
+	assert.NoError(err)
+	Cident, err := C.LocalIdentity()
+	assert.NoError(err)
+	Rident, err := R.LocalIdentity()
+	assert.NoError(err)
+
+	ARex, err := A.Dial(Rident)
+	assert.NoError(err)
+	_, err = C.Dial(Rident)
+	assert.NoError(err)
+
+	x, err := FromEndpoint(A).Introduce(ARex, C.LocalHashname())
+	if !assert.NoError(err) {
+		return
+	}
+
+	var (
+		modA = FromEndpoint(A).(*module)
+		modR = FromEndpoint(R).(*module)
+	)
+
+	routed := func() map[hashname.H]bool {
+		modR.mtx.RLock()
+		defer modR.mtx.RUnlock()
+
+		peers := make(map[hashname.H]bool)
+		for _, r := range modR.packetRoutes {
+			peers[r.x.RemoteHashname()] = true
+		}
+		return peers
+	}
+
+	// the router tears down the idle bridge and the direct path dies
+	modR.expireRoutes(time.Now().Add(2 * modR.config.BridgeIdleTimeout))
+	assert.Empty(routed())
+	mtx.Lock()
+	blocked = append(blocked, Aident.Addresses()...)
+	blocked = append(blocked, Cident.Addresses()...)
+	mtx.Unlock()
+
+	// the fallback requests the peer again, so the router bridges both ends
+	modA.fallbackToBridge(x, []hashname.H{R.LocalHashname()})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(routed()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	peers := routed()
+	assert.True(peers[A.LocalHashname()], "routes to A")
+	assert.True(peers[C.LocalHashname()], "routes to C")
+
+	// stopping twice doesn't panic
+	assert.NoError(modA.Stop())
+	assert.NoError(modA.Stop())
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		now = time.Now()
+		r   = newRoute(nil, 3000, now)
+	)
+
+	// a burst of one second worth of packets is allowed
+	assert.True(r.forward(1500, 3000, now))
+	assert.True(r.forward(1500, 3000, now))
+	assert.False(r.forward(1500, 3000, now))
+
+	// the allowance grows back over time
+	now = now.Add(250 * time.Millisecond)
+	assert.False(r.forward(1500, 3000, now))
+	now = now.Add(250 * time.Millisecond)
+	assert.True(r.forward(1500, 3000, now))
+
+	// the allowance never exceeds the rate
+	now = now.Add(time.Hour)
+	assert.True(r.forward(1500, 3000, now))
+	assert.True(r.forward(1500, 3000, now))
+	assert.False(r.forward(1500, 3000, now))
+
+	// no limit
+	assert.True(r.forward(1500, 0, now))
+}
+
+func TestExpireIdleRoutes(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := newBridge(nil, Config{BridgeIdleTimeout: time.Minute})
+	mod.log = logs.Module("bridge")
+
+	var (
+		now  = time.Now()
+		busy = cipherset.Token{1}
+		idle = cipherset.Token{2}
+	)
+
+	mod.packetRoutes[busy] = newRoute(&e3x.Exchange{}, 0, now)
+	mod.packetRoutes[idle] = newRoute(&e3x.Exchange{}, 0, now)
+
+	now = now.Add(45 * time.Second)
+	assert.True(mod.lookupToken(busy).forward(100, 0, now))
+	mod.expireRoutes(now)
+	assert.NotNil(mod.lookupToken(busy))
+	assert.NotNil(mod.lookupToken(idle))
+
+	now = now.Add(30 * time.Second)
+	mod.expireRoutes(now)
+	assert.NotNil(mod.lookupToken(busy))
+	assert.Nil(mod.lookupToken(idle))
+}
+
+func TestIntroductionAttempts(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mod     = newBridge(nil, Config{})
+		hn      = hashname.H("peer")
+		errPeer = errors.New("peer failed")
+	)
+
+	// a failed attempt leaves the other attempts waiting
+	i := mod.registerIntroduction(hn)
+	assert.True(i == mod.registerIntroduction(hn))
+	i.abandon(errPeer)
+	assert.True(i == mod.getIntroduction(hn))
+
+	// the last attempt to fail fails the introduction
+	i.abandon(errPeer)
+	assert.Nil(mod.getIntroduction(hn))
+	_, err := i.wait(context.Background())
+	assert.Equal(errPeer, err)
+
+	// a canceled wait abandons its attempt
+	i = mod.registerIntroduction(hn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = i.wait(ctx)
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Nil(mod.getIntroduction(hn))
+}
@@ -2,6 +2,8 @@ package bridge
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"net"
 
 	"github.com/telehash/gogotelehash/e3x"
 	"github.com/telehash/gogotelehash/e3x/cipherset"
@@ -9,11 +11,14 @@ import (
 	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
 )
 
 var mainLog = logs.Module("peers")
 
-func (mod *module) connect(ex *e3x.Exchange, inner *bufpool.Buffer) error {
+// connect delivers inner to the peer at the other end of ex. paths are the
+// addresses the sender of inner can be reached at directly.
+func (mod *module) connect(ex *e3x.Exchange, inner *bufpool.Buffer, paths []net.Addr) error {
 	ch, err := ex.Open("connect", false)
 	if err != nil {
 		return err
@@ -21,7 +26,12 @@ func (mod *module) connect(ex *e3x.Exchange, inner *bufpool.Buffer) error {
 
 	defer ch.Kill()
 
-	err = ch.WritePacket(lob.New(inner.RawBytes()))
+	pkt := lob.New(inner.RawBytes())
+	if len(paths) > 0 {
+		pkt.Header().Set("paths", paths)
+	}
+
+	err = ch.WritePacket(pkt)
 	if err != nil {
 		return err
 	}
@@ -37,7 +47,6 @@ func (mod *module) handle_connect(ch *e3x.Channel) {
 		localIdent  *e3x.Identity
 		remoteIdent *e3x.Identity
 		handshake   cipherset.Handshake
-		innerData   = bufpool.New()
 		err         error
 	)
 
@@ -51,9 +60,9 @@ func (mod *module) handle_connect(ch *e3x.Channel) {
 		return
 	}
 
-	pkt.Body(innerData.SetLen(pkt.BodyLen()).RawBytes()[:0])
+	paths := decodePaths(pkt)
 
-	inner, err := lob.Decode(innerData)
+	inner, err := pkt.BodyPacket()
 	if err != nil {
 		return
 	}
@@ -141,24 +150,36 @@ func (mod *module) handle_connect(ch *e3x.Channel) {
 		return
 	}
 
-	// when the BODY contains a handshake
-	if handshake != nil {
-		routerExchange := ch.Exchange()
-		routerAddr := &peerAddr{
-			router: routerExchange.RemoteHashname(),
-		}
+	// the router reached us; bridge through it until a better path is found.
+	// The bridge is added before any hole is punched so it is the path used
+	// when punching fails.
+	routerExchange := ch.Exchange()
+	routerAddr := &peerAddr{
+		router: routerExchange.RemoteHashname(),
+	}
 
-		conn := newConnection(x.RemoteHashname(), routerAddr, routerExchange, func() {
-			mod.unregisterConnection(routerExchange, x.LocalToken())
-		})
+	conn := newConnection(x.RemoteHashname(), routerAddr, routerExchange, func() {
+		mod.unregisterConnection(routerExchange, x.LocalToken())
+	})
 
-		pipe, added := x.AddPipeConnection(conn, nil)
-		if added {
-			mod.registerConnection(routerExchange, x.LocalToken(), conn)
-		}
+	pipe, added := x.AddPipeConnection(conn, nil)
+	if added {
+		mod.registerConnection(routerExchange, x.LocalToken(), conn)
+	}
 
+	// when the BODY contains a handshake
+	if handshake != nil {
 		resp, ok := x.ApplyHandshake(handshake, pipe)
 		if !ok {
+			// the same handshake may have arrived through a punched hole first
+			if i := mod.getIntroduction(from); i != nil && x.State().IsOpen() {
+				// the peer may still answer through the router, which only
+				// bridges those packets once it saw our token.
+				if pkt, err := x.GenerateHandshake(); err == nil {
+					mod.peerVia(ch.Exchange(), from, pkt)
+				}
+				i.resolve(x, nil)
+			}
 			return
 		}
 
@@ -183,6 +204,46 @@ func (mod *module) handle_connect(ch *e3x.Channel) {
 		}
 	}
 
+	// punch holes towards the direct paths of the peer; the peer does the same
+	// when it receives our handshake through the router.
+	for _, addr := range paths {
+		x.PunchHole(addr)
+	}
+
+	mod.addRouter(from, ch.RemoteHashname())
+
 	// Notify on-exchange callbacks
 	mod.getIntroduction(from).resolve(x, nil)
 }
+
+// decodePaths decodes the direct paths in the paths header of pkt.
+func decodePaths(pkt *lob.Packet) []net.Addr {
+	header, found := pkt.Header().Get("paths")
+	if !found {
+		return nil
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil
+	}
+
+	var paths []net.Addr
+	for _, entry := range entries {
+		addr, err := transports.DecodeAddr(entry)
+		if err != nil {
+			continue
+		}
+		if _, bridged := addr.(*peerAddr); bridged {
+			continue
+		}
+		paths = append(paths, addr)
+	}
+
+	return paths
+}
@@ -2,6 +2,7 @@ package bridge
 
 import (
 	"encoding/hex"
+	"net"
 
 	"github.com/telehash/gogotelehash/e3x"
 	"github.com/telehash/gogotelehash/e3x/cipherset"
@@ -106,5 +107,14 @@ func (mod *module) handle_peer(ch *e3x.Channel) {
 		mod.RouteToken(token, ch.Exchange())
 	}
 
-	mod.connect(ex, bufpool.New().Set(pkt.Body(nil)))
+	// tell the target where the requester is seen from here; the target punches
+	// a hole in its NAT by sending handshakes to these paths.
+	var paths []net.Addr
+	if addr := ch.Exchange().ActivePath(); addr != nil {
+		if _, bridged := addr.(*peerAddr); !bridged {
+			paths = append(paths, addr)
+		}
+	}
+
+	mod.connect(ex, bufpool.New().Set(pkt.Body(nil)), paths)
 }
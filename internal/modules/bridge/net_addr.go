@@ -55,7 +55,7 @@ func (*peerAddr) Network() string {
 }
 
 func (a *peerAddr) String() string {
-	return fmt.Sprintf("Peer{via: %q}", string(a.router[:8]))
+	return fmt.Sprintf("Peer{via: %q}", a.router.Short())
 }
 
 func (a *peerAddr) MarshalJSON() ([]byte, error) {
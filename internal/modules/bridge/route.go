@@ -0,0 +1,66 @@
+package bridge
+
+import (
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+)
+
+// route is a bridge kept by a router: the packets carrying its token are
+// forwarded to x.
+type route struct {
+	x *e3x.Exchange
+
+	mtx       sync.Mutex
+	lastSeen  time.Time
+	allowance float64 // the number of bytes which may be forwarded right now
+}
+
+func newRoute(x *e3x.Exchange, rate int, now time.Time) *route {
+	return &route{x: x, lastSeen: now, allowance: float64(rate)}
+}
+
+// forward reports whether a packet of n bytes may be forwarded at now. rate is
+// the limit in bytes per second; bursts of up to one second worth of packets are
+// allowed. A rate of zero or less means no limit.
+func (r *route) forward(n, rate int, now time.Time) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	elapsed := now.Sub(r.lastSeen)
+	r.lastSeen = now
+
+	if rate <= 0 {
+		return true
+	}
+
+	r.allowance += elapsed.Seconds() * float64(rate)
+	if r.allowance > float64(rate) {
+		r.allowance = float64(rate)
+	}
+
+	if r.allowance < float64(n) {
+		return false
+	}
+
+	r.allowance -= float64(n)
+	return true
+}
+
+// touch marks the route as used at now.
+func (r *route) touch(now time.Time) {
+	r.mtx.Lock()
+	if now.After(r.lastSeen) {
+		r.lastSeen = now
+	}
+	r.mtx.Unlock()
+}
+
+// idle reports whether the route was not used for longer than timeout.
+func (r *route) idle(timeout time.Duration, now time.Time) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return now.Sub(r.lastSeen) > timeout
+}
@@ -0,0 +1,165 @@
+package dht
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// ErrBootstrapFailed is returned by Bootstrap when none of the seeds could be
+// reached.
+var ErrBootstrapFailed = errors.New("dht: failed to reach any seed")
+
+const defaultSeedTimeout = 10 * time.Second
+
+// SeedStats records how reliable seeds were in the past. Bootstrap tries the
+// most reliable seeds first. SeedStats can be marshaled to (and unmarshaled
+// from) JSON so the stats can be persisted across restarts.
+type SeedStats struct {
+	mtx   sync.Mutex
+	seeds map[hashname.H]*SeedStat
+}
+
+// SeedStat holds the bootstrap results of a single seed.
+type SeedStat struct {
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+}
+
+// Score returns the reliability of the seed. It is the fraction of successful
+// attempts where an unknown seed scores 0.5.
+func (s SeedStat) Score() float64 {
+	return float64(s.Successes+1) / float64(s.Successes+s.Failures+2)
+}
+
+func NewSeedStats() *SeedStats {
+	return &SeedStats{seeds: make(map[hashname.H]*SeedStat)}
+}
+
+// Get returns the stats for the seed hn.
+func (s *SeedStats) Get(hn hashname.H) SeedStat {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if stat := s.seeds[hn]; stat != nil {
+		return *stat
+	}
+	return SeedStat{}
+}
+
+func (s *SeedStats) record(hn hashname.H, ok bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.seeds == nil {
+		s.seeds = make(map[hashname.H]*SeedStat)
+	}
+
+	stat := s.seeds[hn]
+	if stat == nil {
+		stat = &SeedStat{}
+		s.seeds[hn] = stat
+	}
+
+	if ok {
+		stat.Successes++
+		stat.LastSuccess = time.Now()
+	} else {
+		stat.Failures++
+	}
+}
+
+// order sorts seeds by decreasing reliability. Seeds with equal scores keep
+// their relative order.
+func (s *SeedStats) order(seeds []*e3x.Identity) []*e3x.Identity {
+	ordered := make([]*e3x.Identity, len(seeds))
+	copy(ordered, seeds)
+
+	scores := make(map[*e3x.Identity]float64, len(seeds))
+	for _, seed := range ordered {
+		scores[seed] = s.Get(seed.Hashname()).Score()
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return scores[ordered[i]] > scores[ordered[j]]
+	})
+
+	return ordered
+}
+
+func (s *SeedStats) MarshalJSON() ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return json.Marshal(s.seeds)
+}
+
+func (s *SeedStats) UnmarshalJSON(p []byte) error {
+	var seeds map[hashname.H]*SeedStat
+	if err := json.Unmarshal(p, &seeds); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	s.seeds = seeds
+	s.mtx.Unlock()
+	return nil
+}
+
+func (mod *module) Bootstrap(seeds []*e3x.Identity) (*e3x.Exchange, error) {
+	if mod.config.Store != nil {
+		exchanges, err := mod.Load(mod.config.Store)
+		if err == nil {
+			return exchanges[0], nil
+		}
+		mod.log.Printf("bootstrap: rejoin failed: %s", err)
+	}
+
+	for _, seed := range mod.config.SeedStats.order(seeds) {
+		x, err := mod.dialSeed(seed)
+		mod.config.SeedStats.record(seed.Hashname(), err == nil)
+		if err == nil {
+			return x, nil
+		}
+
+		mod.log.Printf("bootstrap: failed to reach %s: %s", seed.Hashname().Short(), err)
+	}
+
+	return nil, ErrBootstrapFailed
+}
+
+// dialSeed dials seed and gives up after config.SeedTimeout.
+func (mod *module) dialSeed(seed *e3x.Identity) (*e3x.Exchange, error) {
+	return mod.dialWithin(seed, mod.config.SeedTimeout)
+}
+
+// dialWithin dials ident and gives up when no exchange was opened within
+// timeout.
+func (mod *module) dialWithin(ident *e3x.Identity, timeout time.Duration) (*e3x.Exchange, error) {
+	type result struct {
+		x   *e3x.Exchange
+		err error
+	}
+
+	c := make(chan result, 1)
+	go func() {
+		x, err := mod.e.Dial(ident)
+		c <- result{x, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-c:
+		return r.x, r.err
+	case <-timer.C:
+		return nil, e3x.ErrTimeout
+	}
+}
@@ -0,0 +1,183 @@
+package dht
+
+import (
+	"sort"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+const (
+	// maxCandidates limits the number of candidates which are remembered. The
+	// least valuable candidates are evicted first (see admitCandidate).
+	maxCandidates = 1024
+
+	// maxSourcesPerCandidate limits the number of sources which are remembered
+	// for a candidate. The sources which least recently named the candidate
+	// are forgotten first.
+	maxSourcesPerCandidate = 8
+)
+
+// Candidate is a peer which was named in the see response of one or more
+// other peers.
+type Candidate struct {
+	Hashname hashname.H
+
+	// Sources are the peers whose see responses named the candidate, ordered
+	// from the least to the most recent. At most 8 sources are kept.
+	Sources []hashname.H
+
+	// FirstSeen is the time the candidate was first named.
+	FirstSeen time.Time
+
+	// Identity is the identity of the candidate, including the paths at which
+	// it is reachable, as reported by the source which most recently included
+	// it in a see response. It is nil when no source reported it.
+	Identity *e3x.Identity `json:",omitempty"`
+}
+
+func (mod *module) Candidates() []Candidate {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	l := make([]Candidate, 0, len(mod.candidates))
+	for _, c := range mod.candidates {
+		l = append(l, c.clone())
+	}
+
+	sort.Sort(byHashname(l))
+	return l
+}
+
+// addCandidates records that source named the peers in see.
+func (mod *module) addCandidates(source hashname.H, see []hashname.H) {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	local := mod.e.LocalHashname()
+	for _, hn := range see {
+		if hn == local || hn == source {
+			continue
+		}
+
+		c := mod.candidates[hn]
+		if c == nil {
+			c = mod.admitCandidate(hn, time.Now())
+			if c == nil {
+				continue
+			}
+		}
+
+		c.addSource(source)
+	}
+}
+
+// admitCandidate adds a candidate for hn. When the candidates are full the
+// least valuable candidate (the one named by the fewest sources and, among
+// those, the one first seen longest ago) is evicted to make room, unless it is
+// more valuable than the newcomer (which has a single source). nil is returned
+// when the newcomer is not admitted. mod.mtx must be held.
+func (mod *module) admitCandidate(hn hashname.H, now time.Time) *Candidate {
+	if len(mod.candidates) >= maxCandidates {
+		var worst *Candidate
+		for _, c := range mod.candidates {
+			if worst == nil || c.lessValuable(worst) {
+				worst = c
+			}
+		}
+		if worst == nil || len(worst.Sources) > 1 {
+			return nil
+		}
+		delete(mod.candidates, worst.Hashname)
+	}
+
+	c := &Candidate{Hashname: hn, FirstSeen: now}
+	mod.candidates[hn] = c
+	return c
+}
+
+func (c *Candidate) lessValuable(o *Candidate) bool {
+	if len(c.Sources) != len(o.Sources) {
+		return len(c.Sources) < len(o.Sources)
+	}
+	return c.FirstSeen.Before(o.FirstSeen)
+}
+
+// addIdentities records the identities of the candidates in see. Identities
+// of peers which are not in see are ignored.
+func (mod *module) addIdentities(see []hashname.H, idents []*e3x.Identity) {
+	if len(idents) == 0 {
+		return
+	}
+
+	named := make(map[hashname.H]bool, len(see))
+	for _, hn := range see {
+		named[hn] = true
+	}
+
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	for _, ident := range idents {
+		hn := ident.Hashname()
+		if !named[hn] {
+			continue
+		}
+		if c := mod.candidates[hn]; c != nil {
+			c.Identity = ident
+		}
+	}
+}
+
+// identity returns the reported identity of hn (nil when none was reported).
+func (mod *module) identity(hn hashname.H) *e3x.Identity {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	if c := mod.candidates[hn]; c != nil {
+		return c.Identity
+	}
+	return nil
+}
+
+// sources returns the peers which named hn (nil when hn was never named).
+func (mod *module) sources(hn hashname.H) []hashname.H {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	if c := mod.candidates[hn]; c != nil {
+		return c.clone().Sources
+	}
+	return nil
+}
+
+// addSource moves (or adds) hn to the end of the sources and forgets the least
+// recent sources beyond maxSourcesPerCandidate.
+func (c *Candidate) addSource(hn hashname.H) {
+	for i, src := range c.Sources {
+		if src == hn {
+			copy(c.Sources[i:], c.Sources[i+1:])
+			c.Sources = c.Sources[:len(c.Sources)-1]
+			break
+		}
+	}
+
+	c.Sources = append(c.Sources, hn)
+
+	if n := len(c.Sources) - maxSourcesPerCandidate; n > 0 {
+		c.Sources = append(c.Sources[:0], c.Sources[n:]...)
+	}
+}
+
+func (c *Candidate) clone() Candidate {
+	d := *c
+	d.Sources = append([]hashname.H(nil), c.Sources...)
+	return d
+}
+
+type byHashname []Candidate
+
+func (s byHashname) Len() int           { return len(s) }
+func (s byHashname) Less(i, j int) bool { return s[i].Hashname < s[j].Hashname }
+func (s byHashname) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
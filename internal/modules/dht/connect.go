@@ -0,0 +1,136 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/modules/bridge"
+)
+
+// ErrNoRouters is returned by Connect when none of the peers which named the
+// candidate are linked.
+var ErrNoRouters = errors.New("dht: no linked routers for candidate")
+
+// ErrNoBridge is returned by Connect when the bridge module is not registered.
+var ErrNoBridge = errors.New("dht: connect requires the bridge module")
+
+const defaultConnectFanout = 2
+
+func (mod *module) Connect(hn hashname.H) (*e3x.Exchange, error) {
+	if x := mod.e.GetExchange(hn); x != nil {
+		return x, nil
+	}
+
+	if x, err := mod.dialReported(hn, mod.config.LookupTimeout); err == nil {
+		return x, nil
+	}
+
+	b := bridge.FromEndpoint(mod.e)
+	if b == nil {
+		return nil, ErrNoBridge
+	}
+
+	// the most recent sources are asked first
+	var (
+		sources = mod.sources(hn)
+		routers []*e3x.Exchange
+	)
+	for i := len(sources) - 1; i >= 0; i-- {
+		if x := mod.exchangeFor(sources[i]); x != nil {
+			routers = append(routers, x)
+		}
+	}
+	if len(routers) == 0 {
+		return nil, ErrNoRouters
+	}
+
+	ctx, cancel := mod.stopContext()
+	defer cancel()
+
+	x, err := connectVia(ctx, len(routers), mod.config.ConnectFanout, func(ctx context.Context, i int) (*e3x.Exchange, error) {
+		return b.IntroduceContext(ctx, routers[i], hn)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mod.linkExchange(x)
+	return x, nil
+}
+
+// dialReported dials hn directly at the paths reported by its sources (see
+// Candidate.Identity) and links it. It gives up after timeout.
+func (mod *module) dialReported(hn hashname.H, timeout time.Duration) (*e3x.Exchange, error) {
+	ident := mod.identity(hn)
+	if ident == nil {
+		return nil, ErrNoIdentity
+	}
+
+	x, err := mod.dialWithin(ident, timeout)
+	if err != nil {
+		mod.log.Printf("dial: %s is unreachable at its reported paths: %s", hn.Short(), err)
+		return nil, err
+	}
+
+	mod.linkExchange(x)
+	return x, nil
+}
+
+// connectVia calls introduce for up to fanout of the n routers at once and
+// returns the first exchange to be introduced. A router is only asked when one
+// of the pending attempts failed. Once an attempt succeeded no other routers are
+// asked and the context passed to the pending attempts is canceled. The error of
+// the last attempt is returned when all of them failed.
+func connectVia(ctx context.Context, n, fanout int, introduce func(ctx context.Context, i int) (*e3x.Exchange, error)) (*e3x.Exchange, error) {
+	type result struct {
+		x   *e3x.Exchange
+		err error
+	}
+
+	if fanout <= 0 {
+		fanout = defaultConnectFanout
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		results = make(chan result, n)
+		next    = 0
+		pending = 0
+		err     error
+	)
+
+	start := func() {
+		i := next
+		next++
+		pending++
+		go func() {
+			x, err := introduce(ctx, i)
+			results <- result{x, err}
+		}()
+	}
+
+	for next < n && pending < fanout {
+		start()
+	}
+
+	for pending > 0 {
+		r := <-results
+		pending--
+
+		if r.err == nil {
+			return r.x, nil
+		}
+		err = r.err
+
+		if next < n {
+			start()
+		}
+	}
+
+	return nil, err
+}
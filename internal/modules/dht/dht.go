@@ -0,0 +1,619 @@
+// Package dht maintains a Kademlia style routing table of the peers an
+// endpoint has open exchanges with, and answers seek requests from those peers.
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+type Config struct {
+	// K is the maximum number of peers kept in a bucket and the maximum
+	// number of peers returned in a see response. Defaults to 8.
+	K int
+
+	// PreferFresh biases peer selection toward fresh peers. When set, peers
+	// which fall in the same bucket relative to the target are ordered by how
+	// recently they were seen rather than by their exact distance.
+	PreferFresh bool
+
+	// PreferFast biases peer selection toward fast peers. When set, peers
+	// which fall in the same bucket relative to the target are ordered by
+	// their RTT (see PeerInfo) rather than by their exact distance. It applies
+	// to the peers sought by lookups and to the peers returned in see
+	// responses, and takes precedence over PreferFresh.
+	PreferFast bool
+
+	// StaleAfter enables the dead-peer sweep. Peers which were not seen within
+	// StaleAfter are pinged and removed from the table when they don't respond.
+	// The sweep is disabled when StaleAfter is zero.
+	StaleAfter time.Duration
+
+	// SweepInterval is the time between two sweeps. Defaults to 30s.
+	SweepInterval time.Duration
+
+	// MaxPingsPerSweep limits the number of peers pinged during a single
+	// sweep; the stalest peers are pinged first. Defaults to 4.
+	MaxPingsPerSweep int
+
+	// PingTimeout is the time a stale peer has to respond to a ping.
+	// Defaults to 10s.
+	PingTimeout time.Duration
+
+	// JoinFill fills the table when the first exchange is opened. The local
+	// hashname and a random hashname in each bucket are looked up, starting at
+	// the first peer; the peers queried by those lookups are connected through
+	// the bridge module (which must be registered as well).
+	JoinFill bool
+
+	// SeedStats records the reliability of the seeds passed to Bootstrap.
+	// Pass previously persisted stats to keep the ordering across restarts.
+	// Defaults to empty stats.
+	SeedStats *SeedStats
+
+	// SeedTimeout is the time Bootstrap waits for a seed to respond before it
+	// moves on to the next seed. Defaults to 10s.
+	SeedTimeout time.Duration
+
+	// Rand is the source of the random hashnames sought by RefreshBucket and
+	// JoinFill. Together with OrderedLookups a seeded source produces the same
+	// sequence of queries given the same network, which makes lookups
+	// reproducible in tests. Defaults to crypto/rand.
+	Rand io.Reader
+
+	// OrderedLookups makes a lookup handle the responses to its queries in the
+	// order the queries were sent instead of the order in which they arrive, so
+	// the peers it queries don't depend on which peers respond first. A slow
+	// peer holds up the whole lookup; meant for tests.
+	OrderedLookups bool
+
+	// MinHealthyPeers is the number of peers the routing table must hold for
+	// IsHealthy to report the node as healthy. Defaults to 3.
+	MinHealthyPeers int
+
+	// MinHealthyBuckets is the number of non-empty buckets the routing table
+	// must have for IsHealthy to report the node as healthy. Defaults to 2.
+	MinHealthyBuckets int
+
+	// HealthyLookupAge is the maximum age of the last successful lookup (a seek
+	// made by Lookup, RefreshBucket or JoinFill) for IsHealthy to report the
+	// node as healthy. Recent lookups are not required when HealthyLookupAge is
+	// zero.
+	HealthyLookupAge time.Duration
+
+	// ConnectFanout is the number of routers Connect asks at once to relay an
+	// introduction to a candidate. The first introduction to complete is used
+	// and the other routers are not asked. Defaults to 2.
+	ConnectFanout int
+
+	// LookupWindow is the sliding window over which LookupSuccessRate
+	// computes the fraction of successful seeks. Defaults to 10m.
+	LookupWindow time.Duration
+
+	// RefreshInterval enables the refresh loop, which refreshes one bucket
+	// (see RefreshBucket) every RefreshInterval. The loop is disabled when
+	// RefreshInterval is zero.
+	RefreshInterval time.Duration
+
+	// SeeLimit, when set, is consulted for every seek request to limit the
+	// number of peers returned to requester (at most K are returned). This
+	// limits how much of the routing table is exposed to untrusted peers; no
+	// peers are returned when SeeLimit returns zero or less. Seeks for the
+	// local hashname are always answered.
+	SeeLimit func(requester hashname.H) int
+
+	// RefreshStrategy chooses the bucket refreshed by the refresh loop.
+	// Defaults to RefreshOldestFirst.
+	RefreshStrategy RefreshStrategy
+
+	// Alpha is the number of seeks Lookup has outstanding at once.
+	// Defaults to 3.
+	Alpha int
+
+	// LookupTimeout is the time a peer has to answer a seek made by Lookup,
+	// including the time it takes to connect to the peer. Peers which don't
+	// answer in time are skipped. Defaults to 10s.
+	LookupTimeout time.Duration
+
+	// SigningKey signs the values published with Put. Pass a persisted key to
+	// keep replacing the same values across restarts. Defaults to a key
+	// generated when the endpoint is opened.
+	SigningKey ed25519.PrivateKey
+
+	// ValueTTL is the time a value published with Put is stored when no TTL is
+	// given. Defaults to 24h.
+	ValueTTL time.Duration
+
+	// RepublishInterval is the time between two replications of the values
+	// published with Put; a value is republished until it expires. Defaults
+	// to 1h.
+	RepublishInterval time.Duration
+
+	// MaxValueSize is the maximum size of the data of a value, both for values
+	// published with Put and for the values stored for peers. Defaults to 1024
+	// bytes.
+	MaxValueSize int
+
+	// MaxValueTTL is the maximum TTL of a value. Put shortens longer TTLs and
+	// values with a longer TTL are not stored for peers. Defaults to 7 days.
+	MaxValueTTL time.Duration
+
+	// MaxStoredValues is the maximum number of values stored for peers. Once
+	// it is reached values for new keys are refused until stored values
+	// expire. Defaults to 4096.
+	MaxStoredValues int
+
+	// Store enables warm restarts. The routing table is saved in Store every
+	// SaveInterval and Bootstrap rejoins through the saved peers before it
+	// dials any seeds.
+	Store Store
+
+	// SaveInterval is the time between two saves of the routing table in
+	// Store. Defaults to 5m.
+	SaveInterval time.Duration
+
+	// MaintainInterval enables the bucket maintenance loop. Every
+	// MaintainInterval the least recently seen peer of each bucket is pinged,
+	// unless it was seen since the previous round. Peers which don't respond
+	// within PingTimeout are evicted and replaced by a candidate in the same
+	// bucket (see Connect). Buckets which saw no lookups within
+	// IdleBucketAfter are refreshed (see RefreshBucket). The loop is disabled
+	// when MaintainInterval is zero.
+	MaintainInterval time.Duration
+
+	// IdleBucketAfter is the time after which the maintenance loop refreshes a
+	// bucket which saw no lookups. The buckets are refreshed at the earliest
+	// IdleBucketAfter after the endpoint started. Defaults to 1h.
+	IdleBucketAfter time.Duration
+}
+
+// PeerInfo describes a peer in the routing table.
+type PeerInfo struct {
+	Hashname hashname.H
+
+	// Bucket is the index of the bucket holding the peer.
+	Bucket int
+
+	// LastSeen is the last time the peer was validated. A peer is validated
+	// when an exchange with it is opened and whenever a seek request or a see
+	// response is received from it.
+	LastSeen time.Time
+
+	// Sources are the peers whose see responses named the peer (see
+	// Candidate). Sources is empty for peers which were never named.
+	Sources []hashname.H
+
+	// RTT is the smoothed round-trip time of the seeks answered by the peer,
+	// including the pings of the sweep and of the maintenance loop. It is zero
+	// when the peer answered no seeks yet.
+	RTT time.Duration
+}
+
+type DHT interface {
+	// Seek asks the peer at the other end of x for the peers it knows which are
+	// closest to target.
+	Seek(x *e3x.Exchange, target hashname.H) ([]hashname.H, error)
+
+	// SeekContext is like Seek but returns ctx.Err() once ctx is done.
+	SeekContext(ctx context.Context, x *e3x.Exchange, target hashname.H) ([]hashname.H, error)
+
+	// SeekIdentity asks the peer at the other end of x for its own identity,
+	// including the addresses at which it is reachable. A peer answers seeks
+	// for its own hashname authoritatively (rather than with its neighbors).
+	SeekIdentity(x *e3x.Exchange) (*e3x.Identity, error)
+
+	// Closest returns the n known peers which are closest to target.
+	Closest(target hashname.H, n int) []hashname.H
+
+	// ClosestWithLatency returns the n known peers which are closest to target
+	// along with their RTT. Peers which fall in the same bucket relative to
+	// target are ordered by their RTT, fastest first, regardless of
+	// Config.PreferFast.
+	ClosestWithLatency(target hashname.H, n int) []PeerInfo
+
+	// Peers returns a snapshot of the peers in the routing table.
+	Peers() []PeerInfo
+
+	// WalkFromKey calls fn for each known peer in order of increasing XOR
+	// distance to key until fn returns false. The routing table is locked
+	// while walking so fn must not call into the DHT.
+	WalkFromKey(key []byte, fn func(hn hashname.H) bool)
+
+	// Candidates returns the peers which were named in see responses along
+	// with the peers that named them.
+	Candidates() []Candidate
+
+	// RefreshBucket repopulates bucket idx by looking up a random hashname in
+	// its range and connecting to the peers which are found (through the bridge
+	// module). ErrInvalidBucket is returned when idx is out of range.
+	RefreshBucket(idx int) error
+
+	// DryRunSeek returns the seeks a lookup of target would make, in order,
+	// as planned from the current routing table. The responses of the peers
+	// are predicted from the peers they named before (see Candidates). The
+	// plan is computed without any network I/O and without modifying the
+	// routing table or the candidates.
+	DryRunSeek(target hashname.H) []PlannedSeek
+
+	// Bootstrap dials seeds, most reliable first, until one of them responds.
+	// The result of each attempt is recorded in Config.SeedStats. When
+	// Config.Store is set the saved peers are dialed first (see Load) and the
+	// seeds are only dialed when none of them responds. ErrBootstrapFailed is
+	// returned when none of the seeds responded.
+	Bootstrap(seeds []*e3x.Identity) (*e3x.Exchange, error)
+
+	// Save saves the identities of the peers in the routing table, and the
+	// candidates, in store. Nothing is saved when the table is empty, so the
+	// previous neighbors are kept; call Save before the endpoint is closed as
+	// closing the exchanges empties the table.
+	Save(store Store) error
+
+	// Load dials the peers saved in store, up to K at once, and restores the
+	// saved candidates. The exchanges with the peers which responded are
+	// returned. ErrRejoinFailed is returned when no peer responded (or none
+	// were saved).
+	Load(store Store) ([]*e3x.Exchange, error)
+
+	// IsHealthy returns true when the local node is embedded well enough in the
+	// DHT to route reliably. It considers the number of peers, the number of
+	// non-empty buckets and the time of the last successful lookup (see the
+	// MinHealthyPeers, MinHealthyBuckets and HealthyLookupAge options). The
+	// report lists the deficiencies of an unhealthy node.
+	IsHealthy() (bool, HealthReport)
+
+	// KeyspaceCoverage returns the number of peers in each bucket of the
+	// routing table, indexed by bucket. Bucket i holds the peers whose XOR
+	// distance d to the local hashname satisfies 2^i <= d < 2^(i+1); it covers
+	// 1/2^(256-i) of the keyspace. Bucket 255 (the farthest half of the
+	// keyspace) comes last. A well embedded node has peers in the high buckets
+	// and progressively fewer towards the low ones; empty buckets between
+	// non-empty ones are gaps in the coverage.
+	KeyspaceCoverage() []int
+
+	// Connect opens an exchange with the candidate hn. When a source reported
+	// the identity of hn (see Candidate) hn is first dialed directly. Otherwise,
+	// or when hn can't be reached within LookupTimeout, the linked peers which
+	// named it (most recent first) are asked to introduce the local endpoint
+	// through the bridge module. Up to ConnectFanout routers are asked at once.
+	// ErrNoRouters is returned when none of the sources of hn are linked.
+	Connect(hn hashname.H) (*e3x.Exchange, error)
+
+	// PeerReachability returns an approximation of the number of hops between
+	// the local node and hn, based on the linked peers, the routing table and
+	// the candidates. It doesn't make any network requests.
+	PeerReachability(hn hashname.H) Reachability
+
+	// Lookup iteratively seeks the K peers closest to target. It starts with
+	// the closest peers in the routing table and asks Alpha peers at once;
+	// the peers they return are connected to (directly when their identity
+	// was reported, through the bridge module otherwise) and asked in turn. The lookup converges when the K closest peers which
+	// answered have all been asked. Those peers are returned, closest first.
+	// ErrLookupFailed is returned when no peer answered.
+	Lookup(target hashname.H) ([]hashname.H, error)
+
+	// LookupContext is like Lookup but returns ctx.Err() once ctx is done. The
+	// seeks which are outstanding at that moment are abandoned.
+	LookupContext(ctx context.Context, target hashname.H) ([]hashname.H, error)
+
+	// Put signs data with the SigningKey and stores it under the key
+	// ValueKey(public key, name) at the K peers closest to that key. A ttl of
+	// zero means ValueTTL; a ttl above MaxValueTTL is shortened to
+	// MaxValueTTL. The value is republished every RepublishInterval
+	// until it expires. ErrStoreFailed is returned (along with the value) when
+	// none of the peers stored it.
+	Put(name string, data []byte, ttl time.Duration) (*Value, error)
+
+	// Get returns the value stored under key. The value is looked up at the K
+	// peers closest to key unless it is stored locally. ErrValueNotFound is
+	// returned when none of those peers stores a valid value.
+	Get(key []byte) (*Value, error)
+
+	// LookupSuccessRate returns the fraction of the seeks made within the
+	// LookupWindow which returned at least one peer. A declining rate is an
+	// early sign of lost connectivity. 1 is returned when no seeks were made
+	// within the window.
+	LookupSuccessRate() float64
+}
+
+type module struct {
+	mtx        sync.Mutex
+	e          *e3x.Endpoint
+	config     Config
+	table      *table
+	listeners  []*e3x.Listener
+	links      map[*e3x.Exchange]hashname.H
+	seekers    map[*e3x.Exchange]*seeker
+	pinging    map[hashname.H]bool
+	candidates map[hashname.H]*Candidate
+	values     map[string]*Value // stored for peers, by key
+	published  map[string]*Value // published with Put, by key
+	joined     bool
+	lastLookup time.Time
+	lookups    *lookupWindow
+	refreshed  [numBuckets]time.Time
+	done       chan struct{}
+	stopOnce   sync.Once
+	log        *logs.Logger
+}
+
+type moduleKeyType string
+
+const moduleKey = moduleKeyType("dht")
+
+const defaultK = 8
+
+// channelTypes are the types of the channels the module opens and accepts.
+var channelTypes = []string{"seek", "store", "fetch"}
+
+func Module(config Config) e3x.EndpointOption {
+	return func(e *e3x.Endpoint) error {
+		return e3x.RegisterModule(moduleKey, newDHT(e, config))(e)
+	}
+}
+
+func FromEndpoint(e *e3x.Endpoint) DHT {
+	mod := e.Module(moduleKey)
+	if mod == nil {
+		return nil
+	}
+	return mod.(*module)
+}
+
+func newDHT(e *e3x.Endpoint, config Config) *module {
+	if config.K <= 0 {
+		config.K = defaultK
+	}
+	if config.SweepInterval <= 0 {
+		config.SweepInterval = defaultSweepInterval
+	}
+	if config.MaxPingsPerSweep <= 0 {
+		config.MaxPingsPerSweep = defaultMaxPingsPerSweep
+	}
+	if config.PingTimeout <= 0 {
+		config.PingTimeout = seekTimeout
+	}
+	if config.SeedStats == nil {
+		config.SeedStats = NewSeedStats()
+	}
+	if config.SeedTimeout <= 0 {
+		config.SeedTimeout = defaultSeedTimeout
+	}
+	if config.Rand == nil {
+		config.Rand = rand.Reader
+	}
+	config.Rand = &lockedReader{r: config.Rand}
+	if config.MinHealthyPeers <= 0 {
+		config.MinHealthyPeers = defaultMinHealthyPeers
+	}
+	if config.MinHealthyBuckets <= 0 {
+		config.MinHealthyBuckets = defaultMinHealthyBuckets
+	}
+	if config.ConnectFanout <= 0 {
+		config.ConnectFanout = defaultConnectFanout
+	}
+	if config.LookupWindow <= 0 {
+		config.LookupWindow = defaultLookupWindow
+	}
+	if config.RefreshStrategy == nil {
+		config.RefreshStrategy = RefreshOldestFirst
+	}
+	if config.Alpha <= 0 {
+		config.Alpha = defaultAlpha
+	}
+	if config.LookupTimeout <= 0 {
+		config.LookupTimeout = seekTimeout
+	}
+	if config.ValueTTL <= 0 {
+		config.ValueTTL = defaultValueTTL
+	}
+	if config.RepublishInterval <= 0 {
+		config.RepublishInterval = defaultRepublishInterval
+	}
+	if config.MaxValueSize <= 0 {
+		config.MaxValueSize = defaultMaxValueSize
+	}
+	if config.MaxValueTTL <= 0 {
+		config.MaxValueTTL = defaultMaxValueTTL
+	}
+	if config.MaxStoredValues <= 0 {
+		config.MaxStoredValues = defaultMaxStoredValues
+	}
+	if config.SaveInterval <= 0 {
+		config.SaveInterval = defaultSaveInterval
+	}
+	if config.IdleBucketAfter <= 0 {
+		config.IdleBucketAfter = defaultIdleBucketAfter
+	}
+
+	return &module{
+		e:          e,
+		config:     config,
+		links:      make(map[*e3x.Exchange]hashname.H),
+		seekers:    make(map[*e3x.Exchange]*seeker),
+		pinging:    make(map[hashname.H]bool),
+		done:       make(chan struct{}),
+		candidates: make(map[hashname.H]*Candidate),
+		values:     make(map[string]*Value),
+		published:  make(map[string]*Value),
+		lookups:    newLookupWindow(config.LookupWindow),
+	}
+}
+
+func (mod *module) Init() error {
+	mod.log = logs.Module("dht").From(mod.e.LocalHashname())
+
+	table, err := newTable(mod.e.LocalHashname(), mod.config.K, mod.config.PreferFresh)
+	if err != nil {
+		return err
+	}
+	table.preferFast = mod.config.PreferFast
+	mod.table = table
+
+	if mod.config.SigningKey == nil {
+		_, mod.config.SigningKey, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+	}
+
+	mod.e.DefaultExchangeHooks().Register(e3x.ExchangeHook{
+		OnOpened: mod.on_exchange_opened,
+		OnClosed: mod.on_exchange_closed,
+	})
+
+	return nil
+}
+
+func (mod *module) Start() error {
+	mod.acceptChannels("seek", mod.handle_seek)
+	mod.acceptChannels("store", mod.handle_store)
+	mod.acceptChannels("fetch", mod.handle_fetch)
+
+	go mod.republish()
+
+	if mod.config.Store != nil {
+		go mod.autosave()
+	}
+
+	if mod.config.StaleAfter > 0 {
+		go mod.sweep()
+	}
+
+	if mod.config.RefreshInterval > 0 {
+		go mod.refresh()
+	}
+
+	if mod.config.MaintainInterval > 0 {
+		go mod.maintain()
+	}
+
+	return nil
+}
+
+func (mod *module) Stop() error {
+	mod.stopOnce.Do(func() {
+		close(mod.done)
+		for _, l := range mod.listeners {
+			l.Close()
+		}
+	})
+
+	return nil
+}
+
+// stopContext returns a context which is canceled once the module is stopped
+// or cancel is called.
+func (mod *module) stopContext() (ctx context.Context, cancel context.CancelFunc) {
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-mod.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (mod *module) Closest(target hashname.H, n int) []hashname.H {
+	return mod.table.closest(target, n)
+}
+
+func (mod *module) ClosestWithLatency(target hashname.H, n int) []PeerInfo {
+	peers := mod.table.closestPeers(target, n, true)
+
+	l := make([]PeerInfo, len(peers))
+	for i, p := range peers {
+		l[i] = PeerInfo{
+			Hashname: p.hashname,
+			Bucket:   bucketIndex(distance(mod.table.local, p.key)),
+			LastSeen: p.lastSeen,
+			Sources:  mod.sources(p.hashname),
+			RTT:      p.rtt,
+		}
+	}
+	return l
+}
+
+func (mod *module) WalkFromKey(key []byte, fn func(hn hashname.H) bool) {
+	mod.table.walk(key, fn)
+}
+
+func (mod *module) Peers() []PeerInfo {
+	peers := mod.table.snapshot()
+	for i := range peers {
+		peers[i].Sources = mod.sources(peers[i].Hashname)
+	}
+	return peers
+}
+
+// acceptChannels listens for unreliable channels of type typ and handles each
+// of them with handle in its own goroutine.
+func (mod *module) acceptChannels(typ string, handle func(c *e3x.Channel)) {
+	l := mod.e.Listen(typ, false)
+	mod.listeners = append(mod.listeners, l)
+
+	go func() {
+		for {
+			c, err := l.AcceptChannel()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				continue
+			}
+			go handle(c)
+		}
+	}()
+}
+
+// linkExchange links the peer of x, which the module opened itself. The
+// exchange hooks run asynchronously; without this the peer would only be linked
+// some time after the exchange was returned.
+func (mod *module) linkExchange(x *e3x.Exchange) {
+	mod.on_exchange_opened(mod.e, x)
+}
+
+func (mod *module) on_exchange_opened(e *e3x.Endpoint, x *e3x.Exchange) error {
+	hn := x.RemoteHashname()
+
+	mod.mtx.Lock()
+	mod.links[x] = hn
+	join := mod.config.JoinFill && !mod.joined
+	mod.joined = true
+	mod.mtx.Unlock()
+
+	mod.addPeer(hn)
+
+	if join {
+		go mod.joinFill(x)
+	}
+
+	return nil
+}
+
+func (mod *module) on_exchange_closed(e *e3x.Endpoint, x *e3x.Exchange, reason error) error {
+	mod.mtx.Lock()
+	hn, linked := mod.links[x]
+	s := mod.seekers[x]
+	delete(mod.links, x)
+	delete(mod.seekers, x)
+	mod.mtx.Unlock()
+
+	if linked {
+		mod.removePeer(hn, reason)
+	}
+	if s != nil {
+		s.close()
+	}
+
+	return nil
+}
@@ -0,0 +1,1854 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/modules/bridge"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestSeekResponsesAreAttributedByNonce(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		s  = newSeeker(nil, nil)
+		c1 = make(chan seeResponse, 1)
+		c2 = make(chan seeResponse, 1)
+		h1 = hashname.H("nzf4f6j7ylv53z3m4egrwltv2t2yks4rtpaimeg3avwqsoshqxba")
+		h2 = hashname.H("jvdoio6kjvf3yqnxfvck43twaibbg4pmb7y3mqnvxafb26rqllwa")
+	)
+
+	s.pending["0001"] = c1
+	s.pending["0002"] = c2
+
+	// responses arrive in the reverse order of the requests
+	s.received(wirePacket(t, "0002", h2))
+	s.received(wirePacket(t, "0001", h1))
+
+	// a response for an unknown nonce is dropped
+	s.received(wirePacket(t, "0003", h1))
+
+	assert.Equal([]hashname.H{h1}, (<-c1).see)
+	assert.Equal([]hashname.H{h2}, (<-c2).see)
+	assert.Empty(s.pending)
+}
+
+func TestSeek(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A := openEndpoint(t, Module(Config{}))
+	B := openEndpoint(t, Module(Config{}))
+	C := openEndpoint(t, Module(Config{}))
+	D := openEndpoint(t, Module(Config{}))
+	defer A.Close()
+	defer B.Close()
+	defer C.Close()
+	defer D.Close()
+
+	Bident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	x, err := A.Dial(Bident)
+	assert.NoError(err)
+	_, err = C.Dial(Bident)
+	assert.NoError(err)
+	_, err = D.Dial(Bident)
+	assert.NoError(err)
+
+	var (
+		wg  sync.WaitGroup
+		mtx sync.Mutex
+		res = map[hashname.H][]hashname.H{}
+	)
+
+	for _, target := range []hashname.H{C.LocalHashname(), D.LocalHashname()} {
+		wg.Add(1)
+		go func(target hashname.H) {
+			defer wg.Done()
+			see, err := FromEndpoint(A).Seek(x, target)
+			assert.NoError(err)
+
+			mtx.Lock()
+			res[target] = see
+			mtx.Unlock()
+		}(target)
+	}
+	wg.Wait()
+
+	for _, target := range []hashname.H{C.LocalHashname(), D.LocalHashname()} {
+		see := res[target]
+		if assert.Len(see, 2) {
+			assert.Equal(target, see[0])
+			assert.NotContains(see, A.LocalHashname())
+		}
+	}
+	assert.Equal(1.0, FromEndpoint(A).LookupSuccessRate())
+}
+
+func TestSeeLimit(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var trusted hashname.H
+
+	A := openEndpoint(t, Module(Config{}))
+	B := openEndpoint(t, Module(Config{
+		SeeLimit: func(requester hashname.H) int {
+			if requester == trusted {
+				return 100
+			}
+			return 1
+		},
+	}))
+	C := openEndpoint(t, Module(Config{}))
+	D := openEndpoint(t, Module(Config{}))
+	E := openEndpoint(t, Module(Config{}))
+	defer A.Close()
+	defer B.Close()
+	defer C.Close()
+	defer D.Close()
+	defer E.Close()
+	trusted = A.LocalHashname()
+
+	Bident, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	for _, e := range []*e3x.Endpoint{C, D, E} {
+		_, err = e.Dial(Bident)
+		assert.NoError(err)
+	}
+	xA, err := A.Dial(Bident)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	target := testHashname(0x00, 0x00)
+
+	// the trusted peer gets all the other peers
+	see, err := FromEndpoint(A).Seek(xA, target)
+	assert.NoError(err)
+	assert.Len(see, 3)
+	assert.NotContains(see, A.LocalHashname())
+
+	// untrusted peers get a truncated list
+	see, err = FromEndpoint(C).Seek(C.GetExchange(B.LocalHashname()), target)
+	assert.NoError(err)
+	if assert.Len(see, 1) {
+		assert.NotEqual(C.LocalHashname(), see[0])
+	}
+
+	// a seek for the responder itself is still answered
+	see, err = FromEndpoint(C).Seek(C.GetExchange(B.LocalHashname()), B.LocalHashname())
+	assert.NoError(err)
+	assert.Equal([]hashname.H{B.LocalHashname()}, see)
+}
+
+func TestSweepEvictsSilentPeer(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A := openEndpoint(t, Module(Config{
+		StaleAfter:    50 * time.Millisecond,
+		SweepInterval: 50 * time.Millisecond,
+		PingTimeout:   200 * time.Millisecond,
+	}))
+	B := openEndpoint(t) // doesn't answer seek requests
+	C := openEndpoint(t, Module(Config{}))
+	D := openEndpoint(t) // doesn't answer seek requests either
+	defer A.Close()
+	defer B.Close()
+	defer C.Close()
+	defer D.Close()
+
+	for _, e := range []*e3x.Endpoint{B, C, D} {
+		ident, err := e.LocalIdentity()
+		assert.NoError(err)
+		_, err = A.Dial(ident)
+		assert.NoError(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(FromEndpoint(A).Peers(), 3)
+
+	// a transfer with B which is in progress during the eviction
+	l := B.Listen("echo", true)
+	defer l.Close()
+	go func() {
+		c, err := l.AcceptChannel()
+		if err != nil {
+			return
+		}
+		defer c.Kill()
+		for {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			c.WritePacket(lob.New(pkt.Body(nil)))
+		}
+	}()
+	c, err := A.GetExchange(B.LocalHashname()).Open("echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+	assert.NoError(c.WritePacket(lob.New([]byte("data"))))
+
+	time.Sleep(time.Second)
+	peers := FromEndpoint(A).Peers()
+	if assert.Len(peers, 1) {
+		assert.Equal(C.LocalHashname(), peers[0].Hashname)
+	}
+
+	// the exchange with B is still used; the one with D is dropped
+	assert.NotNil(A.GetExchange(B.LocalHashname()))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if pkt, err := c.ReadPacket(); assert.NoError(err) {
+		assert.Equal("data", string(pkt.Body(nil)))
+	}
+	assert.NoError(c.WritePacket(lob.New([]byte("more"))))
+	if pkt, err := c.ReadPacket(); assert.NoError(err) {
+		assert.Equal("more", string(pkt.Body(nil)))
+	}
+	assert.Nil(A.GetExchange(D.LocalHashname()))
+}
+
+func TestPeerEvents(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A := openEndpoint(t, Module(Config{
+		StaleAfter:    50 * time.Millisecond,
+		SweepInterval: 50 * time.Millisecond,
+		PingTimeout:   200 * time.Millisecond,
+	}))
+	B := openEndpoint(t) // doesn't answer seek requests
+	defer A.Close()
+	defer B.Close()
+
+	s := A.Subscribe("dht.peer.added", "dht.peer.evicted")
+	defer s.Close()
+
+	ident, err := B.LocalIdentity()
+	assert.NoError(err)
+	_, err = A.Dial(ident)
+	assert.NoError(err)
+
+	time.Sleep(time.Second)
+
+	var events []e3x.Event
+	for len(s.Events()) > 0 {
+		events = append(events, <-s.Events())
+	}
+	if assert.Len(events, 2) {
+		assert.Equal(PeerAddedEvent{Hashname: B.LocalHashname()}, events[0])
+		if ev, ok := events[1].(PeerEvictedEvent); assert.True(ok) {
+			assert.Equal(B.LocalHashname(), ev.Hashname)
+			assert.Error(ev.Reason)
+		}
+	}
+}
+
+func TestJoinFill(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		seed   = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		others []*e3x.Endpoint
+	)
+	defer seed.Close()
+
+	seedIdent, err := seed.LocalIdentity()
+	assert.NoError(err)
+
+	for i := 0; i < 4; i++ {
+		e := openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		defer e.Close()
+		others = append(others, e)
+
+		_, err = e.Dial(seedIdent)
+		assert.NoError(err)
+	}
+
+	for _, joinFill := range []bool{false, true} {
+		A := openEndpoint(t, Module(Config{JoinFill: joinFill}), bridge.Module(bridge.Config{}))
+		defer A.Close()
+
+		_, err = A.Dial(seedIdent)
+		assert.NoError(err)
+
+		if joinFill {
+			// the seed, the other peers and the previous A
+			deadline := time.Now().Add(3 * time.Second)
+			for len(FromEndpoint(A).Peers()) < len(others)+2 && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			assert.Len(FromEndpoint(A).Peers(), len(others)+2)
+		} else {
+			time.Sleep(500 * time.Millisecond)
+			assert.Len(FromEndpoint(A).Peers(), 1)
+		}
+	}
+}
+
+func TestRefreshBucket(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		seed   = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		A      = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		others []*e3x.Endpoint
+	)
+	defer seed.Close()
+	defer A.Close()
+
+	seedIdent, err := seed.LocalIdentity()
+	assert.NoError(err)
+
+	for i := 0; i < 4; i++ {
+		e := openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		defer e.Close()
+		others = append(others, e)
+
+		_, err = e.Dial(seedIdent)
+		assert.NoError(err)
+	}
+
+	_, err = A.Dial(seedIdent)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(ErrInvalidBucket, FromEndpoint(A).RefreshBucket(-1))
+	assert.Equal(ErrInvalidBucket, FromEndpoint(A).RefreshBucket(numBuckets))
+
+	local, err := keyFromHashname(A.LocalHashname())
+	assert.NoError(err)
+	key, err := keyFromHashname(others[0].LocalHashname())
+	assert.NoError(err)
+	idx := bucketIndex(distance(local, key))
+
+	assert.NoError(FromEndpoint(A).RefreshBucket(idx))
+
+	var found bool
+	for _, p := range FromEndpoint(A).Peers() {
+		if p.Hashname == others[0].LocalHashname() {
+			found = true
+			assert.Equal(idx, p.Bucket)
+		}
+	}
+	assert.True(found)
+}
+
+func TestRefreshStrategies(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := newDHT(nil, Config{K: 2})
+	tab, err := newTable(testHashname(0x00, 0x00), mod.config.K, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.table = tab
+	assert.Equal(RefreshOldestFirst, mod.config.RefreshStrategy)
+
+	assert.Empty(mod.refreshCandidates())
+
+	// 252: 1 peer, 253: empty, 254: 1 peer, 255: full
+	tab.add(testHashname(0x10, 0x01))
+	tab.add(testHashname(0x40, 0x01))
+	tab.add(testHashname(0x80, 0x01))
+	tab.add(testHashname(0x80, 0x02))
+
+	now := time.Now()
+	mod.refreshed[252] = now.Add(-1 * time.Minute)
+	mod.refreshed[253] = now
+	mod.refreshed[254] = now.Add(-2 * time.Minute)
+	mod.refreshed[255] = now.Add(-3 * time.Minute)
+
+	buckets := mod.refreshCandidates()
+	if assert.Len(buckets, 4) {
+		assert.Equal(BucketState{Index: 252, Peers: 1, LastRefreshed: mod.refreshed[252]}, buckets[0])
+		assert.Equal(BucketState{Index: 255, Peers: 2, LastRefreshed: mod.refreshed[255]}, buckets[3])
+	}
+
+	assert.Equal(255, RefreshOldestFirst.NextBucket(buckets, mod.config.K))
+	assert.Equal(252, RefreshNearestFirst.NextBucket(buckets, mod.config.K))
+	assert.Equal(253, RefreshSparsestFirst.NextBucket(buckets, mod.config.K))
+
+	// buckets which were never refreshed come first
+	mod.refreshed[254] = time.Time{}
+	buckets = mod.refreshCandidates()
+	assert.Equal(254, RefreshOldestFirst.NextBucket(buckets, mod.config.K))
+
+	// when all buckets are full the oldest is refreshed first
+	full := []BucketState{
+		{Index: 254, Peers: 2, LastRefreshed: now},
+		{Index: 255, Peers: 2, LastRefreshed: now.Add(-time.Minute)},
+	}
+	assert.Equal(255, RefreshNearestFirst.NextBucket(full, mod.config.K))
+	assert.Equal(255, RefreshSparsestFirst.NextBucket(full, mod.config.K))
+}
+
+func TestSeededLookupsAreReproducible(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		net   = newLookupNetwork(t, 64)
+		local = net.nodes[0]
+
+		// the peers respond after a random delay
+		mtx    sync.Mutex
+		jitter = rand.New(rand.NewSource(time.Now().UnixNano()))
+	)
+
+	// lookups returns the queries made by the lookups of the bucket refreshes:
+	// the target and then the queried peers, each followed by the peer which
+	// named it first. Which peer names a peer first depends on the order of
+	// the responses.
+	lookups := func(seed int64) [][]hashname.H {
+		mod := newDHT(nil, Config{Rand: rand.New(rand.NewSource(seed)), OrderedLookups: true})
+		localKey, err := keyFromHashname(local)
+		assert.NoError(err)
+
+		var l [][]hashname.H
+		for idx := numBuckets - 8; idx < numBuckets; idx++ {
+			target, err := randomHashnameInBucket(mod.config.Rand, localKey, idx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := keyFromHashname(target)
+			assert.NoError(err)
+			assert.Equal(idx, bucketIndex(distance(localKey, key)))
+
+			namedBy := make(map[hashname.H]hashname.H)
+			closest, err := iterativeLookup(context.Background(), local, target, net.views[local][:mod.config.K],
+				mod.config.K, mod.config.Alpha, time.Second, mod.config.OrderedLookups,
+				func(hn, via hashname.H) ([]hashname.H, error) {
+					mtx.Lock()
+					namedBy[hn] = via
+					delay := time.Duration(jitter.Intn(3)) * time.Millisecond
+					mtx.Unlock()
+
+					time.Sleep(delay)
+					return net.see(t, hn, target, mod.config.K), nil
+				})
+			assert.NoError(err)
+			assert.NotEmpty(closest)
+
+			queried := make([]hashname.H, 0, len(namedBy))
+			for hn := range namedBy {
+				queried = append(queried, hn)
+			}
+			sort.Slice(queried, func(i, j int) bool { return queried[i] < queried[j] })
+
+			q := []hashname.H{target}
+			for _, hn := range queried {
+				q = append(q, hn, namedBy[hn])
+			}
+			l = append(l, q)
+		}
+		return l
+	}
+
+	a, b, c := lookups(1), lookups(1), lookups(2)
+	assert.Equal(len(a), len(b))
+	for i := range a {
+		assert.True(equalHashnames(a[i], b[i]), "refresh %d", i)
+		assert.False(equalHashnames(a[i], c[i]), "refresh %d", i)
+	}
+}
+
+func equalHashnames(a, b []hashname.H) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCandidateSources(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		R1 = openEndpoint(t, Module(Config{}))
+		R2 = openEndpoint(t, Module(Config{}))
+		P  = openEndpoint(t, Module(Config{}))
+		A  = openEndpoint(t, Module(Config{}))
+	)
+	defer R1.Close()
+	defer R2.Close()
+	defer P.Close()
+	defer A.Close()
+
+	r1, err := R1.LocalIdentity()
+	assert.NoError(err)
+	r2, err := R2.LocalIdentity()
+	assert.NoError(err)
+
+	_, err = P.Dial(r1)
+	assert.NoError(err)
+	_, err = P.Dial(r2)
+	assert.NoError(err)
+	x1, err := A.Dial(r1)
+	assert.NoError(err)
+	x2, err := A.Dial(r2)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	dht := FromEndpoint(A)
+	assert.Empty(dht.Candidates())
+
+	_, err = dht.Seek(x1, P.LocalHashname())
+	assert.NoError(err)
+	_, err = dht.Seek(x2, P.LocalHashname())
+	assert.NoError(err)
+
+	var found *Candidate
+	for _, c := range dht.Candidates() {
+		assert.NotEqual(A.LocalHashname(), c.Hashname)
+		if c.Hashname == P.LocalHashname() {
+			c := c
+			found = &c
+		}
+	}
+	if assert.NotNil(found) {
+		assert.Equal([]hashname.H{R1.LocalHashname(), R2.LocalHashname()}, found.Sources)
+	}
+
+	// the sources are kept once the peer is linked
+	pi, err := P.LocalIdentity()
+	assert.NoError(err)
+	_, err = A.Dial(pi)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	for _, p := range dht.Peers() {
+		switch p.Hashname {
+		case P.LocalHashname():
+			assert.Len(p.Sources, 2)
+		case R1.LocalHashname(), R2.LocalHashname():
+			assert.Empty(p.Sources)
+		}
+	}
+}
+
+func TestDryRunSeek(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A := openEndpoint(t, Module(Config{K: 2}), bridge.Module(bridge.Config{}))
+	defer A.Close()
+
+	mod := FromEndpoint(A).(*module)
+	tab, err := newTable(A.LocalHashname(), 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.table = tab
+
+	var (
+		target = testHashname(0x00, 0x00)
+		P1     = testHashname(0x80, 0x01) // not linked
+		P2     = testHashname(0x40, 0x01)
+		P3     = testHashname(0x20, 0x01)
+		N1     = testHashname(0x01, 0x01)
+		N2     = testHashname(0x10, 0x01)
+	)
+	for _, hn := range []hashname.H{P1, P2, P3} {
+		tab.add(hn)
+	}
+	mod.links[&e3x.Exchange{}] = P2
+	mod.links[&e3x.Exchange{}] = P3
+	mod.addCandidates(P3, []hashname.H{N2, N1})
+	mod.addCandidates(P2, []hashname.H{N2})
+
+	var (
+		peers      = fmt.Sprint(mod.Peers())
+		candidates = fmt.Sprint(mod.Candidates())
+		plan       = mod.DryRunSeek(target)
+	)
+
+	// the closest peers are asked first; those they are expected to return
+	// are asked next and the lookup ends once the closest peers were asked.
+	assert.Equal(fmt.Sprint([]PlannedSeek{
+		{Peer: P3, Round: 0, Linked: true, See: []hashname.H{N1, N2}},
+		{Peer: P2, Round: 0, Linked: true, See: []hashname.H{N2}},
+		{Peer: N1, Round: 1, Linked: true},
+		{Peer: N2, Round: 1, Linked: true},
+	}), fmt.Sprint(plan))
+
+	// the table and the candidates are left untouched
+	assert.Equal(peers, fmt.Sprint(mod.Peers()))
+	assert.Equal(candidates, fmt.Sprint(mod.Candidates()))
+	assert.Equal(1.0, mod.LookupSuccessRate())
+
+	// unlinked peers are skipped
+	plan = mod.DryRunSeek(testHashname(0x80, 0x00))
+	if assert.NotEmpty(plan) {
+		assert.Equal(P1, plan[0].Peer)
+		assert.False(plan[0].Linked)
+		assert.Empty(plan[0].See)
+	}
+}
+
+func TestPeerReachability(t *testing.T) {
+	assert := assert.New(t)
+
+	A := openEndpoint(t, Module(Config{}))
+	defer A.Close()
+
+	mod := FromEndpoint(A).(*module)
+	tab, err := newTable(A.LocalHashname(), mod.config.K, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.table = tab
+
+	var (
+		linked   = testHashname(0x80, 0x01)
+		stale    = testHashname(0x80, 0x02) // in the table but not linked
+		named    = testHashname(0x40, 0x01) // named by a linked peer
+		hearsay  = testHashname(0x40, 0x02) // named by a peer which isn't linked
+		stranger = testHashname(0x20, 0x01)
+	)
+	tab.add(linked)
+	tab.add(stale)
+	mod.links[&e3x.Exchange{}] = linked
+	mod.addCandidates(linked, []hashname.H{named})
+	mod.addCandidates(stale, []hashname.H{hearsay})
+
+	assert.Equal(ReachDirect, mod.PeerReachability(linked))
+	assert.Equal(ReachDHTOnly, mod.PeerReachability(stale))
+	assert.Equal(ReachViaRouter, mod.PeerReachability(named))
+	assert.Equal(ReachDHTOnly, mod.PeerReachability(hearsay))
+	assert.Equal(ReachUnknown, mod.PeerReachability(stranger))
+	assert.Equal("via-router", ReachViaRouter.String())
+
+	// the query is read-only
+	assert.Len(mod.Peers(), 2)
+	assert.Len(mod.Candidates(), 2)
+}
+
+func TestCandidateSourcesKeepMostRecent(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		c       = &Candidate{}
+		sources []hashname.H
+	)
+	for i := 0; i < maxSourcesPerCandidate; i++ {
+		sources = append(sources, testHashname(byte(i), 0x01))
+		c.addSource(sources[i])
+	}
+	assert.Equal(sources, c.Sources)
+
+	// the first source vouches again; the second one is now the stalest
+	c.addSource(sources[0])
+	assert.Equal(append(sources[1:], sources[0]), c.Sources)
+
+	fresh := testHashname(0xff, 0x01)
+	c.addSource(fresh)
+	if assert.Len(c.Sources, maxSourcesPerCandidate) {
+		assert.Equal(fresh, c.Sources[maxSourcesPerCandidate-1])
+		assert.Equal(sources[0], c.Sources[maxSourcesPerCandidate-2])
+		assert.Equal(sources[2], c.Sources[0])
+	}
+}
+
+func TestSeekSelf(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		A = openEndpoint(t, Module(Config{}))
+		T = openEndpoint(t, Module(Config{}))
+	)
+	defer A.Close()
+	defer T.Close()
+
+	tident, err := T.LocalIdentity()
+	assert.NoError(err)
+
+	xT, err := A.Dial(tident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// T answers for itself
+	see, err := FromEndpoint(A).Seek(xT, T.LocalHashname())
+	assert.NoError(err)
+	assert.Equal([]hashname.H{T.LocalHashname()}, see)
+
+	ident, err := FromEndpoint(A).SeekIdentity(xT)
+	if assert.NoError(err) && assert.NotNil(ident) {
+		assert.Equal(T.LocalHashname(), ident.Hashname())
+		if assert.Len(ident.Addresses(), len(tident.Addresses())) {
+			for i, addr := range tident.Addresses() {
+				assert.Equal(addr.String(), ident.Addresses()[i].String())
+			}
+		}
+	}
+}
+
+func TestSeedStatsOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		stats   = NewSeedStats()
+		unknown = &e3x.Identity{}
+		a       = testIdentity(t)
+		b       = testIdentity(t)
+	)
+
+	stats.record(a.Hashname(), false)
+	stats.record(b.Hashname(), true)
+	assert.Equal([]*e3x.Identity{b, a}, stats.order([]*e3x.Identity{a, b}))
+
+	data, err := stats.MarshalJSON()
+	assert.NoError(err)
+
+	restored := NewSeedStats()
+	assert.NoError(restored.UnmarshalJSON(data))
+	assert.Equal(1, restored.Get(a.Hashname()).Failures)
+	assert.Equal(1, restored.Get(b.Hashname()).Successes)
+	assert.Equal([]*e3x.Identity{b, a}, restored.order([]*e3x.Identity{a, b}))
+	assert.Equal(0.5, restored.Get(unknown.Hashname()).Score())
+}
+
+func TestBootstrapPrefersReliableSeeds(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		stats = NewSeedStats()
+		good  = openEndpoint(t)
+		dead  = openEndpoint(t)
+	)
+	defer good.Close()
+
+	goodIdent, err := good.LocalIdentity()
+	assert.NoError(err)
+	deadIdent, err := dead.LocalIdentity()
+	assert.NoError(err)
+	dead.Close()
+
+	seeds := []*e3x.Identity{deadIdent, goodIdent}
+
+	A := openEndpoint(t, Module(Config{SeedStats: stats, SeedTimeout: 200 * time.Millisecond}))
+	x, err := FromEndpoint(A).Bootstrap(seeds)
+	if assert.NoError(err) && assert.NotNil(x) {
+		assert.Equal(good.LocalHashname(), x.RemoteHashname())
+	}
+	assert.Equal(SeedStat{Failures: 1}, stats.Get(deadIdent.Hashname()))
+	assert.Equal(1, stats.Get(goodIdent.Hashname()).Successes)
+	A.Close()
+
+	// a fresh endpoint with the same stats tries the reliable seed first
+	B := openEndpoint(t, Module(Config{SeedStats: stats, SeedTimeout: 200 * time.Millisecond}))
+	defer B.Close()
+	x, err = FromEndpoint(B).Bootstrap(seeds)
+	if assert.NoError(err) && assert.NotNil(x) {
+		assert.Equal(good.LocalHashname(), x.RemoteHashname())
+	}
+	assert.Equal(SeedStat{Failures: 1}, stats.Get(deadIdent.Hashname()))
+	assert.Equal(2, stats.Get(goodIdent.Hashname()).Successes)
+
+	_, err = FromEndpoint(B).Bootstrap([]*e3x.Identity{deadIdent})
+	assert.Equal(ErrBootstrapFailed, err)
+	assert.Equal(2, stats.Get(deadIdent.Hashname()).Failures)
+}
+
+func testIdentity(t *testing.T) *e3x.Identity {
+	e := openEndpoint(t)
+	defer e.Close()
+
+	ident, err := e.LocalIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ident
+}
+
+func openEndpoint(t *testing.T, options ...e3x.EndpointOption) *e3x.Endpoint {
+	e, err := e3x.Open(append([]e3x.EndpointOption{
+		e3x.Log(nil),
+		e3x.Transport(udp.Config{}),
+	}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func wirePacket(t *testing.T, nonce string, see ...hashname.H) *lob.Packet {
+	l := make([]string, len(see))
+	for i, hn := range see {
+		l[i] = string(hn)
+	}
+
+	pkt := &lob.Packet{}
+	pkt.Header().Set("see", l)
+	pkt.Header().SetString("nonce", nonce)
+
+	buf, err := lob.Encode(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+
+	pkt, err = lob.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func TestIsHealthy(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := newDHT(nil, Config{MinHealthyPeers: 4, MinHealthyBuckets: 2, HealthyLookupAge: time.Minute})
+	tab, err := newTable(testHashname(0x00, 0x00), mod.config.K, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.table = tab
+
+	ok, report := mod.IsHealthy()
+	assert.False(ok)
+	assert.Equal(-1, report.ClosestBucket)
+	assert.Equal("only 0 active peers (want 4), all buckets empty, no successful lookups", report.String())
+
+	// three peers in the farthest bucket
+	for i := byte(1); i <= 3; i++ {
+		tab.add(testHashname(0x80, i))
+	}
+	mod.lastLookup = time.Now().Add(-2 * time.Minute)
+
+	ok, report = mod.IsHealthy()
+	assert.False(ok)
+	assert.Equal(3, report.Peers)
+	assert.Equal(1, report.Buckets)
+	assert.Equal(numBuckets-1, report.ClosestBucket)
+	assert.Equal("only 3 active peers (want 4), only 1 non-empty buckets (want 2), buckets 0-254 empty, last successful lookup 2m0s ago", report.String())
+
+	// a fourth peer in another bucket and a recent lookup
+	tab.add(testHashname(0x00, 0x01))
+	mod.lookupSucceeded()
+
+	ok, report = mod.IsHealthy()
+	assert.True(ok)
+	assert.Equal(4, report.Peers)
+	assert.Equal(2, report.Buckets)
+	assert.Equal(0, report.ClosestBucket)
+	assert.Empty(report.Problems)
+	assert.Equal("healthy: 4 active peers in 2 buckets", report.String())
+}
+
+func TestKeyspaceCoverage(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := newDHT(nil, Config{K: 3})
+	tab, err := newTable(testHashname(0x00, 0x00), mod.config.K, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.table = tab
+
+	coverage := mod.KeyspaceCoverage()
+	assert.Len(coverage, numBuckets)
+	for _, n := range coverage {
+		assert.Equal(0, n)
+	}
+
+	// 255: 3 peers, 254: 1 peer, 253: empty, 252: 2 peers
+	tab.add(testHashname(0x80, 0x01))
+	tab.add(testHashname(0xc0, 0x01))
+	tab.add(testHashname(0xff, 0x01))
+	tab.add(testHashname(0x40, 0x01))
+	tab.add(testHashname(0x10, 0x01))
+	tab.add(testHashname(0x1f, 0x01))
+
+	coverage = mod.KeyspaceCoverage()
+	assert.Equal("[2 0 1 3]", fmt.Sprint(coverage[252:]))
+	for idx, n := range coverage[:252] {
+		assert.Equal(0, n, "bucket %d", idx)
+	}
+
+	// removed peers are no longer counted
+	tab.remove(testHashname(0xc0, 0x01))
+	assert.Equal(2, mod.KeyspaceCoverage()[255])
+}
+
+func TestConnectFanout(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mtx       sync.Mutex
+		contacted []int
+		exchanges = []*e3x.Exchange{{}, {}, {}, {}}
+		delays    = []time.Duration{300 * time.Millisecond, 10 * time.Millisecond, 0, 0}
+	)
+
+	canceled := make(chan int, len(exchanges))
+	x, err := connectVia(context.Background(), len(exchanges), 2, func(ctx context.Context, i int) (*e3x.Exchange, error) {
+		mtx.Lock()
+		contacted = append(contacted, i)
+		mtx.Unlock()
+
+		select {
+		case <-time.After(delays[i]):
+			return exchanges[i], nil
+		case <-ctx.Done():
+			canceled <- i
+			return nil, ctx.Err()
+		}
+	})
+	assert.NoError(err)
+	assert.True(x == exchanges[1], "the faster router wins")
+
+	// the slower attempt is canceled
+	select {
+	case i := <-canceled:
+		assert.Equal(0, i)
+	case <-time.After(200 * time.Millisecond):
+		t.Error("the slower attempt wasn't canceled")
+	}
+	mtx.Lock()
+	assert.Len(contacted, 2)
+	mtx.Unlock()
+
+	// a failed attempt is replaced by the next router
+	mtx.Lock()
+	contacted = nil
+	mtx.Unlock()
+	x, err = connectVia(context.Background(), len(exchanges), 2, func(ctx context.Context, i int) (*e3x.Exchange, error) {
+		mtx.Lock()
+		contacted = append(contacted, i)
+		mtx.Unlock()
+
+		if i < 3 {
+			return nil, e3x.ErrTimeout
+		}
+		return exchanges[i], nil
+	})
+	assert.NoError(err)
+	assert.True(x == exchanges[3])
+	time.Sleep(50 * time.Millisecond)
+	mtx.Lock()
+	assert.Len(contacted, 4)
+	mtx.Unlock()
+
+	_, err = connectVia(context.Background(), 2, 2, func(ctx context.Context, i int) (*e3x.Exchange, error) {
+		return nil, e3x.ErrTimeout
+	})
+	assert.Equal(e3x.ErrTimeout, err)
+}
+
+func TestConnectCandidate(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		R1 = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		R2 = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		P  = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		A  = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+	)
+	defer R1.Close()
+	defer R2.Close()
+	defer P.Close()
+	defer A.Close()
+
+	r1, err := R1.LocalIdentity()
+	assert.NoError(err)
+	r2, err := R2.LocalIdentity()
+	assert.NoError(err)
+
+	_, err = P.Dial(r1)
+	assert.NoError(err)
+	_, err = P.Dial(r2)
+	assert.NoError(err)
+	x1, err := A.Dial(r1)
+	assert.NoError(err)
+	x2, err := A.Dial(r2)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	dht := FromEndpoint(A)
+
+	_, err = dht.Connect(P.LocalHashname())
+	assert.Equal(ErrNoRouters, err)
+
+	_, err = dht.Seek(x1, P.LocalHashname())
+	assert.NoError(err)
+	_, err = dht.Seek(x2, P.LocalHashname())
+	assert.NoError(err)
+
+	x, err := dht.Connect(P.LocalHashname())
+	if assert.NoError(err) && assert.NotNil(x) {
+		assert.Equal(P.LocalHashname(), x.RemoteHashname())
+	}
+	assert.True(A.GetExchange(P.LocalHashname()) == x)
+}
+
+func TestLookupSuccessRate(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		w   = newLookupWindow(time.Minute)
+		now = time.Now()
+	)
+
+	assert.Equal(1.0, w.rate(now))
+
+	// a healthy start: 9 out of 10 seeks succeed
+	for i := 0; i < 10; i++ {
+		w.record(i != 0, now.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(0.9, w.rate(now.Add(10*time.Second)))
+
+	// connectivity degrades: 10 failures
+	for i := 0; i < 10; i++ {
+		w.record(false, now.Add(time.Duration(30+i)*time.Second))
+	}
+	assert.Equal(0.45, w.rate(now.Add(40*time.Second)))
+
+	// the healthy seeks slide out of the window
+	assert.Equal(0.0, w.rate(now.Add(90*time.Second)))
+
+	// and so do the failures
+	assert.Equal(1.0, w.rate(now.Add(2*time.Minute)))
+
+	// the window is bounded
+	for i := 0; i < maxLookupOutcomes+10; i++ {
+		w.record(i >= 10, now.Add(2*time.Minute))
+	}
+	assert.Len(w.outcomes, maxLookupOutcomes)
+	assert.Equal(1.0, w.rate(now.Add(2*time.Minute)))
+}
+
+func TestWriteMetrics(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A := openEndpoint(t, Module(Config{}))
+	B := openEndpoint(t, Module(Config{}))
+	C := openEndpoint(t, Module(Config{}))
+	defer A.Close()
+	defer B.Close()
+	defer C.Close()
+
+	for _, e := range []*e3x.Endpoint{B, C} {
+		ident, err := e.LocalIdentity()
+		assert.NoError(err)
+		_, err = A.Dial(ident)
+		assert.NoError(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	_, err := FromEndpoint(A).Seek(A.GetExchange(B.LocalHashname()), C.LocalHashname())
+	assert.NoError(err)
+
+	rec := httptest.NewRecorder()
+	A.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.Contains(body, "# TYPE telehash_dht_peers gauge\ntelehash_dht_peers 2\n")
+	assert.Contains(body, "# TYPE telehash_dht_lookups_total counter\ntelehash_dht_lookups_total 1\n")
+	assert.Contains(body, "\ntelehash_dht_buckets ")
+	assert.Contains(body, "\ntelehash_dht_lookups_succeeded_total ")
+	assert.Contains(body, "\ntelehash_dht_lookup_success_ratio ")
+	assert.Contains(body, "# TYPE telehash_dht_bucket_peers gauge\n")
+	assert.Contains(body, "\ntelehash_dht_bucket_capacity ")
+	assert.Contains(body, "\ntelehash_exchanges 2\n")
+}
+
+func TestCandidateEviction(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mod  = newDHT(nil, Config{})
+		now  = time.Now()
+		src1 = testHashname(0xf0, 0x01)
+		src2 = testHashname(0xf0, 0x02)
+	)
+
+	// fill the candidates; all have two sources except for two
+	for i := 0; i < maxCandidates; i++ {
+		hn := testHashname(byte(i>>8), byte(i))
+		c := mod.admitCandidate(hn, now.Add(time.Duration(i)*time.Second))
+		c.addSource(src1)
+		if i != 7 && i != 9 {
+			c.addSource(src2)
+		}
+	}
+	assert.Len(mod.candidates, maxCandidates)
+
+	// the oldest candidate with the fewest sources is evicted
+	fresh := testHashname(0xff, 0x01)
+	if assert.NotNil(mod.admitCandidate(fresh, now.Add(time.Hour))) {
+		mod.candidates[fresh].addSource(src1)
+	}
+	assert.Len(mod.candidates, maxCandidates)
+	assert.Nil(mod.candidates[testHashname(0x00, 7)])
+	assert.NotNil(mod.candidates[testHashname(0x00, 9)])
+
+	fresher := testHashname(0xff, 0x02)
+	if assert.NotNil(mod.admitCandidate(fresher, now.Add(2*time.Hour))) {
+		mod.candidates[fresher].addSource(src1)
+	}
+	assert.Nil(mod.candidates[testHashname(0x00, 9)])
+
+	// the next single-source candidate to go is the first newcomer
+	assert.NotNil(mod.admitCandidate(testHashname(0xff, 0x03), now.Add(3*time.Hour)))
+	assert.Nil(mod.candidates[fresh])
+	assert.NotNil(mod.candidates[fresher])
+}
+
+// lookupNetwork is a simulated network in which every node keeps a complete
+// Kademlia routing table of up to k peers per bucket.
+type lookupNetwork struct {
+	nodes []hashname.H
+	views map[hashname.H][]hashname.H
+}
+
+func newLookupNetwork(t *testing.T, n int) *lookupNetwork {
+	net := &lookupNetwork{views: make(map[hashname.H][]hashname.H)}
+	for i := 0; i < n; i++ {
+		net.nodes = append(net.nodes, hashname.H(base32util.EncodeToString(randomKey(t))))
+	}
+
+	// every node knows all the other nodes, which makes the k closest nodes
+	// found by a lookup deterministic.
+	for _, hn := range net.nodes {
+		tab, err := newTable(hn, n, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, other := range net.nodes {
+			tab.add(other)
+		}
+		net.views[hn] = tab.closest(hn, n)
+	}
+
+	return net
+}
+
+// closest returns the k nodes closest to target; target itself is the
+// closest node.
+func (net *lookupNetwork) closest(t *testing.T, target hashname.H, k int) []hashname.H {
+	tab, err := newTable(target, len(net.nodes), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hn := range net.nodes {
+		tab.add(hn)
+	}
+	return append([]hashname.H{target}, tab.closest(target, k-1)...)
+}
+
+// closestTo returns the k nodes closest to target as seen by local, which
+// doesn't count itself.
+func (net *lookupNetwork) closestTo(t *testing.T, local, target hashname.H, k int) []hashname.H {
+	var l []hashname.H
+	for _, hn := range net.closest(t, target, k+1) {
+		if hn != local && len(l) < k {
+			l = append(l, hn)
+		}
+	}
+	return l
+}
+
+// see returns the k peers hn knows which are closest to target.
+func (net *lookupNetwork) see(t *testing.T, hn, target hashname.H, k int) []hashname.H {
+	tab, err := newTable(hn, len(net.nodes), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range net.views[hn] {
+		tab.add(other)
+	}
+	return tab.closest(target, k)
+}
+
+func TestIterativeLookup(t *testing.T) {
+	assert := assert.New(t)
+
+	const k = 4
+
+	var (
+		net    = newLookupNetwork(t, 64)
+		local  = net.nodes[0]
+		target = net.nodes[len(net.nodes)-1]
+
+		mtx      sync.Mutex
+		inFlight int
+		maxIn    int
+		queried  = map[hashname.H]bool{}
+	)
+
+	closest, err := iterativeLookup(context.Background(), local, target, net.views[local][:k], k, 3, time.Second, false,
+		func(hn, via hashname.H) ([]hashname.H, error) {
+			mtx.Lock()
+			assert.False(queried[hn], "%s was asked twice", hn.Short())
+			queried[hn] = true
+			inFlight++
+			if inFlight > maxIn {
+				maxIn = inFlight
+			}
+			mtx.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mtx.Lock()
+			inFlight--
+			mtx.Unlock()
+
+			return net.see(t, hn, target, k), nil
+		})
+	assert.NoError(err)
+	assert.Equal(fmt.Sprint(net.closestTo(t, local, target, k)), fmt.Sprint(closest))
+	assert.Equal(3, maxIn)
+	assert.True(len(queried) < len(net.nodes), "queried %d of %d nodes", len(queried), len(net.nodes))
+}
+
+func TestIterativeLookupSkipsFailedPeers(t *testing.T) {
+	assert := assert.New(t)
+
+	const k = 4
+
+	var (
+		net    = newLookupNetwork(t, 64)
+		local  = net.nodes[0]
+		target = net.nodes[len(net.nodes)-1]
+		real   = net.closestTo(t, local, target, k)
+
+		// the closest peer hangs and the second closest fails
+		hanging = real[0]
+		failing = real[1]
+	)
+
+	began := time.Now()
+	closest, err := iterativeLookup(context.Background(), local, target, net.views[local][:k], k, 3, 100*time.Millisecond, false,
+		func(hn, via hashname.H) ([]hashname.H, error) {
+			switch hn {
+			case hanging:
+				time.Sleep(time.Second)
+			case failing:
+				return nil, ErrSeekerClosed
+			}
+			return net.see(t, hn, target, k), nil
+		})
+	assert.NoError(err)
+	if assert.True(len(closest) >= 2, "closest=%v", closest) {
+		assert.Equal(fmt.Sprint(real[2:4]), fmt.Sprint(closest[:2]))
+	}
+	for _, hn := range closest {
+		assert.NotEqual(hanging, hn)
+		assert.NotEqual(failing, hn)
+	}
+	assert.True(time.Since(began) < 900*time.Millisecond, "the hanging peer was waited for")
+
+	// nobody answers
+	_, err = iterativeLookup(context.Background(), local, target, net.views[local][:k], k, 3, time.Second, false,
+		func(hn, via hashname.H) ([]hashname.H, error) {
+			return nil, ErrSeekerClosed
+		})
+	assert.Equal(ErrSeekerClosed, err)
+
+	_, err = iterativeLookup(context.Background(), local, target, nil, k, 3, time.Second, false, nil)
+	assert.Equal(ErrLookupFailed, err)
+}
+
+func TestIterativeLookupCanceled(t *testing.T) {
+	assert := assert.New(t)
+
+	const k = 4
+
+	var (
+		net    = newLookupNetwork(t, 64)
+		local  = net.nodes[0]
+		target = net.nodes[len(net.nodes)-1]
+	)
+
+	// every peer hangs
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	_, err := iterativeLookup(ctx, local, target, net.views[local][:k], k, 3, time.Second, false,
+		func(hn, via hashname.H) ([]hashname.H, error) {
+			time.Sleep(time.Second)
+			return nil, ErrSeekerClosed
+		})
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.True(time.Since(began) < 900*time.Millisecond, "the lookup wasn't canceled")
+
+	_, err = iterativeLookup(ctx, local, target, net.views[local][:k], k, 3, time.Second, false,
+		func(hn, via hashname.H) ([]hashname.H, error) {
+			return net.see(t, hn, target, k), nil
+		})
+	assert.Equal(context.DeadlineExceeded, err)
+}
+
+func TestLookup(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		seed   = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		A      = openEndpoint(t, Module(Config{Alpha: 2, LookupTimeout: 2 * time.Second}), bridge.Module(bridge.Config{}))
+		others []*e3x.Endpoint
+	)
+	defer seed.Close()
+	defer A.Close()
+
+	seedIdent, err := seed.LocalIdentity()
+	assert.NoError(err)
+
+	for i := 0; i < 4; i++ {
+		e := openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		defer e.Close()
+		others = append(others, e)
+
+		_, err = e.Dial(seedIdent)
+		assert.NoError(err)
+	}
+
+	_, err = A.Dial(seedIdent)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	target := others[0].LocalHashname()
+	closest, err := FromEndpoint(A).Lookup(target)
+	assert.NoError(err)
+	assert.NotEmpty(closest)
+
+	// the seed named the target, which was then connected to
+	assert.NotNil(A.GetExchange(target))
+}
+
+func TestValueSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	_, prv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := newValue(prv, "greeting", []byte("hello"), time.Hour, time.Now())
+	assert.NoError(v.Verify())
+	assert.Equal(fmt.Sprint(ValueKey(prv.Public().(ed25519.PublicKey), "greeting")), fmt.Sprint(v.Key))
+
+	w, err := decodeValue(v.encode())
+	if assert.NoError(err) {
+		assert.Equal("hello", string(w.Data))
+		assert.Equal(v.Published, w.Published)
+		assert.Equal(time.Hour, w.TTL)
+	}
+
+	// tampered data
+	tampered := *v
+	tampered.Data = []byte("bye")
+	_, err = decodeValue(tampered.encode())
+	assert.Equal(ErrInvalidValue, err)
+
+	// a value can't be moved to another key
+	pkt := v.encode()
+	pkt.Header().SetString("name", "other")
+	_, err = decodeValue(pkt)
+	assert.Equal(ErrInvalidValue, err)
+}
+
+func TestStoreValue(t *testing.T) {
+	assert := assert.New(t)
+
+	_, prv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mod   = newDHT(nil, Config{})
+		now   = time.Now()
+		older = newValue(prv, "v", []byte("1"), time.Minute, now)
+		newer = newValue(prv, "v", []byte("2"), time.Minute, now.Add(time.Second))
+	)
+
+	assert.True(mod.storeValue(newer, now))
+	assert.False(mod.storeValue(older, now))
+	assert.Equal("2", string(mod.localValue(newer.Key, now).Data))
+
+	// expired values are neither stored nor returned
+	assert.False(mod.storeValue(older, now.Add(time.Hour)))
+	assert.Nil(mod.localValue(newer.Key, now.Add(time.Hour)))
+
+	mod.published[string(older.Key)] = older
+	assert.Len(mod.expireValues(now), 1)
+	assert.Len(mod.expireValues(now.Add(time.Hour)), 0)
+	assert.Empty(mod.values)
+	assert.Empty(mod.published)
+
+	// values for new keys are refused once MaxStoredValues are stored, unless
+	// some of them expired
+	mod = newDHT(nil, Config{MaxStoredValues: 1})
+	other := newValue(prv, "w", []byte("3"), 2*time.Minute, now)
+	assert.True(mod.storeValue(older, now))
+	assert.False(mod.storeValue(other, now))
+	assert.True(mod.storeValue(newer, now))
+	assert.True(mod.storeValue(other, now.Add(90*time.Second)))
+	assert.Len(mod.values, 1)
+}
+
+func TestFetchRejectsExpiredValues(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	_, prv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		A = openEndpoint(t, Module(Config{LookupTimeout: time.Second}))
+		B = openEndpoint(t)
+		v = newValue(prv, "v", []byte("stale"), time.Minute, time.Now().Add(-time.Hour))
+	)
+	defer A.Close()
+	defer B.Close()
+
+	// B returns a value which expired long ago
+	l := B.Listen("fetch", false)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+			if pkt, err := c.ReadPacket(); err == nil {
+				pkt.Free()
+				resp := v.encode()
+				resp.Header().SetBool("found", true)
+				c.WritePacket(resp)
+			}
+			c.Kill()
+		}
+	}()
+
+	ident, err := B.LocalIdentity()
+	assert.NoError(err)
+	x, err := A.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	w, err := FromEndpoint(A).(*module).fetchFrom(x, v.Key)
+	assert.Equal(ErrInvalidValue, err)
+	assert.Nil(w)
+}
+
+func TestResponsibleFor(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := newDHT(nil, Config{K: 2})
+	tab, err := newTable(testHashname(0x00, 0x00), mod.config.K, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.table = tab
+
+	key := func(first byte) []byte {
+		k, _ := keyFromHashname(testHashname(first, 0x00))
+		return k
+	}
+
+	assert.True(mod.responsibleFor(key(0x81)))
+
+	tab.add(testHashname(0x80, 0x01))
+	assert.True(mod.responsibleFor(key(0x81)))
+
+	// two known peers are closer to 0x81 than the local node
+	tab.add(testHashname(0xc0, 0x01))
+	assert.False(mod.responsibleFor(key(0x81)))
+	assert.True(mod.responsibleFor(key(0x01)))
+}
+
+func TestPutGet(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	config := Config{Alpha: 2, LookupTimeout: 2 * time.Second}
+
+	seed := openEndpoint(t, Module(config), bridge.Module(bridge.Config{}))
+	defer seed.Close()
+
+	seedIdent, err := seed.LocalIdentity()
+	assert.NoError(err)
+
+	var endpoints []*e3x.Endpoint
+	for i := 0; i < 5; i++ {
+		e := openEndpoint(t, Module(config), bridge.Module(bridge.Config{}))
+		defer e.Close()
+		endpoints = append(endpoints, e)
+
+		_, err = e.Dial(seedIdent)
+		assert.NoError(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	var (
+		publisher = FromEndpoint(endpoints[0])
+		fetcher   = FromEndpoint(endpoints[1])
+	)
+
+	v, err := publisher.Put("greeting", []byte("hello"), time.Hour)
+	if !assert.NoError(err) {
+		return
+	}
+
+	w, err := fetcher.Get(v.Key)
+	if assert.NoError(err) {
+		assert.Equal("hello", string(w.Data))
+	}
+
+	_, err = fetcher.Get(ValueKey(v.PublicKey, "unknown"))
+	assert.Error(err)
+
+	_, err = publisher.Put("large", make([]byte, defaultMaxValueSize+1), 0)
+	assert.Equal(ErrValueTooLarge, err)
+}
+
+// memoryStore is a Store which keeps snapshots in memory.
+type memoryStore struct {
+	snapshot *Snapshot
+	saves    int
+}
+
+func (s *memoryStore) Save(snapshot *Snapshot) error {
+	s.snapshot = snapshot
+	s.saves++
+	return nil
+}
+
+func (s *memoryStore) Load() (*Snapshot, error) {
+	return s.snapshot, nil
+}
+
+func TestWarmRestart(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		store  = &memoryStore{}
+		others []*e3x.Endpoint
+	)
+
+	A := openEndpoint(t, Module(Config{}))
+	for i := 0; i < 3; i++ {
+		e := openEndpoint(t, Module(Config{}))
+		defer e.Close()
+		others = append(others, e)
+
+		ident, err := e.LocalIdentity()
+		assert.NoError(err)
+		_, err = A.Dial(ident)
+		assert.NoError(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	candidate := hashname.H(base32util.EncodeToString(randomKey(t)))
+	FromEndpoint(A).(*module).addCandidates(others[0].LocalHashname(), []hashname.H{candidate})
+
+	assert.NoError(FromEndpoint(A).Save(store))
+	if assert.NotNil(store.snapshot) {
+		assert.Len(store.snapshot.Peers, len(others))
+	}
+	assert.NoError(A.Close())
+
+	// an empty table doesn't replace the saved peers
+	assert.NoError(FromEndpoint(A).Save(store))
+	assert.Equal(1, store.saves)
+
+	// the restarted endpoint rejoins without any seeds
+	B := openEndpoint(t, Module(Config{Store: store}))
+	defer B.Close()
+
+	x, err := FromEndpoint(B).Bootstrap(nil)
+	assert.NoError(err)
+	assert.NotNil(x)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(FromEndpoint(B).Peers(), len(others))
+	assert.Equal(fmt.Sprint([]hashname.H{others[0].LocalHashname()}), fmt.Sprint(FromEndpoint(B).(*module).sources(candidate)))
+
+	// nothing saved
+	_, err = FromEndpoint(B).Load(&memoryStore{})
+	assert.Equal(ErrRejoinFailed, err)
+}
+
+func TestFileStore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "dht")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := FileStore(filepath.Join(dir, "table.json"))
+
+	s, err := store.Load()
+	assert.NoError(err)
+	assert.Nil(s)
+
+	var (
+		hn    = hashname.H(base32util.EncodeToString(randomKey(t)))
+		saved = &Snapshot{
+			Candidates: []Candidate{{Hashname: hn, FirstSeen: time.Unix(1000, 0).UTC()}},
+			Saved:      time.Unix(2000, 0).UTC(),
+		}
+	)
+	assert.NoError(store.Save(saved))
+
+	s, err = store.Load()
+	if assert.NoError(err) && assert.NotNil(s) {
+		assert.Equal(saved.Saved, s.Saved)
+		assert.Equal(fmt.Sprint(saved.Candidates), fmt.Sprint(s.Candidates))
+	}
+}
+
+func TestMaintainReplacesSilentPeer(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		A = openEndpoint(t, Module(Config{
+			MaintainInterval: 50 * time.Millisecond,
+			PingTimeout:      200 * time.Millisecond,
+		}), bridge.Module(bridge.Config{}))
+		R = openEndpoint(t, Module(Config{}), bridge.Module(bridge.Config{}))
+		B *e3x.Endpoint // doesn't answer seek requests
+		P *e3x.Endpoint
+	)
+	defer A.Close()
+	defer R.Close()
+
+	local, err := keyFromHashname(A.LocalHashname())
+	assert.NoError(err)
+
+	// the silent peer and the candidate must fall in the farthest bucket,
+	// which covers half of the key space
+	farthest := func(options ...e3x.EndpointOption) *e3x.Endpoint {
+		for i := 0; i < 32; i++ {
+			e := openEndpoint(t, options...)
+			key, err := keyFromHashname(e.LocalHashname())
+			assert.NoError(err)
+			if bucketIndex(distance(local, key)) == numBuckets-1 {
+				return e
+			}
+			e.Close()
+		}
+		t.Fatal("no endpoint in the farthest bucket")
+		return nil
+	}
+	B = farthest()
+	defer B.Close()
+	P = farthest(Module(Config{}), bridge.Module(bridge.Config{}))
+	defer P.Close()
+
+	r, err := R.LocalIdentity()
+	assert.NoError(err)
+	b, err := B.LocalIdentity()
+	assert.NoError(err)
+
+	_, err = P.Dial(r)
+	assert.NoError(err)
+	x, err := A.Dial(r)
+	assert.NoError(err)
+	_, err = A.Dial(b)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	// R names P, which makes P a candidate of A
+	_, err = FromEndpoint(A).Seek(x, P.LocalHashname())
+	assert.NoError(err)
+
+	time.Sleep(time.Second)
+	var hashnames []hashname.H
+	for _, p := range FromEndpoint(A).Peers() {
+		hashnames = append(hashnames, p.Hashname)
+	}
+	assert.Contains(fmt.Sprint(hashnames), string(P.LocalHashname()))
+	assert.NotContains(fmt.Sprint(hashnames), string(B.LocalHashname()))
+	assert.Nil(A.GetExchange(B.LocalHashname()))
+}
+
+func TestMaintainRefreshesIdleBuckets(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		A = openEndpoint(t, Module(Config{
+			MaintainInterval: 50 * time.Millisecond,
+			IdleBucketAfter:  200 * time.Millisecond,
+		}))
+		B = openEndpoint(t, Module(Config{}))
+	)
+	defer A.Close()
+	defer B.Close()
+
+	ident, err := B.LocalIdentity()
+	assert.NoError(err)
+	_, err = A.Dial(ident)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	mod := FromEndpoint(A).(*module)
+	buckets := mod.refreshCandidates()
+	if !assert.NotEmpty(buckets) {
+		return
+	}
+	for _, b := range buckets {
+		assert.True(b.LastRefreshed.IsZero())
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	for _, b := range mod.refreshCandidates() {
+		assert.False(b.LastRefreshed.IsZero(), "bucket %d was not refreshed", b.Index)
+	}
+}
+
+func TestClosestWithLatency(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		A = openEndpoint(t, Module(Config{PreferFast: true}))
+		B = openEndpoint(t, Module(Config{}))
+		C = openEndpoint(t, Module(Config{}))
+	)
+	defer A.Close()
+	defer B.Close()
+	defer C.Close()
+
+	dht := FromEndpoint(A)
+
+	b, err := B.LocalIdentity()
+	assert.NoError(err)
+	c, err := C.LocalIdentity()
+	assert.NoError(err)
+	x, err := A.Dial(b)
+	assert.NoError(err)
+	_, err = A.Dial(c)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	// only B answered a seek
+	_, err = dht.Seek(x, C.LocalHashname())
+	assert.NoError(err)
+
+	peers := dht.ClosestWithLatency(B.LocalHashname(), 2)
+	if assert.Len(peers, 2) {
+		assert.Equal(B.LocalHashname(), peers[0].Hashname)
+		assert.True(peers[0].RTT > 0)
+	}
+	for _, p := range peers {
+		if p.Hashname == C.LocalHashname() {
+			assert.Equal(time.Duration(0), p.RTT)
+		}
+	}
+}
+
+func TestSeeReportsIdentities(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var (
+		A = openEndpoint(t, Module(Config{})) // no bridge; connects directly
+		R = openEndpoint(t, Module(Config{}))
+		P = openEndpoint(t, Module(Config{}))
+	)
+	defer A.Close()
+	defer R.Close()
+	defer P.Close()
+
+	r, err := R.LocalIdentity()
+	assert.NoError(err)
+	p, err := P.LocalIdentity()
+	assert.NoError(err)
+	_, err = R.Dial(p)
+	assert.NoError(err)
+	x, err := A.Dial(r)
+	assert.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+
+	dht := FromEndpoint(A)
+
+	see, err := dht.Seek(x, P.LocalHashname())
+	if assert.NoError(err) {
+		assert.Equal([]hashname.H{P.LocalHashname()}, see)
+	}
+
+	candidates := dht.Candidates()
+	if assert.Len(candidates, 1) && assert.NotNil(candidates[0].Identity) {
+		assert.Equal(P.LocalHashname(), candidates[0].Identity.Hashname())
+		assert.NotEmpty(candidates[0].Identity.Addresses())
+	}
+
+	y, err := dht.Connect(P.LocalHashname())
+	if assert.NoError(err) && assert.NotNil(y) {
+		assert.Equal(P.LocalHashname(), y.RemoteHashname())
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	assert := assert.New(t)
+
+	mod := newDHT(nil, Config{})
+	assert.NoError(mod.Stop())
+	assert.NoError(mod.Stop())
+}
@@ -0,0 +1,131 @@
+package dht
+
+import (
+	"sort"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/modules/bridge"
+)
+
+// PlannedSeek is a seek which a lookup would make (see DryRunSeek).
+type PlannedSeek struct {
+	// Peer is the peer which would be asked.
+	Peer hashname.H
+
+	// Round is the round of the lookup in which Peer would be asked. The
+	// peers in the routing table are asked in round 0 and the peers they
+	// return in later rounds.
+	Round int
+
+	// Linked is false when there is no exchange with Peer; the lookup skips
+	// such peers.
+	Linked bool
+
+	// See are the peers Peer is expected to return: the candidates it named in
+	// earlier see responses which are closest to the target. See is empty
+	// when Peer never named any peers.
+	See []hashname.H
+}
+
+func (mod *module) DryRunSeek(target hashname.H) []PlannedSeek {
+	key, err := keyFromHashname(target)
+	if err != nil {
+		return nil
+	}
+
+	var (
+		connects = bridge.FromEndpoint(mod.e) != nil
+		queried  = map[hashname.H]bool{mod.e.LocalHashname(): true}
+		known    = map[hashname.H]*peer{}
+		linked   = map[hashname.H]bool{}
+		plan     []PlannedSeek
+	)
+
+	for _, p := range mod.table.snapshot() {
+		known[p.Hashname] = &peer{hashname: p.Hashname, lastSeen: p.LastSeen}
+		linked[p.Hashname] = mod.exchangeFor(p.Hashname) != nil
+	}
+
+	for round := 0; ; round++ {
+		var next []hashname.H
+		for _, hn := range mod.plannedClosest(key, known) {
+			if !queried[hn] {
+				next = append(next, hn)
+			}
+		}
+		if len(next) == 0 {
+			return plan
+		}
+
+		for _, hn := range next {
+			queried[hn] = true
+
+			seek := PlannedSeek{Peer: hn, Round: round, Linked: linked[hn]}
+			if seek.Linked {
+				seek.See = mod.namedBy(hn, key)
+			}
+			plan = append(plan, seek)
+
+			if !connects {
+				continue
+			}
+
+			// the lookup connects to the peers it finds; assume it succeeds
+			for _, found := range seek.See {
+				if queried[found] || known[found] != nil || mod.e.GetExchange(found) != nil {
+					continue
+				}
+				known[found] = &peer{hashname: found}
+				linked[found] = true
+			}
+		}
+	}
+}
+
+// plannedClosest returns the config.K peers in known which are closest to key.
+func (mod *module) plannedClosest(key []byte, known map[hashname.H]*peer) []hashname.H {
+	peers := make([]*peer, 0, len(known))
+	for _, p := range known {
+		if p.key == nil {
+			k, err := keyFromHashname(p.hashname)
+			if err != nil {
+				continue
+			}
+			p.key = k
+		}
+		peers = append(peers, p)
+	}
+
+	sort.Sort(&byDistance{key, peers, mod.config.PreferFresh, mod.config.PreferFast})
+	if len(peers) > mod.config.K {
+		peers = peers[:mod.config.K]
+	}
+
+	l := make([]hashname.H, len(peers))
+	for i, p := range peers {
+		l[i] = p.hashname
+	}
+	return l
+}
+
+// namedBy returns the config.K candidates named by source which are closest to
+// key.
+func (mod *module) namedBy(source hashname.H, key []byte) []hashname.H {
+	named := map[hashname.H]*peer{}
+
+	mod.mtx.Lock()
+	for hn, c := range mod.candidates {
+		for _, src := range c.Sources {
+			if src == source {
+				named[hn] = &peer{hashname: hn}
+				break
+			}
+		}
+	}
+	mod.mtx.Unlock()
+
+	if len(named) == 0 {
+		return nil
+	}
+	return mod.plannedClosest(key, named)
+}
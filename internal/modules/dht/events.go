@@ -0,0 +1,43 @@
+package dht
+
+import (
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// PeerAddedEvent is published on the endpoint (see e3x.Endpoint.Subscribe)
+// when a peer is added to the routing table.
+type PeerAddedEvent struct {
+	Hashname hashname.H
+}
+
+// PeerEvictedEvent is published when a peer is removed from the routing
+// table, either because its exchange closed or because it stopped responding.
+type PeerEvictedEvent struct {
+	Hashname hashname.H
+	Reason   error
+}
+
+func (PeerAddedEvent) EventType() string   { return "dht.peer.added" }
+func (PeerEvictedEvent) EventType() string { return "dht.peer.evicted" }
+
+var (
+	_ e3x.Event = PeerAddedEvent{}
+	_ e3x.Event = PeerEvictedEvent{}
+)
+
+// addPeer adds hn to the routing table and reports it when it is new.
+func (mod *module) addPeer(hn hashname.H) {
+	known := mod.table.contains(hn)
+	if mod.table.add(hn) && !known {
+		mod.e.Publish(PeerAddedEvent{Hashname: hn})
+	}
+}
+
+// removePeer removes hn from the routing table and reports it when it was
+// there.
+func (mod *module) removePeer(hn hashname.H, reason error) {
+	if mod.table.remove(hn) {
+		mod.e.Publish(PeerEvictedEvent{Hashname: hn, Reason: reason})
+	}
+}
@@ -0,0 +1,113 @@
+package dht
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMinHealthyPeers   = 3
+	defaultMinHealthyBuckets = 2
+)
+
+// HealthReport describes how well the local node is embedded in the DHT.
+type HealthReport struct {
+	// Peers is the number of peers in the routing table.
+	Peers int
+
+	// Buckets is the number of non-empty buckets.
+	Buckets int
+
+	// ClosestBucket is the index of the non-empty bucket closest to the local
+	// hashname or -1 when the table is empty.
+	ClosestBucket int
+
+	// LastLookup is the time of the last successful lookup (the zero time when
+	// no lookup succeeded yet).
+	LastLookup time.Time
+
+	// Problems lists the deficiencies which make the node unhealthy.
+	Problems []string
+}
+
+// String returns a human readable summary of the report.
+func (r HealthReport) String() string {
+	if len(r.Problems) == 0 {
+		return fmt.Sprintf("healthy: %d active peers in %d buckets", r.Peers, r.Buckets)
+	}
+	return strings.Join(r.Problems, ", ")
+}
+
+func (mod *module) IsHealthy() (bool, HealthReport) {
+	var (
+		report = HealthReport{ClosestBucket: -1}
+		now    = time.Now()
+	)
+
+	mod.table.mtx.RLock()
+	for idx, bucket := range mod.table.buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		if report.ClosestBucket < 0 {
+			report.ClosestBucket = idx
+		}
+		report.Peers += len(bucket)
+		report.Buckets++
+	}
+	mod.table.mtx.RUnlock()
+
+	mod.mtx.Lock()
+	report.LastLookup = mod.lastLookup
+	mod.mtx.Unlock()
+
+	if report.Peers < mod.config.MinHealthyPeers {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("only %d active peers (want %d)", report.Peers, mod.config.MinHealthyPeers))
+	}
+
+	if report.Buckets < mod.config.MinHealthyBuckets {
+		switch report.ClosestBucket {
+		case -1:
+			report.Problems = append(report.Problems, "all buckets empty")
+		case 0:
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("only %d non-empty buckets (want %d)", report.Buckets, mod.config.MinHealthyBuckets))
+		default:
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("only %d non-empty buckets (want %d), buckets 0-%d empty",
+					report.Buckets, mod.config.MinHealthyBuckets, report.ClosestBucket-1))
+		}
+	}
+
+	if maxAge := mod.config.HealthyLookupAge; maxAge > 0 {
+		if report.LastLookup.IsZero() {
+			report.Problems = append(report.Problems, "no successful lookups")
+		} else if age := now.Sub(report.LastLookup); age > maxAge {
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("last successful lookup %s ago", age.Truncate(time.Second)))
+		}
+	}
+
+	return len(report.Problems) == 0, report
+}
+
+// lookupSucceeded records that a seek made on behalf of a lookup succeeded.
+func (mod *module) lookupSucceeded() {
+	mod.mtx.Lock()
+	mod.lastLookup = time.Now()
+	mod.mtx.Unlock()
+}
+
+func (mod *module) KeyspaceCoverage() []int {
+	coverage := make([]int, numBuckets)
+
+	mod.table.mtx.RLock()
+	for idx, bucket := range mod.table.buckets {
+		coverage[idx] = len(bucket)
+	}
+	mod.table.mtx.RUnlock()
+
+	return coverage
+}
@@ -0,0 +1,98 @@
+package dht
+
+import (
+	"io"
+	"sync"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/modules/bridge"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+)
+
+// joinFillConcurrency is the number of lookups joinFill runs at once.
+const joinFillConcurrency = 4
+
+// joinFill populates the table after joining the network through seed. It
+// looks up the local hashname and a random hashname in the range of each
+// bucket, starting each lookup at seed, which connects the peers queried by
+// those lookups. A failed lookup doesn't stop the others.
+func (mod *module) joinFill(seed *e3x.Exchange) {
+	if bridge.FromEndpoint(mod.e) == nil {
+		mod.log.Printf("join-fill: requires the bridge module")
+		return
+	}
+
+	targets := []hashname.H{mod.e.LocalHashname()}
+	for idx := 0; idx < numBuckets; idx++ {
+		target, err := randomHashnameInBucket(mod.config.Rand, mod.table.local, idx)
+		if err != nil {
+			mod.log.Printf("join-fill: %s", err)
+			return
+		}
+		targets = append(targets, target)
+	}
+
+	var (
+		initial     = []hashname.H{seed.RemoteHashname()}
+		ctx, cancel = mod.stopContext()
+		sem         = make(chan struct{}, joinFillConcurrency)
+		wg          sync.WaitGroup
+	)
+	defer cancel()
+
+	for _, target := range targets {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(target hashname.H) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if _, err := mod.lookupFrom(ctx, target, initial); err != nil {
+				mod.log.Printf("join-fill: lookup for %s: %s", target.Short(), err)
+			}
+		}(target)
+	}
+
+	wg.Wait()
+}
+
+// randomHashnameInBucket returns a random hashname (read from r) that falls in
+// bucket idx relative to local.
+func randomHashnameInBucket(r io.Reader, local []byte, idx int) (hashname.H, error) {
+	var d [keyLen]byte
+	if _, err := io.ReadFull(r, d[:]); err != nil {
+		return "", err
+	}
+
+	// clear the bits above idx and set bit idx
+	var (
+		byteIdx = keyLen - 1 - idx/8
+		bit     = byte(1) << uint(idx%8)
+	)
+	for i := 0; i < byteIdx; i++ {
+		d[i] = 0
+	}
+	d[byteIdx] = (d[byteIdx] & (bit - 1)) | bit
+
+	return hashname.H(base32util.EncodeToString(distance(local, d[:]))), nil
+}
+
+// lockedReader serializes reads from a source (like a math/rand.Rand) which is
+// not safe for concurrent use.
+type lockedReader struct {
+	mtx sync.Mutex
+	r   io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.r.Read(p)
+}
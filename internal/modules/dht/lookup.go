@@ -0,0 +1,257 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/modules/bridge"
+)
+
+// ErrLookupFailed is returned by Lookup when none of the peers it asked
+// responded.
+var ErrLookupFailed = errors.New("dht: no peer responded to the lookup")
+
+const defaultAlpha = 3
+
+type lookupState uint8
+
+const (
+	lookupUnqueried lookupState = iota
+	lookupPending
+	lookupResponded
+	lookupFailed
+)
+
+// lookupEntry is a peer in the shortlist of a lookup.
+type lookupEntry struct {
+	hashname hashname.H
+	distance []byte     // to the target
+	via      hashname.H // the peer which named it; empty for the initial peers
+	state    lookupState
+}
+
+type lookupResult struct {
+	hashname hashname.H
+	see      []hashname.H
+	err      error
+}
+
+// lookupQuery asks hn for the peers closest to the target. via is the peer
+// which named hn; it is empty when hn was one of the initial peers.
+type lookupQuery func(hn, via hashname.H) ([]hashname.H, error)
+
+func (mod *module) Lookup(target hashname.H) ([]hashname.H, error) {
+	return mod.LookupContext(context.Background(), target)
+}
+
+func (mod *module) LookupContext(ctx context.Context, target hashname.H) ([]hashname.H, error) {
+	return mod.lookupFrom(ctx, target, mod.table.closest(target, mod.config.K))
+}
+
+// lookupFrom runs the lookup of LookupContext starting at the initial peers
+// instead of the closest peers in the table.
+func (mod *module) lookupFrom(ctx context.Context, target hashname.H, initial []hashname.H) ([]hashname.H, error) {
+	b := bridge.FromEndpoint(mod.e)
+
+	mod.markRefreshed(target)
+
+	return iterativeLookup(ctx, mod.e.LocalHashname(), target, initial,
+		mod.config.K, mod.config.Alpha, mod.config.LookupTimeout, mod.config.OrderedLookups,
+		func(hn, via hashname.H) ([]hashname.H, error) {
+			x := mod.exchangeFor(hn)
+			if x == nil {
+				// leave half of the timeout for an introduction
+				x, _ = mod.dialReported(hn, mod.config.LookupTimeout/2)
+			}
+			if x == nil {
+				router := mod.exchangeFor(via)
+				if router == nil {
+					return nil, ErrNoRouters
+				}
+				if b == nil {
+					return nil, ErrNoBridge
+				}
+
+				y, err := b.IntroduceContext(ctx, router, hn)
+				if err != nil {
+					return nil, err
+				}
+
+				mod.linkExchange(y)
+				x = y
+			}
+
+			see, err := mod.SeekContext(ctx, x, target)
+			if err != nil {
+				return nil, err
+			}
+
+			mod.lookupSucceeded()
+			return see, nil
+		})
+}
+
+// iterativeLookup runs a Kademlia node lookup for target starting at the
+// initial peers. Up to alpha queries are outstanding at any time; each query
+// goes to the closest peer which wasn't asked yet. The peers returned by a
+// query are merged into the shortlist. The lookup ends when the k closest peers
+// which didn't fail have all responded; those peers are returned, closest
+// first. A query which doesn't return within timeout fails. When ordered is set
+// the responses are handled in the order the queries were sent (see
+// Config.OrderedLookups). Once ctx is done the lookup returns ctx.Err(); the
+// outstanding queries are abandoned.
+func iterativeLookup(ctx context.Context, local, target hashname.H, initial []hashname.H, k, alpha int, timeout time.Duration, ordered bool, query lookupQuery) ([]hashname.H, error) {
+	key, err := keyFromHashname(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if k <= 0 {
+		k = defaultK
+	}
+	if alpha <= 0 {
+		alpha = defaultAlpha
+	}
+	if timeout <= 0 {
+		timeout = seekTimeout
+	}
+
+	var (
+		entries   = make(map[hashname.H]*lookupEntry)
+		shortlist []*lookupEntry
+		results   = make(chan lookupResult, alpha)
+		queue     []chan lookupResult // the results of the pending queries when ordered
+		pending   int
+		lastErr   error
+	)
+
+	add := func(hn, via hashname.H) {
+		if hn == local || entries[hn] != nil {
+			return
+		}
+
+		other, err := keyFromHashname(hn)
+		if err != nil {
+			return
+		}
+
+		e := &lookupEntry{hashname: hn, distance: distance(key, other), via: via}
+		entries[hn] = e
+		shortlist = append(shortlist, e)
+	}
+
+	start := func(e *lookupEntry) {
+		e.state = lookupPending
+		pending++
+
+		out := results
+		if ordered {
+			out = make(chan lookupResult, 1)
+			queue = append(queue, out)
+		}
+
+		go func() {
+			done := make(chan lookupResult, 1)
+			go func() {
+				see, err := query(e.hashname, e.via)
+				done <- lookupResult{e.hashname, see, err}
+			}()
+
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+
+			select {
+			case r := <-done:
+				out <- r
+			case <-timer.C:
+				out <- lookupResult{hashname: e.hashname, err: e3x.ErrTimeout}
+			}
+		}()
+	}
+
+	for _, hn := range initial {
+		add(hn, "")
+	}
+
+	for {
+		sort.Sort(byLookupDistance(shortlist))
+
+		// ask the closest unqueried peers among the k closest live peers
+		n := 0
+		for _, e := range shortlist {
+			if n >= k || pending >= alpha {
+				break
+			}
+			if e.state == lookupFailed {
+				continue
+			}
+			n++
+
+			if e.state == lookupUnqueried {
+				start(e)
+			}
+		}
+
+		if pending == 0 {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		next := results
+		if ordered {
+			next, queue = queue[0], queue[1:]
+		}
+
+		var r lookupResult
+		select {
+		case r = <-next:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		pending--
+
+		e := entries[r.hashname]
+		if r.err != nil {
+			e.state = lookupFailed
+			lastErr = r.err
+			continue
+		}
+
+		e.state = lookupResponded
+		for _, hn := range r.see {
+			add(hn, r.hashname)
+		}
+	}
+
+	var closest []hashname.H
+	for _, e := range shortlist {
+		if len(closest) >= k {
+			break
+		}
+		if e.state == lookupResponded {
+			closest = append(closest, e.hashname)
+		}
+	}
+
+	if len(closest) == 0 {
+		if lastErr == nil {
+			lastErr = ErrLookupFailed
+		}
+		return nil, lastErr
+	}
+
+	return closest, nil
+}
+
+type byLookupDistance []*lookupEntry
+
+func (s byLookupDistance) Len() int           { return len(s) }
+func (s byLookupDistance) Less(i, j int) bool { return lessDistance(s[i].distance, s[j].distance) }
+func (s byLookupDistance) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
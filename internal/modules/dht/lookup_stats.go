@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultLookupWindow = 10 * time.Minute
+
+	// maxLookupOutcomes limits the number of seeks remembered by the window;
+	// the oldest are forgotten first.
+	maxLookupOutcomes = 4096
+)
+
+type lookupOutcome struct {
+	at time.Time
+	ok bool
+}
+
+// lookupWindow records the outcomes of the seeks made within a sliding window.
+type lookupWindow struct {
+	mtx      sync.Mutex
+	window   time.Duration
+	outcomes []lookupOutcome
+	ok       int
+
+	// the totals since the module was created
+	total     uint64
+	succeeded uint64
+}
+
+func newLookupWindow(window time.Duration) *lookupWindow {
+	return &lookupWindow{window: window}
+}
+
+func (w *lookupWindow) record(ok bool, now time.Time) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.expire(now)
+
+	if len(w.outcomes) >= maxLookupOutcomes {
+		w.drop(1)
+	}
+
+	w.outcomes = append(w.outcomes, lookupOutcome{now, ok})
+	w.total++
+	if ok {
+		w.ok++
+		w.succeeded++
+	}
+}
+
+// totals returns the number of seeks recorded since the window was created and
+// how many of them succeeded.
+func (w *lookupWindow) totals() (total, succeeded uint64) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return w.total, w.succeeded
+}
+
+// rate returns the fraction of the seeks within the window which succeeded or
+// 1 when no seeks were made within the window.
+func (w *lookupWindow) rate(now time.Time) float64 {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.expire(now)
+
+	if len(w.outcomes) == 0 {
+		return 1
+	}
+	return float64(w.ok) / float64(len(w.outcomes))
+}
+
+func (w *lookupWindow) expire(now time.Time) {
+	n := 0
+	for n < len(w.outcomes) && now.Sub(w.outcomes[n].at) >= w.window {
+		n++
+	}
+	w.drop(n)
+}
+
+func (w *lookupWindow) drop(n int) {
+	for _, o := range w.outcomes[:n] {
+		if o.ok {
+			w.ok--
+		}
+	}
+	w.outcomes = append(w.outcomes[:0], w.outcomes[n:]...)
+}
+
+func (mod *module) LookupSuccessRate() float64 {
+	return mod.lookups.rate(time.Now())
+}
@@ -0,0 +1,124 @@
+package dht
+
+import (
+	"sort"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+const defaultIdleBucketAfter = time.Hour
+
+// maintain runs maintainOnce every config.MaintainInterval. The buckets count
+// as refreshed when the loop starts.
+func (mod *module) maintain() {
+	ticker := time.NewTicker(mod.config.MaintainInterval)
+	defer ticker.Stop()
+
+	started := time.Now()
+
+	for {
+		select {
+		case <-mod.done:
+			return
+		case <-ticker.C:
+			mod.maintainOnce(started)
+		}
+	}
+}
+
+// maintainOnce verifies the least recently seen peer of each bucket (unless it
+// was seen within config.MaintainInterval) and refreshes the buckets which saw
+// no lookups within config.IdleBucketAfter (and since started).
+func (mod *module) maintainOnce(started time.Time) {
+	var (
+		now     = time.Now()
+		oldest  = map[int]PeerInfo{}
+		buckets []int
+	)
+
+	for _, p := range mod.table.snapshot() {
+		o, found := oldest[p.Bucket]
+		if !found {
+			buckets = append(buckets, p.Bucket)
+		}
+		if !found || p.LastSeen.Before(o.LastSeen) {
+			oldest[p.Bucket] = p
+		}
+	}
+
+	for _, idx := range buckets {
+		p := oldest[idx]
+		if p.LastSeen.After(now.Add(-mod.config.MaintainInterval)) {
+			continue
+		}
+		go mod.verify(idx, p.Hashname)
+	}
+
+	deadline := now.Add(-mod.config.IdleBucketAfter)
+	if !started.Before(deadline) {
+		return
+	}
+	for _, b := range mod.refreshCandidates() {
+		select {
+		case <-mod.done:
+			return
+		default:
+		}
+
+		if !b.LastRefreshed.Before(deadline) {
+			continue
+		}
+		if err := mod.RefreshBucket(b.Index); err != nil {
+			mod.log.Printf("maintain: bucket %d: %s", b.Index, err)
+		}
+	}
+}
+
+// verify pings the peer hn in bucket idx. When hn doesn't respond it is
+// evicted in favor of the first pending candidate in the same bucket which
+// can be connected.
+func (mod *module) verify(idx int, hn hashname.H) {
+	if mod.ping(hn) {
+		return
+	}
+
+	for _, c := range mod.pendingCandidates(idx) {
+		if c == hn {
+			continue
+		}
+		if _, err := mod.Connect(c); err != nil {
+			mod.log.Printf("maintain: failed to replace %s with %s: %s", hn.Short(), c.Short(), err)
+			continue
+		}
+		mod.log.Printf("maintain: replaced %s with %s", hn.Short(), c.Short())
+		return
+	}
+}
+
+// pendingCandidates returns the candidates in bucket idx which are not in the
+// table, the most valuable first (see admitCandidate).
+func (mod *module) pendingCandidates(idx int) []hashname.H {
+	var l []Candidate
+
+	mod.mtx.Lock()
+	for hn, c := range mod.candidates {
+		key, err := keyFromHashname(hn)
+		if err != nil || bucketIndex(distance(mod.table.local, key)) != idx {
+			continue
+		}
+		l = append(l, c.clone())
+	}
+	mod.mtx.Unlock()
+
+	sort.Sort(byHashname(l))
+	sort.SliceStable(l, func(i, j int) bool { return l[j].lessValuable(&l[i]) })
+
+	var pending []hashname.H
+	for _, c := range l {
+		if !mod.table.contains(c.Hashname) {
+			pending = append(pending, c.Hashname)
+		}
+	}
+	return pending
+}
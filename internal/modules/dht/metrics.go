@@ -0,0 +1,32 @@
+package dht
+
+import (
+	"strconv"
+
+	"github.com/telehash/gogotelehash/e3x"
+)
+
+var _ e3x.MetricsModule = (*module)(nil)
+
+func (mod *module) WriteMetrics(w *e3x.MetricsWriter) {
+	var (
+		_, report        = mod.IsHealthy()
+		total, succeeded = mod.lookups.totals()
+		fill             = map[string]float64{}
+	)
+
+	for _, p := range mod.table.snapshot() {
+		fill[strconv.Itoa(p.Bucket)]++
+	}
+
+	w.Gauge("telehash_dht_peers", "Peers in the routing table.", float64(report.Peers))
+	w.Gauge("telehash_dht_buckets", "Non-empty buckets in the routing table.", float64(report.Buckets))
+	w.GaugeVec("telehash_dht_bucket_peers", "Peers in each non-empty bucket of the routing table.",
+		"bucket", fill)
+	w.Gauge("telehash_dht_bucket_capacity", "Peers a bucket can hold (K).", float64(mod.config.K))
+	w.Gauge("telehash_dht_candidates", "Peers named in see responses.", float64(len(mod.Candidates())))
+	w.Counter("telehash_dht_lookups_total", "Seeks made by the local node.", float64(total))
+	w.Counter("telehash_dht_lookups_succeeded_total", "Seeks which returned at least one peer.", float64(succeeded))
+	w.Gauge("telehash_dht_lookup_success_ratio", "Fraction of the seeks within the lookup window which succeeded.",
+		mod.LookupSuccessRate())
+}
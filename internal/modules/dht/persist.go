@@ -0,0 +1,189 @@
+package dht
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+)
+
+// ErrRejoinFailed is returned by Load when none of the saved peers could be
+// reached.
+var ErrRejoinFailed = errors.New("dht: failed to reach any saved peer")
+
+const defaultSaveInterval = 5 * time.Minute
+
+// Snapshot is the persisted state of the routing table.
+type Snapshot struct {
+	// Peers are the identities of the peers in the routing table, including
+	// the paths at which they were reachable. The peers nearest to the local
+	// hashname come first.
+	Peers []*e3x.Identity `json:"peers"`
+
+	// Candidates are the peers which were named by other peers (see
+	// Candidates).
+	Candidates []Candidate `json:"candidates,omitempty"`
+
+	Saved time.Time `json:"saved"`
+}
+
+// Store persists snapshots of the routing table across restarts.
+type Store interface {
+	Save(s *Snapshot) error
+
+	// Load returns the last saved snapshot, or nil when none was saved.
+	Load() (*Snapshot, error)
+}
+
+// FileStore is a Store which keeps the snapshot as JSON in the file at its
+// path. The file is replaced atomically.
+type FileStore string
+
+func (path FileStore) Save(s *Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(string(path)), filepath.Base(string(path))+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), string(path))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (path FileStore) Load() (*Snapshot, error) {
+	data, err := ioutil.ReadFile(string(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (mod *module) Save(store Store) error {
+	s := &Snapshot{Candidates: mod.Candidates(), Saved: time.Now()}
+
+	for _, peer := range mod.table.snapshot() {
+		if x := mod.exchangeFor(peer.Hashname); x != nil {
+			s.Peers = append(s.Peers, x.RemoteIdentity())
+		}
+	}
+
+	if len(s.Peers) == 0 {
+		// keep the previous neighbors; they are a better start than nothing
+		return nil
+	}
+
+	return store.Save(s)
+}
+
+func (mod *module) Load(store Store) ([]*e3x.Exchange, error) {
+	s, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || len(s.Peers) == 0 {
+		return nil, ErrRejoinFailed
+	}
+
+	mod.restoreCandidates(s.Candidates)
+
+	var (
+		wg        sync.WaitGroup
+		mtx       sync.Mutex
+		exchanges []*e3x.Exchange
+		slots     = make(chan struct{}, mod.config.K)
+	)
+
+	for _, ident := range s.Peers {
+		if ident == nil || ident.Hashname() == mod.e.LocalHashname() {
+			continue
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(ident *e3x.Identity) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			x, err := mod.dialSeed(ident)
+			if err != nil {
+				mod.log.Printf("load: failed to reach %s: %s", ident.Hashname().Short(), err)
+				return
+			}
+
+			mtx.Lock()
+			exchanges = append(exchanges, x)
+			mtx.Unlock()
+		}(ident)
+	}
+	wg.Wait()
+
+	if len(exchanges) == 0 {
+		return nil, ErrRejoinFailed
+	}
+	return exchanges, nil
+}
+
+// restoreCandidates remembers the saved candidates which are not known yet.
+func (mod *module) restoreCandidates(saved []Candidate) {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	local := mod.e.LocalHashname()
+	for _, s := range saved {
+		if s.Hashname == local || mod.candidates[s.Hashname] != nil {
+			continue
+		}
+
+		c := mod.admitCandidate(s.Hashname, s.FirstSeen)
+		if c == nil {
+			continue
+		}
+		for _, src := range s.Sources {
+			c.addSource(src)
+		}
+		c.Identity = s.Identity
+	}
+}
+
+// autosave saves the routing table in config.Store every config.SaveInterval.
+func (mod *module) autosave() {
+	ticker := time.NewTicker(mod.config.SaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mod.done:
+			return
+		case <-ticker.C:
+			if err := mod.Save(mod.config.Store); err != nil {
+				mod.log.Printf("save: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package dht
+
+import (
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// Reachability describes how a peer can be reached from the local node (see
+// PeerReachability).
+type Reachability int
+
+const (
+	// ReachUnknown peers are neither in the routing table nor candidates.
+	ReachUnknown Reachability = iota
+
+	// ReachDirect peers are linked; they are one hop away.
+	ReachDirect
+
+	// ReachViaRouter peers were named by a linked peer which can introduce the
+	// local node (see Connect); they are two hops away.
+	ReachViaRouter
+
+	// ReachDHTOnly peers are known but neither linked nor named by a linked
+	// peer; they can only be found through an iterative lookup.
+	ReachDHTOnly
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case ReachUnknown:
+		return "unknown"
+	case ReachDirect:
+		return "direct"
+	case ReachViaRouter:
+		return "via-router"
+	case ReachDHTOnly:
+		return "dht-only"
+	default:
+		return "invalid"
+	}
+}
+
+func (mod *module) PeerReachability(hn hashname.H) Reachability {
+	if mod.exchangeFor(hn) != nil {
+		return ReachDirect
+	}
+
+	sources := mod.sources(hn)
+	for _, src := range sources {
+		if mod.exchangeFor(src) != nil {
+			return ReachViaRouter
+		}
+	}
+
+	if len(sources) > 0 || mod.table.contains(hn) {
+		return ReachDHTOnly
+	}
+
+	return ReachUnknown
+}
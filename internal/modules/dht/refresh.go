@@ -0,0 +1,172 @@
+package dht
+
+import (
+	"errors"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// ErrInvalidBucket is returned by RefreshBucket when the bucket index is out of
+// range.
+var ErrInvalidBucket = errors.New("dht: invalid bucket index")
+
+func (mod *module) RefreshBucket(idx int) error {
+	if idx < 0 || idx >= numBuckets {
+		return ErrInvalidBucket
+	}
+
+	target, err := randomHashnameInBucket(mod.config.Rand, mod.table.local, idx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := mod.stopContext()
+	defer cancel()
+
+	if _, err := mod.LookupContext(ctx, target); err != nil {
+		mod.log.Printf("refresh: lookup for bucket %d: %s", idx, err)
+	}
+	return nil
+}
+
+// markRefreshed records that the bucket holding target was refreshed now by a
+// lookup for target.
+func (mod *module) markRefreshed(target hashname.H) {
+	key, err := keyFromHashname(target)
+	if err != nil {
+		return
+	}
+
+	idx := bucketIndex(distance(mod.table.local, key))
+	if idx < 0 {
+		return
+	}
+
+	mod.mtx.Lock()
+	mod.refreshed[idx] = time.Now()
+	mod.mtx.Unlock()
+}
+
+// BucketState describes a bucket considered by a RefreshStrategy.
+type BucketState struct {
+	// Index is the index of the bucket; lower indices are nearer to the local
+	// hashname.
+	Index int
+
+	// Peers is the number of peers in the bucket.
+	Peers int
+
+	// LastRefreshed is the last time the bucket was refreshed, either by
+	// RefreshBucket or by a Lookup for a hashname in the bucket. It is zero for
+	// buckets which were never refreshed.
+	LastRefreshed time.Time
+}
+
+// RefreshStrategy chooses the bucket refreshed by the next iteration of the
+// refresh loop (see Config.RefreshInterval).
+type RefreshStrategy interface {
+	// NextBucket returns the Index of the bucket in buckets which must be
+	// refreshed next. buckets is never empty and is ordered by Index. k is the
+	// maximum number of peers in a bucket.
+	NextBucket(buckets []BucketState, k int) int
+}
+
+var (
+	// RefreshOldestFirst refreshes the bucket which was refreshed least
+	// recently. Buckets which were never refreshed come first, nearest first.
+	RefreshOldestFirst RefreshStrategy = oldestFirst{}
+
+	// RefreshNearestFirst refreshes the nearest bucket which is not full. It
+	// keeps the buckets around the local hashname dense. When all buckets are
+	// full the oldest bucket is refreshed.
+	RefreshNearestFirst RefreshStrategy = nearestFirst{}
+
+	// RefreshSparsestFirst refreshes the bucket with the fewest peers, which
+	// spreads the table over the key space. Ties are broken by refreshing the
+	// oldest bucket.
+	RefreshSparsestFirst RefreshStrategy = sparsestFirst{}
+)
+
+type oldestFirst struct{}
+
+func (oldestFirst) NextBucket(buckets []BucketState, k int) int {
+	next := buckets[0]
+	for _, b := range buckets[1:] {
+		if b.LastRefreshed.Before(next.LastRefreshed) {
+			next = b
+		}
+	}
+	return next.Index
+}
+
+type nearestFirst struct{}
+
+func (nearestFirst) NextBucket(buckets []BucketState, k int) int {
+	for _, b := range buckets {
+		if b.Peers < k {
+			return b.Index
+		}
+	}
+	return oldestFirst{}.NextBucket(buckets, k)
+}
+
+type sparsestFirst struct{}
+
+func (sparsestFirst) NextBucket(buckets []BucketState, k int) int {
+	next := buckets[0]
+	for _, b := range buckets[1:] {
+		if b.Peers < next.Peers ||
+			(b.Peers == next.Peers && b.LastRefreshed.Before(next.LastRefreshed)) {
+			next = b
+		}
+	}
+	return next.Index
+}
+
+// refresh periodically refreshes the bucket chosen by config.RefreshStrategy.
+func (mod *module) refresh() {
+	ticker := time.NewTicker(mod.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mod.done:
+			return
+		case <-ticker.C:
+			buckets := mod.refreshCandidates()
+			if len(buckets) == 0 {
+				continue
+			}
+			idx := mod.config.RefreshStrategy.NextBucket(buckets, mod.config.K)
+			if err := mod.RefreshBucket(idx); err != nil {
+				mod.log.Printf("refresh: bucket %d: %s", idx, err)
+			}
+		}
+	}
+}
+
+// refreshCandidates returns the state of the buckets which can be refreshed:
+// the nearest non-empty bucket and all the buckets beyond it. Nearer buckets
+// cover too little of the key space to hold any peers. No buckets are returned
+// when the table is empty.
+func (mod *module) refreshCandidates() []BucketState {
+	var buckets []BucketState
+
+	mod.table.mtx.RLock()
+	for idx, bucket := range mod.table.buckets {
+		if len(bucket) == 0 && len(buckets) == 0 {
+			continue
+		}
+		buckets = append(buckets, BucketState{Index: idx, Peers: len(bucket)})
+	}
+	mod.table.mtx.RUnlock()
+
+	mod.mtx.Lock()
+	for i := range buckets {
+		buckets[i].LastRefreshed = mod.refreshed[buckets[i].Index]
+	}
+	mod.mtx.Unlock()
+
+	return buckets
+}
@@ -0,0 +1,385 @@
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// ErrSeekerClosed is returned by Seek when the seek channel was closed before
+// a response was received.
+var ErrSeekerClosed = errors.New("dht: seek channel closed")
+
+// ErrNoIdentity is returned by SeekIdentity when the peer didn't include its
+// identity in its response.
+var ErrNoIdentity = errors.New("dht: peer did not report its identity")
+
+const seekTimeout = 10 * time.Second
+
+// seeker multiplexes seek requests over a single seek channel. Each request
+// carries a nonce which the responder echoes in its see response. This allows
+// responses to be attributed to the right request even when several requests
+// are outstanding.
+type seeker struct {
+	mtx     sync.Mutex
+	c       *e3x.Channel
+	table   *table
+	pending map[string]chan seeResponse
+	self    *e3x.Identity // as last reported by the remote peer
+	closed  bool
+}
+
+// seeResponse is a see response: the peers closest to the target along with
+// the identities of those peers, as far as they fit in the response.
+type seeResponse struct {
+	see    []hashname.H
+	idents []*e3x.Identity
+}
+
+func newSeeker(c *e3x.Channel, t *table) *seeker {
+	return &seeker{
+		c:       c,
+		table:   t,
+		pending: make(map[string]chan seeResponse),
+	}
+}
+
+func (mod *module) Seek(x *e3x.Exchange, target hashname.H) ([]hashname.H, error) {
+	return mod.SeekContext(context.Background(), x, target)
+}
+
+func (mod *module) SeekContext(ctx context.Context, x *e3x.Exchange, target hashname.H) ([]hashname.H, error) {
+	s, err := mod.getSeeker(x)
+	if err != nil {
+		mod.lookups.record(false, time.Now())
+		return nil, err
+	}
+
+	r, err := s.query(ctx, target, seekTimeout)
+	mod.lookups.record(err == nil && len(r.see) > 0, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	mod.addCandidates(x.RemoteHashname(), r.see)
+	mod.addIdentities(r.see, r.idents)
+	return r.see, nil
+}
+
+func (mod *module) SeekIdentity(x *e3x.Exchange) (*e3x.Identity, error) {
+	s, err := mod.getSeeker(x)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.seek(x.RemoteHashname(), seekTimeout); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	ident := s.self
+	s.mtx.Unlock()
+
+	if ident == nil || ident.Hashname() != x.RemoteHashname() {
+		return nil, ErrNoIdentity
+	}
+	return ident, nil
+}
+
+func (mod *module) getSeeker(x *e3x.Exchange) (*seeker, error) {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	if s := mod.seekers[x]; s != nil {
+		return s, nil
+	}
+
+	c, err := x.Open("seek", false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSeeker(c, mod.table)
+	mod.seekers[x] = s
+
+	go func() {
+		s.run()
+
+		mod.mtx.Lock()
+		if mod.seekers[x] == s {
+			delete(mod.seekers, x)
+		}
+		mod.mtx.Unlock()
+	}()
+
+	return s, nil
+}
+
+func (s *seeker) seek(target hashname.H, timeout time.Duration) ([]hashname.H, error) {
+	r, err := s.query(context.Background(), target, timeout)
+	return r.see, err
+}
+
+// query sends a seek for target and waits for the response, until timeout
+// passed or ctx is done.
+func (s *seeker) query(ctx context.Context, target hashname.H, timeout time.Duration) (seeResponse, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return seeResponse{}, err
+	}
+
+	see := make(chan seeResponse, 1)
+
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return seeResponse{}, ErrSeekerClosed
+	}
+	s.pending[nonce] = see
+	s.mtx.Unlock()
+
+	defer func() {
+		s.mtx.Lock()
+		delete(s.pending, nonce)
+		s.mtx.Unlock()
+	}()
+
+	pkt := &lob.Packet{}
+	pkt.Header().SetString("seek", string(target))
+	pkt.Header().SetString("nonce", nonce)
+	sent := time.Now()
+	if err := s.c.WritePacket(pkt); err != nil {
+		return seeResponse{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r, ok := <-see:
+		if !ok {
+			return seeResponse{}, ErrSeekerClosed
+		}
+		s.table.sampleRTT(s.c.RemoteHashname(), time.Since(sent))
+		return r, nil
+	case <-timer.C:
+		return seeResponse{}, e3x.ErrTimeout
+	case <-ctx.Done():
+		return seeResponse{}, ctx.Err()
+	}
+}
+
+func (s *seeker) run() {
+	defer s.close()
+
+	for {
+		pkt, err := s.c.ReadPacket()
+		if err != nil {
+			return
+		}
+
+		s.table.touch(s.c.RemoteHashname())
+		s.received(pkt)
+	}
+}
+
+// received delivers a see response to the request with the matching nonce.
+// Responses without a known nonce are dropped.
+func (s *seeker) received(pkt *lob.Packet) {
+	nonce, ok := pkt.Header().GetString("nonce")
+	if !ok {
+		return
+	}
+
+	v, _ := pkt.Header().Get("see")
+	r := seeResponse{see: parseSee(v)}
+
+	if v, found := pkt.Header().Get("peers"); found {
+		r.idents = parseIdentities(v)
+	}
+
+	var self *e3x.Identity
+	if v, found := pkt.Header().Get("self"); found {
+		self = parseIdentity(v)
+	}
+
+	s.mtx.Lock()
+	if self != nil {
+		s.self = self
+	}
+	c := s.pending[nonce]
+	delete(s.pending, nonce)
+	s.mtx.Unlock()
+
+	if c != nil {
+		c <- r
+	}
+}
+
+func (s *seeker) close() {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return
+	}
+	s.closed = true
+	pending := s.pending
+	s.pending = make(map[string]chan seeResponse)
+	s.mtx.Unlock()
+
+	for _, c := range pending {
+		close(c)
+	}
+
+	s.c.Kill()
+}
+
+func (mod *module) handle_seek(c *e3x.Channel) {
+	defer c.Kill()
+
+	log := mod.log.From(c.RemoteHashname()).To(mod.e.LocalHashname())
+
+	for {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+
+		mod.table.touch(c.RemoteHashname())
+
+		target, ok := pkt.Header().GetString("seek")
+		if !ok {
+			log.Printf("drop: no seek in packet")
+			continue
+		}
+		nonce, _ := pkt.Header().GetString("nonce")
+
+		var (
+			resp   = &lob.Packet{}
+			see    []string
+			idents []*e3x.Identity
+		)
+		if hashname.H(target) == mod.e.LocalHashname() {
+			// we are the target; answer authoritatively with our own identity
+			// (including the addresses at which we are reachable).
+			see = []string{target}
+			if ident, err := mod.e.LocalIdentity(); err == nil {
+				resp.Header().Set("self", ident)
+			}
+		} else {
+			limit := mod.seeLimit(c.RemoteHashname())
+			for _, hn := range mod.table.closest(hashname.H(target), mod.config.K+1) {
+				if hn == c.RemoteHashname() || len(see) >= limit {
+					continue
+				}
+				see = append(see, string(hn))
+				if x := mod.exchangeFor(hn); x != nil && x.RemoteIdentity() != nil {
+					idents = append(idents, x.RemoteIdentity())
+				}
+			}
+		}
+
+		resp.Header().Set("see", see)
+		if nonce != "" {
+			resp.Header().SetString("nonce", nonce)
+		}
+		if len(idents) > 0 {
+			resp.Header().Set("peers", idents)
+		}
+		err = c.WritePacket(resp)
+		for err == e3x.ErrPacketTooLarge && len(idents) > 0 {
+			// leave out the identities of the farthest peers; they can still be
+			// reached through an introduction
+			idents = idents[:len(idents)-1]
+			if len(idents) > 0 {
+				resp.Header().Set("peers", idents)
+			} else {
+				delete(resp.Header().Extra, "peers")
+			}
+			err = c.WritePacket(resp)
+		}
+		if err == e3x.ErrPacketTooLarge {
+			// too many paths; answer without the identity
+			delete(resp.Header().Extra, "self")
+			err = c.WritePacket(resp)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// seeLimit returns the maximum number of peers returned in see responses to
+// requester.
+func (mod *module) seeLimit(requester hashname.H) int {
+	limit := mod.config.K
+	if f := mod.config.SeeLimit; f != nil {
+		if n := f(requester); n < limit {
+			limit = n
+		}
+	}
+	return limit
+}
+
+func parseSee(v interface{}) []hashname.H {
+	var l []hashname.H
+
+	switch x := v.(type) {
+	case []string:
+		for _, s := range x {
+			l = append(l, hashname.H(s))
+		}
+	case []interface{}:
+		for _, y := range x {
+			if s, ok := y.(string); ok {
+				l = append(l, hashname.H(s))
+			}
+		}
+	}
+
+	return l
+}
+
+// parseIdentity decodes an identity from a (decoded) JSON header value.
+func parseIdentity(v interface{}) *e3x.Identity {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	ident := &e3x.Identity{}
+	if err := json.Unmarshal(data, ident); err != nil {
+		return nil
+	}
+	return ident
+}
+
+// parseIdentities decodes a list of identities from a (decoded) JSON header
+// value. Entries which can't be decoded are skipped.
+func parseIdentities(v interface{}) []*e3x.Identity {
+	l, _ := v.([]interface{})
+
+	var idents []*e3x.Identity
+	for _, w := range l {
+		if ident := parseIdentity(w); ident != nil {
+			idents = append(idents, ident)
+		}
+	}
+	return idents
+}
+
+func newNonce() (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
@@ -0,0 +1,493 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+)
+
+// ErrValueNotFound is returned by Get when neither the local node nor the peers
+// closest to the key store a value for it.
+var ErrValueNotFound = errors.New("dht: value not found")
+
+// ErrInvalidValue is returned when a value is malformed, expired or its
+// signature doesn't match its publisher.
+var ErrInvalidValue = errors.New("dht: invalid value")
+
+// ErrValueTooLarge is returned by Put when the data exceeds MaxValueSize.
+var ErrValueTooLarge = errors.New("dht: value too large")
+
+// ErrStoreFailed is returned by Put when none of the peers closest to the key
+// stored the value.
+var ErrStoreFailed = errors.New("dht: no peer stored the value")
+
+const (
+	defaultValueTTL          = 24 * time.Hour
+	defaultRepublishInterval = time.Hour
+	defaultMaxValueSize      = 1024
+	defaultMaxValueTTL       = 7 * 24 * time.Hour
+	defaultMaxStoredValues   = 4096
+
+	// storeAttempts is the number of times a store or fetch is sent before
+	// the peer is given up on.
+	storeAttempts = 3
+)
+
+// Value is a small signed value stored in the DHT. A value is stored at the K
+// peers closest to its Key, which is derived from the publisher's public key
+// and the name of the value; only the publisher can replace it.
+type Value struct {
+	// Key is the SHA-256 of PublicKey followed by Name (see ValueKey).
+	Key  []byte
+	Name string
+	Data []byte
+
+	// PublicKey is the key of the publisher which signed the value.
+	PublicKey ed25519.PublicKey
+
+	// Published is the time the value was signed, with a resolution of one
+	// second. A peer replaces a stored value only by a value published later.
+	Published time.Time
+
+	// TTL is the time the value is stored after it was published.
+	TTL time.Duration
+
+	Signature []byte
+}
+
+// ValueKey returns the key under which the value called name, published by the
+// holder of the private key of pub, is stored.
+func ValueKey(pub ed25519.PublicKey, name string) []byte {
+	h := sha256.New()
+	h.Write(pub)
+	h.Write([]byte(name))
+	return h.Sum(nil)
+}
+
+func newValue(prv ed25519.PrivateKey, name string, data []byte, ttl time.Duration, now time.Time) *Value {
+	pub := prv.Public().(ed25519.PublicKey)
+
+	v := &Value{
+		Key:       ValueKey(pub, name),
+		Name:      name,
+		Data:      data,
+		PublicKey: pub,
+		Published: time.Unix(now.Unix(), 0),
+		TTL:       ttl / time.Second * time.Second,
+	}
+	v.Signature = ed25519.Sign(prv, v.signedBytes())
+	return v
+}
+
+// Expires returns the time after which the value is no longer stored.
+func (v *Value) Expires() time.Time {
+	return v.Published.Add(v.TTL)
+}
+
+// Verify returns ErrInvalidValue unless the value was signed by its publisher
+// and its key matches.
+func (v *Value) Verify() error {
+	if len(v.PublicKey) != ed25519.PublicKeySize || v.TTL <= 0 {
+		return ErrInvalidValue
+	}
+	if !bytes.Equal(v.Key, ValueKey(v.PublicKey, v.Name)) {
+		return ErrInvalidValue
+	}
+	if !ed25519.Verify(v.PublicKey, v.signedBytes(), v.Signature) {
+		return ErrInvalidValue
+	}
+	return nil
+}
+
+func (v *Value) signedBytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(v.Key)
+	binary.Write(&buf, binary.BigEndian, v.Published.Unix())
+	binary.Write(&buf, binary.BigEndian, int64(v.TTL/time.Second))
+	buf.Write(v.Data)
+	return buf.Bytes()
+}
+
+func (v *Value) encode() *lob.Packet {
+	pkt := lob.New(v.Data)
+	hdr := pkt.Header()
+	hdr.SetString("key", hex.EncodeToString(v.Key))
+	hdr.SetString("name", v.Name)
+	hdr.SetString("pub", hex.EncodeToString(v.PublicKey))
+	hdr.SetInt("published", int(v.Published.Unix()))
+	hdr.SetInt("ttl", int(v.TTL/time.Second))
+	hdr.SetString("sig", hex.EncodeToString(v.Signature))
+	return pkt
+}
+
+// decodeValue decodes and verifies the value in pkt.
+func decodeValue(pkt *lob.Packet) (*Value, error) {
+	var (
+		hdr          = pkt.Header()
+		key, _       = hdr.GetString("key")
+		name, _      = hdr.GetString("name")
+		pub, _       = hdr.GetString("pub")
+		published, _ = hdr.GetInt("published")
+		ttl, _       = hdr.GetInt("ttl")
+		sig, _       = hdr.GetString("sig")
+		err          error
+	)
+
+	v := &Value{
+		Name:      name,
+		Data:      pkt.Body(nil),
+		Published: time.Unix(int64(published), 0),
+		TTL:       time.Duration(ttl) * time.Second,
+	}
+	if v.Key, err = hex.DecodeString(key); err != nil {
+		return nil, ErrInvalidValue
+	}
+	if v.PublicKey, err = hex.DecodeString(pub); err != nil {
+		return nil, ErrInvalidValue
+	}
+	if v.Signature, err = hex.DecodeString(sig); err != nil {
+		return nil, ErrInvalidValue
+	}
+
+	if err := v.Verify(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// hashnameFromKey returns the hashname which is at the position of key in the
+// key space.
+func hashnameFromKey(key []byte) hashname.H {
+	return hashname.H(base32util.EncodeToString(key))
+}
+
+func (mod *module) Put(name string, data []byte, ttl time.Duration) (*Value, error) {
+	if len(data) > mod.config.MaxValueSize {
+		return nil, ErrValueTooLarge
+	}
+	if ttl <= 0 {
+		ttl = mod.config.ValueTTL
+	}
+	if ttl > mod.config.MaxValueTTL {
+		ttl = mod.config.MaxValueTTL
+	}
+
+	v := newValue(mod.config.SigningKey, name, data, ttl, time.Now())
+
+	mod.mtx.Lock()
+	mod.published[string(v.Key)] = v
+	mod.mtx.Unlock()
+
+	return v, mod.replicate(v)
+}
+
+// replicate stores v at the K peers closest to its key.
+func (mod *module) replicate(v *Value) error {
+	closest, err := mod.Lookup(hashnameFromKey(v.Key))
+	if err != nil {
+		return err
+	}
+
+	stored := 0
+	for _, hn := range closest {
+		x := mod.exchangeFor(hn)
+		if x == nil {
+			continue
+		}
+
+		if err := mod.storeAt(x, v); err != nil {
+			mod.log.Printf("store: %x at %s failed: %s", v.Key[:4], hn.Short(), err)
+			continue
+		}
+		stored++
+	}
+
+	if stored == 0 {
+		return ErrStoreFailed
+	}
+	return nil
+}
+
+func (mod *module) Get(key []byte) (*Value, error) {
+	if v := mod.localValue(key, time.Now()); v != nil {
+		return v, nil
+	}
+
+	closest, err := mod.Lookup(hashnameFromKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hn := range closest {
+		x := mod.exchangeFor(hn)
+		if x == nil {
+			continue
+		}
+
+		v, err := mod.fetchFrom(x, key)
+		if err != nil {
+			continue
+		}
+		if v != nil && bytes.Equal(v.Key, key) {
+			return v, nil
+		}
+	}
+
+	return nil, ErrValueNotFound
+}
+
+// localValue returns the unexpired value for key which was published by the
+// local node or stored at it by a peer.
+func (mod *module) localValue(key []byte, now time.Time) *Value {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	v := mod.published[string(key)]
+	if v == nil {
+		v = mod.values[string(key)]
+	}
+	if v == nil || !now.Before(v.Expires()) {
+		return nil
+	}
+	return v
+}
+
+// storeValue stores v unless a value for the same key which was published later
+// is stored already. A value for a new key is only stored while fewer than
+// config.MaxStoredValues unexpired values are stored. It reports whether v is
+// stored.
+func (mod *module) storeValue(v *Value, now time.Time) bool {
+	if !now.Before(v.Expires()) {
+		return false
+	}
+
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	old := mod.values[string(v.Key)]
+	if old != nil && old.Published.After(v.Published) {
+		return false
+	}
+	if old == nil && len(mod.values) >= mod.config.MaxStoredValues {
+		mod.dropExpiredValues(now)
+		if len(mod.values) >= mod.config.MaxStoredValues {
+			return false
+		}
+	}
+	mod.values[string(v.Key)] = v
+	return true
+}
+
+// responsibleFor reports whether the local node is among the K peers closest
+// to key which it knows of; only those peers store the values for key.
+func (mod *module) responsibleFor(key []byte) bool {
+	var (
+		local  = distance(mod.table.local, key)
+		closer = 0
+	)
+
+	mod.table.walk(key, func(hn hashname.H) bool {
+		other, err := keyFromHashname(hn)
+		if err != nil {
+			return true
+		}
+		if !lessDistance(distance(other, key), local) {
+			return false
+		}
+		closer++
+		return closer < mod.config.K
+	})
+
+	return closer < mod.config.K
+}
+
+// request sends the packet returned by newPacket over a new channel of type typ
+// and returns the response. Store and fetch channels are unreliable; when no
+// response arrives within config.LookupTimeout a new packet is sent over a new
+// channel, up to storeAttempts times.
+func (mod *module) request(x *e3x.Exchange, typ string, newPacket func() *lob.Packet) (*lob.Packet, error) {
+	var err error
+
+	for i := 0; i < storeAttempts; i++ {
+		var resp *lob.Packet
+		resp, err = mod.requestOnce(x, typ, newPacket())
+		if err != e3x.ErrTimeout {
+			return resp, err
+		}
+	}
+
+	return nil, err
+}
+
+func (mod *module) requestOnce(x *e3x.Exchange, typ string, pkt *lob.Packet) (*lob.Packet, error) {
+	c, err := x.Open(typ, false)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Kill()
+
+	if err := c.WritePacket(pkt); err != nil {
+		return nil, err
+	}
+
+	c.SetReadDeadline(time.Now().Add(mod.config.LookupTimeout))
+	return c.ReadPacket()
+}
+
+// storeAt asks the peer at the other end of x to store v.
+func (mod *module) storeAt(x *e3x.Exchange, v *Value) error {
+	resp, err := mod.request(x, "store", v.encode)
+	if err != nil {
+		return err
+	}
+
+	if ok, _ := resp.Header().GetBool("stored"); !ok {
+		return ErrStoreFailed
+	}
+	return nil
+}
+
+// fetchFrom asks the peer at the other end of x for the value stored for key.
+// A nil value is returned when the peer doesn't store one; ErrInvalidValue is
+// returned when the peer returns an expired value.
+func (mod *module) fetchFrom(x *e3x.Exchange, key []byte) (*Value, error) {
+	resp, err := mod.request(x, "fetch", func() *lob.Packet {
+		pkt := &lob.Packet{}
+		pkt.Header().SetString("fetch", hex.EncodeToString(key))
+		return pkt
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if found, _ := resp.Header().GetBool("found"); !found {
+		return nil, nil
+	}
+
+	v, err := decodeValue(resp)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(v.Expires()) {
+		// like localValue, never return a value past its expiry
+		return nil, ErrInvalidValue
+	}
+	return v, nil
+}
+
+func (mod *module) handle_store(c *e3x.Channel) {
+	defer c.Kill()
+
+	log := mod.log.From(c.RemoteHashname()).To(mod.e.LocalHashname())
+
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		return
+	}
+
+	mod.table.touch(c.RemoteHashname())
+
+	stored := false
+	if pkt.BodyLen() > mod.config.MaxValueSize {
+		log.Printf("drop: value too large")
+	} else if v, err := decodeValue(pkt); err != nil {
+		log.Printf("drop: %s", err)
+	} else if v.TTL > mod.config.MaxValueTTL {
+		log.Printf("drop: ttl too long")
+	} else if !mod.responsibleFor(v.Key) {
+		log.Printf("drop: not among the closest peers to %x", v.Key[:4])
+	} else {
+		stored = mod.storeValue(v, time.Now())
+	}
+
+	resp := &lob.Packet{}
+	resp.Header().SetBool("stored", stored)
+	c.WritePacket(resp)
+}
+
+func (mod *module) handle_fetch(c *e3x.Channel) {
+	defer c.Kill()
+
+	log := mod.log.From(c.RemoteHashname()).To(mod.e.LocalHashname())
+
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		return
+	}
+
+	mod.table.touch(c.RemoteHashname())
+
+	keyStr, _ := pkt.Header().GetString("fetch")
+	key, err := hex.DecodeString(keyStr)
+	if err != nil || len(key) != sha256.Size {
+		log.Printf("drop: invalid key in fetch")
+		return
+	}
+
+	resp := &lob.Packet{}
+	v := mod.localValue(key, time.Now())
+	if v != nil {
+		resp = v.encode()
+	}
+	resp.Header().SetBool("found", v != nil)
+	c.WritePacket(resp)
+}
+
+// republish stores the unexpired values published by the local node at the K
+// closest peers again every config.RepublishInterval. Expired values are
+// dropped.
+func (mod *module) republish() {
+	ticker := time.NewTicker(mod.config.RepublishInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mod.done:
+			return
+		case now := <-ticker.C:
+			for _, v := range mod.expireValues(now) {
+				if err := mod.replicate(v); err != nil {
+					mod.log.Printf("republish: %x failed: %s", v.Key[:4], err)
+				}
+			}
+		}
+	}
+}
+
+// expireValues drops the expired values and returns the unexpired values
+// published by the local node.
+func (mod *module) expireValues(now time.Time) []*Value {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	mod.dropExpiredValues(now)
+
+	var live []*Value
+	for key, v := range mod.published {
+		if !now.Before(v.Expires()) {
+			delete(mod.published, key)
+			continue
+		}
+		live = append(live, v)
+	}
+	return live
+}
+
+// dropExpiredValues drops the expired values stored for peers; mod.mtx must be
+// held.
+func (mod *module) dropExpiredValues(now time.Time) {
+	for key, v := range mod.values {
+		if !now.Before(v.Expires()) {
+			delete(mod.values, key)
+		}
+	}
+}
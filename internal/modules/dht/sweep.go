@@ -0,0 +1,114 @@
+package dht
+
+import (
+	"sort"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+const (
+	defaultSweepInterval    = 30 * time.Second
+	defaultMaxPingsPerSweep = 4
+)
+
+// sweep periodically pings the peers which were not seen within
+// config.StaleAfter and removes those that fail to respond.
+func (mod *module) sweep() {
+	ticker := time.NewTicker(mod.config.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mod.done:
+			return
+		case <-ticker.C:
+			mod.sweepOnce()
+		}
+	}
+}
+
+// sweepOnce pings at most config.MaxPingsPerSweep of the stalest peers.
+func (mod *module) sweepOnce() {
+	var (
+		deadline = time.Now().Add(-mod.config.StaleAfter)
+		stale    []PeerInfo
+	)
+
+	for _, p := range mod.table.snapshot() {
+		if p.LastSeen.Before(deadline) {
+			stale = append(stale, p)
+		}
+	}
+
+	// stalest first
+	sort.Slice(stale, func(i, j int) bool { return stale[i].LastSeen.Before(stale[j].LastSeen) })
+	if len(stale) > mod.config.MaxPingsPerSweep {
+		stale = stale[:mod.config.MaxPingsPerSweep]
+	}
+
+	for _, p := range stale {
+		go mod.ping(p.Hashname)
+	}
+}
+
+// ping validates the peer hn by sending it a seek for its own hashname. The
+// peer is deactivated when it doesn't respond. ping returns false when hn was
+// deactivated; it returns true when a previous ping to hn is still outstanding.
+func (mod *module) ping(hn hashname.H) bool {
+	mod.mtx.Lock()
+	if mod.pinging[hn] {
+		// a previous ping is still outstanding
+		mod.mtx.Unlock()
+		return true
+	}
+	mod.pinging[hn] = true
+	mod.mtx.Unlock()
+
+	defer func() {
+		mod.mtx.Lock()
+		delete(mod.pinging, hn)
+		mod.mtx.Unlock()
+	}()
+
+	x := mod.exchangeFor(hn)
+	if x == nil {
+		mod.deactivatePeer(hn, e3x.ErrPeerGone)
+		return false
+	}
+
+	s, err := mod.getSeeker(x)
+	if err == nil {
+		_, err = s.seek(hn, mod.config.PingTimeout)
+	}
+	if err != nil {
+		mod.log.Printf("evicting %s: %s", hn.Short(), err)
+		mod.deactivatePeer(hn, err)
+		return false
+	}
+	return true
+}
+
+// deactivatePeer removes hn from the routing table. The exchange with hn is
+// dropped as well, failing its channels with e3x.ErrPeerGone, unless channels
+// other than those of the DHT still use it.
+func (mod *module) deactivatePeer(hn hashname.H, reason error) {
+	mod.removePeer(hn, reason)
+
+	if x := mod.e.GetExchange(hn); x != nil && x.ChannelCount(channelTypes...) == 0 {
+		mod.e.DropExchange(hn)
+	}
+}
+
+func (mod *module) exchangeFor(hn hashname.H) *e3x.Exchange {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	for x, linked := range mod.links {
+		if linked == hn {
+			return x
+		}
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+)
+
+// keyLen is the length (in bytes) of a decoded hashname.
+const keyLen = 32
+
+// numBuckets is the number of buckets in a table (one for each bit in a key).
+const numBuckets = keyLen * 8
+
+// table holds the known peers grouped in buckets by their distance to the local
+// hashname. Bucket i holds the peers whose distance d satisfies 2^i <= d < 2^(i+1).
+type table struct {
+	mtx         sync.RWMutex
+	local       []byte
+	k           int
+	preferFresh bool
+	preferFast  bool
+	buckets     [numBuckets][]*peer
+}
+
+type peer struct {
+	hashname hashname.H
+	key      []byte
+	lastSeen time.Time
+	rtt      time.Duration // smoothed; zero until the first sample
+}
+
+func newTable(local hashname.H, k int, preferFresh bool) (*table, error) {
+	key, err := keyFromHashname(local)
+	if err != nil {
+		return nil, err
+	}
+
+	return &table{local: key, k: k, preferFresh: preferFresh}, nil
+}
+
+// keyFromHashname decodes a hashname into its raw key.
+func keyFromHashname(hn hashname.H) ([]byte, error) {
+	if !hn.Valid() {
+		return nil, hashname.ErrInvalidKey
+	}
+
+	key, err := base32util.DecodeString(string(hn))
+	if err != nil {
+		return nil, hashname.ErrInvalidKey
+	}
+
+	return key, nil
+}
+
+// distance returns the XOR distance between a and b. When the lengths of a and
+// b differ the missing trailing bytes of the shorter key are treated as zero and
+// the distance has the length of the longer key.
+func distance(a, b []byte) []byte {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+
+	d := make([]byte, n)
+	for i := range d {
+		d[i] = byteAt(a, i) ^ byteAt(b, i)
+	}
+	return d
+}
+
+// byteAt returns the i-th byte of k or zero when k is shorter than i+1 bytes.
+func byteAt(k []byte, i int) byte {
+	if i < len(k) {
+		return k[i]
+	}
+	return 0
+}
+
+// bucketIndex returns the index of the bucket for distance d or -1 when d is zero.
+func bucketIndex(d []byte) int {
+	for i, b := range d {
+		if b == 0 {
+			continue
+		}
+		for j := 7; j >= 0; j-- {
+			if b&(1<<uint(j)) != 0 {
+				return (len(d)-i-1)*8 + j
+			}
+		}
+	}
+	return -1
+}
+
+// lessDistance returns true when distance a is smaller than distance b. Missing
+// trailing bytes are treated as zero.
+func lessDistance(a, b []byte) bool {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+
+	for i := 0; i < n; i++ {
+		x, y := byteAt(a, i), byteAt(b, i)
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+func (t *table) add(hn hashname.H) bool {
+	key, err := keyFromHashname(hn)
+	if err != nil {
+		return false
+	}
+
+	idx := bucketIndex(distance(t.local, key))
+	if idx < 0 {
+		return false // local hashname
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	bucket := t.buckets[idx]
+	for _, p := range bucket {
+		if p.hashname == hn {
+			p.lastSeen = time.Now()
+			return true
+		}
+	}
+
+	if len(bucket) >= t.k {
+		return false
+	}
+
+	t.buckets[idx] = append(bucket, &peer{hashname: hn, key: key, lastSeen: time.Now()})
+	return true
+}
+
+// touch marks hn as seen now. It is a no-op when hn is not in the table.
+func (t *table) touch(hn hashname.H) {
+	key, err := keyFromHashname(hn)
+	if err != nil {
+		return
+	}
+
+	idx := bucketIndex(distance(t.local, key))
+	if idx < 0 {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, p := range t.buckets[idx] {
+		if p.hashname == hn {
+			p.lastSeen = time.Now()
+			return
+		}
+	}
+}
+
+// sampleRTT folds the round-trip time d of a request to hn into the smoothed
+// RTT of hn. It is a no-op when hn is not in the table.
+func (t *table) sampleRTT(hn hashname.H, d time.Duration) {
+	key, err := keyFromHashname(hn)
+	if err != nil {
+		return
+	}
+
+	idx := bucketIndex(distance(t.local, key))
+	if idx < 0 {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, p := range t.buckets[idx] {
+		if p.hashname == hn {
+			if p.rtt == 0 {
+				p.rtt = d
+			} else {
+				p.rtt += (d - p.rtt) / 8
+			}
+			return
+		}
+	}
+}
+
+// contains returns true when hn is in the table.
+func (t *table) contains(hn hashname.H) bool {
+	key, err := keyFromHashname(hn)
+	if err != nil {
+		return false
+	}
+
+	idx := bucketIndex(distance(t.local, key))
+	if idx < 0 {
+		return false
+	}
+
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	for _, p := range t.buckets[idx] {
+		if p.hashname == hn {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes hn from the table; it returns false when hn wasn't in it.
+func (t *table) remove(hn hashname.H) bool {
+	key, err := keyFromHashname(hn)
+	if err != nil {
+		return false
+	}
+
+	idx := bucketIndex(distance(t.local, key))
+	if idx < 0 {
+		return false
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	bucket := t.buckets[idx]
+	for i, p := range bucket {
+		if p.hashname == hn {
+			copy(bucket[i:], bucket[i+1:])
+			bucket[len(bucket)-1] = nil
+			t.buckets[idx] = bucket[:len(bucket)-1]
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the n known peers which are closest to target.
+func (t *table) closest(target hashname.H, n int) []hashname.H {
+	peers := t.closestPeers(target, n, t.preferFast)
+
+	l := make([]hashname.H, len(peers))
+	for i, p := range peers {
+		l[i] = p.hashname
+	}
+	return l
+}
+
+// closestPeers returns copies of the n known peers which are closest to
+// target. When fast is set peers in the same bucket (relative to target) are
+// ordered by their RTT.
+func (t *table) closestPeers(target hashname.H, n int, fast bool) []*peer {
+	key, err := keyFromHashname(target)
+	if err != nil {
+		return nil
+	}
+
+	t.mtx.RLock()
+	var candidates []*peer
+	for _, bucket := range t.buckets {
+		for _, p := range bucket {
+			c := *p
+			candidates = append(candidates, &c)
+		}
+	}
+	t.mtx.RUnlock()
+
+	sort.Sort(&byDistance{key, candidates, t.preferFresh, fast})
+
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// walk calls fn for the known peers in order of increasing distance to key
+// until fn returns false. Only the buckets which are visited are sorted. The
+// table is read locked while walking; fn must not modify the table.
+func (t *table) walk(key []byte, fn func(hn hashname.H) bool) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	if len(key) != len(t.local) {
+		// the bucket order below requires keys of equal length
+		var peers []*peer
+		for _, bucket := range t.buckets {
+			peers = append(peers, bucket...)
+		}
+		walkPeers(key, peers, fn)
+		return
+	}
+
+	// Let d be the distance between the local key and key. The peers in a
+	// bucket whose bit is set in d are closer to key than d, the bucket for the
+	// highest set bit being the closest. The peers in the other buckets are
+	// further away than d, the bucket for the lowest bit being the closest.
+	d := distance(t.local, key)
+	for idx := numBuckets - 1; idx >= 0; idx-- {
+		if bitSet(d, idx) && !walkPeers(key, t.buckets[idx], fn) {
+			return
+		}
+	}
+	for idx := 0; idx < numBuckets; idx++ {
+		if !bitSet(d, idx) && !walkPeers(key, t.buckets[idx], fn) {
+			return
+		}
+	}
+}
+
+// walkPeers calls fn for peers in order of increasing distance to key. false is
+// returned when fn returned false.
+func walkPeers(key []byte, peers []*peer, fn func(hn hashname.H) bool) bool {
+	if len(peers) == 0 {
+		return true
+	}
+
+	sorted := make([]*peer, len(peers))
+	copy(sorted, peers)
+	sort.Sort(&byDistance{key, sorted, false, false})
+
+	for _, p := range sorted {
+		if !fn(p.hashname) {
+			return false
+		}
+	}
+	return true
+}
+
+// bitSet returns true when bit idx (counting from the least significant bit)
+// of d is set.
+func bitSet(d []byte, idx int) bool {
+	i := len(d) - 1 - idx/8
+	if i < 0 {
+		return false
+	}
+	return d[i]&(1<<uint(idx%8)) != 0
+}
+
+// snapshot returns information about all the peers in the table.
+func (t *table) snapshot() []PeerInfo {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	var l []PeerInfo
+	for idx, bucket := range t.buckets {
+		for _, p := range bucket {
+			l = append(l, PeerInfo{Hashname: p.hashname, Bucket: idx, LastSeen: p.lastSeen, RTT: p.rtt})
+		}
+	}
+	return l
+}
+
+// byDistance orders peers by their distance to target. When fast is set
+// peers in the same bucket (relative to target) are ordered by their RTT
+// instead, fastest first; peers without an RTT sample come last. When fresh
+// is set the remaining ties are ordered by how recently the peers were seen.
+type byDistance struct {
+	target []byte
+	peers  []*peer
+	fresh  bool
+	fast   bool
+}
+
+func (s *byDistance) Len() int      { return len(s.peers) }
+func (s *byDistance) Swap(i, j int) { s.peers[i], s.peers[j] = s.peers[j], s.peers[i] }
+func (s *byDistance) Less(i, j int) bool {
+	a := distance(s.target, s.peers[i].key)
+	b := distance(s.target, s.peers[j].key)
+
+	if s.fresh || s.fast {
+		ai, bi := bucketIndex(a), bucketIndex(b)
+		if ai != bi {
+			return ai < bi
+		}
+	}
+
+	if s.fast {
+		x, y := s.peers[i].rtt, s.peers[j].rtt
+		if x != y {
+			return y == 0 || (x != 0 && x < y)
+		}
+	}
+
+	if s.fresh {
+		if !s.peers[i].lastSeen.Equal(s.peers[j].lastSeen) {
+			return s.peers[i].lastSeen.After(s.peers[j].lastSeen)
+		}
+	}
+
+	return lessDistance(a, b)
+}
@@ -0,0 +1,222 @@
+package dht
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+)
+
+func TestPeersSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	tab, err := newTable(testHashname(0x00, 0x00), 8, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := testHashname(0x80, 0x00)
+	b := testHashname(0x00, 0x01)
+
+	before := time.Now()
+	assert.True(tab.add(a))
+	assert.True(tab.add(b))
+
+	peers := tab.snapshot()
+	if assert.Len(peers, 2) {
+		assert.Equal(b, peers[0].Hashname)
+		assert.Equal(0, peers[0].Bucket)
+		assert.Equal(a, peers[1].Hashname)
+		assert.Equal(numBuckets-1, peers[1].Bucket)
+		assert.False(peers[1].LastSeen.Before(before))
+	}
+
+	seen := peers[0].LastSeen
+	time.Sleep(time.Millisecond)
+	tab.touch(b)
+
+	peers = tab.snapshot()
+	if assert.Len(peers, 2) {
+		assert.True(peers[0].LastSeen.After(seen))
+	}
+}
+
+func TestClosestPreferFresh(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		local  = testHashname(0xff, 0x00)
+		target = testHashname(0x00, 0x00)
+		near   = testHashname(0x01, 0x00) // same bucket as far, but closer
+		far    = testHashname(0x01, 0x01)
+	)
+
+	for _, preferFresh := range []bool{false, true} {
+		tab, err := newTable(local, 8, preferFresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tab.add(near)
+		tab.add(far)
+		time.Sleep(time.Millisecond)
+		tab.touch(far)
+
+		if preferFresh {
+			assert.Equal([]hashname.H{far, near}, tab.closest(target, 2))
+		} else {
+			assert.Equal([]hashname.H{near, far}, tab.closest(target, 2))
+		}
+	}
+}
+
+func TestClosestPreferFast(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		local      = testHashname(0xff, 0x00)
+		target     = testHashname(0x00, 0x00)
+		near       = testHashname(0x01, 0x00) // same bucket as far, but closer
+		far        = testHashname(0x01, 0x01)
+		unmeasured = testHashname(0x01, 0x02)
+	)
+
+	for _, preferFast := range []bool{false, true} {
+		tab, err := newTable(local, 8, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tab.preferFast = preferFast
+
+		tab.add(near)
+		tab.add(far)
+		tab.add(unmeasured)
+		tab.sampleRTT(near, 80*time.Millisecond)
+		tab.sampleRTT(far, 10*time.Millisecond)
+
+		if preferFast {
+			assert.Equal([]hashname.H{far, near, unmeasured}, tab.closest(target, 3))
+		} else {
+			assert.Equal([]hashname.H{near, far, unmeasured}, tab.closest(target, 3))
+		}
+	}
+}
+
+func TestSampleRTT(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		local = testHashname(0xff, 0x00)
+		peer  = testHashname(0x01, 0x00)
+	)
+
+	tab, err := newTable(local, 8, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tab.sampleRTT(peer, time.Second) // not in the table
+	tab.add(peer)
+	assert.Equal(time.Duration(0), tab.snapshot()[0].RTT)
+
+	tab.sampleRTT(peer, 80*time.Millisecond)
+	assert.Equal(80*time.Millisecond, tab.snapshot()[0].RTT)
+
+	tab.sampleRTT(peer, 160*time.Millisecond)
+	assert.Equal(90*time.Millisecond, tab.snapshot()[0].RTT)
+}
+
+func TestDistanceLengthMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	var tab = []struct {
+		a, b []byte
+		d    []byte
+	}{
+		{nil, nil, []byte{}},
+		{[]byte{0x0f}, nil, []byte{0x0f}},
+		{nil, []byte{0x0f}, []byte{0x0f}},
+		{[]byte{0xff, 0x01}, []byte{0x0f}, []byte{0xf0, 0x01}},
+		{[]byte{0x0f}, []byte{0xff, 0x01}, []byte{0xf0, 0x01}},
+	}
+
+	for _, e := range tab {
+		assert.Equal(e.d, distance(e.a, e.b), "distance(%x, %x)", e.a, e.b)
+	}
+
+	assert.False(lessDistance([]byte{0x01}, []byte{0x01, 0x00}))
+	assert.False(lessDistance([]byte{0x01, 0x00}, []byte{0x01}))
+	assert.True(lessDistance([]byte{0x01}, []byte{0x01, 0x01}))
+	assert.False(lessDistance([]byte{0x01, 0x01}, []byte{0x01}))
+	assert.True(lessDistance(nil, []byte{0x01}))
+	assert.False(lessDistance([]byte{0x01}, nil))
+
+	assert.Equal(-1, bucketIndex(distance([]byte{0x01}, []byte{0x01, 0x00})))
+}
+
+// testHashname returns a hashname whose key starts with first and ends with last.
+func testHashname(first, last byte) hashname.H {
+	var key [keyLen]byte
+	key[0] = first
+	key[keyLen-1] = last
+	return hashname.H(base32util.EncodeToString(key[:]))
+}
+
+func TestWalkOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	local := randomKey(t)
+	tab, err := newTable(hashname.H(base32util.EncodeToString(local)), 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		tab.add(hashname.H(base32util.EncodeToString(randomKey(t))))
+	}
+	// a few close peers
+	for i := 0; i < 8; i++ {
+		key := append([]byte(nil), local...)
+		key[keyLen-1] ^= byte(1 << uint(i))
+		tab.add(hashname.H(base32util.EncodeToString(key)))
+	}
+
+	for i := 0; i < 10; i++ {
+		target := randomKey(t)
+
+		var walked []hashname.H
+		tab.walk(target, func(hn hashname.H) bool {
+			walked = append(walked, hn)
+			return true
+		})
+		assert.Equal(tab.closest(hashname.H(base32util.EncodeToString(target)), 1000), walked)
+
+		// walking stops when fn returns false
+		var n int
+		tab.walk(target, func(hn hashname.H) bool {
+			n++
+			return n < 5
+		})
+		assert.Equal(5, n)
+	}
+
+	// keys of another length are supported as well
+	var walked []hashname.H
+	tab.walk([]byte{0x01}, func(hn hashname.H) bool {
+		walked = append(walked, hn)
+		return true
+	})
+	assert.Len(walked, len(tab.snapshot()))
+}
+
+func randomKey(t *testing.T) []byte {
+	key := make([]byte, keyLen)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
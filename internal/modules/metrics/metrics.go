@@ -0,0 +1,127 @@
+// Package metrics publishes the metrics of an endpoint (see
+// e3x.Endpoint.MetricsHandler) through expvar and, optionally, serves them to
+// Prometheus over HTTP.
+package metrics
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/telehash/gogotelehash/e3x"
+)
+
+const moduleKey = "metrics"
+
+// vars holds the metrics of the endpoints in the process, keyed by
+// Config.Name.
+var vars = expvar.NewMap("telehash")
+
+type Config struct {
+	// Name is the key the metrics of the endpoint are published under in the
+	// "telehash" expvar map. Defaults to the short hashname of the endpoint.
+	Name string
+
+	// ListenAddr is the TCP address of an HTTP server which serves /metrics
+	// (in the Prometheus text format) and /debug/vars (expvar). No server is
+	// started when it is empty.
+	ListenAddr string
+}
+
+// Metrics exposes the metrics module of an endpoint.
+type Metrics interface {
+	// Handler returns the Prometheus handler of the endpoint.
+	Handler() http.Handler
+
+	// Addr returns the address the HTTP server listens on, or nil when
+	// Config.ListenAddr is empty.
+	Addr() net.Addr
+}
+
+type module struct {
+	e      *e3x.Endpoint
+	config Config
+
+	mtx sync.Mutex
+	srv *http.Server
+	l   net.Listener
+}
+
+func Module(c Config) e3x.EndpointOption {
+	return func(e *e3x.Endpoint) error {
+		return e3x.RegisterModule(moduleKey, &module{e: e, config: c})(e)
+	}
+}
+
+func FromEndpoint(e *e3x.Endpoint) Metrics {
+	mod := e.Module(moduleKey)
+	if mod == nil {
+		return nil
+	}
+	return mod.(*module)
+}
+
+func (mod *module) Init() error {
+	if mod.config.Name == "" {
+		mod.config.Name = mod.e.LocalHashname().Short()
+	}
+
+	vars.Set(mod.config.Name, expvar.Func(func() interface{} {
+		return mod.e.MetricsSnapshot()
+	}))
+	return nil
+}
+
+func (mod *module) Start() error {
+	if mod.config.ListenAddr == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", mod.config.ListenAddr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", mod.e.MetricsHandler())
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	srv := &http.Server{Handler: mux}
+
+	mod.mtx.Lock()
+	mod.l = l
+	mod.srv = srv
+	mod.mtx.Unlock()
+
+	go srv.Serve(l)
+	return nil
+}
+
+func (mod *module) Stop() error {
+	vars.Delete(mod.config.Name)
+
+	mod.mtx.Lock()
+	srv := mod.srv
+	mod.srv = nil
+	mod.mtx.Unlock()
+
+	if srv != nil {
+		return srv.Close()
+	}
+	return nil
+}
+
+func (mod *module) Handler() http.Handler {
+	return mod.e.MetricsHandler()
+}
+
+func (mod *module) Addr() net.Addr {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	if mod.l == nil {
+		return nil
+	}
+	return mod.l.Addr()
+}
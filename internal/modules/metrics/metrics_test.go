@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestServeMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(
+		e3x.Log(nil),
+		e3x.Transport(inproc.Config{}),
+		Module(Config{Name: "A", ListenAddr: "127.0.0.1:0"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := FromEndpoint(A).Addr()
+	if !assert.NotNil(addr) {
+		return
+	}
+
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	if assert.NoError(err) {
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Contains(string(data), "# TYPE telehash_handshake_failures_total counter\n")
+	}
+
+	resp, err = http.Get("http://" + addr.String() + "/debug/vars")
+	if assert.NoError(err) {
+		var v struct {
+			Telehash map[string]map[string]interface{} `json:"telehash"`
+		}
+		assert.NoError(json.NewDecoder(resp.Body).Decode(&v))
+		resp.Body.Close()
+		_, found := v.Telehash["A"]["telehash_exchanges"]
+		assert.True(found)
+	}
+
+	assert.NoError(A.Close())
+	assert.Nil(vars.Get("A"))
+	_, err = http.Get("http://" + addr.String() + "/metrics")
+	assert.Error(err)
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/transports"
 )
@@ -17,12 +19,34 @@ const moduleKey = "paths"
 type module struct {
 	endpoint *e3x.Endpoint
 	listener *e3x.Listener
+
+	mtx      sync.Mutex
+	observed map[hashname.H]observation
+}
+
+// Paths exposes what the paths module learned while negotiating paths.
+type Paths interface {
+	// ReflexiveAddr returns the best guess of the public UDP address of the
+	// endpoint; that is the address most peers reported seeing the endpoint at.
+	// When the peers disagree the most recent report wins.
+	ReflexiveAddr() (*net.UDPAddr, bool)
 }
 
 func Module() e3x.EndpointOption {
 	return func(e *e3x.Endpoint) error {
-		return e3x.RegisterModule(moduleKey, &module{endpoint: e})(e)
+		return e3x.RegisterModule(moduleKey, &module{
+			endpoint: e,
+			observed: make(map[hashname.H]observation),
+		})(e)
+	}
+}
+
+func FromEndpoint(e *e3x.Endpoint) Paths {
+	mod := e.Module(moduleKey)
+	if mod == nil {
+		return nil
 	}
+	return mod.(*module)
 }
 
 func (mod *module) Init() error {
@@ -31,6 +55,7 @@ func (mod *module) Init() error {
 	})
 	mod.endpoint.DefaultExchangeHooks().Register(e3x.ExchangeHook{
 		OnOpened: mod.onNewLink,
+		OnClosed: mod.onLinkClosed,
 	})
 
 	mod.listener = mod.endpoint.Listen("path", false)
@@ -95,13 +120,19 @@ func (mod *module) negotiatePaths(x *e3x.Exchange) {
 	}
 
 	for {
-		_, err := c.ReadPacket()
+		pkt, err := c.ReadPacket()
 		if err == io.EOF || err == e3x.ErrTimeout {
 			return
 		}
 		if err != nil {
 			return
 		}
+
+		// the peer reports the address it received the request from in
+		// the response over that path
+		if addr := decodeAddr(pkt.Header(), "path"); addr != nil {
+			mod.observe(x.RemoteHashname(), addr)
+		}
 	}
 }
 
@@ -136,11 +167,18 @@ func (mod *module) handlePathRequest(c *e3x.Channel) {
 		}
 	}
 
-	var pipes = c.Exchange().KnownPipes()
+	var (
+		pipes = c.Exchange().KnownPipes()
+		via   = c.OpenedVia()
+	)
 
+	// a response is sent over every path; only the one over the path the
+	// request arrived on reports the address the request came from.
 	for _, pipe := range pipes {
 		pkt := &lob.Packet{}
-		pkt.Header().Set("path", pipe.RemoteAddr())
+		if pipe == via {
+			pkt.Header().Set("path", pipe.RemoteAddr())
+		}
 		c.WritePacketTo(pkt, pipe)
 	}
 }
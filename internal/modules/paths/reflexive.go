@@ -0,0 +1,106 @@
+package paths
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// observation is the address a peer reported seeing the endpoint at.
+type observation struct {
+	addr *net.UDPAddr
+	at   time.Time
+}
+
+type udpAddr interface {
+	ToUDPAddr() *net.UDPAddr
+}
+
+func (mod *module) onLinkClosed(e *e3x.Endpoint, x *e3x.Exchange, reason error) error {
+	mod.mtx.Lock()
+	delete(mod.observed, x.RemoteHashname())
+	mod.mtx.Unlock()
+	return nil
+}
+
+// observe records the address reported by peer. Only UDP addresses are
+// recorded.
+func (mod *module) observe(peer hashname.H, addr net.Addr) {
+	var udp *net.UDPAddr
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		udp = a
+	case udpAddr:
+		udp = a.ToUDPAddr()
+	default:
+		return
+	}
+
+	mod.mtx.Lock()
+	mod.observed[peer] = observation{addr: udp, at: time.Now()}
+	mod.mtx.Unlock()
+}
+
+func (mod *module) ReflexiveAddr() (*net.UDPAddr, bool) {
+	mod.mtx.Lock()
+	defer mod.mtx.Unlock()
+
+	type vote struct {
+		addr  *net.UDPAddr
+		count int
+		at    time.Time
+	}
+
+	var (
+		votes = make(map[string]*vote, len(mod.observed))
+		best  *vote
+	)
+
+	for _, o := range mod.observed {
+		key := o.addr.String()
+		v := votes[key]
+		if v == nil {
+			v = &vote{addr: o.addr}
+			votes[key] = v
+		}
+		v.count++
+		if o.at.After(v.at) {
+			v.at = o.at
+		}
+	}
+
+	for _, v := range votes {
+		if best == nil || v.count > best.count || (v.count == best.count && v.at.After(best.at)) {
+			best = v
+		}
+	}
+
+	if best == nil {
+		return nil, false
+	}
+	return best.addr, true
+}
+
+// decodeAddr decodes the address in the header field key.
+func decodeAddr(hdr *lob.Header, key string) net.Addr {
+	v, found := hdr.Get(key)
+	if !found {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	addr, err := transports.DecodeAddr(data)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
@@ -0,0 +1,212 @@
+package paths
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/fw"
+	"github.com/telehash/gogotelehash/transports/mux"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestReflexiveAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	n := newNAT(t)
+	defer n.Close()
+
+	// B only talks to the outside of the NAT
+	B, err := e3x.Open(
+		e3x.Log(nil),
+		e3x.Transport(fw.Config{
+			Config: udp.Config{Addr: "127.0.0.1:0"},
+			Allow: fw.RuleFunc(func(src net.Addr) bool {
+				return src.String() == n.outside.LocalAddr().String()
+			}),
+		}),
+		Module())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	A, err := e3x.Open(
+		e3x.Log(nil),
+		e3x.Transport(udp.Config{Addr: "127.0.0.1:0"}),
+		Module())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	_, found := FromEndpoint(A).ReflexiveAddr()
+	assert.False(found)
+
+	identB, err := B.LocalIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.forwardTo(identB.Addresses()[0].(interface {
+		ToUDPAddr() *net.UDPAddr
+	}).ToUDPAddr())
+
+	// A reaches B through the NAT
+	inside, err := transports.ResolveAddr("udp4", n.inside.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	identB, err = e3x.NewIdentity(identB.Keys(), nil, []net.Addr{inside})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = A.Dial(identB)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var addr *net.UDPAddr
+	for i := 0; i < 100 && !found; i++ {
+		time.Sleep(20 * time.Millisecond)
+		addr, found = FromEndpoint(A).ReflexiveAddr()
+	}
+	if assert.True(found, "A learned its reflexive address") {
+		assert.Equal(n.outside.LocalAddr().String(), addr.String())
+
+		identA, err := A.LocalIdentity()
+		if assert.NoError(err) {
+			assert.NotEqual(identA.Addresses()[0].String(), addr.String())
+		}
+	}
+}
+
+func TestPathResponseReportsArrivalPath(t *testing.T) {
+	assert := assert.New(t)
+
+	// A has two paths to B
+	A, err := e3x.Open(
+		e3x.Log(nil),
+		e3x.Transport(mux.Config{
+			udp.Config{Addr: "127.0.0.1:0"},
+			udp.Config{Addr: "127.0.0.1:0"},
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	B, err := e3x.Open(
+		e3x.Log(nil),
+		e3x.Transport(udp.Config{Addr: "127.0.0.1:0"}),
+		Module())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	identB, err := B.LocalIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := A.Dial(identB)
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := x.Open("path", false)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	pkt := &lob.Packet{}
+	pkt.Header().Set("paths", e3x.TransportsFromEndpoint(A).LocalAddresses())
+	assert.NoError(c.WritePacket(pkt))
+
+	var responses, reported int
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			break
+		}
+		responses++
+		if decodeAddr(pkt.Header(), "path") != nil {
+			reported++
+		}
+	}
+
+	// B answered over both paths but reported only the one the request
+	// arrived on
+	assert.Equal(2, responses)
+	assert.Equal(1, reported)
+}
+
+// nat maps a single inside peer to the outside socket.
+type nat struct {
+	inside  *net.UDPConn
+	outside *net.UDPConn
+
+	mtx  sync.Mutex
+	peer *net.UDPAddr // the inside peer
+	dst  *net.UDPAddr // the outside destination
+}
+
+func newNAT(t *testing.T) *nat {
+	inside, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &nat{inside: inside, outside: outside}
+	go n.run(inside, func(src *net.UDPAddr, b []byte) {
+		n.mtx.Lock()
+		n.peer = src
+		dst := n.dst
+		n.mtx.Unlock()
+		if dst != nil {
+			n.outside.WriteToUDP(b, dst)
+		}
+	})
+	go n.run(outside, func(src *net.UDPAddr, b []byte) {
+		n.mtx.Lock()
+		peer := n.peer
+		n.mtx.Unlock()
+		if peer != nil {
+			n.inside.WriteToUDP(b, peer)
+		}
+	})
+	return n
+}
+
+func (n *nat) forwardTo(dst *net.UDPAddr) {
+	n.mtx.Lock()
+	n.dst = dst
+	n.mtx.Unlock()
+}
+
+func (n *nat) run(conn *net.UDPConn, f func(src *net.UDPAddr, b []byte)) {
+	var buf [1500]byte
+	for {
+		l, src, err := conn.ReadFromUDP(buf[:])
+		if err != nil {
+			return
+		}
+		f(src, buf[:l])
+	}
+}
+
+func (n *nat) Close() {
+	n.inside.Close()
+	n.outside.Close()
+}
@@ -7,11 +7,12 @@ import (
 	"sync/atomic"
 )
 
-const bufferSize = 1500
+// Size is the capacity of a Buffer.
+const Size = 1500
 
 var bufferPool = sync.Pool{
 	New: func() interface{} {
-		return &Buffer{make([]byte, 0, bufferSize), true, 1}
+		return &Buffer{make([]byte, 0, Size), true, 1}
 	},
 }
 
@@ -51,13 +52,17 @@ func (b *Buffer) Len() int {
 }
 
 func (b *Buffer) Get(buf []byte) []byte {
+	if b == nil {
+		return buf
+	}
+
 	b.secure()
 	return append(buf, b.bytes...)
 }
 
 func (b *Buffer) Set(buf []byte) *Buffer {
 	b.secure()
-	if len(buf) > bufferSize {
+	if len(buf) > Size {
 		panic("data too large")
 	}
 	b.bytes = append(b.bytes[:0], buf...)
@@ -93,7 +98,7 @@ func (b *Buffer) Free() {
 		return
 	}
 
-	if b.bytes == nil || cap(b.bytes) != bufferSize {
+	if b.bytes == nil || cap(b.bytes) != Size {
 		panic("invalid buffer return")
 	}
 
@@ -6,10 +6,44 @@ import (
 	"github.com/telehash/gogotelehash/internal/hashname"
 )
 
-var defaultLogger = New(os.Stdout)
+var (
+	defaultSink   = NewTerminalSink(os.Stdout)
+	defaultLogger = NewWithSink(defaultSinkRef{})
+)
+
+// defaultSinkRef forwards to the current default sink, so SetDefaultSink also
+// affects the loggers which were derived before.
+type defaultSinkRef struct{}
+
+func (defaultSinkRef) Write(e *Entry) error {
+	configMtx.RLock()
+	s := defaultSink
+	configMtx.RUnlock()
+
+	return s.Write(e)
+}
+
+// SetDefaultSink replaces the sink of the default logger (stdout).
+func SetDefaultSink(s Sink) {
+	if s == nil {
+		s = NewTerminalSink(os.Stdout)
+	}
+
+	configMtx.Lock()
+	defer configMtx.Unlock()
+
+	defaultSink = s
+}
 
 func ResetLogger() {
-	defaultLogger = New(os.Stderr)
+	configMtx.Lock()
+	defaultSink = NewTerminalSink(os.Stderr)
+	moduleSinks = map[string]Sink{}
+	moduleLevels = map[string]Level{}
+	defaultLevel = Info
+	configMtx.Unlock()
+
+	defaultLogger = NewWithSink(defaultSinkRef{})
 	disabledMods = map[string]bool{}
 }
 
@@ -29,6 +63,10 @@ func To(id hashname.H) *Logger {
 	return defaultLogger.To(id)
 }
 
+func With(keyvals ...interface{}) *Logger {
+	return defaultLogger.With(keyvals...)
+}
+
 func ResetTimer() *Logger {
 	return defaultLogger.ResetTimer()
 }
@@ -3,8 +3,8 @@ package logs
 import (
 	"fmt"
 	"io"
-	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/telehash/gogotelehash/internal/hashname"
@@ -12,21 +12,66 @@ import (
 
 var disabledMods = map[string]bool{}
 
+var (
+	configMtx    sync.RWMutex
+	moduleSinks  = map[string]Sink{}
+	moduleLevels = map[string]Level{}
+	defaultLevel = Info
+)
+
 type Logger struct {
 	module string
 	from   string
 	to     string
 	start  time.Time
-	log    *log.Logger
+	fields []Field
+	sink   Sink
 }
 
+// New returns a logger which writes to out in the terminal format (see
+// NewTerminalSink).
 func New(out io.Writer) *Logger {
+	return NewWithSink(NewTerminalSink(out))
+}
+
+// NewWithSink returns a logger which writes to s.
+func NewWithSink(s Sink) *Logger {
 	l := new(Logger)
 	l.start = time.Now()
-	l.log = log.New(out, "", 0)
+	l.sink = s
 	return l
 }
 
+// SetSink routes the entries of module to s instead of the sink of their
+// logger. A nil s removes the route.
+func SetSink(module string, s Sink) {
+	configMtx.Lock()
+	defer configMtx.Unlock()
+
+	if s == nil {
+		delete(moduleSinks, module)
+	} else {
+		moduleSinks[module] = s
+	}
+}
+
+// SetLevel drops the entries of module below lvl.
+func SetLevel(module string, lvl Level) {
+	configMtx.Lock()
+	defer configMtx.Unlock()
+
+	moduleLevels[module] = lvl
+}
+
+// SetDefaultLevel drops the entries below lvl of the modules without a level
+// of their own. The default is Info.
+func SetDefaultLevel(lvl Level) {
+	configMtx.Lock()
+	defer configMtx.Unlock()
+
+	defaultLevel = lvl
+}
+
 func DisableModule(name string) {
 	disabledMods[name] = true
 }
@@ -53,7 +98,7 @@ func (l *Logger) From(id hashname.H) *Logger {
 
 	x := new(Logger)
 	*x = *l
-	x.from = string(id)[:4]
+	x.from = id.Short()
 	return x
 }
 
@@ -64,7 +109,7 @@ func (l *Logger) To(id hashname.H) *Logger {
 
 	x := new(Logger)
 	*x = *l
-	x.to = string(id)[:4]
+	x.to = id.Short()
 	return x
 }
 
@@ -79,12 +124,53 @@ func (l *Logger) ResetTimer() *Logger {
 	return x
 }
 
+func levelFor(module string) Level {
+	configMtx.RLock()
+	defer configMtx.RUnlock()
+
+	if lvl, found := moduleLevels[module]; found {
+		return lvl
+	}
+	return defaultLevel
+}
+
+// With returns a logger which attaches the key-value pairs in keyvals to each
+// entry. A key without a value is paired with nil.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	x := new(Logger)
+	*x = *l
+	x.fields = make([]Field, len(l.fields), len(l.fields)+(len(keyvals)+1)/2)
+	copy(x.fields, l.fields)
+	for i := 0; i < len(keyvals); i += 2 {
+		f := Field{Key: fmt.Sprint(keyvals[i])}
+		if i+1 < len(keyvals) {
+			f.Value = keyvals[i+1]
+		}
+		x.fields = append(x.fields, f)
+	}
+	return x
+}
+
+// Enabled reports whether entries at lvl are emitted by l. Use it to skip
+// expensive formatting.
+func (l *Logger) Enabled(lvl Level) bool {
+	if l == nil {
+		return false
+	}
+
+	return lvl >= levelFor(l.module)
+}
+
 func (l *Logger) Print(args ...interface{}) {
 	if l == nil {
 		return
 	}
 
-	l.emit(fmt.Sprint(args...))
+	l.emit(Info, fmt.Sprint(args...))
 }
 
 func (l *Logger) Println(args ...interface{}) {
@@ -92,7 +178,7 @@ func (l *Logger) Println(args ...interface{}) {
 		return
 	}
 
-	l.emit(fmt.Sprintln(args...))
+	l.emit(Info, fmt.Sprintln(args...))
 }
 
 func (l *Logger) Printf(format string, args ...interface{}) {
@@ -100,62 +186,74 @@ func (l *Logger) Printf(format string, args ...interface{}) {
 		return
 	}
 
-	l.emit(fmt.Sprintf(format, args...))
+	l.emit(Info, fmt.Sprintf(format, args...))
 }
 
-func (l *Logger) emit(msg string) {
+func (l *Logger) Debugf(format string, args ...interface{}) {
 	if l == nil {
 		return
 	}
 
-	if msg == "" {
+	l.emit(Debug, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l == nil {
 		return
 	}
 
-	var (
-		th, tm, ts, tms time.Duration
-		from            string
-		to              string
-		module          string
-	)
+	l.emit(Info, fmt.Sprintf(format, args...))
+}
 
-	{
-		d := time.Since(l.start)
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
 
-		th = d / time.Hour
-		d -= th * time.Hour
+	l.emit(Warn, fmt.Sprintf(format, args...))
+}
 
-		tm = d / time.Minute
-		d -= tm * time.Minute
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
 
-		ts = d / time.Second
-		d -= ts * time.Second
+	l.emit(Error, fmt.Sprintf(format, args...))
+}
 
-		tms = d / time.Millisecond
+func (l *Logger) emit(lvl Level, msg string) {
+	if l == nil {
+		return
 	}
 
-	from = l.from
-	if from == "" {
-		from = "    " // 4 spaces
-	} else {
-		from = colorize(from)
+	msg = strings.TrimSuffix(msg, "\n")
+	if msg == "" {
+		return
 	}
 
-	to = l.to
-	if to == "" {
-		to = "    " // 4 spaces
-	} else {
-		to = colorize(to)
+	if lvl < levelFor(l.module) {
+		return
 	}
 
-	module = l.module
-	moduleLen := len(module)
-	if moduleLen > 0 {
-		module = colorize(module)
+	configMtx.RLock()
+	sink := moduleSinks[l.module]
+	configMtx.RUnlock()
+	if sink == nil {
+		sink = l.sink
 	}
-	if moduleLen < 12 {
-		module += strings.Repeat(" ", 12-moduleLen)
+	if sink == nil {
+		return
 	}
 
-	l.log.Printf("\x1B[2;37m%02d:%02d:%02d.%03d |\x1B[0m %s %s \x1B[2;37m|\x1B[0m %s \x1B[2;37m|\x1B[0m %s", th, tm, ts, tms, from, to, module, msg)
+	now := time.Now()
+	sink.Write(&Entry{
+		Time:    now,
+		Elapsed: now.Sub(l.start),
+		Level:   lvl,
+		Module:  l.module,
+		From:    l.from,
+		To:      l.to,
+		Message: msg,
+		Fields:  l.fields,
+	})
 }
@@ -0,0 +1,117 @@
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+type memSink struct {
+	mtx     sync.Mutex
+	entries []Entry
+}
+
+func (s *memSink) Write(e *Entry) error {
+	s.mtx.Lock()
+	s.entries = append(s.entries, *e)
+	s.mtx.Unlock()
+	return nil
+}
+
+func TestLevels(t *testing.T) {
+	defer ResetLogger()
+	ResetLogger()
+
+	var (
+		sink = &memSink{}
+		l    = NewWithSink(sink).Module("a")
+	)
+
+	l.Debugf("hidden")
+	l.Printf("shown")
+	SetLevel("a", Warn)
+	l.Infof("hidden")
+	l.Errorf("shown %d", 2)
+
+	if assert.Len(t, sink.entries, 2) {
+		assert.Equal(t, Info, sink.entries[0].Level)
+		assert.Equal(t, "shown 2", sink.entries[1].Message)
+		assert.Equal(t, Error, sink.entries[1].Level)
+	}
+	assert.False(t, l.Enabled(Info))
+	assert.True(t, l.Module("b").Enabled(Info))
+}
+
+func TestFieldsAndModuleSinks(t *testing.T) {
+	defer ResetLogger()
+	ResetLogger()
+
+	var (
+		base   = &memSink{}
+		routed = &memSink{}
+		l      = NewWithSink(base).With("peer", "abc")
+	)
+
+	SetSink("b", routed)
+	l.Module("a").With("n", 1).Printf("one")
+	l.Module("b").Printf("two")
+	l.Printf("three")
+
+	assert.Len(t, base.entries, 2)
+	if assert.Len(t, routed.entries, 1) {
+		assert.Equal(t, "two", routed.entries[0].Message)
+	}
+	assert.Equal(t, "[{peer abc} {n 1}]", fmt.Sprint(base.entries[0].Fields))
+	assert.Equal(t, "[{peer abc}]", fmt.Sprint(base.entries[1].Fields))
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+
+	NewWithSink(NewWriterSink(&buf)).
+		Module("e3x").
+		With("path", "udp 1.2.3.4").
+		Warnf("\x1B[34mUpdated\x1B[0m path")
+
+	line := buf.String()
+	assert.Contains(t, line, ` level=warn module=e3x msg="Updated path" path="udp 1.2.3.4"`+"\n")
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "th.log")
+	sink, err := NewFileSink(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewWithSink(sink)
+	for i := 0; i < 20; i++ {
+		l.Printf("message %d", i)
+	}
+	assert.NoError(t, sink.Close())
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := ioutil.ReadFile(name)
+		if assert.NoError(t, err) {
+			assert.True(t, len(data) <= 200, "%s has %d bytes", name, len(data))
+		}
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	data, _ := ioutil.ReadFile(path)
+	assert.True(t, strings.HasSuffix(string(data), "msg=\"message 19\"\n"))
+}
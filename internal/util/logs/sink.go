@@ -0,0 +1,220 @@
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// Field is a structured key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry is a single log message as it is handed to a Sink.
+type Entry struct {
+	Time    time.Time
+	Elapsed time.Duration // since the logger's timer was (re)set
+	Level   Level
+	Module  string
+	From    string
+	To      string
+	Message string
+	Fields  []Field
+}
+
+// Sink receives the log entries. Sinks must be safe for concurrent use.
+type Sink interface {
+	Write(e *Entry) error
+}
+
+// NewTerminalSink returns a sink which writes colored, human readable lines
+// to w. This is the format of the default logger.
+func NewTerminalSink(w io.Writer) Sink {
+	return &terminalSink{w: w}
+}
+
+// NewWriterSink returns a sink which writes uncolored lines in logfmt
+// (key=value) to w.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+type terminalSink struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func (s *terminalSink) Write(e *Entry) error {
+	var (
+		th, tm, ts, tms time.Duration
+		from            string
+		to              string
+		module          string
+		msg             string
+	)
+
+	{
+		d := e.Elapsed
+
+		th = d / time.Hour
+		d -= th * time.Hour
+
+		tm = d / time.Minute
+		d -= tm * time.Minute
+
+		ts = d / time.Second
+		d -= ts * time.Second
+
+		tms = d / time.Millisecond
+	}
+
+	from = e.From
+	if from == "" {
+		from = strings.Repeat(" ", hashname.ShortLen)
+	} else {
+		from = colorize(from)
+	}
+
+	to = e.To
+	if to == "" {
+		to = strings.Repeat(" ", hashname.ShortLen)
+	} else {
+		to = colorize(to)
+	}
+
+	module = e.Module
+	moduleLen := len(module)
+	if moduleLen > 0 {
+		module = colorize(module)
+	}
+	if moduleLen < 12 {
+		module += strings.Repeat(" ", 12-moduleLen)
+	}
+
+	switch e.Level {
+	case Debug:
+		msg = "\x1B[2;37mDEBUG\x1B[0m "
+	case Warn:
+		msg = "\x1B[33mWARN\x1B[0m "
+	case Error:
+		msg = "\x1B[31mERROR\x1B[0m "
+	}
+	msg += e.Message
+	for _, f := range e.Fields {
+		msg += fmt.Sprintf(" \x1B[2;37m%s=\x1B[0m%s", f.Key, formatValue(f.Value))
+	}
+
+	line := fmt.Sprintf("\x1B[2;37m%02d:%02d:%02d.%03d |\x1B[0m %s %s \x1B[2;37m|\x1B[0m %s \x1B[2;37m|\x1B[0m %s\n", th, tm, ts, tms, from, to, module, msg)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, err := io.WriteString(s.w, line)
+	return err
+}
+
+type writerSink struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func (s *writerSink) Write(e *Entry) error {
+	line := formatLogfmt(e, true)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, err := s.w.Write(line)
+	return err
+}
+
+// formatLogfmt formats e as a logfmt line. The time and level are omitted
+// unless withHeader is set.
+func formatLogfmt(e *Entry, withHeader bool) []byte {
+	var buf bytes.Buffer
+
+	pair := func(key, value string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(quoteValue(value))
+	}
+
+	if withHeader {
+		pair("time", e.Time.Format("2006-01-02T15:04:05.000Z07:00"))
+		pair("level", e.Level.String())
+	}
+	if e.Module != "" {
+		pair("module", e.Module)
+	}
+	if e.From != "" {
+		pair("from", e.From)
+	}
+	if e.To != "" {
+		pair("to", e.To)
+	}
+	pair("msg", stripEscapes(e.Message))
+	for _, f := range e.Fields {
+		pair(f.Key, stripEscapes(formatValue(f.Value)))
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func formatValue(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case hashname.H:
+		return x.Short()
+	case error:
+		return x.Error()
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func quoteValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// stripEscapes removes the ANSI color sequences which some modules embed in
+// their messages.
+func stripEscapes(s string) string {
+	if strings.IndexByte(s, 0x1B) < 0 {
+		return s
+	}
+
+	var (
+		buf bytes.Buffer
+		esc bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case esc:
+			if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+				esc = false
+			}
+		case c == 0x1B:
+			esc = true
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logs
+
+import (
+	"log/syslog"
+)
+
+// NewSyslogSink returns a sink which writes to the local syslog daemon,
+// tagged with tag. The entry levels map to the syslog severities.
+func NewSyslogSink(tag string) (Sink, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func (s *syslogSink) Write(e *Entry) error {
+	msg := string(formatLogfmt(e, false))
+
+	switch e.Level {
+	case Debug:
+		return s.w.Debug(msg)
+	case Warn:
+		return s.w.Warning(msg)
+	case Error:
+		return s.w.Err(msg)
+	default:
+		return s.w.Info(msg)
+	}
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package logs
+
+import (
+	"errors"
+)
+
+func NewSyslogSink(tag string) (Sink, error) {
+	return nil, errors.New("logs: syslog is not supported on this platform")
+}
@@ -0,0 +1,260 @@
+package telehash
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+var (
+	// ErrNoPrivateKeys is returned by Save when the identity lacks the private
+	// keys (like the identities of peers).
+	ErrNoPrivateKeys = errors.New("telehash: identity has no private keys")
+
+	// ErrBadPassphrase is returned by LoadIdentity when the keystore can't be
+	// decrypted with the passphrase.
+	ErrBadPassphrase = errors.New("telehash: bad passphrase")
+
+	// ErrInvalidKeystore is returned by LoadIdentity when the keystore is
+	// malformed or its hashname doesn't match its keys.
+	ErrInvalidKeystore = errors.New("telehash: invalid keystore")
+)
+
+const (
+	pemIdentityType = "TELEHASH IDENTITY"
+	pemKDF          = "pbkdf2-sha256"
+	kdfIterations   = 100000
+	kdfSaltSize     = 16
+)
+
+// keystore is the JSON format of a stored identity. It is the format written
+// by th-keygen.
+type keystore struct {
+	Hashname hashname.H            `json:"hashname,omitempty"`
+	Parts    cipherset.Parts       `json:"parts,omitempty"`
+	Keys     cipherset.PrivateKeys `json:"keys,omitempty"`
+}
+
+// GenerateIdentity generates a key for every registered cipher set. Pass it to
+// Open with Keys and store it with Save to keep the hashname across restarts.
+func GenerateIdentity() (*Identity, error) {
+	keys, err := cipherset.GenerateKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := e3x.NewIdentity(keys, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{inner}, nil
+}
+
+// Keys makes the endpoint use the keys of i (see GenerateIdentity and
+// LoadIdentity).
+func Keys(i *Identity) EndpointOption {
+	return EndpointOption(e3x.Keys(i.inner.Keys()))
+}
+
+// Save stores the keys of i at path (with mode 0600). When passphrase is empty
+// the keys are stored as JSON (like th-keygen does), unless path ends in ".pem".
+// Otherwise they are stored as a PEM block encrypted with a key derived from
+// passphrase.
+func (i *Identity) Save(path string, passphrase []byte) error {
+	keys := i.inner.Keys()
+	for _, key := range keys {
+		if len(key.Private()) == 0 {
+			return ErrNoPrivateKeys
+		}
+	}
+
+	data, err := json.MarshalIndent(keystore{
+		Hashname: i.inner.Hashname(),
+		Parts:    hashname.PartsFromKeys(keys),
+		Keys:     cipherset.PrivateKeys(keys),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if len(passphrase) > 0 || strings.HasSuffix(path, ".pem") {
+		data, err = encodePEM(i.inner.Hashname(), data, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(path, data, 0600)
+}
+
+// LoadIdentity loads the identity stored at path by Save (or th-keygen).
+// passphrase is ignored when the keystore isn't encrypted.
+func LoadIdentity(path string, passphrase []byte) (*Identity, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		data, err = decodePEM(data, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ks keystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, ErrInvalidKeystore
+	}
+
+	inner, err := e3x.NewIdentity(cipherset.Keys(ks.Keys), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ks.Hashname != "" && ks.Hashname != inner.Hashname() {
+		return nil, ErrInvalidKeystore
+	}
+
+	return &Identity{inner}, nil
+}
+
+func encodePEM(hn hashname.H, data, passphrase []byte) ([]byte, error) {
+	block := &pem.Block{
+		Type:    pemIdentityType,
+		Headers: map[string]string{"Hashname": string(hn)},
+		Bytes:   data,
+	}
+
+	if len(passphrase) > 0 {
+		var (
+			salt  = make([]byte, kdfSaltSize)
+			nonce [24]byte
+		)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return nil, err
+		}
+
+		key := deriveKey(passphrase, salt, kdfIterations)
+		block.Headers["KDF"] = pemKDF
+		block.Headers["Iterations"] = strconv.Itoa(kdfIterations)
+		block.Headers["Salt"] = hex.EncodeToString(salt)
+		block.Headers["Nonce"] = hex.EncodeToString(nonce[:])
+		block.Bytes = secretbox.Seal(nil, data, &nonce, key)
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+func decodePEM(data, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemIdentityType {
+		return nil, ErrInvalidKeystore
+	}
+
+	kdf, encrypted := block.Headers["KDF"]
+	if !encrypted {
+		return block.Bytes, nil
+	}
+	if kdf != pemKDF {
+		return nil, ErrInvalidKeystore
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrBadPassphrase
+	}
+
+	iterations, err := strconv.Atoi(block.Headers["Iterations"])
+	if err != nil || iterations <= 0 {
+		return nil, ErrInvalidKeystore
+	}
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, ErrInvalidKeystore
+	}
+	nonceBytes, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil || len(nonceBytes) != 24 {
+		return nil, ErrInvalidKeystore
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], nonceBytes)
+
+	plain, ok := secretbox.Open(nil, block.Bytes, &nonce, deriveKey(passphrase, salt, iterations))
+	if !ok {
+		return nil, ErrBadPassphrase
+	}
+	return plain, nil
+}
+
+// deriveKey derives a secretbox key from passphrase with PBKDF2-HMAC-SHA256.
+func deriveKey(passphrase, salt []byte, iterations int) *[32]byte {
+	var (
+		key   [32]byte
+		mac   = hmac.New(sha256.New, passphrase)
+		block [4]byte
+	)
+
+	// a single block is needed as the key is as long as the hash
+	binary.BigEndian.PutUint32(block[:], 1)
+	mac.Write(salt)
+	mac.Write(block[:])
+	u := mac.Sum(nil)
+	copy(key[:], u)
+
+	for n := 1; n < iterations; n++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for i := range key {
+			key[i] ^= u[i]
+		}
+	}
+
+	return &key
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// to path, so a crash never leaves a truncated keystore behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package telehash
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestKeystore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "telehash-keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ident, err := GenerateIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	for _, name := range []string{"keys.json", "keys.pem"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(ident.Save(path, nil))
+
+		loaded, err := LoadIdentity(path, nil)
+		if assert.NoError(err, name) {
+			assert.Equal(ident.Hashname(), loaded.Hashname(), name)
+			assert.Equal(len(ident.inner.Keys()), len(loaded.inner.Keys()), name)
+		}
+	}
+
+	// encrypted
+	path := filepath.Join(dir, "secret")
+	assert.NoError(ident.Save(path, []byte("open sesame")))
+	fi, err := os.Stat(path)
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	_, err = LoadIdentity(path, []byte("wrong"))
+	assert.Equal(ErrBadPassphrase, err)
+	_, err = LoadIdentity(path, nil)
+	assert.Equal(ErrBadPassphrase, err)
+
+	loaded, err := LoadIdentity(path, []byte("open sesame"))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(ident.Hashname(), loaded.Hashname())
+
+	// the endpoint keeps its hashname
+	e, err := Open(Transport(inproc.Config{}), Keys(loaded))
+	if assert.NoError(err) {
+		defer e.Close()
+		local, err := e.LocalIdentity()
+		if assert.NoError(err) {
+			assert.Equal(ident.Hashname(), local.Hashname())
+		}
+	}
+
+	// the identities of peers can't be saved
+	peer := &Identity{}
+	assert.NoError(peer.UnmarshalJSON(mustMarshal(t, ident)))
+	assert.Equal(ErrNoPrivateKeys, peer.Save(filepath.Join(dir, "peer.json"), nil))
+}
+
+func TestDeriveKey(t *testing.T) {
+	// the common PBKDF2-HMAC-SHA256 test vectors
+	for _, v := range []struct {
+		iterations int
+		key        string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+	} {
+		key := deriveKey([]byte("password"), []byte("salt"), v.iterations)
+		assert.Equal(t, v.key, hex.EncodeToString(key[:]))
+	}
+}
+
+func mustMarshal(t *testing.T, i *Identity) []byte {
+	data, err := i.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
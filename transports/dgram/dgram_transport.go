@@ -26,6 +26,20 @@ type Transport interface {
 	Close() error
 }
 
+// MultiReader can be implemented by a Transport which can be read from
+// concurrently. Wrap will start a reader goroutine for each of the NumReaders
+// sources; ReadN reads from source i.
+type MultiReader interface {
+	NumReaders() int
+	ReadN(i int, b []byte) (n int, addr Addr, err error)
+}
+
+// PriorityWriter can be implemented by a Transport which can mark individual
+// packets with a DSCP value.
+type PriorityWriter interface {
+	WritePriority(b []byte, addr Addr, dscp int) (n int, err error)
+}
+
 type transport struct {
 	inner Transport
 
@@ -48,7 +62,8 @@ type connection struct {
 }
 
 var (
-	_ transports.Transport = (*transport)(nil)
+	_ transports.Transport    = (*transport)(nil)
+	_ transports.PriorityConn = (*connection)(nil)
 )
 
 // Wrap a drgram transport in a stream Transport
@@ -56,7 +71,14 @@ func Wrap(inner Transport) (transports.Transport, error) {
 	t := &transport{inner: inner}
 	t.cndAccept = sync.NewCond(&t.mtxAccept)
 
-	go t.reader()
+	if m, ok := inner.(MultiReader); ok && m.NumReaders() > 1 {
+		for i := 0; i < m.NumReaders(); i++ {
+			i := i
+			go t.reader(func(b []byte) (int, Addr, error) { return m.ReadN(i, b) })
+		}
+	} else {
+		go t.reader(inner.Read)
+	}
 
 	return t, nil
 }
@@ -175,11 +197,11 @@ func (t *transport) dropConnection(addr Addr) {
 	}
 }
 
-func (t *transport) reader() {
+func (t *transport) reader(read func(b []byte) (int, Addr, error)) {
 	var b [1500]byte
 
 	for {
-		n, addr, err := t.inner.Read(b[:])
+		n, addr, err := read(b[:])
 		if err != nil {
 			return
 		}
@@ -213,6 +235,12 @@ func (c *connection) Read(b []byte) (n int, err error) {
 }
 
 func (c *connection) Write(b []byte) (n int, err error) {
+	return c.WritePriority(b, 0)
+}
+
+// WritePriority writes b marked with dscp. When the inner transport can't mark
+// individual packets b is written like any other packet.
+func (c *connection) WritePriority(b []byte, dscp int) (n int, err error) {
 	if len(b) > 1472 {
 		return 0, io.ErrShortWrite
 	}
@@ -224,6 +252,9 @@ func (c *connection) Write(b []byte) (n int, err error) {
 	}
 	c.mtx.RUnlock()
 
+	if w, ok := c.transport.inner.(PriorityWriter); ok && dscp != 0 {
+		return w.WritePriority(b, c.raddr, dscp)
+	}
 	return c.transport.inner.Write(b, c.raddr)
 }
 
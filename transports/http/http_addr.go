@@ -0,0 +1,83 @@
+package http
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+func init() {
+	transports.RegisterAddr(&httpAddr{})
+
+	transports.RegisterResolver("http", func(str string) (net.Addr, error) {
+		return parseAddr(str)
+	})
+}
+
+// httpAddr is the URL of an HTTP transport. Both http and https URLs can be
+// dialed; the latter is useful behind a TLS terminating proxy.
+type httpAddr struct {
+	URL string
+}
+
+// sessionAddr is the remote address of an accepted connection. Clients can't be
+// dialed; a sessionAddr only identifies the client's session.
+type sessionAddr struct {
+	id     string
+	remote string
+}
+
+var (
+	_ transports.AddrMarshaler = (*httpAddr)(nil)
+	_ net.Addr                 = (*sessionAddr)(nil)
+)
+
+func parseAddr(str string) (*httpAddr, error) {
+	u, err := url.Parse(str)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, transports.ErrInvalidAddr
+	}
+	return &httpAddr{URL: u.String()}, nil
+}
+
+func (a *httpAddr) Network() string { return "http" }
+func (a *httpAddr) String() string  { return a.URL }
+
+func (a *httpAddr) UnmarshalJSON(data []byte) error {
+	var desc struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+
+	err := json.Unmarshal(data, &desc)
+	if err != nil {
+		return transports.ErrInvalidAddr
+	}
+
+	addr, err := parseAddr(desc.URL)
+	if err != nil {
+		return transports.ErrInvalidAddr
+	}
+
+	*a = *addr
+	return nil
+}
+
+func (a *httpAddr) MarshalJSON() ([]byte, error) {
+	var desc = struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}{
+		Type: a.Network(),
+		URL:  a.URL,
+	}
+	return json.Marshal(&desc)
+}
+
+func (a *sessionAddr) Network() string { return "http-session" }
+func (a *sessionAddr) String() string  { return a.id + "@" + a.remote }
@@ -0,0 +1,299 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports/transportsutil"
+)
+
+// clientConn is a dialed connection. Writes are POSTed to the server; once the
+// first write succeeded the connection polls the server for inbound packets.
+type clientConn struct {
+	transport *transport
+	raddr     *httpAddr
+	session   string
+	url       string
+	halfPipe  *transportsutil.HalfPipe
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	mtx     sync.Mutex
+	closed  bool
+	polling bool
+}
+
+// serverConn is an accepted connection. Writes are queued until the client
+// polls for them.
+type serverConn struct {
+	transport *transport
+	raddr     *sessionAddr
+	halfPipe  *transportsutil.HalfPipe
+	notify    chan struct{} // signaled when a packet was queued
+	done      chan struct{} // closed when the connection is closed
+
+	mtx      sync.Mutex
+	closed   bool
+	outbound [][]byte
+	lastSeen time.Time
+}
+
+var (
+	_ net.Conn = (*clientConn)(nil)
+	_ net.Conn = (*serverConn)(nil)
+)
+
+func sessionURL(addr *httpAddr, session string) string {
+	u, err := url.Parse(addr.URL)
+	if err != nil {
+		return addr.URL
+	}
+
+	q := u.Query()
+	q.Set("s", session)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (c *clientConn) Read(b []byte) (int, error) {
+	return c.halfPipe.Read(b)
+}
+
+func (c *clientConn) Write(b []byte) (int, error) {
+	if len(b) > 1472 {
+		return 0, io.ErrShortWrite
+	}
+
+	c.mtx.Lock()
+	closed := c.closed
+	c.mtx.Unlock()
+	if closed {
+		return 0, io.EOF
+	}
+
+	var body bytes.Buffer
+	writeFrame(&body, b)
+
+	req, err := http.NewRequest("POST", c.url, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.transport.client.Do(req.WithContext(c.ctx))
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, errors.New("http: " + resp.Status)
+	}
+
+	c.startPolling()
+	return len(b), nil
+}
+
+func (c *clientConn) startPolling() {
+	c.mtx.Lock()
+	start := !c.polling && !c.closed
+	c.polling = true
+	c.mtx.Unlock()
+
+	if start {
+		go c.poller()
+	}
+}
+
+// poller polls the server until the connection is closed or the server ended
+// the session.
+func (c *clientConn) poller() {
+	for {
+		req, err := http.NewRequest("GET", c.url, nil)
+		if err != nil {
+			c.Close()
+			return
+		}
+
+		resp, err := c.transport.client.Do(req.WithContext(c.ctx))
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			// the server is unreachable; retry later
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			readFrames(resp.Body, c.halfPipe.PushMessage)
+		case http.StatusNoContent:
+		default:
+			// the session ended
+			resp.Body.Close()
+			c.markAsClosed()
+			c.transport.dropDialed(c)
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+// Close closes the connection and ends the session on the server.
+func (c *clientConn) Close() error {
+	if !c.markAsClosed() {
+		return nil
+	}
+	c.transport.dropDialed(c)
+
+	go func() {
+		req, err := http.NewRequest("DELETE", c.url, nil)
+		if err != nil {
+			return
+		}
+		resp, err := c.transport.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	return nil
+}
+
+func (c *clientConn) markAsClosed() bool {
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return false
+	}
+	c.closed = true
+	c.mtx.Unlock()
+
+	c.cancel()
+	c.halfPipe.Close()
+	return true
+}
+
+func (c *clientConn) LocalAddr() net.Addr {
+	return c.transport.localAddr()
+}
+
+func (c *clientConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *clientConn) SetDeadline(t time.Time) error {
+	return c.halfPipe.SetReadDeadline(t)
+}
+
+func (c *clientConn) SetReadDeadline(t time.Time) error {
+	return c.halfPipe.SetReadDeadline(t)
+}
+
+func (c *clientConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *serverConn) Read(b []byte) (int, error) {
+	return c.halfPipe.Read(b)
+}
+
+func (c *serverConn) Write(b []byte) (int, error) {
+	if len(b) > 1472 {
+		return 0, io.ErrShortWrite
+	}
+
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return 0, io.EOF
+	}
+	if len(c.outbound) < maxQueued {
+		c.outbound = append(c.outbound, append([]byte(nil), b...))
+	}
+	c.mtx.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+
+	return len(b), nil
+}
+
+func (c *serverConn) takeOutbound() [][]byte {
+	c.mtx.Lock()
+	pkts := c.outbound
+	c.outbound = nil
+	c.mtx.Unlock()
+	return pkts
+}
+
+func (c *serverConn) touch() {
+	c.mtx.Lock()
+	c.lastSeen = time.Now()
+	c.mtx.Unlock()
+}
+
+func (c *serverConn) idleSince() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lastSeen
+}
+
+func (c *serverConn) Close() error {
+	if c.markAsClosed() {
+		c.transport.dropSession(c)
+	}
+	return nil
+}
+
+func (c *serverConn) markAsClosed() bool {
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return false
+	}
+	c.closed = true
+	c.outbound = nil
+	close(c.done)
+	c.mtx.Unlock()
+
+	c.halfPipe.Close()
+	return true
+}
+
+func (c *serverConn) LocalAddr() net.Addr {
+	return c.transport.localAddr()
+}
+
+func (c *serverConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *serverConn) SetDeadline(t time.Time) error {
+	return c.halfPipe.SetReadDeadline(t)
+}
+
+func (c *serverConn) SetReadDeadline(t time.Time) error {
+	return c.halfPipe.SetReadDeadline(t)
+}
+
+func (c *serverConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
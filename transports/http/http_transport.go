@@ -0,0 +1,393 @@
+// Package http implements a transport which tunnels packets over HTTP. It is a
+// fallback for networks which block everything except HTTP.
+//
+// Clients POST their outbound packets to the server and receive inbound packets
+// through long-poll GET requests. Each request identifies the client's session
+// with the s query parameter. Request and response bodies carry packets as
+// frames; each packet is preceded by its length as a big endian uint16.
+//
+//   e3x.New(keys, http.Config{Addr: ":8080"})
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportsutil"
+)
+
+// Config for the HTTP transport. Typically the zero value is sufficient to get started.
+type Config struct {
+	// Can be set to an address and/or port.
+	// The zero value will bind it to a random port while listening on all interfaces.
+	Addr string
+
+	// PollTimeout is how long the server holds a poll request when there are
+	// no packets for the client. It must be shorter than the idle timeout of
+	// the proxies between the client and the server.
+	// Defaults to 25 seconds.
+	PollTimeout time.Duration
+}
+
+const (
+	contentType = "application/octet-stream"
+
+	// maxQueued is the number of outbound packets the server keeps for a client
+	// which isn't polling. Newer packets are dropped.
+	maxQueued = 256
+
+	// maxBody limits the size of a POST body.
+	maxBody = 64 * 1024
+)
+
+type transport struct {
+	laddr       *net.TCPAddr
+	listener    net.Listener
+	server      *http.Server
+	client      *http.Client
+	pollTimeout time.Duration
+
+	mtx      sync.Mutex
+	closed   bool
+	done     chan struct{}
+	accepted chan *serverConn
+	sessions map[string]*serverConn  // accepted connections by session
+	dialed   map[*clientConn]struct{} // dialed connections
+}
+
+var (
+	_ transports.Transport = (*transport)(nil)
+	_ transports.Config    = Config{}
+)
+
+// Open opens the transport.
+func (c Config) Open() (transports.Transport, error) {
+	if c.Addr == "" {
+		c.Addr = ":0"
+	}
+	if c.PollTimeout <= 0 {
+		c.PollTimeout = 25 * time.Second
+	}
+
+	listener, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &transport{
+		laddr:       listener.Addr().(*net.TCPAddr),
+		listener:    listener,
+		client:      &http.Client{Timeout: c.PollTimeout + 10*time.Second},
+		pollTimeout: c.PollTimeout,
+		done:        make(chan struct{}),
+		accepted:    make(chan *serverConn, 16),
+		sessions:    make(map[string]*serverConn),
+		dialed:      make(map[*clientConn]struct{}),
+	}
+	t.server = &http.Server{Handler: t}
+
+	go t.server.Serve(listener)
+	go t.expireSessions()
+
+	return t, nil
+}
+
+func (t *transport) Addrs() []net.Addr {
+	var (
+		port  = strconv.Itoa(t.laddr.Port)
+		addrs []net.Addr
+	)
+
+	if !t.laddr.IP.IsUnspecified() {
+		addrs = append(addrs, &httpAddr{URL: "http://" + net.JoinHostPort(t.laddr.IP.String(), port) + "/"})
+		return addrs
+	}
+
+	ips, err := transportsutil.InterfaceIPs()
+	if err != nil {
+		return addrs
+	}
+
+	for _, addr := range ips {
+		addrs = append(addrs, &httpAddr{URL: "http://" + net.JoinHostPort(addr.IP.String(), port) + "/"})
+	}
+
+	return addrs
+}
+
+// localAddr is the local address of the connections.
+func (t *transport) localAddr() net.Addr {
+	return &httpAddr{URL: "http://" + t.laddr.String() + "/"}
+}
+
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	x, ok := addr.(*httpAddr)
+	if !ok {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &clientConn{
+		transport: t,
+		raddr:     x,
+		session:   randomString(16),
+		halfPipe:  transportsutil.NewHalfPipe(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	c.url = sessionURL(x, c.session)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.closed {
+		cancel()
+		return nil, io.EOF
+	}
+	t.dialed[c] = struct{}{}
+
+	return c, nil
+}
+
+func (t *transport) Accept() (net.Conn, error) {
+	select {
+	case c := <-t.accepted:
+		return c, nil
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+func (t *transport) Close() error {
+	t.mtx.Lock()
+	if t.closed {
+		t.mtx.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.done)
+	sessions, dialed := t.sessions, t.dialed
+	t.sessions, t.dialed = nil, nil
+	t.mtx.Unlock()
+
+	for _, c := range sessions {
+		c.markAsClosed()
+	}
+	for c := range dialed {
+		c.markAsClosed()
+	}
+
+	return t.server.Close()
+}
+
+// ServeHTTP handles the requests of the clients.
+func (t *transport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("s")
+	if session == "" {
+		http.Error(w, "missing session", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		c := t.getSession(session, r.RemoteAddr, true)
+		if c == nil {
+			http.Error(w, "closed", http.StatusServiceUnavailable)
+			return
+		}
+		c.touch()
+
+		err := readFrames(io.LimitReader(r.Body, maxBody), c.halfPipe.PushMessage)
+		if err != nil {
+			http.Error(w, "invalid frame", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "GET":
+		c := t.getSession(session, r.RemoteAddr, false)
+		if c == nil {
+			http.NotFound(w, r)
+			return
+		}
+		c.touch()
+		defer c.touch()
+
+		t.poll(w, r, c)
+
+	case "DELETE":
+		if c := t.getSession(session, r.RemoteAddr, false); c != nil {
+			c.Close()
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// poll holds the request until there are packets for c or the poll timed out.
+func (t *transport) poll(w http.ResponseWriter, r *http.Request, c *serverConn) {
+	timer := time.NewTimer(t.pollTimeout)
+	defer timer.Stop()
+
+	for {
+		if pkts := c.takeOutbound(); len(pkts) > 0 {
+			w.Header().Set("Content-Type", contentType)
+			for _, pkt := range pkts {
+				if err := writeFrame(w, pkt); err != nil {
+					return
+				}
+			}
+			return
+		}
+
+		select {
+		case <-c.notify:
+		case <-c.done:
+			http.NotFound(w, r)
+			return
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// getSession returns the connection of session. When create is true a new
+// connection is accepted for unknown sessions.
+func (t *transport) getSession(session, remote string, create bool) *serverConn {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.closed {
+		return nil
+	}
+
+	c := t.sessions[session]
+	if c == nil && create {
+		c = &serverConn{
+			transport: t,
+			raddr:     &sessionAddr{id: session, remote: remote},
+			halfPipe:  transportsutil.NewHalfPipe(),
+			notify:    make(chan struct{}, 1),
+			done:      make(chan struct{}),
+			lastSeen:  time.Now(),
+		}
+
+		select {
+		case t.accepted <- c:
+			t.sessions[session] = c
+		default:
+			// the accept queue is full
+			return nil
+		}
+	}
+
+	return c
+}
+
+func (t *transport) dropSession(c *serverConn) {
+	t.mtx.Lock()
+	if t.sessions[c.raddr.id] == c {
+		delete(t.sessions, c.raddr.id)
+	}
+	t.mtx.Unlock()
+}
+
+// expireSessions closes the sessions of clients which stopped polling.
+func (t *transport) expireSessions() {
+	ticker := time.NewTicker(t.pollTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			var expired []*serverConn
+
+			t.mtx.Lock()
+			for _, c := range t.sessions {
+				if now.Sub(c.idleSince()) > 3*t.pollTimeout {
+					expired = append(expired, c)
+				}
+			}
+			t.mtx.Unlock()
+
+			for _, c := range expired {
+				c.Close()
+			}
+		}
+	}
+}
+
+func (t *transport) dropDialed(c *clientConn) {
+	t.mtx.Lock()
+	delete(t.dialed, c)
+	t.mtx.Unlock()
+}
+
+func readFrames(r io.Reader, fn func([]byte)) error {
+	var (
+		bufr = bufio.NewReader(r)
+		hdr  [2]byte
+		buf  [1500]byte
+	)
+
+	for {
+		_, err := io.ReadFull(bufr, hdr[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		msgLen := int(binary.BigEndian.Uint16(hdr[:]))
+		if msgLen > len(buf) {
+			return errors.New("http: frame too large")
+		}
+
+		_, err = io.ReadFull(bufr, buf[:msgLen])
+		if err != nil {
+			return err
+		}
+
+		fn(buf[:msgLen])
+	}
+}
+
+func writeFrame(w io.Writer, b []byte) error {
+	var hdr [2]byte
+	binary.BigEndian.PutUint16(hdr[:], uint16(len(b)))
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func randomString(n int) string {
+	var buf = make([]byte, n/2)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(buf)
+}
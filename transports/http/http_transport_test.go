@@ -0,0 +1,156 @@
+package http
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+func TestLocalAddresses(t *testing.T) {
+	assert := assert.New(t)
+	var tab = []Config{
+		{},
+		{Addr: "127.0.0.1:0"},
+		{Addr: ":0"},
+	}
+
+	for _, factory := range tab {
+		trans, err := factory.Open()
+		if assert.NoError(err) && assert.NotNil(trans) {
+			addrs := trans.Addrs()
+			assert.NotEmpty(addrs)
+
+			t.Logf("factory=%v addrs=%v", factory, addrs)
+			err = trans.Close()
+			assert.NoError(err)
+		}
+	}
+}
+
+func TestAddrJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, err := transports.ResolveAddr("http", "http://127.0.0.1:8080/")
+	if !assert.NoError(err) {
+		return
+	}
+
+	data, err := transports.EncodeAddr(addr)
+	assert.NoError(err)
+	assert.Equal(`{"type":"http","url":"http://127.0.0.1:8080/"}`, string(data))
+
+	decoded, err := transports.DecodeAddr(data)
+	assert.NoError(err)
+	assert.True(transports.EqualAddr(addr, decoded))
+
+	_, err = transports.ResolveAddr("http", "udp://127.0.0.1:8080/")
+	assert.Equal(transports.ErrInvalidAddr, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0", PollTimeout: 200 * time.Millisecond}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var (
+		out [1500]byte
+		dst = A.Addrs()[0]
+	)
+
+	w, err := B.Dial(dst)
+	if !assert.NoError(err) {
+		return
+	}
+	w.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(err)
+	_, err = w.Write([]byte("world"))
+	assert.NoError(err)
+
+	r, err := A.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	r.SetDeadline(time.Now().Add(5 * time.Second))
+
+	n, err := r.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("hello", string(out[:n]))
+	n, err = r.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("world", string(out[:n]))
+
+	// replies are delivered after empty polls
+	time.Sleep(500 * time.Millisecond)
+	_, err = r.Write([]byte("reply"))
+	assert.NoError(err)
+
+	n, err = w.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("reply", string(out[:n]))
+
+	// closing the accepted connection ends the session
+	assert.NoError(r.Close())
+	_, err = w.Read(out[:])
+	assert.Equal(io.EOF, err)
+}
+
+func TestAcceptAfterClose(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(A.Close())
+
+	_, err = A.Accept()
+	assert.Equal(io.EOF, err)
+
+	_, err = A.Dial(&net.TCPAddr{})
+	assert.Equal(transports.ErrInvalidAddr, err)
+}
+
+func TestEndpoints(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(e3x.Transport(Config{Addr: "127.0.0.1:0"}), e3x.Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	B, err := e3x.Open(e3x.Transport(Config{Addr: "127.0.0.1:0"}), e3x.Log(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	ident, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	x, err := A.Dial(ident)
+	if assert.NoError(err) {
+		assert.Equal(B.LocalHashname(), x.RemoteHashname())
+	}
+}
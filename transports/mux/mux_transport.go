@@ -42,6 +42,10 @@ func (c Config) Open() (transports.Transport, error) {
 	for _, f := range c {
 		s, err := f.Open()
 		if err != nil {
+			// don't leak the sub-transports which were opened already
+			for _, s := range t.transports {
+				s.Close()
+			}
 			return nil, err
 		}
 
@@ -2,12 +2,14 @@ package mux
 
 import (
 	"bytes"
+	"errors"
 	"net"
 	"testing"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
 
 	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
 	"github.com/telehash/gogotelehash/transports/udp"
 )
 
@@ -46,6 +48,76 @@ func TestManagerWithOneTransport(t *testing.T) {
 	}
 }
 
+func TestManagerWithTwoTransports(t *testing.T) {
+	assert := assert.New(t)
+
+	tr, err := Config{udp.Config{Network: "udp4"}, inproc.Config{}}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	// the addresses of both transports are advertised
+	networks := map[string]bool{}
+	for _, addr := range tr.Addrs() {
+		networks[addr.Network()] = true
+	}
+	assert.True(networks["udp4"])
+	assert.True(networks["inproc"])
+
+	// dials are routed to the transport which supports the address
+	other, err := inproc.Config{}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer other.Close()
+
+	conn, err := tr.Dial(other.Addrs()[0])
+	if assert.NoError(err) {
+		conn.Close()
+	}
+}
+
+func TestManagerClosesTransportsOnError(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		opened = &closeRecorder{Config: inproc.Config{}}
+		errFoo = errors.New("foo")
+	)
+
+	_, err := Config{opened, failingConfig{errFoo}}.Open()
+	assert.Equal(errFoo, err)
+	assert.True(opened.closed)
+}
+
+type failingConfig struct{ err error }
+
+func (c failingConfig) Open() (transports.Transport, error) { return nil, c.err }
+
+type closeRecorder struct {
+	transports.Config
+	closed bool
+}
+
+func (c *closeRecorder) Open() (transports.Transport, error) {
+	tr, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &closeRecorderTransport{tr, c}, nil
+}
+
+type closeRecorderTransport struct {
+	transports.Transport
+	config *closeRecorder
+}
+
+func (t *closeRecorderTransport) Close() error {
+	t.config.closed = true
+	return t.Transport.Close()
+}
+
 func Benchmark(b *testing.B) {
 	A, err := Config{udp.Config{}}.Open()
 	if err != nil {
@@ -20,6 +20,16 @@ var (
 	_ transports.Config    = Config{}
 )
 
+var (
+	// discoverGateway finds the UPnP IGD or NAT-PMP gateway.
+	discoverGateway = nat.DiscoverGateway
+
+	// leaseDuration is the lifetime of a port mapping. Mappings are renewed
+	// every refreshInterval.
+	leaseDuration   = 60 * time.Minute
+	refreshInterval = 50 * time.Minute
+)
+
 // NATableAddr must be implemented by transports that support NAT port mapping.
 type Addr interface {
 	// Make sure transports.Addr is implemented
@@ -44,9 +54,10 @@ type Config struct {
 }
 
 type transport struct {
-	t    transports.Transport
-	nat  nat.NAT
-	done chan struct{}
+	t       transports.Transport
+	nat     nat.NAT
+	done    chan struct{}
+	stopped chan struct{} // closed once the mapper removed its mappings
 
 	mtx     sync.RWMutex
 	mapping map[string]*natMapping
@@ -58,7 +69,11 @@ type natMapping struct {
 	stale    bool
 }
 
-// Open opens the sub-transport and starts the port mapper.
+// Open opens the sub-transport and starts the port mapper. The mapper looks for
+// a gateway right away and maps the addresses of the sub-transport; the
+// external addresses are returned by Addrs along with the internal ones. The
+// mappings are refreshed before their lease expires and removed when the
+// transport is closed.
 func (c Config) Open() (transports.Transport, error) {
 	t, err := c.Config.Open()
 	if err != nil {
@@ -69,6 +84,7 @@ func (c Config) Open() (transports.Transport, error) {
 		t:       t,
 		mapping: make(map[string]*natMapping),
 		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
 	}
 
 	go nat.runMapper()
@@ -96,6 +112,8 @@ func (t *transport) Accept() (net.Conn, error) {
 	return t.t.Accept()
 }
 
+// Close stops the port mapper and closes the sub-transport. It returns once the
+// mappings are removed from the gateway.
 func (t *transport) Close() error {
 	select {
 	case <-t.done: // is closed
@@ -103,10 +121,14 @@ func (t *transport) Close() error {
 		close(t.done)
 	}
 
+	<-t.stopped
+
 	return t.t.Close()
 }
 
 func (t *transport) runMapper() {
+	defer close(t.stopped)
+
 	var closed bool
 	for !closed {
 		if t.nat == nil {
@@ -126,6 +148,14 @@ func (t *transport) runDiscoverMode() bool {
 
 	var knownAddrs = make(map[string]bool)
 
+	// don't wait for the first tick
+	if t.updateKnownAddresses(knownAddrs) {
+		t.discoverNAT()
+	}
+	if t.nat != nil {
+		return false // not done
+	}
+
 	for {
 		select {
 
@@ -198,17 +228,20 @@ func (t *transport) updateKnownAddresses(known map[string]bool) bool {
 }
 
 func (t *transport) runMappingMode() bool {
-	var refreshTicker = time.NewTicker(50 * time.Minute)
+	var refreshTicker = time.NewTicker(refreshInterval)
 	defer refreshTicker.Stop()
 
 	var updateTicker = time.NewTicker(5 * time.Second)
 	defer updateTicker.Stop()
 
-	for {
+	// map the current addresses right away
+	t.updateMappings()
+
+	for t.nat != nil {
 		select {
 
 		case <-t.done:
-			t.mapping = make(map[string]*natMapping)
+			t.deleteMappings()
 			return true // done
 
 		case <-refreshTicker.C:
@@ -219,15 +252,34 @@ func (t *transport) runMappingMode() bool {
 
 		}
 
-		if t.nat == nil {
-			t.mapping = make(map[string]*natMapping)
-			return false // not done
+	}
+
+	// the gateway is gone; so are its mappings
+	t.mtx.Lock()
+	t.mapping = make(map[string]*natMapping)
+	t.mtx.Unlock()
+	return false // not done
+}
+
+// deleteMappings removes all the port mappings from the gateway.
+func (t *transport) deleteMappings() {
+	t.mtx.Lock()
+	mapping := t.mapping
+	t.mapping = make(map[string]*natMapping)
+	t.mtx.Unlock()
+
+	for _, m := range mapping {
+		proto, _, internalPort := asNATableAddr(m.internal)
+		if proto == "" {
+			continue
 		}
+
+		t.nat.DeletePortMapping(proto, internalPort)
 	}
 }
 
 func (t *transport) discoverNAT() {
-	nat, err := nat.DiscoverGateway()
+	nat, err := discoverGateway()
 	if err != nil {
 		return
 	}
@@ -282,12 +334,12 @@ func (t *transport) updateMappings() {
 		}
 
 		key := mappingKey(proto, ip, internalPort)
-		if m := t.mapping[key]; m != nil {
+		if m := mapping[key]; m != nil {
 			m.stale = false
 			continue // Already exists
 		}
 
-		externalPort, err := t.nat.AddPortMapping(proto, internalPort, "Telehash", 60*time.Minute)
+		externalPort, err := t.nat.AddPortMapping(proto, internalPort, "Telehash", leaseDuration)
 		if err != nil {
 			continue // unable to map address
 		}
@@ -364,7 +416,7 @@ func (t *transport) refreshMapping() {
 			continue
 		}
 
-		externalPort, err := t.nat.AddPortMapping(proto, internalPort, "Telehash", 60*time.Minute)
+		externalPort, err := t.nat.AddPortMapping(proto, internalPort, "Telehash", leaseDuration)
 		if err != nil {
 			droplist = append(droplist, key)
 			continue
@@ -376,7 +428,7 @@ func (t *transport) refreshMapping() {
 			continue
 		}
 
-		m.external = globaddr
+		mapping[key] = &natMapping{external: globaddr, internal: m.internal}
 	}
 
 	for _, key := range droplist {
@@ -0,0 +1,148 @@
+package nat
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/fd/go-nat"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// fakeAddr is a NATable address.
+type fakeAddr struct {
+	ip   net.IP
+	port int
+}
+
+func (a *fakeAddr) Network() string { return "fake" }
+func (a *fakeAddr) String() string  { return net.JoinHostPort(a.ip.String(), strconv.Itoa(a.port)) }
+
+func (a *fakeAddr) InternalAddr() (string, net.IP, int) { return "udp", a.ip, a.port }
+
+func (a *fakeAddr) MakeGlobal(ip net.IP, port int) net.Addr {
+	return &fakeAddr{ip: ip, port: port}
+}
+
+// fakeConfig opens a transport with a single fixed address.
+type fakeConfig struct{}
+
+type fakeTransport struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func (fakeConfig) Open() (transports.Transport, error) {
+	return &fakeTransport{done: make(chan struct{})}, nil
+}
+
+func (t *fakeTransport) Addrs() []net.Addr {
+	return []net.Addr{&fakeAddr{ip: net.IPv4(127, 0, 0, 1), port: 4000}}
+}
+
+func (t *fakeTransport) Dial(addr net.Addr) (net.Conn, error) {
+	return nil, transports.ErrInvalidAddr
+}
+
+func (t *fakeTransport) Accept() (net.Conn, error) {
+	<-t.done
+	return nil, io.EOF
+}
+
+func (t *fakeTransport) Close() error {
+	t.once.Do(func() { close(t.done) })
+	return nil
+}
+
+// fakeGateway maps every port to port+1000 on 203.0.113.1.
+type fakeGateway struct {
+	mtx     sync.Mutex
+	leases  map[int]int // number of leases by internal port
+	deleted map[int]bool
+}
+
+func (g *fakeGateway) Type() string { return "fake" }
+
+func (g *fakeGateway) GetDeviceAddress() (net.IP, error) {
+	return net.IPv4(127, 0, 0, 254), nil
+}
+
+func (g *fakeGateway) GetExternalAddress() (net.IP, error) {
+	return net.IPv4(203, 0, 113, 1), nil
+}
+
+func (g *fakeGateway) GetInternalAddress() (net.IP, error) {
+	return net.IPv4(127, 0, 0, 1), nil
+}
+
+func (g *fakeGateway) AddPortMapping(proto string, port int, desc string, timeout time.Duration) (int, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.leases[port]++
+	return port + 1000, nil
+}
+
+func (g *fakeGateway) DeletePortMapping(proto string, port int) error {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.deleted[port] = true
+	return nil
+}
+
+func (g *fakeGateway) state(port int) (leases int, deleted bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.leases[port], g.deleted[port]
+}
+
+func TestPortMapping(t *testing.T) {
+	assert := assert.New(t)
+
+	gateway := &fakeGateway{leases: make(map[int]int), deleted: make(map[int]bool)}
+
+	oldDiscover, oldRefresh := discoverGateway, refreshInterval
+	discoverGateway = func() (nat.NAT, error) { return gateway, nil }
+	refreshInterval = 100 * time.Millisecond
+	defer func() { discoverGateway, refreshInterval = oldDiscover, oldRefresh }()
+
+	trans, err := Config{fakeConfig{}}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const port = 4000
+	internal := trans.Addrs()[0]
+
+	// the external address is advertised without waiting for the update ticker
+	var external net.Addr
+	for i := 0; i < 50 && external == nil; i++ {
+		for _, addr := range trans.Addrs() {
+			if addr.String() != internal.String() {
+				external = addr
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if assert.NotNil(external, "addrs=%v", trans.Addrs()) {
+		_, ip, externalPort := asNATableAddr(external)
+		assert.Equal("203.0.113.1", ip.String())
+		assert.Equal(port+1000, externalPort)
+	}
+
+	// the lease is renewed
+	time.Sleep(350 * time.Millisecond)
+	leases, _ := gateway.state(port)
+	assert.True(leases >= 3, "leases=%d", leases)
+
+	// closing the transport removes the mapping before it returns
+	assert.NoError(trans.Close())
+	_, deleted := gateway.state(port)
+	assert.True(deleted)
+
+	assert.NoError(trans.Close())
+}
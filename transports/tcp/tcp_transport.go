@@ -40,15 +40,28 @@ type transport struct {
 	net      string
 	laddr    tcpAddr
 	listener *net.TCPListener
+
+	mtx    sync.Mutex
+	closed bool
+	dialed map[*connection]struct{} // the open dialed connections
 }
 
+// connection frames packets over a TCP connection; each packet is preceded by
+// its length as a big endian uint16.
+//
+// Every Dial returns a connection of its own; the peer binds the packets of an
+// accepted TCP connection to a single exchange, so TCP connections are never
+// shared. A connection is closed as soon as its TCP connection breaks; the
+// e3x pipe which owns it dials the remote address again on its next write.
 type connection struct {
 	transport *transport
 	raddr     tcpAddr
+	dialed    bool
 	conn      *net.TCPConn
 	bufr      *bufio.Reader
 	mtxWrite  sync.Mutex
 	mtxRead   sync.Mutex
+	closeOnce sync.Once
 }
 
 var (
@@ -135,12 +148,7 @@ func (t *transport) Addrs() []net.Addr {
 func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
 	switch x := addr.(type) {
 	case tcpAddr:
-		conn, err := net.DialTCP("tcp", nil, x.ToTCPAddr())
-		if err != nil {
-			return nil, err
-		}
-
-		return &connection{transport: t, raddr: x, conn: conn, bufr: bufio.NewReader(conn)}, nil
+		return t.dial(x)
 	case *net.TCPAddr:
 		return t.Dial(wrapAddr(x))
 	default:
@@ -148,9 +156,46 @@ func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
 	}
 }
 
+// dial returns a new connection to addr. The TCP connection is dialed right
+// away.
+func (t *transport) dial(addr tcpAddr) (*connection, error) {
+	tconn, err := net.DialTCP(t.net, nil, addr.ToTCPAddr())
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &connection{transport: t, raddr: addr, dialed: true, conn: tconn, bufr: bufio.NewReader(tconn)}
+
+	t.mtx.Lock()
+	if t.closed {
+		t.mtx.Unlock()
+		tconn.Close()
+		return nil, io.EOF
+	}
+	if t.dialed == nil {
+		t.dialed = make(map[*connection]struct{})
+	}
+	t.dialed[conn] = struct{}{}
+	t.mtx.Unlock()
+
+	return conn, nil
+}
+
+func (t *transport) dropConnection(conn *connection) {
+	t.mtx.Lock()
+	delete(t.dialed, conn)
+	t.mtx.Unlock()
+}
+
 func (t *transport) Accept() (c net.Conn, err error) {
 	tconn, err := t.listener.AcceptTCP()
 	if err != nil {
+		t.mtx.Lock()
+		closed := t.closed
+		t.mtx.Unlock()
+		if closed {
+			return nil, io.EOF
+		}
 		return nil, err
 	}
 
@@ -161,55 +206,84 @@ func (t *transport) Accept() (c net.Conn, err error) {
 }
 
 func (t *transport) Close() error {
+	t.mtx.Lock()
+	conns := t.dialed
+	t.dialed = nil
+	t.closed = true
+	t.mtx.Unlock()
+
+	for conn := range conns {
+		conn.Close()
+	}
+
 	return t.listener.Close()
 }
 
+// Read reads a single packet. The connection is closed when reading fails,
+// unless a read deadline passed before any of the packet was read.
 func (c *connection) Read(b []byte) (n int, err error) {
 	var hdr [2]byte
 
 	c.mtxRead.Lock()
 	defer c.mtxRead.Unlock()
 
-	_, err = io.ReadFull(c.bufr, hdr[:])
+	n, err = io.ReadFull(c.bufr, hdr[:])
 	if err != nil {
+		if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() || n > 0 {
+			c.Close()
+		}
 		return 0, err
 	}
 
 	msgLen := binary.BigEndian.Uint16(hdr[:])
 
-	return io.ReadFull(c.bufr, b[:msgLen])
+	n, err = io.ReadFull(c.bufr, b[:msgLen])
+	if err != nil {
+		c.Close()
+	}
+	return n, err
 }
 
+// Write writes b as a single packet. The connection is closed when writing
+// fails.
 func (c *connection) Write(b []byte) (n int, err error) {
 	var lenB = len(b)
 	if lenB > 1472 {
 		return 0, io.ErrShortWrite
 	}
 
-	var hdr [2]byte
-	var hdrP = hdr[:]
-	binary.BigEndian.PutUint16(hdrP, uint16(lenB))
-
 	c.mtxWrite.Lock()
 	defer c.mtxWrite.Unlock()
 
+	if err := writeFrame(c.conn, b); err != nil {
+		c.Close()
+		return 0, err
+	}
+	return lenB, nil
+}
+
+func writeFrame(conn *net.TCPConn, b []byte) error {
+	var hdr [2]byte
+	var hdrP = hdr[:]
+	binary.BigEndian.PutUint16(hdrP, uint16(len(b)))
+
 	for len(hdrP) > 0 {
-		n, err := c.conn.Write(hdrP)
+		n, err := conn.Write(hdrP)
 		if err != nil {
-			return 0, err
+			return err
 		}
 		hdrP = hdrP[n:]
 	}
 
 	for len(b) > 0 {
-		n, err := c.conn.Write(b)
+		n, err := conn.Write(b)
 		if err != nil {
-			return 0, err
+			return err
 		}
 		b = b[n:]
 	}
 
-	return lenB, nil
+	return nil
 }
 
 func (c *connection) SetDeadline(t time.Time) error {
@@ -233,5 +307,14 @@ func (c *connection) RemoteAddr() net.Addr {
 }
 
 func (c *connection) Close() error {
-	return c.conn.Close()
+	var err error
+
+	c.closeOnce.Do(func() {
+		if c.dialed {
+			c.transport.dropConnection(c)
+		}
+		err = c.conn.Close()
+	})
+
+	return err
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
 )
@@ -31,6 +32,128 @@ func TestLocalAddresses(t *testing.T) {
 	}
 }
 
+func TestBrokenConnectionIsClosed(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var (
+		dst = B.Addrs()[0]
+		out [1500]byte
+	)
+
+	w, err := A.Dial(dst)
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(err)
+
+	r, err := B.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	n, err := r.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("hello", string(out[:n]))
+
+	// a read deadline leaves the connection open
+	w.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = w.Read(out[:])
+	assert.Error(err)
+	w.SetReadDeadline(time.Time{})
+	_, err = r.Write([]byte("still open"))
+	assert.NoError(err)
+	n, err = w.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("still open", string(out[:n]))
+
+	// the remote end drops the TCP connection; w is closed and forgotten
+	assert.NoError(r.Close())
+	_, err = w.Read(out[:])
+	assert.Error(err)
+	_, err = w.Write([]byte("closed"))
+	assert.Error(err)
+
+	tr := A.(*transport)
+	tr.mtx.Lock()
+	assert.Empty(tr.dialed)
+	tr.mtx.Unlock()
+}
+
+func TestDialedConnectionsAreNotShared(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var (
+		dst = B.Addrs()[0]
+		out [1500]byte
+	)
+
+	w1, err := A.Dial(dst)
+	if !assert.NoError(err) {
+		return
+	}
+	r1, err := B.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	w2, err := A.Dial(dst)
+	if !assert.NoError(err) {
+		return
+	}
+	r2, err := B.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	assert.False(w1 == w2)
+
+	// the packets sent to a dialed connection are read from that connection
+	_, err = r2.Write([]byte("two"))
+	assert.NoError(err)
+	_, err = r1.Write([]byte("one"))
+	assert.NoError(err)
+
+	n, err := w1.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("one", string(out[:n]))
+	n, err = w2.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("two", string(out[:n]))
+
+	// closing one connection leaves the other one open
+	assert.NoError(w2.Close())
+	_, err = w1.Write([]byte("still open"))
+	assert.NoError(err)
+	n, err = r1.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("still open", string(out[:n]))
+
+	w1.Close()
+}
+
 func Benchmark(b *testing.B) {
 	A, err := Config{}.Open()
 	if err != nil {
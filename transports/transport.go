@@ -58,3 +58,12 @@ func EqualAddr(a, b net.Addr) bool {
 type AddrEqualer interface {
 	Equal(other net.Addr) bool
 }
+
+// PriorityConn can be implemented by a connection which can mark individual
+// packets with a DSCP (Differentiated Services Code Point) value.
+type PriorityConn interface {
+	net.Conn
+
+	// WritePriority writes b marked with the DSCP value dscp (0-63).
+	WritePriority(b []byte, dscp int) (n int, err error)
+}
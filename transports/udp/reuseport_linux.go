@@ -0,0 +1,7 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !sparc64
+// +build linux,!mips,!mipsle,!mips64,!mips64le,!sparc64
+
+package udp
+
+// soReusePort is SO_REUSEPORT which is missing from package syscall on linux.
+const soReusePort = 0xf
@@ -0,0 +1,9 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le || sparc64)
+// +build linux
+// +build mips mipsle mips64 mips64le sparc64
+
+package udp
+
+// soReusePort is SO_REUSEPORT which is missing from package syscall on linux;
+// mips and sparc number it differently.
+const soReusePort = 0x200
@@ -0,0 +1,25 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package udp
+
+import (
+	"syscall"
+)
+
+const reusePortSupported = true
+
+// reusePort sets SO_REUSEPORT on the socket so multiple sockets can be bound
+// to the same address.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	return err
+}
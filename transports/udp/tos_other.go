@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package udp
+
+import (
+	"syscall"
+)
+
+func setTOS(network string, c syscall.RawConn, tos int) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package udp
+
+import (
+	"syscall"
+)
+
+// setTOS sets the default TOS (or IPv6 traffic class) of all the packets sent
+// on the socket.
+func setTOS(network string, c syscall.RawConn, tos int) error {
+	var err error
+
+	cerr := c.Control(func(fd uintptr) {
+		switch network {
+		case UDPv6:
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		case UDP:
+			// a dual-stack socket sends IPv4 packets as well
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+			if err == nil {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+			}
+		default:
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	return err
+}
@@ -0,0 +1,28 @@
+package udp
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const perPacketTOSSupported = true
+
+// tosOOB returns the control message which marks a single packet with tos.
+func tosOOB(network string, tos int) []byte {
+	var (
+		level = syscall.IPPROTO_IP
+		typ   = syscall.IP_TOS
+		b     = make([]byte, syscall.CmsgSpace(4))
+		h     = (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	)
+
+	if network != UDPv4 {
+		level, typ = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+	}
+
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(syscall.CmsgLen(4))
+	*(*int32)(unsafe.Pointer(&b[syscall.CmsgLen(0)])) = int32(tos)
+	return b
+}
@@ -0,0 +1,93 @@
+package udp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+func TestWritePriority(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := net.ListenUDP(UDPv4, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	raw, err := r.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	A, err := Config{Network: UDPv4, Addr: "127.0.0.1:0", DSCP: 10}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	w, err := A.Dial(r.LocalAddr())
+	if !assert.NoError(err) {
+		return
+	}
+	pw, ok := w.(transports.PriorityConn)
+	if !assert.True(ok, "udp connections support priorities") {
+		return
+	}
+
+	// the socket-wide DSCP
+	_, err = w.Write([]byte("default"))
+	assert.NoError(err)
+	assert.Equal(10, readDSCP(t, r))
+
+	// high priority (expedited forwarding)
+	_, err = pw.WritePriority([]byte("high"), 46)
+	assert.NoError(err)
+	assert.Equal(46, readDSCP(t, r))
+
+	_, err = pw.WritePriority([]byte("invalid"), 64)
+	assert.Equal(errInvalidDSCP, err)
+}
+
+func TestInvalidDSCP(t *testing.T) {
+	_, err := Config{DSCP: 64}.Open()
+	assert.Equal(t, errInvalidDSCP, err)
+}
+
+// readDSCP reads a packet from r and returns the DSCP it was marked with.
+func readDSCP(t *testing.T, r *net.UDPConn) int {
+	var (
+		b   [1500]byte
+		oob [128]byte
+	)
+
+	r.SetReadDeadline(time.Now().Add(time.Second))
+	_, oobn, _, _, err := r.ReadMsgUDP(b[:], oob[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_TOS && len(msg.Data) > 0 {
+			return int(msg.Data[0] >> 2)
+		}
+	}
+
+	t.Fatal("no TOS control message")
+	return -1
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package udp
+
+const perPacketTOSSupported = false
+
+func tosOOB(network string, tos int) []byte {
+	return nil
+}
@@ -2,7 +2,6 @@ package udp
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/json"
 	"net"
 
@@ -100,8 +99,8 @@ func (u *udpv4) Key() interface{} {
 		k connKey
 	)
 
-	copy(k[:16], u.IP.To16())
-	binary.BigEndian.PutUint16(k[16:], uint16(u.Port))
+	copy(k.ip[:], u.IP.To16())
+	k.port = uint16(u.Port)
 
 	return k
 }
@@ -110,8 +109,9 @@ func (u *udpv6) Key() interface{} {
 		k connKey
 	)
 
-	copy(k[:16], u.IP.To16())
-	binary.BigEndian.PutUint16(k[16:], uint16(u.Port))
+	copy(k.ip[:], u.IP.To16())
+	k.port = uint16(u.Port)
+	k.zone = u.Zone
 
 	return k
 }
@@ -148,6 +148,7 @@ func (u *udpv4) UnmarshalJSON(data []byte) error {
 func (u *udpv6) UnmarshalJSON(data []byte) error {
 	var desc struct {
 		IP   string `json:"ip"`
+		Zone string `json:"zone"`
 		Port int    `json:"port"`
 	}
 
@@ -165,7 +166,12 @@ func (u *udpv6) UnmarshalJSON(data []byte) error {
 		return transports.ErrInvalidAddr
 	}
 
-	addr := wrapAddr(&net.UDPAddr{IP: ip, Port: desc.Port})
+	// link-local addresses are only meaningful with their zone
+	if ip.IsLinkLocalUnicast() && desc.Zone == "" {
+		return transports.ErrInvalidAddr
+	}
+
+	addr := wrapAddr(&net.UDPAddr{IP: ip, Zone: desc.Zone, Port: desc.Port})
 	if !addr.IsIPv6() {
 		return transports.ErrInvalidAddr
 	}
@@ -192,10 +198,12 @@ func (u *udpv6) MarshalJSON() ([]byte, error) {
 	var desc = struct {
 		Type string `json:"type"`
 		IP   string `json:"ip"`
+		Zone string `json:"zone,omitempty"`
 		Port int    `json:"port"`
 	}{
 		Type: u.Network(),
 		IP:   u.IP.String(),
+		Zone: u.Zone,
 		Port: u.Port,
 	}
 
@@ -227,7 +235,7 @@ func (u *udpv4) Equal(other net.Addr) bool {
 
 func (u *udpv6) Equal(other net.Addr) bool {
 	if b, ok := other.(*udpv6); ok {
-		return bytes.Equal(u.IP.To16(), b.IP.To16()) && u.Port == b.Port
+		return bytes.Equal(u.IP.To16(), b.IP.To16()) && u.Port == b.Port && u.Zone == b.Zone
 	}
 	return false
 }
@@ -4,6 +4,7 @@
 package udp
 
 import (
+	"context"
 	"errors"
 	"net"
 
@@ -16,8 +17,11 @@ import (
 //
 //   e3x.New(keys, udp.Config{})
 type Config struct {
-	// Can be set to UDPv4, UDPv6 or can be left blank.
+	// Can be set to UDPv4, UDPv6, UDP or can be left blank.
 	// Defaults to UDPv4
+	//
+	// A UDP transport listens on a single dual-stack socket and reaches both
+	// IPv4 and IPv6 addresses.
 	Network string
 
 	// Can be set to an address and/or port.
@@ -25,6 +29,18 @@ type Config struct {
 	// When port is unspecified ("127.0.0.1") a random port will be chosen.
 	// When ip is unspecified (":3000") the transport will listen on all interfaces.
 	Addr string
+
+	// Readers is the number of goroutines reading from the socket. When it is
+	// greater than one each reader gets its own socket bound to the same address
+	// (using SO_REUSEPORT) so reads are spread across cores. On platforms which
+	// don't support SO_REUSEPORT a single reader is used. Defaults to 1.
+	Readers int
+
+	// DSCP is the Differentiated Services Code Point (0-63) every packet is
+	// marked with unless a packet is written with its own priority. Per packet
+	// priorities are only supported on Linux; on other platforms all packets are
+	// marked with DSCP. Defaults to 0 (best effort).
+	DSCP int
 }
 
 const (
@@ -32,19 +48,30 @@ const (
 	UDPv4 = "udp4"
 	// UDPv6 is used for IPv6 UDP networks
 	UDPv6 = "udp6"
+	// UDP is used for dual-stack (IPv4 and IPv6) UDP networks
+	UDP = "udp"
 )
 
-type connKey [18]byte
+type connKey struct {
+	ip   [16]byte
+	port uint16
+	zone string
+}
 
 type transport struct {
-	net   string
-	laddr udpAddr
-	c     *net.UDPConn
+	net     string
+	laddr   udpAddr
+	c       *net.UDPConn   // used for writing
+	readers []*net.UDPConn // the first reader is c
 }
 
+var errInvalidDSCP = errors.New("udp: DSCP must be in the range 0-63")
+
 var (
-	_ dgram.Transport   = (*transport)(nil)
-	_ transports.Config = Config{}
+	_ dgram.Transport      = (*transport)(nil)
+	_ dgram.MultiReader    = (*transport)(nil)
+	_ dgram.PriorityWriter = (*transport)(nil)
+	_ transports.Config    = Config{}
 )
 
 // Open opens the transport.
@@ -61,8 +88,12 @@ func (c Config) Open() (transports.Transport, error) {
 		c.Addr = ":0"
 	}
 
-	if c.Network != UDPv4 && c.Network != UDPv6 {
-		return nil, errors.New("udp: Network must be either `udp4` or `udp6`")
+	if c.Network != UDPv4 && c.Network != UDPv6 && c.Network != UDP {
+		return nil, errors.New("udp: Network must be either `udp4`, `udp6` or `udp`")
+	}
+
+	if c.DSCP < 0 || c.DSCP > 63 {
+		return nil, errInvalidDSCP
 	}
 
 	{ // parse and verify source address
@@ -78,29 +109,92 @@ func (c Config) Open() (transports.Transport, error) {
 		if c.Network == UDPv6 && addr.IP != nil && ipIs4(addr.IP) {
 			return nil, errors.New("udp: expected a IPv6 address")
 		}
+
+		// a socket bound to a specific address only reaches its own family
+		if c.Network == UDP && addr.IP != nil && !addr.IP.IsUnspecified() {
+			if ipIs4(addr.IP) {
+				c.Network = UDPv4
+			} else {
+				c.Network = UDPv6
+			}
+		}
 	}
 
-	conn, err := net.ListenUDP(c.Network, addr)
-	if err != nil {
-		return nil, err
+	if c.Readers < 1 || !reusePortSupported {
+		c.Readers = 1
 	}
 
-	addr = conn.LocalAddr().(*net.UDPAddr)
+	if c.Readers == 1 {
+		conn, err := net.ListenUDP(c.Network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		addr = conn.LocalAddr().(*net.UDPAddr)
+
+		t := &transport{net: c.Network, laddr: wrapAddr(addr), c: conn, readers: []*net.UDPConn{conn}}
+		if err := t.setDSCP(c.DSCP); err != nil {
+			t.Close()
+			return nil, err
+		}
+		return dgram.Wrap(t)
+	}
 
-	t := &transport{net: c.Network, laddr: wrapAddr(addr), c: conn}
+	var (
+		lc    = net.ListenConfig{Control: reusePort}
+		conns = make([]*net.UDPConn, 0, c.Readers)
+	)
+	for i := 0; i < c.Readers; i++ {
+		conn, err := lc.ListenPacket(context.Background(), c.Network, addr.String())
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn.(*net.UDPConn))
+
+		// all other sockets bind to the port chosen for the first one
+		addr = conns[0].LocalAddr().(*net.UDPAddr)
+	}
+
+	t := &transport{net: c.Network, laddr: wrapAddr(addr), c: conns[0], readers: conns}
+	if err := t.setDSCP(c.DSCP); err != nil {
+		t.Close()
+		return nil, err
+	}
 	return dgram.Wrap(t)
 }
 
+// setDSCP marks all packets written on the transport with dscp.
+func (t *transport) setDSCP(dscp int) error {
+	if dscp == 0 {
+		return nil
+	}
+
+	raw, err := t.c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return setTOS(t.net, raw, dscp<<2)
+}
+
 func (t *transport) Close() error {
-	return t.c.Close()
+	var err error
+	for _, conn := range t.readers {
+		if cerr := conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
 func (t *transport) NormalizeAddr(addr net.Addr) (dgram.Addr, error) {
 	if a, ok := addr.(*net.UDPAddr); ok {
 		return t.NormalizeAddr(wrapAddr(a))
-	} else if a, ok := addr.(*udpv4); ok && t.net == UDPv4 {
+	} else if a, ok := addr.(*udpv4); ok && t.net != UDPv6 {
 		return a, nil
-	} else if a, ok := addr.(*udpv6); ok && t.net == UDPv6 {
+	} else if a, ok := addr.(*udpv6); ok && t.net != UDPv4 {
 		return a, nil
 	} else {
 		return nil, transports.ErrInvalidAddr
@@ -108,7 +202,15 @@ func (t *transport) NormalizeAddr(addr net.Addr) (dgram.Addr, error) {
 }
 
 func (t *transport) Read(b []byte) (n int, addr dgram.Addr, err error) {
-	n, uaddr, err := t.c.ReadFromUDP(b)
+	return t.ReadN(0, b)
+}
+
+func (t *transport) NumReaders() int {
+	return len(t.readers)
+}
+
+func (t *transport) ReadN(i int, b []byte) (n int, addr dgram.Addr, err error) {
+	n, uaddr, err := t.readers[i].ReadFromUDP(b)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -119,6 +221,20 @@ func (t *transport) Write(b []byte, addr dgram.Addr) (n int, err error) {
 	return t.c.WriteToUDP(b, addr.(udpAddr).ToUDPAddr())
 }
 
+// WritePriority writes b marked with dscp. On platforms which don't support per
+// packet marking the packet is marked with the DSCP of the Config instead.
+func (t *transport) WritePriority(b []byte, addr dgram.Addr, dscp int) (n int, err error) {
+	if dscp < 0 || dscp > 63 {
+		return 0, errInvalidDSCP
+	}
+	if !perPacketTOSSupported {
+		return t.Write(b, addr)
+	}
+
+	n, _, err = t.c.WriteMsgUDP(b, tosOOB(t.net, dscp<<2), addr.(udpAddr).ToUDPAddr())
+	return n, err
+}
+
 func (t *transport) Addrs() []net.Addr {
 	var (
 		port  uint16
@@ -144,7 +260,7 @@ func (t *transport) Addrs() []net.Addr {
 			Zone: addr.Zone,
 			Port: int(port),
 		})
-		if addr.IsIPv6() && t.net == UDPv6 || !addr.IsIPv6() && t.net == UDPv4 {
+		if t.net == UDP || addr.IsIPv6() && t.net == UDPv6 || !addr.IsIPv6() && t.net == UDPv4 {
 			addrs = append(addrs, addr)
 		}
 	}
@@ -2,10 +2,16 @@ package udp
 
 import (
 	"bytes"
+	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
 )
 
 func TestAddrs(t *testing.T) {
@@ -16,6 +22,7 @@ func TestAddrs(t *testing.T) {
 		{Network: "udp4", Addr: "127.0.0.1:8080"},
 		{Network: "udp4", Addr: ":0"},
 		{Network: "udp6", Addr: ":0"},
+		{Network: "udp", Addr: ":0"},
 	}
 
 	for _, factory := range tab {
@@ -97,3 +104,279 @@ func Benchmark(b *testing.B) {
 		}
 	}
 }
+
+func TestMultipleReaders(t *testing.T) {
+	assert := assert.New(t)
+
+	B, err := Config{Network: "udp4", Addr: "127.0.0.1:0", Readers: 4}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	var senders []net.Conn
+	for i := 0; i < 8; i++ {
+		A, err := Config{Network: "udp4", Addr: "127.0.0.1:0"}.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer A.Close()
+
+		w, err := A.Dial(B.Addrs()[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		senders = append(senders, w)
+	}
+
+	for i, w := range senders {
+		_, err = w.Write([]byte{byte(i)})
+		assert.NoError(err)
+	}
+
+	seen := map[byte]bool{}
+	for range senders {
+		r, err := B.Accept()
+		if !assert.NoError(err) {
+			return
+		}
+
+		var out [1500]byte
+		n, err := r.Read(out[:])
+		if assert.NoError(err) && assert.Equal(1, n) {
+			seen[out[0]] = true
+		}
+	}
+	assert.Len(seen, len(senders))
+}
+
+func TestDualStack(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Network: "udp"}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	var families = map[string]bool{}
+	for _, addr := range A.Addrs() {
+		families[addr.Network()] = true
+	}
+	assert.True(families["udp4"], "addrs=%v", A.Addrs())
+	assert.True(families["udp6"], "addrs=%v", A.Addrs())
+
+	for _, config := range []Config{
+		{Network: "udp4", Addr: "127.0.0.1:0"},
+		{Network: "udp6", Addr: "[::1]:0"},
+	} {
+		B, err := config.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer B.Close()
+
+		port := int(A.Addrs()[0].(udpAddr).GetPort())
+		dst := wrapAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if config.Network == "udp6" {
+			dst = wrapAddr(&net.UDPAddr{IP: net.IPv6loopback, Port: port})
+		}
+
+		w, err := B.Dial(dst)
+		if !assert.NoError(err) {
+			continue
+		}
+		_, err = w.Write([]byte(config.Network))
+		assert.NoError(err)
+
+		r, err := A.Accept()
+		if !assert.NoError(err) {
+			continue
+		}
+		assert.Equal(config.Network, r.RemoteAddr().Network())
+
+		var out [1500]byte
+		n, err := r.Read(out[:])
+		assert.NoError(err)
+		assert.Equal(config.Network, string(out[:n]))
+
+		// the reply goes out over the same socket
+		_, err = r.Write([]byte("reply"))
+		assert.NoError(err)
+		n, err = w.Read(out[:])
+		assert.NoError(err)
+		assert.Equal("reply", string(out[:n]))
+	}
+}
+
+func TestLinkLocalAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := wrapAddr(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "eth0", Port: 4000})
+
+	data, err := transports.EncodeAddr(addr)
+	assert.NoError(err)
+	assert.Equal(`{"type":"udp6","ip":"fe80::1","zone":"eth0","port":4000}`, string(data))
+
+	decoded, err := transports.DecodeAddr(data)
+	if assert.NoError(err) {
+		assert.True(transports.EqualAddr(addr, decoded))
+		assert.Equal(addr.Key(), decoded.(udpAddr).Key())
+	}
+
+	// the zone tells link-local addresses apart
+	other := wrapAddr(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "eth1", Port: 4000})
+	assert.False(transports.EqualAddr(addr, other))
+	assert.NotEqual(addr.Key(), other.Key())
+
+	// link-local addresses without a zone are unusable
+	_, err = transports.DecodeAddr([]byte(`{"type":"udp6","ip":"fe80::1","port":4000}`))
+	assert.Equal(transports.ErrInvalidAddr, err)
+}
+
+func TestLinkLocalRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	laddr := linkLocalAddr()
+	if laddr == nil {
+		t.Skip("no IPv6 link-local address")
+	}
+
+	A, err := Config{Network: "udp6", Addr: laddr.String()}.Open()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer A.Close()
+
+	B, err := Config{Network: "udp6", Addr: laddr.String()}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer B.Close()
+
+	dst := A.Addrs()[0]
+	assert.Equal(laddr.Zone, dst.(udpAddr).ToUDPAddr().Zone)
+
+	w, err := B.Dial(dst)
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(err)
+
+	r, err := A.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+
+	var out [1500]byte
+	n, err := r.Read(out[:])
+	assert.NoError(err)
+	assert.Equal("hello", string(out[:n]))
+	assert.Equal(laddr.Zone, r.RemoteAddr().(udpAddr).ToUDPAddr().Zone)
+}
+
+// linkLocalAddr returns an IPv6 link-local address of one of the interfaces or
+// nil when there is none.
+func linkLocalAddr() *net.UDPAddr {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if ok && ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast() {
+				return &net.UDPAddr{IP: ipnet.IP, Zone: iface.Name}
+			}
+		}
+	}
+
+	return nil
+}
+
+func BenchmarkReaders(b *testing.B) {
+	for _, readers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			benchmarkReaders(b, readers, 8)
+		})
+	}
+}
+
+// benchmarkReaders measures the number of packets per second received by a
+// transport with readers readers from senders concurrent senders.
+func benchmarkReaders(b *testing.B, readers, senders int) {
+	B, err := Config{Network: "udp4", Addr: "127.0.0.1:0", Readers: readers}.Open()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer B.Close()
+
+	var (
+		msg      = bytes.Repeat([]byte{'x'}, 1200)
+		received int64
+		done     = make(chan struct{})
+		wg       sync.WaitGroup
+	)
+
+	go func() {
+		for {
+			r, err := B.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				var out [1500]byte
+				for {
+					if _, err := r.Read(out[:]); err != nil {
+						return
+					}
+					atomic.AddInt64(&received, 1)
+				}
+			}()
+		}
+	}()
+
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+
+	for i := 0; i < senders; i++ {
+		A, err := Config{Network: "udp4", Addr: "127.0.0.1:0"}.Open()
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer A.Close()
+
+		w, err := A.Dial(B.Addrs()[0])
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w.Write(msg)
+			}
+		}()
+	}
+
+	// packets may be dropped; keep sending until b.N packets were received
+	for atomic.LoadInt64(&received) < int64(b.N) {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}